@@ -1,13 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -23,7 +22,6 @@ import (
 	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
 	hpgcp "github.com/hyperpilotio/deployer/clusters/gcp"
 	"github.com/hyperpilotio/deployer/job"
-	"github.com/hyperpilotio/go-utils/funcs"
 	"github.com/spf13/viper"
 
 	"net/http"
@@ -47,10 +45,12 @@ type DeploymentInfo struct {
 	Deployer   clustermanagers.Deployer `json:"-"`
 	Deployment *apis.Deployment         `json:"Deployment"`
 	TemplateId string                   `json:"TemplateId"`
-	Created    time.Time                `json:"Created"`
-	ShutDown   time.Time                `json:"ShutDown"`
-	State      DeploymentState          `json:"State"`
-	Error      string                   `json:"Error"`
+	// Values of the template variables the deployment is rendered with
+	TemplateValues map[string]interface{} `json:"TemplateValues,omitempty"`
+	Created        time.Time              `json:"Created"`
+	ShutDown       time.Time              `json:"ShutDown"`
+	State          DeploymentState        `json:"State"`
+	Error          string                 `json:"Error"`
 }
 
 type DeploymentUserProfile struct {
@@ -88,13 +88,48 @@ type StoreDeployment struct {
 	UserId      string
 	Deployment  string
 	TemplateId  string
+	// Values of the template variables the deployment is rendered with
+	TemplateValues map[string]interface{}
+	// Ssh host keys pinned for the hosts of the cluster
+	HostKeys map[string][]string
 	// Stores cluster manager specific stored information
 	ClusterManager interface{}
 }
 
 type StoreTemplateDeployment struct {
 	TemplateId string
+	// Version is 0 for templates stored before versioning
+	Version    int
+	Created    string
 	Deployment string
+	// Deleted marks the last version of a deleted template, which is kept so versions of the
+	// template are never reused
+	Deleted bool `json:",omitempty"`
+}
+
+// CreateDeploymentRequest is a deployment, with the values of the template variables when
+// it's created from a template
+type CreateDeploymentRequest struct {
+	*apis.Deployment
+	Values map[string]interface{} `form:"values" json:"values"`
+}
+
+// ResetTemplateDeploymentRequest is the optional values of the template variables a deployment
+// is reset with
+type ResetTemplateDeploymentRequest struct {
+	Values map[string]interface{} `form:"values" json:"values"`
+}
+
+type ScaleTaskRequest struct {
+	Replicas *int `form:"replicas" json:"replicas" binding:"required"`
+}
+
+type AddNodesRequest struct {
+	Nodes []apis.ClusterNode `form:"nodes" json:"nodes" binding:"required"`
+}
+
+type RemoveNodesRequest struct {
+	NodeIds []int `form:"nodeIds" json:"nodeIds" binding:"required"`
 }
 
 func (deploymentInfo *DeploymentInfo) GetDeploymentType() string {
@@ -146,6 +181,7 @@ type Server struct {
 	InClusterDeploymentStore blobstore.BlobStore
 	ProfileStore             blobstore.BlobStore
 	TemplateStore            blobstore.BlobStore
+	FileStore                blobstore.BlobStore
 
 	// Maps all available users
 	DeploymentUserProfiles map[string]clusters.UserProfile
@@ -153,13 +189,24 @@ type Server struct {
 	// Maps deployment name to deployed cluster struct
 	DeployedClusters map[string]*DeploymentInfo
 
-	// Maps file id to location on disk
+	// Maps user and file id to location on disk
 	UploadedFiles map[string]string
 
+	// Maps user and file id to the catalog entry of uploaded files
+	Files map[string]*StoreFile
+
 	// Maps template id to templates
-	Templates map[string]*apis.Deployment
+	Templates map[string]*Template
+
+	// Maps template id to the highest version reserved, also of deleted templates, so
+	// versions are never reused
+	templateVersions map[string]int
 
 	mutex sync.Mutex
+
+	// Guards Files, UploadedFiles and the blobs on disk, so file store calls don't block the
+	// server mutex. It's taken before mutex when both are held.
+	filesMutex sync.RWMutex
 }
 
 // NewServer return an instance of Server struct.
@@ -169,7 +216,9 @@ func NewServer(config *viper.Viper) *Server {
 		DeploymentUserProfiles: make(map[string]clusters.UserProfile),
 		DeployedClusters:       make(map[string]*DeploymentInfo),
 		UploadedFiles:          make(map[string]string),
-		Templates:              make(map[string]*apis.Deployment),
+		Files:                  make(map[string]*StoreFile),
+		Templates:              make(map[string]*Template),
+		templateVersions:       make(map[string]int),
 	}
 }
 
@@ -185,6 +234,7 @@ func (deploymentInfo *DeploymentInfo) NewStoreDeployment() (*StoreDeployment, er
 		UserId:         deploymentInfo.Deployment.UserId,
 		Region:         deploymentInfo.Deployment.Region,
 		TemplateId:     deploymentInfo.TemplateId,
+		TemplateValues: deploymentInfo.TemplateValues,
 		Deployment:     string(b),
 		Status:         GetStateString(deploymentInfo.State),
 		Created:        deploymentInfo.Created.Format(time.RFC822),
@@ -196,6 +246,7 @@ func (deploymentInfo *DeploymentInfo) NewStoreDeployment() (*StoreDeployment, er
 	if cluster.GetKeyMaterial() != "" {
 		storeDeployment.KeyMaterial = cluster.GetKeyMaterial()
 	}
+	storeDeployment.HostKeys = cluster.GetHostKeys().Keys()
 
 	return storeDeployment, nil
 }
@@ -236,6 +287,20 @@ func (server *Server) StartServer() error {
 		server.TemplateStore = templateStore
 	}
 
+	if fileStore, err := blobstore.NewBlobStore("Files", server.Config); err != nil {
+		return errors.New("Unable to create files store: " + err.Error())
+	} else {
+		server.FileStore = fileStore
+	}
+
+	if err := server.reloadFiles(); err != nil {
+		return errors.New("Unable to reload files: " + err.Error())
+	}
+
+	if err := server.reloadTemplates(); err != nil {
+		return errors.New("Unable to reload templates: " + err.Error())
+	}
+
 	if err := server.reloadClusterState(); err != nil {
 		return errors.New("Unable to reload cluster state: " + err.Error())
 	}
@@ -268,7 +333,9 @@ func (server *Server) StartServer() error {
 		usersGroup.POST("/:userId/deployments", server.createDeployment)
 		usersGroup.PUT("/:userId/deployments/:deployment", server.updateDeployment)
 
+		usersGroup.GET("/:userId/files", server.getFiles)
 		usersGroup.POST("/:userId/files/:fileId", server.uploadFile)
+		usersGroup.DELETE("/:userId/files/:fileId", server.deleteFile)
 	}
 
 	daemonsGroup := router.Group("/v1/deployments")
@@ -280,12 +347,21 @@ func (server *Server) StartServer() error {
 		daemonsGroup.PUT("/:deployment", server.updateDeployment)
 
 		daemonsGroup.GET("/:deployment/ssh_key", server.getPemFile)
+		daemonsGroup.POST("/:deployment/ssh_key/rotate", server.rotateSshKey)
 		daemonsGroup.GET("/:deployment/kubeconfig", server.getKubeConfigFile)
 		daemonsGroup.GET("/:deployment/state", server.getDeploymentState)
+		daemonsGroup.GET("/:deployment/vpc_peering", server.getVPCPeering)
 
 		daemonsGroup.GET("/:deployment/services/:service/url", server.getServiceUrl)
 		daemonsGroup.GET("/:deployment/services/:service/address", server.getServiceAddress)
 		daemonsGroup.GET("/:deployment/services", server.getServices)
+
+		daemonsGroup.PUT("/:deployment/tasks/:task/scale", server.scaleTask)
+		daemonsGroup.POST("/:deployment/nodes", server.addNodes)
+		daemonsGroup.DELETE("/:deployment/nodes", server.removeNodes)
+		daemonsGroup.POST("/:deployment/nodes/:nodeId/exec", server.execNodeCommand)
+		daemonsGroup.GET("/:deployment/nodes/:nodeId/files", server.getNodeFile)
+		daemonsGroup.POST("/:deployment/diagnostics", server.collectDiagnostics)
 	}
 
 	awsRegionGroup := router.Group("/v1/aws/regions")
@@ -295,7 +371,11 @@ func (server *Server) StartServer() error {
 
 	templateGroup := router.Group("/v1/templates")
 	{
+		templateGroup.GET("", server.getTemplates)
+		templateGroup.GET("/:templateId", server.getTemplate)
+		templateGroup.GET("/:templateId/versions", server.getTemplateVersions)
 		templateGroup.POST("/:templateId", server.storeTemplateFile)
+		templateGroup.DELETE("/:templateId", server.deleteTemplate)
 		templateGroup.POST("/:templateId/deployments", server.createDeployment)
 		templateGroup.PUT("/:templateId/deployments/:deployment/reset", server.resetTemplateDeployment)
 		templateGroup.PUT("/:templateId/deployments/:deployment/deploy", server.deployExtensions)
@@ -342,80 +422,6 @@ func (server *Server) getNodeAddressForTask(c *gin.Context) {
 	})
 }
 
-func (server *Server) storeUploadedFile(fileId string, userId string, c *gin.Context) (*string, error) {
-	file, _, err := c.Request.FormFile("upload")
-	tempFile := "/tmp/" + userId + "_" + fileId + ".tmp"
-	destination := path.Join(server.Config.GetString("filesPath"), userId+"_"+fileId)
-	out, err := os.Create(tempFile)
-	defer func() {
-		out.Close()
-		os.Remove(tempFile)
-	}()
-	if err != nil {
-		return nil, errors.New("Unable to create temporary file: " + err.Error())
-	}
-	_, err = io.Copy(out, file)
-	if err != nil {
-		return nil, errors.New("Unable to write to temporary file: " + err.Error())
-	}
-
-	if err := os.Rename(tempFile, destination); err != nil {
-		return nil, errors.New("Unable to rename file: " + err.Error())
-	}
-
-	return &destination, nil
-}
-
-func (server *Server) getFiles(c *gin.Context) {
-	server.mutex.Lock()
-	defer server.mutex.Unlock()
-
-	c.JSON(http.StatusOK, gin.H{
-		"error": false,
-		"data":  server.UploadedFiles,
-	})
-}
-
-func (server *Server) uploadFile(c *gin.Context) {
-	userId := c.Param("userId")
-	if userId == "" {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": true,
-			"data":  "Unable to find userId",
-		})
-		return
-	}
-
-	fileId := c.Param("fileId")
-	server.mutex.Lock()
-	defer server.mutex.Unlock()
-
-	file, err := server.storeUploadedFile(fileId, userId, c)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": true,
-			"data":  err.Error(),
-		})
-		return
-	}
-
-	server.UploadedFiles[userId+"_"+fileId] = *file
-
-	c.JSON(http.StatusAccepted, gin.H{
-		"error": false,
-		"data":  "",
-	})
-}
-
-func (server *Server) deleteFile(c *gin.Context) {
-	// TODO implement function to delete file upload
-
-	c.JSON(http.StatusNotImplemented, gin.H{
-		"error": false,
-		"data":  "",
-	})
-}
-
 func (server *Server) updateDeployment(c *gin.Context) {
 	deploymentName := c.Param("deployment")
 
@@ -432,6 +438,14 @@ func (server *Server) updateDeployment(c *gin.Context) {
 		return
 	}
 
+	if err := deployment.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Invalid deployment: " + err.Error(),
+		})
+		return
+	}
+
 	server.mutex.Lock()
 	deploymentInfo, ok := server.DeployedClusters[deploymentName]
 	if !ok {
@@ -505,7 +519,10 @@ func (server *Server) createDeployment(c *gin.Context) {
 		UserId: c.Param("userId"),
 	}
 
-	if err := c.BindJSON(deployment); err != nil {
+	request := &CreateDeploymentRequest{
+		Deployment: deployment,
+	}
+	if err := c.BindJSON(request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": true,
 			"data":  "Error deserializing deployment: " + err.Error(),
@@ -513,9 +530,18 @@ func (server *Server) createDeployment(c *gin.Context) {
 		return
 	}
 
+	// Deployments record the template version they are created from
 	templateId := c.Param("templateId")
+	if templateId == "" && len(request.Values) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Values are only accepted by deployments of templates",
+		})
+		return
+	}
+
 	if templateId != "" {
-		mergeDeployment, mergeErr := server.mergeNewDeployment(templateId, deployment)
+		mergeDeployment, templateRef, mergeErr := server.mergeNewDeployment(templateId, deployment, request.Values)
 		if mergeErr != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error": true,
@@ -524,13 +550,23 @@ func (server *Server) createDeployment(c *gin.Context) {
 			return
 		}
 		deployment = mergeDeployment
+		templateId = templateRef
+	}
+
+	if err := deployment.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Invalid deployment: " + err.Error(),
+		})
+		return
 	}
 
 	deploymentInfo := &DeploymentInfo{
-		TemplateId: templateId,
-		Deployment: deployment,
-		Created:    time.Now(),
-		State:      CREATING,
+		TemplateId:     templateId,
+		TemplateValues: request.Values,
+		Deployment:     deployment,
+		Created:        time.Now(),
+		State:          CREATING,
 	}
 	deploymentType := deploymentInfo.GetDeploymentType()
 
@@ -564,6 +600,7 @@ func (server *Server) createDeployment(c *gin.Context) {
 		return
 	}
 	deploymentInfo.Deployer = deployer
+	uploadedFiles := server.uploadedFiles()
 
 	server.mutex.Lock()
 	defer server.mutex.Unlock()
@@ -576,12 +613,24 @@ func (server *Server) createDeployment(c *gin.Context) {
 		return
 	}
 
+	// The template could be deleted after it was merged, deleteTemplate only sees the
+	// deployment once it's registered
+	if templateId != "" {
+		if _, err := server.findTemplateVersion(templateId); err != nil {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": true,
+				"data":  "Template of the deployment was deleted: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	server.DeployedClusters[deployment.Name] = deploymentInfo
 
 	go func() {
 		log := deployer.GetLog()
 
-		if resp, err := deployer.CreateDeployment(server.UploadedFiles); err != nil {
+		if resp, err := deployer.CreateDeployment(uploadedFiles); err != nil {
 			log.Logger.Infof("Unable to create deployment: " + err.Error())
 			deploymentInfo.SetFailure(err.Error())
 		} else {
@@ -671,6 +720,26 @@ func (server *Server) resetTemplateDeployment(c *gin.Context) {
 	deploymentName := c.Param("deployment")
 	templateId := c.Param("templateId")
 
+	// Reset requests without a body keep the values of the deployment
+	request := &ResetTemplateDeploymentRequest{}
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Unable to read reset request: " + err.Error(),
+		})
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": true,
+				"data":  "Error deserializing reset request: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	server.mutex.Lock()
 	deploymentInfo, ok := server.DeployedClusters[deploymentName]
 	if !ok {
@@ -691,18 +760,27 @@ func (server *Server) resetTemplateDeployment(c *gin.Context) {
 		return
 	}
 
-	templateDeployment, ok := server.Templates[templateId]
-	if !ok {
+	templateVersion, err := server.findTemplateVersion(templateId)
+	if err != nil {
 		server.mutex.Unlock()
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": true,
-			"data":  "Template not found",
+			"data":  err.Error(),
 		})
 		return
 	}
+	templateRef := templateVersion.Ref()
 
-	deployment := &apis.Deployment{}
-	funcs.DeepCopy(deployment, templateDeployment)
+	templateValues := deploymentTemplateValues(deploymentInfo, templateId, request.Values)
+	deployment, err := templateVersion.Deployment.Render(templateValues)
+	if err != nil {
+		server.mutex.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Unable to render template deployment: " + err.Error(),
+		})
+		return
+	}
 
 	deployment.UserId = deploymentInfo.Deployment.UserId
 	deployment.Name = deploymentName
@@ -712,7 +790,7 @@ func (server *Server) resetTemplateDeployment(c *gin.Context) {
 	log := deploymentInfo.Deployer.GetLog()
 
 	go func() {
-		log.Logger.Infof("Resetting deployment to template %s: %+v", templateId, deployment)
+		log.Logger.Infof("Resetting deployment to template %s: %+v", templateRef, deployment)
 
 		if err := deploymentInfo.Deployer.UpdateDeployment(deployment); err != nil {
 			log.Logger.Error("Unable to reset template deployment: " + err.Error())
@@ -720,6 +798,8 @@ func (server *Server) resetTemplateDeployment(c *gin.Context) {
 		} else {
 			log.Logger.Infof("Reset template deployment successfully!")
 			deploymentInfo.Deployment = deployment
+			deploymentInfo.TemplateId = templateRef
+			deploymentInfo.TemplateValues = templateValues
 			deploymentInfo.SetState(AVAILABLE)
 		}
 
@@ -740,7 +820,10 @@ func (server *Server) deployExtensions(c *gin.Context) {
 	templateId := c.Param("templateId")
 
 	deployment := &apis.Deployment{}
-	if err := c.BindJSON(deployment); err != nil {
+	request := &CreateDeploymentRequest{
+		Deployment: deployment,
+	}
+	if err := c.BindJSON(request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": true,
 			"data":  "Error deserializing deployment: " + err.Error(),
@@ -756,7 +839,13 @@ func (server *Server) deployExtensions(c *gin.Context) {
 		return
 	}
 
-	newDeployment, err := server.mergeNewDeployment(templateId, deployment)
+	// Extensions are merged into the template rendered with the values of the request, or
+	// the values of the deployment when it was created from the same template
+	server.mutex.Lock()
+	templateValues := deploymentTemplateValues(server.DeployedClusters[deploymentName], templateId, request.Values)
+	server.mutex.Unlock()
+
+	newDeployment, templateRef, err := server.mergeNewDeployment(templateId, deployment, templateValues)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": true,
@@ -765,6 +854,14 @@ func (server *Server) deployExtensions(c *gin.Context) {
 		return
 	}
 
+	if err := newDeployment.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Invalid deployment: " + err.Error(),
+		})
+		return
+	}
+
 	server.mutex.Lock()
 	deploymentInfo, ok := server.DeployedClusters[deploymentName]
 	if !ok {
@@ -801,6 +898,8 @@ func (server *Server) deployExtensions(c *gin.Context) {
 		} else {
 			log.Logger.Infof("Deploy extensions deployment successfully!")
 			deploymentInfo.Deployment = newDeployment
+			deploymentInfo.TemplateId = templateRef
+			deploymentInfo.TemplateValues = templateValues
 			deploymentInfo.SetState(AVAILABLE)
 		}
 
@@ -888,6 +987,35 @@ func (server *Server) getDeploymentState(c *gin.Context) {
 	})
 }
 
+func (server *Server) getVPCPeering(c *gin.Context) {
+	deploymentName := c.Param("deployment")
+
+	server.mutex.Lock()
+	deploymentInfo, ok := server.DeployedClusters[deploymentName]
+	server.mutex.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  "Unable to find deployment",
+		})
+		return
+	}
+
+	status, err := deploymentInfo.Deployer.GetVPCPeering()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Unable to get vpc peering: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"error": false,
+		"data":  status,
+	})
+}
+
 func (server *Server) getServiceUrl(c *gin.Context) {
 	deploymentName := c.Param("deployment")
 	serviceName := c.Param("service")
@@ -978,6 +1106,199 @@ func (server *Server) getServices(c *gin.Context) {
 	})
 }
 
+func (server *Server) scaleTask(c *gin.Context) {
+	deploymentName := c.Param("deployment")
+	taskName := c.Param("task")
+
+	var request ScaleTaskRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Error deserializing scale request: " + err.Error(),
+		})
+		return
+	}
+
+	if *request.Replicas < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Replicas cannot be negative",
+		})
+		return
+	}
+
+	server.mutex.Lock()
+	deploymentInfo, ok := server.DeployedClusters[deploymentName]
+	if !ok {
+		server.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  "Deployment not found",
+		})
+		return
+	}
+
+	if deploymentInfo.State != AVAILABLE {
+		server.mutex.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Deployment is not available",
+		})
+		return
+	}
+
+	deploymentInfo.SetState(UPDATING)
+	server.mutex.Unlock()
+
+	log := deploymentInfo.Deployer.GetLog()
+	err := deploymentInfo.Deployer.ScaleTask(taskName, *request.Replicas)
+	// A failed scale leaves the rest of the deployment running, so it stays available
+	deploymentInfo.SetState(AVAILABLE)
+	if err != nil {
+		log.Logger.Errorf("Unable to scale task %s: %s", taskName, err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": true,
+			"data":  "Unable to scale task: " + err.Error(),
+		})
+		return
+	}
+
+	log.Logger.Infof("Scaled task %s to %d replicas", taskName, *request.Replicas)
+	server.storeDeployment(deploymentInfo)
+
+	c.JSON(http.StatusOK, gin.H{
+		"error": false,
+		"data":  fmt.Sprintf("Scaled task %s to %d replicas", taskName, *request.Replicas),
+	})
+}
+
+func (server *Server) addNodes(c *gin.Context) {
+	deploymentName := c.Param("deployment")
+
+	var request AddNodesRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Error deserializing add nodes request: " + err.Error(),
+		})
+		return
+	}
+	uploadedFiles := server.uploadedFiles()
+
+	server.mutex.Lock()
+	deploymentInfo, ok := server.DeployedClusters[deploymentName]
+	if !ok {
+		server.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  "Deployment not found",
+		})
+		return
+	}
+
+	if deploymentInfo.State != AVAILABLE {
+		server.mutex.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Deployment is not available",
+		})
+		return
+	}
+
+	if err := deploymentInfo.Deployment.ValidateNewNodes(request.Nodes); err != nil {
+		server.mutex.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Invalid nodes: " + err.Error(),
+		})
+		return
+	}
+
+	deploymentInfo.SetState(UPDATING)
+	server.mutex.Unlock()
+
+	go func() {
+		log := deploymentInfo.Deployer.GetLog()
+
+		if err := deploymentInfo.Deployer.AddNodes(request.Nodes, uploadedFiles); err != nil {
+			log.Logger.Error("Unable to add nodes: " + err.Error())
+			deploymentInfo.SetFailure(err.Error())
+		} else {
+			log.Logger.Infof("Added %d nodes successfully!", len(request.Nodes))
+			deploymentInfo.SetState(AVAILABLE)
+		}
+		server.storeDeployment(deploymentInfo)
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"error": false,
+		"data":  "Start to add nodes to deployment " + deploymentName + "......",
+	})
+}
+
+func (server *Server) removeNodes(c *gin.Context) {
+	deploymentName := c.Param("deployment")
+
+	var request RemoveNodesRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Error deserializing remove nodes request: " + err.Error(),
+		})
+		return
+	}
+
+	server.mutex.Lock()
+	deploymentInfo, ok := server.DeployedClusters[deploymentName]
+	if !ok {
+		server.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  "Deployment not found",
+		})
+		return
+	}
+
+	if deploymentInfo.State != AVAILABLE {
+		server.mutex.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Deployment is not available",
+		})
+		return
+	}
+
+	if err := deploymentInfo.Deployment.ValidateRemovedNodes(request.NodeIds); err != nil {
+		server.mutex.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Invalid nodes: " + err.Error(),
+		})
+		return
+	}
+
+	deploymentInfo.SetState(UPDATING)
+	server.mutex.Unlock()
+
+	go func() {
+		log := deploymentInfo.Deployer.GetLog()
+
+		if err := deploymentInfo.Deployer.RemoveNodes(request.NodeIds); err != nil {
+			log.Logger.Error("Unable to remove nodes: " + err.Error())
+			deploymentInfo.SetFailure(err.Error())
+		} else {
+			log.Logger.Infof("Removed nodes %v successfully!", request.NodeIds)
+			deploymentInfo.SetState(AVAILABLE)
+		}
+		server.storeDeployment(deploymentInfo)
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"error": false,
+		"data":  "Start to remove nodes from deployment " + deploymentName + "......",
+	})
+}
+
 func (server *Server) getAWSRegionInstances(c *gin.Context) {
 	regionName := c.Param("region")
 	availabilityZoneName := c.Param("availabilityZone")
@@ -1054,11 +1375,25 @@ func (server *Server) storeDeployment(deploymentInfo *DeploymentInfo) error {
 	return nil
 }
 
+// storePinnedHostKeys stores the deployment when ssh connections pinned host keys of new hosts
+func (server *Server) storePinnedHostKeys(deploymentInfo *DeploymentInfo) {
+	if deploymentInfo.Deployer.GetCluster().GetHostKeys().TakeChanged() {
+		server.storeDeployment(deploymentInfo)
+	}
+}
+
 func (server *Server) storeTemplateFile(c *gin.Context) {
-	deployment := &apis.Deployment{
-		UserId: "",
+	content, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Unable to read deployment: " + err.Error(),
+		})
+		return
 	}
-	if err := c.BindJSON(&deployment); err != nil {
+
+	deployment, err := apis.ParseDeploymentTemplate(content)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": true,
 			"data":  "Error deserializing deployment: " + err.Error(),
@@ -1066,6 +1401,14 @@ func (server *Server) storeTemplateFile(c *gin.Context) {
 		return
 	}
 
+	if err := deployment.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Invalid deployment: " + err.Error(),
+		})
+		return
+	}
+
 	b, jsonErr := json.Marshal(deployment)
 	if jsonErr != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -1076,12 +1419,36 @@ func (server *Server) storeTemplateFile(c *gin.Context) {
 	}
 
 	templateId := c.Param("templateId")
+	if strings.Contains(templateId, templateVersionSeparator) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Template id can't contain " + templateVersionSeparator,
+		})
+		return
+	}
+
+	// Stored templates are never overwritten, a new version is added instead. The version is
+	// reserved first, so the template store isn't called with the server mutex held.
+	server.mutex.Lock()
+	version := server.templateVersions[templateId] + 1
+	server.templateVersions[templateId] = version
+	server.mutex.Unlock()
+
+	templateVersion := &TemplateVersion{
+		TemplateId: templateId,
+		Version:    version,
+		Created:    time.Now().Format(time.RFC3339),
+		Deployment: deployment,
+		storeKey:   templateRef(templateId, version),
+	}
 	templateDeployment := &StoreTemplateDeployment{
 		TemplateId: templateId,
+		Version:    version,
+		Created:    templateVersion.Created,
 		Deployment: string(b),
 	}
 
-	if err := server.TemplateStore.Store(templateId, templateDeployment); err != nil {
+	if err := server.TemplateStore.Store(templateVersion.storeKey, templateDeployment); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": true,
 			"data":  fmt.Errorf("Unable to store %s templates: %s", templateId, err.Error()),
@@ -1090,26 +1457,34 @@ func (server *Server) storeTemplateFile(c *gin.Context) {
 	}
 
 	server.mutex.Lock()
-	server.Templates[templateId] = deployment
+	server.addTemplateVersion(templateVersion)
 	server.mutex.Unlock()
 
 	c.JSON(http.StatusAccepted, gin.H{
 		"error": false,
-		"data":  "",
+		"data":  templateVersion.Ref(),
 	})
 }
 
-func (server *Server) mergeNewDeployment(templateId string, newDeployment *apis.Deployment) (*apis.Deployment, error) {
+// mergeNewDeployment merges the new deployment into the template version of the reference,
+// rendered with the values of its variables, and return the merged deployment with the
+// templateId@version it's merged into
+func (server *Server) mergeNewDeployment(
+	templateId string,
+	newDeployment *apis.Deployment,
+	values map[string]interface{}) (*apis.Deployment, string, error) {
 	server.mutex.Lock()
 	defer server.mutex.Unlock()
 
-	templateDeployment, ok := server.Templates[templateId]
-	if !ok {
-		return nil, fmt.Errorf("Unable to find %s deployment templates", templateId)
+	templateVersion, err := server.findTemplateVersion(templateId)
+	if err != nil {
+		return nil, "", err
 	}
 
-	newTemplateDeployment := &apis.Deployment{}
-	funcs.DeepCopy(templateDeployment, newTemplateDeployment)
+	newTemplateDeployment, err := templateVersion.Deployment.Render(values)
+	if err != nil {
+		return nil, "", errors.New("Unable to render template: " + err.Error())
+	}
 
 	if newDeployment.UserId != "" {
 		newTemplateDeployment.UserId = newDeployment.UserId
@@ -1135,7 +1510,7 @@ func (server *Server) mergeNewDeployment(templateId string, newDeployment *apis.
 
 	for _, nodeMapping := range newDeployment.NodeMapping {
 		if err := checkDuplicateTask(newTemplateDeployment.NodeMapping, nodeMapping); err != nil {
-			return nil, fmt.Errorf("Unable to merge deployment: %s", err.Error())
+			return nil, "", fmt.Errorf("Unable to merge deployment: %s", err.Error())
 		}
 		newTemplateDeployment.NodeMapping =
 			append(newTemplateDeployment.NodeMapping, nodeMapping)
@@ -1146,7 +1521,7 @@ func (server *Server) mergeNewDeployment(templateId string, newDeployment *apis.
 			append(newTemplateDeployment.KubernetesDeployment.Kubernetes, task)
 	}
 
-	return newTemplateDeployment, nil
+	return newTemplateDeployment, templateVersion.Ref(), nil
 }
 
 func checkDuplicateTask(nodeMappings []apis.NodeMapping, checkNodeMapping apis.NodeMapping) error {
@@ -1191,28 +1566,6 @@ func (server *Server) reloadClusterState() error {
 		return fmt.Errorf("Unable to load deployment status: %s", err.Error())
 	}
 
-	templates, templateErr := server.TemplateStore.LoadAll(func() interface{} {
-		return &StoreTemplateDeployment{}
-	})
-	if templateErr != nil {
-		return fmt.Errorf("Unable to load deployment templates: %s", templateErr.Error())
-	}
-
-	glog.V(1).Infof("Loading %d template deployment from store", len(templates.([]interface{})))
-	for _, template := range templates.([]interface{}) {
-		templateDeployment := template.(*StoreTemplateDeployment)
-		deploymentJSON := template.(*StoreTemplateDeployment).Deployment
-
-		deployment := &apis.Deployment{}
-		if err := json.Unmarshal([]byte(deploymentJSON), deployment); err != nil {
-			glog.Warningf("Skip loading template deployment %s: Unmarshal error", templateDeployment.TemplateId)
-			continue
-		}
-
-		glog.V(1).Infof("Recovered template %s deployment from store", templateDeployment.TemplateId)
-		server.Templates[templateDeployment.TemplateId] = deployment
-	}
-
 	shutdownTime := server.Config.GetString("shutDownTime")
 	if shutdownTime == "" {
 		shutdownTime = "12h"
@@ -1277,11 +1630,12 @@ func (server *Server) reloadClusterState() error {
 		}
 
 		deploymentInfo := &DeploymentInfo{
-			Deployer:   deployer,
-			Deployment: deployment,
-			TemplateId: storeDeployment.TemplateId,
-			Created:    time.Now(),
-			State:      ParseStateString(storeDeployment.Status),
+			Deployer:       deployer,
+			Deployment:     deployment,
+			TemplateId:     storeDeployment.TemplateId,
+			TemplateValues: storeDeployment.TemplateValues,
+			Created:        time.Now(),
+			State:          ParseStateString(storeDeployment.Status),
 		}
 
 		// Reload keypair
@@ -1295,6 +1649,7 @@ func (server *Server) reloadClusterState() error {
 				glog.Warningf("Skipping reloading because unable to load %s keyPair: %s", deploymentName, err.Error())
 				continue
 			}
+			cluster.GetHostKeys().Load(storeDeployment.HostKeys)
 		}
 
 		glog.Infof("Reloading cluster state for deployment: %s", deployment.Name)
@@ -0,0 +1,125 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+)
+
+// Defaults of the kubernetes stack created on AWS
+const (
+	DefaultAWSK8SDiskSizeGb           = 40
+	DefaultAWSK8SNetworkingProvider   = "weave"
+	DefaultAWSK8SAdminIngressLocation = "0.0.0.0/0"
+	DefaultAWSK8SBastionInstanceType  = "t2.micro"
+	DefaultAWSK8SKubernetesVersion    = "1.7.2"
+)
+
+var kubernetesVersionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+
+// AWSK8SDefinition storing the cloudformation settings of a kubernetes cluster on AWS
+type AWSK8SDefinition struct {
+	// Size of the root volume of each node, defaults to 40
+	DiskSizeGb int `form:"diskSizeGb" json:"diskSizeGb,omitempty"`
+	// Availability zones of the cluster, defaults to the first zone of the region
+	AvailabilityZones []string `form:"availabilityZones" json:"availabilityZones,omitempty"`
+	// CNI provider of the cluster, weave or calico, defaults to weave
+	NetworkingProvider string `form:"networkingProvider" json:"networkingProvider,omitempty"`
+	// CIDR allowed to ssh into the bastion and reach the API server, defaults to 0.0.0.0/0
+	AdminIngressLocation string `form:"adminIngressLocation" json:"adminIngressLocation,omitempty"`
+	BastionInstanceType  string `form:"bastionInstanceType" json:"bastionInstanceType,omitempty"`
+	// Selects the published stack template of this Kubernetes version, defaults to 1.7.2
+	KubernetesVersion string `form:"kubernetesVersion" json:"kubernetesVersion,omitempty"`
+	// Name of a template bundled in the deployer templates directory, used instead of the
+	// published template of the Kubernetes version when set
+	TemplateFile string `form:"templateFile" json:"templateFile,omitempty"`
+}
+
+// GetAWSK8SDefinition return the AWS kubernetes definition of the deployment with defaults
+// filled in for the unset fields, region is used for the default availability zone
+func (deployment *Deployment) GetAWSK8SDefinition(region string) AWSK8SDefinition {
+	definition := AWSK8SDefinition{}
+	if deployment.KubernetesDeployment != nil && deployment.AWSK8SDefinition != nil {
+		definition = *deployment.AWSK8SDefinition
+	}
+
+	if definition.DiskSizeGb == 0 {
+		definition.DiskSizeGb = DefaultAWSK8SDiskSizeGb
+	}
+
+	if len(definition.AvailabilityZones) == 0 {
+		definition.AvailabilityZones = []string{region + "a"}
+	}
+
+	if definition.NetworkingProvider == "" {
+		definition.NetworkingProvider = DefaultAWSK8SNetworkingProvider
+	}
+
+	if definition.AdminIngressLocation == "" {
+		definition.AdminIngressLocation = DefaultAWSK8SAdminIngressLocation
+	}
+
+	if definition.BastionInstanceType == "" {
+		definition.BastionInstanceType = DefaultAWSK8SBastionInstanceType
+	}
+
+	if definition.KubernetesVersion == "" {
+		definition.KubernetesVersion = DefaultAWSK8SKubernetesVersion
+	}
+
+	return definition
+}
+
+// ValidateAWSK8SDefinition checks the explicitly set fields of the AWS kubernetes definition
+func (deployment *Deployment) ValidateAWSK8SDefinition() error {
+	if deployment.KubernetesDeployment == nil {
+		return nil
+	}
+
+	definition := deployment.AWSK8SDefinition
+	if definition == nil {
+		return nil
+	}
+
+	if definition.DiskSizeGb < 0 || (definition.DiskSizeGb > 0 && definition.DiskSizeGb < 8) {
+		return fmt.Errorf("Disk size %d is smaller than 8 GB", definition.DiskSizeGb)
+	}
+
+	zones := map[string]bool{}
+	for _, zone := range definition.AvailabilityZones {
+		if zone == "" {
+			return errors.New("Empty availability zone")
+		}
+
+		if zones[zone] {
+			return fmt.Errorf("Availability zone %s is listed twice", zone)
+		}
+		zones[zone] = true
+	}
+
+	switch definition.NetworkingProvider {
+	case "", "weave", "calico":
+	default:
+		return fmt.Errorf("Unsupported networking provider %s", definition.NetworkingProvider)
+	}
+
+	if definition.AdminIngressLocation != "" {
+		if _, _, err := net.ParseCIDR(definition.AdminIngressLocation); err != nil {
+			return fmt.Errorf("Invalid admin ingress location %s: %s", definition.AdminIngressLocation, err.Error())
+		}
+	}
+
+	if definition.KubernetesVersion != "" && !kubernetesVersionPattern.MatchString(definition.KubernetesVersion) {
+		return fmt.Errorf("Invalid kubernetes version %s", definition.KubernetesVersion)
+	}
+
+	templateFile := definition.TemplateFile
+	if templateFile != "" &&
+		(filepath.Base(templateFile) != templateFile || templateFile == "." || templateFile == "..") {
+		return fmt.Errorf("Template file %s must be a file name without directories", templateFile)
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package apis
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestValidateECSOnlyDeployment(t *testing.T) {
+	family := "app"
+	deployment := &Deployment{
+		Name:        "test",
+		Region:      "us-east-1",
+		ClusterType: "ECS",
+		ClusterDefinition: ClusterDefinition{
+			Nodes: []ClusterNode{ClusterNode{Id: 1, InstanceType: "t2.medium"}},
+		},
+		NodeMapping: NodeMappings{NodeMapping{Id: 1, Task: family}},
+		ECSDeployment: &ECSDeployment{
+			TaskDefinitions: []ecs.RegisterTaskDefinitionInput{
+				ecs.RegisterTaskDefinitionInput{Family: &family},
+			},
+		},
+	}
+
+	if err := deployment.Validate(); err != nil {
+		t.Errorf("Unexpected error validating ECS deployment: %s", err.Error())
+	}
+
+	definition := deployment.GetAWSK8SDefinition(deployment.Region)
+	if definition.DiskSizeGb != DefaultAWSK8SDiskSizeGb {
+		t.Errorf("Unexpected default disk size %d", definition.DiskSizeGb)
+	}
+}
@@ -0,0 +1,128 @@
+package apis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DeployPhases groups tasks into ordered phases so that every task is deployed
+// after all the tasks it depends on. Tasks within the same phase have no
+// dependencies between each other and can be deployed together.
+func DeployPhases(dependencies map[string][]string) ([][]string, error) {
+	inDegrees := map[string]int{}
+	dependents := map[string][]string{}
+	for task, dependsOn := range dependencies {
+		if _, ok := inDegrees[task]; !ok {
+			inDegrees[task] = 0
+		}
+
+		for _, dependency := range dependsOn {
+			if _, ok := dependencies[dependency]; !ok {
+				return nil, fmt.Errorf("Task %s depends on unknown task %s", task, dependency)
+			}
+
+			if dependency == task {
+				return nil, fmt.Errorf("Task %s depends on itself", task)
+			}
+
+			inDegrees[task] += 1
+			dependents[dependency] = append(dependents[dependency], task)
+		}
+	}
+
+	phases := [][]string{}
+	current := []string{}
+	for task, inDegree := range inDegrees {
+		if inDegree == 0 {
+			current = append(current, task)
+		}
+	}
+
+	deployedCount := 0
+	for len(current) > 0 {
+		// Sorting keeps the deploy order deterministic within a phase
+		sort.Strings(current)
+		phases = append(phases, current)
+		deployedCount += len(current)
+
+		next := []string{}
+		for _, task := range current {
+			for _, dependent := range dependents[task] {
+				inDegrees[dependent] -= 1
+				if inDegrees[dependent] == 0 {
+					next = append(next, dependent)
+				}
+			}
+		}
+		current = next
+	}
+
+	if deployedCount != len(inDegrees) {
+		cyclicTasks := []string{}
+		for task, inDegree := range inDegrees {
+			if inDegree > 0 {
+				cyclicTasks = append(cyclicTasks, task)
+			}
+		}
+		sort.Strings(cyclicTasks)
+		return nil, fmt.Errorf("Found dependency cycle between tasks: %s", strings.Join(cyclicTasks, ", "))
+	}
+
+	return phases, nil
+}
+
+// TaskDependencies return the dependencies of every kubernetes task keyed by task family
+func (k8sDeployment *KubernetesDeployment) TaskDependencies() map[string][]string {
+	dependencies := map[string][]string{}
+	for _, task := range k8sDeployment.Kubernetes {
+		dependencies[task.Family] = append(dependencies[task.Family], task.DependsOn...)
+	}
+
+	return dependencies
+}
+
+// TaskDependencies return the dependencies of every ECS task definition keyed by task family
+func (ecsDeployment *ECSDeployment) TaskDependencies() map[string][]string {
+	dependencies := map[string][]string{}
+	for _, taskDefinition := range ecsDeployment.TaskDefinitions {
+		if taskDefinition.Family == nil {
+			continue
+		}
+
+		family := *taskDefinition.Family
+		dependencies[family] = append(dependencies[family], ecsDeployment.DependsOn[family]...)
+	}
+
+	return dependencies
+}
+
+// ValidateTaskDependencies checks the task dependencies of a deployment are resolvable and acyclic
+func (deployment *Deployment) ValidateTaskDependencies() error {
+	if deployment.KubernetesDeployment != nil {
+		if _, err := DeployPhases(deployment.KubernetesDeployment.TaskDependencies()); err != nil {
+			return err
+		}
+	}
+
+	if deployment.ECSDeployment != nil {
+		dependencies := deployment.ECSDeployment.TaskDependencies()
+		// Dependencies of unknown families would be dropped from the phases silently
+		families := []string{}
+		for family := range deployment.ECSDeployment.DependsOn {
+			families = append(families, family)
+		}
+		sort.Strings(families)
+		for _, family := range families {
+			if _, ok := dependencies[family]; !ok {
+				return fmt.Errorf("Dependencies set for unknown task %s", family)
+			}
+		}
+
+		if _, err := DeployPhases(dependencies); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
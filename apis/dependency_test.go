@@ -0,0 +1,75 @@
+package apis
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestDeployPhases(t *testing.T) {
+	phases, err := DeployPhases(map[string][]string{
+		"app":       []string{"mysql", "redis"},
+		"mysql":     nil,
+		"redis":     nil,
+		"benchmark": []string{"app"},
+	})
+	if err != nil {
+		t.Fatalf("Unable to get deploy phases: %s", err.Error())
+	}
+
+	expected := [][]string{
+		[]string{"mysql", "redis"},
+		[]string{"app"},
+		[]string{"benchmark"},
+	}
+	if !reflect.DeepEqual(expected, phases) {
+		t.Errorf("Unexpected deploy phases: %v", phases)
+	}
+}
+
+func TestDeployPhasesRejectsCycle(t *testing.T) {
+	_, err := DeployPhases(map[string][]string{
+		"app":   []string{"mysql"},
+		"mysql": []string{"app"},
+		"redis": nil,
+	})
+	if err == nil {
+		t.Error("Expected dependency cycle to be rejected")
+	}
+}
+
+func TestDeployPhasesRejectsUnknownTask(t *testing.T) {
+	_, err := DeployPhases(map[string][]string{
+		"app": []string{"mysql"},
+	})
+	if err == nil {
+		t.Error("Expected unknown dependency to be rejected")
+	}
+}
+
+func TestValidateTaskDependenciesRejectsUnknownFamily(t *testing.T) {
+	family := "app"
+	for _, deployment := range []*Deployment{
+		&Deployment{
+			KubernetesDeployment: &KubernetesDeployment{
+				Kubernetes: []KubernetesTask{
+					KubernetesTask{Family: "app", DependsOn: []string{"mysq"}},
+					KubernetesTask{Family: "mysql"},
+				},
+			},
+		},
+		&Deployment{
+			ECSDeployment: &ECSDeployment{
+				TaskDefinitions: []ecs.RegisterTaskDefinitionInput{
+					ecs.RegisterTaskDefinitionInput{Family: &family},
+				},
+				DependsOn: map[string][]string{"ap": []string{"app"}},
+			},
+		},
+	} {
+		if err := deployment.ValidateTaskDependencies(); err == nil {
+			t.Error("Expected unknown task family to be rejected")
+		}
+	}
+}
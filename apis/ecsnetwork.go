@@ -0,0 +1,119 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	// MaxECSAvailabilityZones is the number of zones the subnets of the ECS VPC range are split for
+	MaxECSAvailabilityZones = 8
+	// DefaultECSVpcCidrBlock is the range of the VPC created for ECS deployments
+	DefaultECSVpcCidrBlock = "172.31.0.0/16"
+)
+
+// ECSNetworkDefinition storing the layout of the VPC of an ECS deployment
+type ECSNetworkDefinition struct {
+	// Availability zones with a subnet each, defaults to the zones of the nodes, or a
+	// single subnet in a zone picked by AWS when nodes don't set any
+	AvailabilityZones []string `form:"availabilityZones" json:"availabilityZones,omitempty"`
+
+	// Launches nodes and Fargate tasks in private subnets, reaching the internet through a
+	// NAT gateway in each zone
+	PrivateSubnets bool `form:"privateSubnets" json:"privateSubnets,omitempty"`
+
+	// Range of the VPC created for the deployment, a /16 block split into a /20 subnet for
+	// each zone and tier. Defaults to 172.31.0.0/16, which peered VPCs often use as well.
+	VpcCidrBlock string `form:"vpcCidrBlock" json:"vpcCidrBlock,omitempty"`
+}
+
+// ECSVpcCidrBlock return the range of the VPC created for the ECS deployment
+func (deployment *Deployment) ECSVpcCidrBlock() string {
+	if deployment.ECSDeployment != nil && deployment.ECSDeployment.Network != nil &&
+		deployment.ECSDeployment.Network.VpcCidrBlock != "" {
+		return deployment.ECSDeployment.Network.VpcCidrBlock
+	}
+
+	return DefaultECSVpcCidrBlock
+}
+
+// ECSAvailabilityZones return the availability zones the ECS deployment has subnets in, an
+// empty list stands for a single zone picked by AWS
+func (deployment *Deployment) ECSAvailabilityZones() []string {
+	if deployment.ECSDeployment != nil && deployment.ECSDeployment.Network != nil &&
+		len(deployment.ECSDeployment.Network.AvailabilityZones) > 0 {
+		return deployment.ECSDeployment.Network.AvailabilityZones
+	}
+
+	zones := []string{}
+	found := map[string]bool{}
+	for _, node := range deployment.ClusterDefinition.Nodes {
+		if node.AvailabilityZone != "" && !found[node.AvailabilityZone] {
+			found[node.AvailabilityZone] = true
+			zones = append(zones, node.AvailabilityZone)
+		}
+	}
+
+	return zones
+}
+
+// HasPrivateSubnets return true if the ECS deployment runs its nodes in private subnets
+func (deployment *Deployment) HasPrivateSubnets() bool {
+	return deployment.ECSDeployment != nil && deployment.ECSDeployment.Network != nil &&
+		deployment.ECSDeployment.Network.PrivateSubnets
+}
+
+// ValidateECSNetwork checks the availability zones of the ECS deployment and its nodes
+func (deployment *Deployment) ValidateECSNetwork() error {
+	if deployment.ECSDeployment == nil {
+		return nil
+	}
+
+	zones := deployment.ECSAvailabilityZones()
+	if len(zones) > MaxECSAvailabilityZones {
+		return fmt.Errorf("Nodes can be spread over at most %d availability zones", MaxECSAvailabilityZones)
+	}
+
+	validZones := map[string]bool{}
+	for _, zone := range zones {
+		if !strings.HasPrefix(zone, deployment.Region) || len(zone) == len(deployment.Region) {
+			return fmt.Errorf("Availability zone %s is not in region %s", zone, deployment.Region)
+		}
+
+		if validZones[zone] {
+			return fmt.Errorf("Availability zone %s is listed twice", zone)
+		}
+		validZones[zone] = true
+	}
+
+	for _, node := range deployment.ClusterDefinition.Nodes {
+		if node.AvailabilityZone != "" && !validZones[node.AvailabilityZone] {
+			return fmt.Errorf("Availability zone %s of node %d is not a zone of the deployment",
+				node.AvailabilityZone, node.Id)
+		}
+	}
+
+	// Files are uploaded through ssh, which needs the nodes to be reachable
+	if deployment.HasPrivateSubnets() && len(deployment.Files) > 0 {
+		return errors.New("Files can't be uploaded to nodes in private subnets")
+	}
+
+	if network := deployment.ECSDeployment.Network; network != nil && network.VpcCidrBlock != "" {
+		if deployment.ExistingNetwork != nil {
+			return errors.New("VPC range can't be set when using an existing VPC")
+		}
+
+		ip, ipNet, err := net.ParseCIDR(network.VpcCidrBlock)
+		if err != nil || ip.To4() == nil {
+			return fmt.Errorf("Invalid VPC range %s", network.VpcCidrBlock)
+		}
+
+		if ones, _ := ipNet.Mask.Size(); ones != 16 || !ip.Equal(ipNet.IP) {
+			return fmt.Errorf("VPC range %s has to be a /16 block", network.VpcCidrBlock)
+		}
+	}
+
+	return nil
+}
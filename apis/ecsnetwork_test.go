@@ -0,0 +1,56 @@
+package apis
+
+import (
+	"testing"
+)
+
+func TestValidateECSNetwork(t *testing.T) {
+	tests := []struct {
+		name    string
+		network *ECSNetworkDefinition
+		nodes   []ClusterNode
+		files   []DeploymentFile
+		valid   bool
+	}{
+		{"default network", nil, []ClusterNode{{Id: 1}}, nil, true},
+		{"zones of nodes", nil, []ClusterNode{{Id: 1, AvailabilityZone: "us-east-1a"}}, nil, true},
+		{"zone of another region", nil, []ClusterNode{{Id: 1, AvailabilityZone: "us-west-2a"}}, nil, false},
+		{"region as zone", &ECSNetworkDefinition{AvailabilityZones: []string{"us-east-1"}}, nil, nil, false},
+		{"duplicate zone", &ECSNetworkDefinition{AvailabilityZones: []string{"us-east-1a", "us-east-1a"}}, nil, nil, false},
+		{
+			"too many zones",
+			&ECSNetworkDefinition{AvailabilityZones: []string{
+				"us-east-1a", "us-east-1b", "us-east-1c", "us-east-1d", "us-east-1e",
+				"us-east-1f", "us-east-1g", "us-east-1h", "us-east-1i",
+			}},
+			nil, nil, false,
+		},
+		{
+			"node outside of the zones",
+			&ECSNetworkDefinition{AvailabilityZones: []string{"us-east-1a"}},
+			[]ClusterNode{{Id: 1, AvailabilityZone: "us-east-1b"}},
+			nil, false,
+		},
+		{"files in private subnets", &ECSNetworkDefinition{PrivateSubnets: true}, nil, []DeploymentFile{{Path: "/tmp/a"}}, false},
+		{"vpc range", &ECSNetworkDefinition{VpcCidrBlock: "10.1.0.0/16"}, nil, nil, true},
+		{"vpc range not a /16", &ECSNetworkDefinition{VpcCidrBlock: "10.1.0.0/20"}, nil, nil, false},
+		{"vpc range not a block start", &ECSNetworkDefinition{VpcCidrBlock: "10.1.2.0/16"}, nil, nil, false},
+		{"invalid vpc range", &ECSNetworkDefinition{VpcCidrBlock: "10.1.0.0"}, nil, nil, false},
+	}
+
+	for _, test := range tests {
+		deployment := &Deployment{
+			Region:            "us-east-1",
+			ClusterDefinition: ClusterDefinition{Nodes: test.nodes},
+			Files:             test.files,
+			ECSDeployment:     &ECSDeployment{Network: test.network},
+		}
+
+		err := deployment.ValidateECSNetwork()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected network to be rejected", test.name)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Possible exposure types of a task port
+const (
+	ExposureClusterIP    = "ClusterIP"
+	ExposureNodePort     = "NodePort"
+	ExposureLoadBalancer = "LoadBalancer"
+	ExposureHeadless     = "Headless"
+)
+
+// PortExposure describes how a container port is exposed through kubernetes services
+type PortExposure struct {
+	// One of ClusterIP, NodePort, LoadBalancer or Headless, defaults to ClusterIP
+	Type string `form:"type" json:"type"`
+	// Port of the service, defaults to the host port of the container port
+	ServicePort int32 `form:"servicePort" json:"servicePort,omitempty"`
+	// Fixed node port for NodePort and LoadBalancer ports, allocated by kubernetes when empty
+	NodePort int32 `form:"nodePort" json:"nodePort,omitempty"`
+	// TCP or UDP, defaults to TCP
+	Protocol string `form:"protocol" json:"protocol,omitempty"`
+	// Source CIDRs allowed to reach a LoadBalancer port, open to everyone when empty
+	SourceRanges []string `form:"sourceRanges" json:"sourceRanges,omitempty"`
+	// None or ClientIP, defaults to None
+	SessionAffinity string `form:"sessionAffinity" json:"sessionAffinity,omitempty"`
+}
+
+// IsPublic return true when the port can be reached from outside of the cluster
+func (exposure PortExposure) IsPublic() bool {
+	return exposure.Type == ExposureNodePort || exposure.Type == ExposureLoadBalancer
+}
+
+// GetPortExposure return the exposure of the container port at the given index. Ports without
+// an explicit exposure fall back to PortTypes, where public ports are exposed by a load balancer.
+// The second return value is false when the exposure is derived from PortTypes.
+func (task *KubernetesTask) GetPortExposure(index int) (PortExposure, bool) {
+	if index < len(task.Exposures) {
+		exposure := task.Exposures[index]
+		if exposure.Type == "" {
+			exposure.Type = ExposureClusterIP
+		}
+		return exposure, true
+	}
+
+	if index < len(task.PortTypes) && task.PortTypes[index] == 1 {
+		return PortExposure{Type: ExposureLoadBalancer}, false
+	}
+
+	return PortExposure{Type: ExposureClusterIP}, false
+}
+
+func (exposure PortExposure) validate() error {
+	switch exposure.Type {
+	case "", ExposureClusterIP, ExposureNodePort, ExposureLoadBalancer, ExposureHeadless:
+	default:
+		return fmt.Errorf("Unknown exposure type %s", exposure.Type)
+	}
+
+	switch strings.ToUpper(exposure.Protocol) {
+	case "", "TCP", "UDP":
+	default:
+		return fmt.Errorf("Unsupported protocol %s", exposure.Protocol)
+	}
+
+	switch exposure.SessionAffinity {
+	case "", "None", "ClientIP":
+	default:
+		return fmt.Errorf("Unknown session affinity %s", exposure.SessionAffinity)
+	}
+
+	if exposure.ServicePort < 0 || exposure.ServicePort > 65535 {
+		return fmt.Errorf("Invalid service port %d", exposure.ServicePort)
+	}
+
+	if exposure.NodePort != 0 {
+		if !exposure.IsPublic() {
+			return fmt.Errorf("Node port can only be set for %s or %s ports", ExposureNodePort, ExposureLoadBalancer)
+		}
+
+		if exposure.NodePort < 30000 || exposure.NodePort > 32767 {
+			return fmt.Errorf("Node port %d is outside of the range 30000-32767", exposure.NodePort)
+		}
+	}
+
+	if len(exposure.SourceRanges) > 0 && exposure.Type != ExposureLoadBalancer {
+		return fmt.Errorf("Source ranges can only be set for %s ports", ExposureLoadBalancer)
+	}
+
+	for _, sourceRange := range exposure.SourceRanges {
+		if _, _, err := net.ParseCIDR(sourceRange); err != nil {
+			return fmt.Errorf("Invalid source range %s: %s", sourceRange, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// ValidatePortExposures checks the port exposures of every kubernetes task are supported
+func (deployment *Deployment) ValidatePortExposures() error {
+	if deployment.KubernetesDeployment == nil {
+		return nil
+	}
+
+	for _, task := range deployment.KubernetesDeployment.Kubernetes {
+		for i, exposure := range task.Exposures {
+			if err := exposure.validate(); err != nil {
+				return fmt.Errorf("Invalid exposure of port %d in task %s: %s", i, task.Family, err.Error())
+			}
+
+			// GCP nodes only open fixed node ports in their firewall
+			if deployment.ClusterType == "GCP" && exposure.Type == ExposureNodePort && exposure.NodePort == 0 {
+				return fmt.Errorf("Node port of port %d in task %s has to be set on GCP clusters", i, task.Family)
+			}
+		}
+
+		if err := validateServiceExposures(task.Exposures); err != nil {
+			return fmt.Errorf("Invalid exposures of task %s: %s", task.Family, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// validateServiceExposures checks the exposures of the ports of a task agree on the settings
+// of the internal service they share, which is headless and keeps session affinity for all of
+// its ports or none of them
+func validateServiceExposures(exposures []PortExposure) error {
+	headless := false
+	clusterIP := false
+	sessionAffinities := map[string]bool{}
+	for _, exposure := range exposures {
+		switch exposure.Type {
+		case ExposureHeadless:
+			headless = true
+		case "", ExposureClusterIP:
+			clusterIP = true
+		}
+
+		sessionAffinity := exposure.SessionAffinity
+		if sessionAffinity == "" {
+			sessionAffinity = "None"
+		}
+		sessionAffinities[sessionAffinity] = true
+	}
+
+	if headless && clusterIP {
+		return fmt.Errorf("%s and %s ports share one service and can't be mixed", ExposureHeadless, ExposureClusterIP)
+	}
+
+	if len(sessionAffinities) > 1 {
+		return errors.New("Ports share one service and have to set the same session affinity")
+	}
+
+	return nil
+}
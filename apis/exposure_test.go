@@ -0,0 +1,135 @@
+package apis
+
+import (
+	"testing"
+)
+
+func TestValidatePortExposure(t *testing.T) {
+	tests := []struct {
+		name     string
+		exposure PortExposure
+		valid    bool
+	}{
+		{"default", PortExposure{}, true},
+		{"cluster ip", PortExposure{Type: ExposureClusterIP, ServicePort: 8080}, true},
+		{"headless", PortExposure{Type: ExposureHeadless}, true},
+		{"unknown type", PortExposure{Type: "ExternalName"}, false},
+		{"udp", PortExposure{Protocol: "udp"}, true},
+		{"sctp", PortExposure{Protocol: "SCTP"}, false},
+		{"client ip affinity", PortExposure{SessionAffinity: "ClientIP"}, true},
+		{"unknown affinity", PortExposure{SessionAffinity: "Cookie"}, false},
+		{"negative service port", PortExposure{ServicePort: -1}, false},
+		{"service port out of range", PortExposure{ServicePort: 65536}, false},
+		{"fixed node port", PortExposure{Type: ExposureNodePort, NodePort: 30080}, true},
+		{"fixed load balancer node port", PortExposure{Type: ExposureLoadBalancer, NodePort: 32767}, true},
+		{"node port of cluster ip", PortExposure{Type: ExposureClusterIP, NodePort: 30080}, false},
+		{"node port below range", PortExposure{Type: ExposureNodePort, NodePort: 29999}, false},
+		{"node port above range", PortExposure{Type: ExposureNodePort, NodePort: 32768}, false},
+		{"load balancer source ranges", PortExposure{Type: ExposureLoadBalancer,
+			SourceRanges: []string{"10.0.0.0/8", "192.168.1.0/24"}}, true},
+		{"node port source ranges", PortExposure{Type: ExposureNodePort, SourceRanges: []string{"10.0.0.0/8"}}, false},
+		{"invalid source range", PortExposure{Type: ExposureLoadBalancer, SourceRanges: []string{"10.0.0.0"}}, false},
+	}
+
+	for _, test := range tests {
+		err := test.exposure.validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected exposure to be rejected", test.name)
+		}
+	}
+}
+
+func TestValidateServiceExposures(t *testing.T) {
+	tests := []struct {
+		name      string
+		exposures []PortExposure
+		valid     bool
+	}{
+		{"no exposures", nil, true},
+		{"cluster ip ports", []PortExposure{{}, {Type: ExposureClusterIP}}, true},
+		{"headless ports", []PortExposure{{Type: ExposureHeadless}, {Type: ExposureHeadless}}, true},
+		{"headless and cluster ip", []PortExposure{{Type: ExposureHeadless}, {Type: ExposureClusterIP}}, false},
+		{"headless and default", []PortExposure{{Type: ExposureHeadless}, {}}, false},
+		{"headless and public ports", []PortExposure{{Type: ExposureHeadless}, {Type: ExposureLoadBalancer}}, true},
+		{"same affinity", []PortExposure{{SessionAffinity: "ClientIP"}, {SessionAffinity: "ClientIP"}}, true},
+		{"default and none affinity", []PortExposure{{}, {SessionAffinity: "None"}}, true},
+		{"mixed affinity", []PortExposure{{SessionAffinity: "ClientIP"}, {}}, false},
+	}
+
+	for _, test := range tests {
+		err := validateServiceExposures(test.exposures)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected exposures to be rejected", test.name)
+		}
+	}
+}
+
+func TestValidatePortExposures(t *testing.T) {
+	tests := []struct {
+		name        string
+		clusterType string
+		exposures   []PortExposure
+		valid       bool
+	}{
+		{"aws node port", "K8S", []PortExposure{{Type: ExposureNodePort}}, true},
+		{"gcp node port", "GCP", []PortExposure{{Type: ExposureNodePort}}, false},
+		{"gcp fixed node port", "GCP", []PortExposure{{Type: ExposureNodePort, NodePort: 30080}}, true},
+		{"gcp load balancer", "GCP", []PortExposure{{Type: ExposureLoadBalancer}}, true},
+		{"invalid exposure", "K8S", []PortExposure{{Type: "ExternalName"}}, false},
+		{"mixed service exposures", "K8S", []PortExposure{{Type: ExposureHeadless}, {}}, false},
+	}
+
+	for _, test := range tests {
+		deployment := &Deployment{
+			ClusterType: test.clusterType,
+			KubernetesDeployment: &KubernetesDeployment{
+				Kubernetes: []KubernetesTask{
+					{Family: "app", Exposures: test.exposures},
+				},
+			},
+		}
+
+		err := deployment.ValidatePortExposures()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected exposures to be rejected", test.name)
+		}
+	}
+
+	if err := (&Deployment{}).ValidatePortExposures(); err != nil {
+		t.Errorf("Unexpected error of deployment without kubernetes tasks: %s", err.Error())
+	}
+}
+
+func TestGetPortExposure(t *testing.T) {
+	task := &KubernetesTask{
+		Family:    "app",
+		PortTypes: []int{0, 1, 1, 0},
+		Exposures: []PortExposure{{}, {Type: ExposureNodePort}},
+	}
+
+	tests := []struct {
+		index    int
+		expected string
+		explicit bool
+	}{
+		{0, ExposureClusterIP, true},
+		{1, ExposureNodePort, true},
+		{2, ExposureLoadBalancer, false},
+		{3, ExposureClusterIP, false},
+		{4, ExposureClusterIP, false},
+	}
+
+	for _, test := range tests {
+		exposure, explicit := task.GetPortExposure(test.index)
+		if exposure.Type != test.expected || explicit != test.explicit {
+			t.Errorf("port %d: expected %s explicit %v, got %s explicit %v",
+				test.index, test.expected, test.explicit, exposure.Type, explicit)
+		}
+	}
+}
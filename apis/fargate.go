@@ -0,0 +1,78 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Launch types of the ECS services started for node mappings
+const (
+	LaunchTypeEC2     = "EC2"
+	LaunchTypeFargate = "FARGATE"
+)
+
+// IsFargate return true if the service of the mapping runs on Fargate instead of a cluster node
+func (mapping NodeMapping) IsFargate() bool {
+	return mapping.LaunchType == LaunchTypeFargate
+}
+
+// FargateTasks return the families of the tasks running on Fargate
+func (deployment *Deployment) FargateTasks() map[string]bool {
+	families := map[string]bool{}
+	for _, mapping := range deployment.NodeMapping {
+		if mapping.IsFargate() {
+			families[mapping.Task] = true
+		}
+	}
+
+	return families
+}
+
+// ValidateLaunchTypes checks the launch type of each node mapping. A task family has to run
+// with a single launch type, and Fargate tasks need the task level cpu and memory set.
+func (deployment *Deployment) ValidateLaunchTypes() error {
+	launchTypes := map[string]string{}
+	for _, mapping := range deployment.NodeMapping {
+		launchType := mapping.LaunchType
+		switch launchType {
+		case "":
+			launchType = LaunchTypeEC2
+		case LaunchTypeEC2, LaunchTypeFargate:
+		default:
+			return fmt.Errorf("Unsupported launch type %s of task %s", mapping.LaunchType, mapping.Task)
+		}
+
+		if existing, ok := launchTypes[mapping.Task]; ok && existing != launchType {
+			return fmt.Errorf("Task %s is mapped with both %s and %s launch types", mapping.Task, existing, launchType)
+		}
+		launchTypes[mapping.Task] = launchType
+	}
+
+	fargateTasks := deployment.FargateTasks()
+	if len(fargateTasks) == 0 {
+		return nil
+	}
+
+	if deployment.ECSDeployment == nil {
+		return errors.New("Fargate launch type is only supported by ECS deployments")
+	}
+
+	for _, taskDefinition := range deployment.ECSDeployment.TaskDefinitions {
+		family := aws.StringValue(taskDefinition.Family)
+		if !fargateTasks[family] {
+			continue
+		}
+
+		if aws.StringValue(taskDefinition.Cpu) == "" || aws.StringValue(taskDefinition.Memory) == "" {
+			return fmt.Errorf("Fargate task %s has to set both cpu and memory", family)
+		}
+
+		if networkMode := aws.StringValue(taskDefinition.NetworkMode); networkMode != "" && networkMode != "awsvpc" {
+			return fmt.Errorf("Fargate task %s only supports awsvpc network mode", family)
+		}
+	}
+
+	return nil
+}
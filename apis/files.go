@@ -0,0 +1,134 @@
+package apis
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+var fileOwnerPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*(:[A-Za-z_][A-Za-z0-9_.-]*)?$`)
+
+// DeploymentFile is an uploaded file or file url copied to the nodes of a deployment
+type DeploymentFile struct {
+	FileId  string `json:"fileId"`
+	FileUrl string `json:"fileUrl"`
+	Path    string `json:"path"`
+	// Template files are rendered with the variables of each node they are uploaded to
+	Template bool `json:"template,omitempty"`
+
+	// Nodes the file is uploaded to, along with the nodes matching the selector.
+	// Files without node ids or selector are uploaded to every node.
+	NodeIds []int `json:"nodeIds,omitempty"`
+	// Labels a node of the cluster definition has to have all of to get the file
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Octal permissions of the file, e.g. "0644"
+	Mode string `json:"mode,omitempty"`
+	// Owner of the file as user or user:group, applied to every extracted file of archives
+	Owner string `json:"owner,omitempty"`
+	// Extract the file as a tar, tar.gz or zip archive into the Path directory
+	Extract bool `json:"extract,omitempty"`
+}
+
+// FileMode return the permissions set on the file, or 0 when the mode isn't set
+func (file *DeploymentFile) FileMode() (os.FileMode, error) {
+	if file.Mode == "" {
+		return 0, nil
+	}
+
+	mode, err := strconv.ParseUint(file.Mode, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, fmt.Errorf("Invalid file mode %s", file.Mode)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// TargetsNode return if the file is uploaded to the node
+func (file *DeploymentFile) TargetsNode(node *ClusterNode) bool {
+	if len(file.NodeIds) == 0 && len(file.NodeSelector) == 0 {
+		return true
+	}
+
+	for _, nodeId := range file.NodeIds {
+		if nodeId == node.Id {
+			return true
+		}
+	}
+
+	if len(file.NodeSelector) == 0 {
+		return false
+	}
+
+	for key, value := range file.NodeSelector {
+		if label, ok := node.Labels[key]; !ok || label != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NodeFiles return the files of the deployment uploaded to the node
+func (deployment *Deployment) NodeFiles(nodeId int) []DeploymentFile {
+	node := &ClusterNode{Id: nodeId}
+	for i := range deployment.ClusterDefinition.Nodes {
+		if deployment.ClusterDefinition.Nodes[i].Id == nodeId {
+			node = &deployment.ClusterDefinition.Nodes[i]
+			break
+		}
+	}
+
+	files := []DeploymentFile{}
+	for _, file := range deployment.Files {
+		if file.TargetsNode(node) {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+func (deployment *Deployment) ValidateFiles() error {
+	nodeIds := map[int]bool{}
+	for _, node := range deployment.ClusterDefinition.Nodes {
+		nodeIds[node.Id] = true
+	}
+
+	for i, file := range deployment.Files {
+		if file.Path == "" {
+			return fmt.Errorf("Path of file %d is required", i)
+		}
+
+		if (file.FileId == "") == (file.FileUrl == "") {
+			return fmt.Errorf("File %s requires either a fileId or a fileUrl", file.Path)
+		}
+
+		for _, nodeId := range file.NodeIds {
+			if !nodeIds[nodeId] {
+				return fmt.Errorf("Unable to find node %d of file %s in cluster", nodeId, file.Path)
+			}
+		}
+
+		for key := range file.NodeSelector {
+			if key == "" {
+				return fmt.Errorf("Empty label in node selector of file %s", file.Path)
+			}
+		}
+
+		if _, err := file.FileMode(); err != nil {
+			return err
+		}
+
+		if file.Extract && file.Mode != "" {
+			return fmt.Errorf("Mode of file %s can't be set on an extracted archive", file.Path)
+		}
+
+		if file.Owner != "" && !fileOwnerPattern.MatchString(file.Owner) {
+			return fmt.Errorf("Invalid owner %s of file %s", file.Owner, file.Path)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package apis
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFileMode(t *testing.T) {
+	tests := []struct {
+		mode     string
+		expected os.FileMode
+		valid    bool
+	}{
+		{"", 0, true},
+		{"0644", 0644, true},
+		{"755", 0755, true},
+		{"4755", 04755, true},
+		{"0888", 0, false},
+		{"10000", 0, false},
+		{"rw-r--r--", 0, false},
+	}
+
+	for _, test := range tests {
+		file := &DeploymentFile{Path: "/tmp/a", Mode: test.mode}
+		mode, err := file.FileMode()
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %v, got error %v", test.mode, test.valid, err)
+			continue
+		}
+		if mode != test.expected {
+			t.Errorf("%q: expected mode %o, got %o", test.mode, test.expected, mode)
+		}
+	}
+}
+
+func TestTargetsNode(t *testing.T) {
+	node := &ClusterNode{Id: 2, Labels: map[string]string{"role": "db", "zone": "a"}}
+
+	tests := []struct {
+		name     string
+		file     DeploymentFile
+		expected bool
+	}{
+		{"every node", DeploymentFile{}, true},
+		{"node id", DeploymentFile{NodeIds: []int{1, 2}}, true},
+		{"other node id", DeploymentFile{NodeIds: []int{1}}, false},
+		{"matching selector", DeploymentFile{NodeSelector: map[string]string{"role": "db"}}, true},
+		{"all labels of selector", DeploymentFile{NodeSelector: map[string]string{"role": "db", "zone": "a"}}, true},
+		{"other label value", DeploymentFile{NodeSelector: map[string]string{"role": "web"}}, false},
+		{"missing label", DeploymentFile{NodeSelector: map[string]string{"disk": "ssd"}}, false},
+		{"other node id or matching selector", DeploymentFile{NodeIds: []int{1},
+			NodeSelector: map[string]string{"role": "db"}}, true},
+		{"node id or other selector", DeploymentFile{NodeIds: []int{2},
+			NodeSelector: map[string]string{"role": "web"}}, true},
+	}
+
+	for _, test := range tests {
+		if targets := test.file.TargetsNode(node); targets != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, targets)
+		}
+	}
+}
+
+func TestNodeFiles(t *testing.T) {
+	deployment := &Deployment{
+		ClusterDefinition: ClusterDefinition{
+			Nodes: []ClusterNode{
+				{Id: 1, Labels: map[string]string{"role": "web"}},
+				{Id: 2, Labels: map[string]string{"role": "db"}},
+			},
+		},
+		Files: []DeploymentFile{
+			{FileId: "all", Path: "/tmp/all"},
+			{FileId: "first", Path: "/tmp/first", NodeIds: []int{1}},
+			{FileId: "db", Path: "/tmp/db", NodeSelector: map[string]string{"role": "db"}},
+		},
+	}
+
+	tests := []struct {
+		nodeId   int
+		expected []string
+	}{
+		{1, []string{"all", "first"}},
+		{2, []string{"all", "db"}},
+		// Nodes outside of the cluster definition have no labels
+		{3, []string{"all"}},
+	}
+
+	for _, test := range tests {
+		fileIds := []string{}
+		for _, file := range deployment.NodeFiles(test.nodeId) {
+			fileIds = append(fileIds, file.FileId)
+		}
+		if !reflect.DeepEqual(fileIds, test.expected) {
+			t.Errorf("node %d: expected files %v, got %v", test.nodeId, test.expected, fileIds)
+		}
+	}
+}
+
+func TestValidateFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  DeploymentFile
+		valid bool
+	}{
+		{"uploaded file", DeploymentFile{FileId: "config", Path: "/tmp/config"}, true},
+		{"file url", DeploymentFile{FileUrl: "s3://bucket/config", Path: "/tmp/config"}, true},
+		{"no file id or url", DeploymentFile{Path: "/tmp/config"}, false},
+		{"file id and url", DeploymentFile{FileId: "config", FileUrl: "s3://bucket/config", Path: "/tmp/config"}, false},
+		{"no path", DeploymentFile{FileId: "config"}, false},
+		{"node of the cluster", DeploymentFile{FileId: "config", Path: "/tmp/config", NodeIds: []int{1}}, true},
+		{"unknown node", DeploymentFile{FileId: "config", Path: "/tmp/config", NodeIds: []int{3}}, false},
+		{"empty selector label", DeploymentFile{FileId: "config", Path: "/tmp/config",
+			NodeSelector: map[string]string{"": "db"}}, false},
+		{"mode", DeploymentFile{FileId: "config", Path: "/tmp/config", Mode: "0600"}, true},
+		{"invalid mode", DeploymentFile{FileId: "config", Path: "/tmp/config", Mode: "0999"}, false},
+		{"mode of archive", DeploymentFile{FileId: "config", Path: "/tmp/config", Extract: true, Mode: "0600"}, false},
+		{"archive", DeploymentFile{FileId: "config", Path: "/tmp/config", Extract: true, Owner: "app"}, true},
+		{"owner and group", DeploymentFile{FileId: "config", Path: "/tmp/config", Owner: "app:staff"}, true},
+		{"invalid owner", DeploymentFile{FileId: "config", Path: "/tmp/config", Owner: "app;rm"}, false},
+	}
+
+	for _, test := range tests {
+		deployment := &Deployment{
+			ClusterDefinition: ClusterDefinition{Nodes: []ClusterNode{{Id: 1}, {Id: 2}}},
+			Files:             []DeploymentFile{test.file},
+		}
+
+		err := deployment.ValidateFiles()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected file to be rejected", test.name)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package apis
+
+import (
+	"errors"
+)
+
+// ValidateIngress checks the ingress exposure is only set on clusters that record the public
+// urls of the ingress controller, other clusters would ignore it
+func (deployment *Deployment) ValidateIngress() error {
+	if deployment.KubernetesDeployment == nil || deployment.KubernetesDeployment.Ingress == nil {
+		return nil
+	}
+
+	if deployment.ClusterType != "K8S" {
+		return errors.New("Ingress is not supported on " + deployment.ClusterType + " clusters")
+	}
+
+	return nil
+}
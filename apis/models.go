@@ -1,7 +1,9 @@
 package apis
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
 
 	"k8s.io/client-go/pkg/api/v1"
 	appsv1beta1 "k8s.io/client-go/pkg/apis/apps/v1beta1"
@@ -14,6 +16,10 @@ type ClusterNode struct {
 	Id           int               `form:"id" json:"id" binding:"required"`
 	InstanceType string            `form:"instanceType" json:"instanceType" binding:"required"`
 	Labels       map[string]string `form:"labels" json:"labels" binding:"required"`
+
+	// Availability zone of the node in ECS deployments, nodes without one are spread over
+	// the zones of the deployment
+	AvailabilityZone string `form:"availabilityZone" json:"availabilityZone,omitempty"`
 }
 
 // ClusterDefinition storing the information of a cluster
@@ -25,9 +31,21 @@ type GCPDefinition struct {
 	ClusterVersion string `form:"clusterVersion" json:"clusterVersion"`
 }
 
+// EKSDefinition storing the settings of an EKS control plane and its managed node groups
+type EKSDefinition struct {
+	// Kubernetes version of the control plane, the latest version supported by EKS when empty
+	ClusterVersion string `form:"clusterVersion" json:"clusterVersion"`
+	// Size of the root volume of each node, defaults to 20 GB
+	DiskSizeGb int `form:"diskSizeGb" json:"diskSizeGb,omitempty"`
+}
+
 type NodeMapping struct {
 	Id   int    `json:"id"`
 	Task string `json:"task"`
+
+	// Launch type of the ECS service of the task, EC2 or FARGATE, defaults to EC2.
+	// Fargate services don't run on a cluster node, so the id is ignored.
+	LaunchType string `json:"launchType,omitempty"`
 }
 
 // Service return a string with suffix "-service"
@@ -43,6 +61,25 @@ func (mapping NodeMapping) ImageIdAttribute() string {
 // ECSDeployment storing the information of a ECS deployment
 type ECSDeployment struct {
 	TaskDefinitions []ecs.RegisterTaskDefinitionInput `form:"taskDefinitions" json:"taskDefinitions" binding:"required"`
+
+	// Maps task family to the task families that need to be running before it starts
+	DependsOn map[string][]string `form:"dependsOn" json:"dependsOn,omitempty"`
+
+	// Maps task family to the desired count of the task, split across the services of its
+	// node mappings. Every service runs one task when the family isn't set.
+	DesiredCounts map[string]int `form:"desiredCounts" json:"desiredCounts,omitempty"`
+
+	Network *ECSNetworkDefinition `form:"network" json:"network,omitempty"`
+}
+
+// ServiceDesiredCounts return the desired count of each of the given number of services of a task family
+func (ecsDeployment *ECSDeployment) ServiceDesiredCounts(family string, services int) []int {
+	count, ok := ecsDeployment.DesiredCounts[family]
+	if !ok {
+		count = services
+	}
+
+	return SplitReplicas(count, services)
 }
 
 type KubernetesTask struct {
@@ -51,8 +88,19 @@ type KubernetesTask struct {
 	Deployment  *v1beta1.Deployment      `form:"deployment" json:"deployment,omitempty"`
 	Family      string                   `form:"family" json:"family" binding:"required"`
 
-	// Type of each port opened by a container: 0 - private, 1 - public
+	// Type of each port opened by a container: 0 - private, 1 - public.
+	// Deprecated in favor of Exposures, only used for ports without an exposure.
 	PortTypes []int `form:"portTypes" json:"portTypes"`
+
+	// Exposure of each port opened by a container
+	Exposures []PortExposure `form:"exposures" json:"exposures,omitempty"`
+
+	// Families of the tasks that need to be ready before this task is deployed
+	DependsOn []string `form:"dependsOn" json:"dependsOn,omitempty"`
+
+	// Ingress path of each public port when the deployment exposes ports through ingress,
+	// defaults to /<service>/port<index>
+	IngressPaths []string `form:"ingressPaths" json:"ingressPaths,omitempty"`
 }
 
 func (task *KubernetesTask) GetPorts() []v1.ContainerPort {
@@ -79,10 +127,26 @@ func (task *KubernetesTask) GetPorts() []v1.ContainerPort {
 
 // KubernetesDeployment storing the information of a Kubernetes deployment
 type KubernetesDeployment struct {
-	Kubernetes          []KubernetesTask `form:"taskDefinitions" json:"taskDefinitions" binding:"required"`
-	Secrets             []v1.Secret      `form:"secrets" json:"secrets"`
-	SkipDeleteOnFailure bool             `form:"skipdDeleteOnFailure" json:"skipDeleteOnFailure"`
-	GCPDefinition       *GCPDefinition   `form:"gcpDefinition" json:"gcpDefinition"`
+	Kubernetes          []KubernetesTask  `form:"taskDefinitions" json:"taskDefinitions" binding:"required"`
+	Secrets             []v1.Secret       `form:"secrets" json:"secrets"`
+	SkipDeleteOnFailure bool              `form:"skipdDeleteOnFailure" json:"skipDeleteOnFailure"`
+	GCPDefinition       *GCPDefinition    `form:"gcpDefinition" json:"gcpDefinition"`
+	AWSK8SDefinition    *AWSK8SDefinition `form:"awsK8sDefinition" json:"awsK8sDefinition,omitempty"`
+	EKSDefinition       *EKSDefinition    `form:"eksDefinition" json:"eksDefinition,omitempty"`
+
+	// Exposes public ports through a single ingress controller instead of a load balancer per port
+	Ingress *IngressDefinition `form:"ingress" json:"ingress,omitempty"`
+
+	// Maps task family to the replicas the task is scaled to, split across the deployments of
+	// its node mappings. Deployments use the replicas of their spec when the family isn't set.
+	TaskReplicas map[string]int32 `form:"taskReplicas" json:"taskReplicas,omitempty"`
+}
+
+// IngressDefinition storing the information of the ingress controller of a deployment
+type IngressDefinition struct {
+	// Host matched by the ingress rules, rules match any host when empty
+	Host            string `form:"host" json:"host"`
+	ControllerImage string `form:"controllerImage" json:"controllerImage"`
 }
 
 type NodeMappings []NodeMapping
@@ -93,34 +157,110 @@ func (d NodeMappings) Less(i, j int) bool {
 }
 func (d NodeMappings) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
 
+// VPCPeering peers the deployment VPC with a VPC of another account or region. With the gcp
+// provider, the target owner is the project and the target VPC the network peered with.
 type VPCPeering struct {
+	// Cloud of the target VPC, aws or gcp, defaults to the cloud of the deployment
+	Provider      string `json:"provider,omitempty"`
 	TargetOwnerId string `json:"targetOwnerId"`
 	TargetVpcId   string `json:"targetVpcId"`
+
+	// Region of the target VPC, defaults to the region of the deployment
+	TargetRegion string `json:"targetRegion,omitempty"`
+	// Name of the aws profile in the deployer config accepting the peering in the target
+	// account, the deployer credentials are used when empty
+	TargetProfile string `json:"targetProfile,omitempty"`
+	// Route tables of the target VPC routing to the deployment
+	TargetRouteTableIds []string `json:"targetRouteTableIds,omitempty"`
+	// Security groups of the target VPC opened to the deployment
+	TargetSecurityGroupIds []string `json:"targetSecurityGroupIds,omitempty"`
+
+	// Route tables of the deployment VPC routing to the target, required in existing VPCs.
+	// Every route table of a VPC created by the deployer is routed otherwise.
+	RouteTableIds []string `json:"routeTableIds,omitempty"`
+
+	// Protocols and ports opened to the peered network on both sides
+	Ports []PeeringPort `json:"ports"`
 }
 
 type Deployment struct {
-	UserId      string `form:"userId" json:"userId"`
-	Name        string `form:"name" json:"name" binding:"required"`
-	Region      string `form:"region" json:"region" binding:"required"`
-	ClusterType string `form:"clusterType" json:"clusterType"`
-	Files       []struct {
-		FileId  string `json:"fileId"`
-		FileUrl string `json:"fileUrl"`
-		Path    string `json:"path"`
-	} `form:"files" json:"files"`
+	UserId            string            `form:"userId" json:"userId"`
+	Name              string            `form:"name" json:"name" binding:"required"`
+	Region            string            `form:"region" json:"region" binding:"required"`
+	ClusterType       string            `form:"clusterType" json:"clusterType"`
+	Files             []DeploymentFile  `form:"files" json:"files"`
 	AllowedPorts      []int             `form:"allowedPorts" json:"allowedPorts"`
 	ClusterDefinition ClusterDefinition `form:"clusterDefinition" json:"clusterDefinition" binding:"required"`
 	NodeMapping       NodeMappings      `form:"nodeMapping" json:"nodeMapping" binding:"required"`
 	IamRole           `form:"iamRole" json:"iamRole" binding:"required"`
 
+	// AMI of the ECS container instances, defaults to the current ECS optimized image of the
+	// region. The ECS optimized image doesn't ship Weave, set an image with Weave to use it.
+	ImageId string `form:"imageId" json:"imageId,omitempty"`
+
 	*ECSDeployment        `form:"ecs" json:"ecs,omitempty"`
 	*KubernetesDeployment `form:"kubernetes" json:"kubernetes,omitempty"`
 
 	*VPCPeering `json:"vpcPeering,omitempty"`
 
+	ExistingNetwork *ExistingNetwork `form:"existingNetwork" json:"existingNetwork,omitempty"`
+
 	ShutDownTime string `form:"shutDownTime" json:"shutDownTime,omitempty"`
 }
 
+var imageIdPattern = regexp.MustCompile(`^ami-[0-9a-f]+$`)
+
+// Validate checks the deployment definition before it is deployed
+func (deployment *Deployment) Validate() error {
+	if err := deployment.ValidateTaskDependencies(); err != nil {
+		return errors.New("Invalid task dependencies: " + err.Error())
+	}
+
+	if err := deployment.ValidatePortExposures(); err != nil {
+		return errors.New("Invalid port exposures: " + err.Error())
+	}
+
+	if err := deployment.ValidateIngress(); err != nil {
+		return errors.New("Invalid ingress: " + err.Error())
+	}
+
+	if err := deployment.ValidateAWSK8SDefinition(); err != nil {
+		return errors.New("Invalid aws kubernetes definition: " + err.Error())
+	}
+
+	if err := deployment.ValidateLaunchTypes(); err != nil {
+		return errors.New("Invalid launch types: " + err.Error())
+	}
+
+	if deployment.ImageId != "" {
+		if deployment.ClusterType != "ECS" {
+			return errors.New("Image id is only supported in ECS deployments")
+		}
+
+		if !imageIdPattern.MatchString(deployment.ImageId) {
+			return fmt.Errorf("Invalid image id %s", deployment.ImageId)
+		}
+	}
+
+	if err := deployment.ValidateECSNetwork(); err != nil {
+		return errors.New("Invalid ecs network: " + err.Error())
+	}
+
+	if err := deployment.ValidateExistingNetwork(); err != nil {
+		return errors.New("Invalid existing network: " + err.Error())
+	}
+
+	if err := deployment.ValidateVPCPeering(); err != nil {
+		return errors.New("Invalid vpc peering: " + err.Error())
+	}
+
+	if err := deployment.ValidateFiles(); err != nil {
+		return errors.New("Invalid files: " + err.Error())
+	}
+
+	return nil
+}
+
 // IamRole store the information of iam role
 type IamRole struct {
 	PolicyDocument string `form:"policyDocument" json:"policyDocument"`
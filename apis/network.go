@@ -0,0 +1,82 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ExistingNetwork references a network the deployment runs in instead of creating its own.
+// The deployer only creates its own security groups or firewall rules in it, and never
+// deletes the network itself.
+type ExistingNetwork struct {
+	// Id of the AWS VPC and of the subnets in it the nodes are launched in
+	VpcId     string   `form:"vpcId" json:"vpcId,omitempty"`
+	SubnetIds []string `form:"subnetIds" json:"subnetIds,omitempty"`
+
+	// Name of the GCP network and of the subnetwork in the region of the cluster
+	Network    string `form:"network" json:"network,omitempty"`
+	Subnetwork string `form:"subnetwork" json:"subnetwork,omitempty"`
+}
+
+// ValidateExistingNetwork checks the existing network matches the cloud of the deployment
+func (deployment *Deployment) ValidateExistingNetwork() error {
+	network := deployment.ExistingNetwork
+	if network == nil {
+		return nil
+	}
+
+	if deployment.ClusterType == "GCP" {
+		if network.Network == "" {
+			return errors.New("Network is required in GCP deployments")
+		}
+
+		if network.VpcId != "" || len(network.SubnetIds) > 0 {
+			return errors.New("VPC and subnets are only supported in AWS deployments")
+		}
+
+		return nil
+	}
+
+	if network.Network != "" || network.Subnetwork != "" {
+		return errors.New("Network and subnetwork are only supported in GCP deployments")
+	}
+
+	if !strings.HasPrefix(network.VpcId, "vpc-") {
+		return fmt.Errorf("Invalid VPC id %s", network.VpcId)
+	}
+
+	if len(network.SubnetIds) == 0 {
+		return errors.New("At least one subnet is required")
+	}
+
+	// EKS control planes need subnets in two availability zones
+	if deployment.ClusterType == "EKS" && len(network.SubnetIds) < 2 {
+		return errors.New("At least two subnets are required in EKS deployments")
+	}
+
+	subnetIds := map[string]bool{}
+	for _, subnetId := range network.SubnetIds {
+		if !strings.HasPrefix(subnetId, "subnet-") {
+			return fmt.Errorf("Invalid subnet id %s", subnetId)
+		}
+
+		if subnetIds[subnetId] {
+			return fmt.Errorf("Subnet %s is listed twice", subnetId)
+		}
+		subnetIds[subnetId] = true
+	}
+
+	// The layout of an existing VPC is up to its owner
+	if deployment.ECSDeployment != nil && deployment.ECSDeployment.Network != nil {
+		return errors.New("ECS network definition can't be used with an existing VPC")
+	}
+
+	// The published kubernetes stack templates always create their own VPC
+	if deployment.ClusterType == "K8S" &&
+		(deployment.AWSK8SDefinition == nil || deployment.AWSK8SDefinition.TemplateFile == "") {
+		return errors.New("Existing VPCs require a template file in K8S deployments")
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ValidateNewNodes checks the nodes can be added to the cluster of the deployment
+func (deployment *Deployment) ValidateNewNodes(nodes []ClusterNode) error {
+	if len(nodes) == 0 {
+		return errors.New("No nodes to add")
+	}
+
+	existingIds := map[int]bool{}
+	for _, node := range deployment.ClusterDefinition.Nodes {
+		existingIds[node.Id] = true
+	}
+
+	// ECS nodes can only join the zones the deployment has subnets in, which are only known
+	// from the subnets themselves in existing VPCs
+	zones := map[string]bool{}
+	checkZones := deployment.ECSDeployment != nil && deployment.ExistingNetwork == nil
+	if checkZones {
+		for _, zone := range deployment.ECSAvailabilityZones() {
+			zones[zone] = true
+		}
+	}
+
+	for _, node := range nodes {
+		if node.Id <= 0 {
+			return fmt.Errorf("Invalid node id %d", node.Id)
+		}
+
+		if node.InstanceType == "" {
+			return fmt.Errorf("Instance type of node %d is required", node.Id)
+		}
+
+		if existingIds[node.Id] {
+			return fmt.Errorf("Node id %d is already used", node.Id)
+		}
+
+		if node.AvailabilityZone != "" && checkZones && !zones[node.AvailabilityZone] {
+			return fmt.Errorf("Deployment has no subnet in availability zone %s of node %d",
+				node.AvailabilityZone, node.Id)
+		}
+		existingIds[node.Id] = true
+	}
+
+	return nil
+}
+
+// ValidateRemovedNodes checks the nodes exist in the cluster and no task is still mapped to them
+func (deployment *Deployment) ValidateRemovedNodes(nodeIds []int) error {
+	if len(nodeIds) == 0 {
+		return errors.New("No nodes to remove")
+	}
+
+	existingIds := map[int]bool{}
+	for _, node := range deployment.ClusterDefinition.Nodes {
+		existingIds[node.Id] = true
+	}
+
+	removedIds := map[int]bool{}
+	for _, nodeId := range nodeIds {
+		if !existingIds[nodeId] {
+			return fmt.Errorf("Unable to find node %d in cluster", nodeId)
+		}
+
+		if removedIds[nodeId] {
+			return fmt.Errorf("Node %d is listed more than once", nodeId)
+		}
+		removedIds[nodeId] = true
+	}
+
+	if len(removedIds) == len(existingIds) {
+		return errors.New("Unable to remove every node of the cluster")
+	}
+
+	for _, mapping := range deployment.NodeMapping {
+		if !mapping.IsFargate() && removedIds[mapping.Id] {
+			return fmt.Errorf("Node %d is still used by task %s", mapping.Id, mapping.Task)
+		}
+	}
+
+	return nil
+}
+
+// RemoveNodes removes the nodes with the given ids from the cluster definition
+func (clusterDefinition *ClusterDefinition) RemoveNodes(nodeIds []int) {
+	removedIds := map[int]bool{}
+	for _, nodeId := range nodeIds {
+		removedIds[nodeId] = true
+	}
+
+	nodes := []ClusterNode{}
+	for _, node := range clusterDefinition.Nodes {
+		if !removedIds[node.Id] {
+			nodes = append(nodes, node)
+		}
+	}
+	clusterDefinition.Nodes = nodes
+}
+
+// NodeGroup is a group of cluster nodes sharing an instance type and labels, created together
+// as one node pool or node group of a managed kubernetes cluster
+type NodeGroup struct {
+	Name         string
+	InstanceType string
+	Labels       map[string]string
+	Nodes        []ClusterNode
+}
+
+// GroupNodes groups the nodes sharing an instance type and labels, in the order of their first
+// node. New groups are named by newName from the instance type and the names used so far, which
+// start with the existing names, so they never collide with them.
+func GroupNodes(
+	nodes []ClusterNode,
+	existingNames []string,
+	newName func(instanceType string, usedNames map[string]bool) string) []*NodeGroup {
+	usedNames := map[string]bool{}
+	for _, name := range existingNames {
+		usedNames[name] = true
+	}
+
+	groups := []*NodeGroup{}
+	for _, node := range nodes {
+		var nodeGroup *NodeGroup
+		for _, group := range groups {
+			if node.Matches(group.InstanceType, group.Labels) {
+				nodeGroup = group
+				break
+			}
+		}
+
+		if nodeGroup == nil {
+			nodeGroup = &NodeGroup{
+				Name:         newName(node.InstanceType, usedNames),
+				InstanceType: node.InstanceType,
+				Labels:       node.Labels,
+			}
+			usedNames[nodeGroup.Name] = true
+			groups = append(groups, nodeGroup)
+		}
+		nodeGroup.Nodes = append(nodeGroup.Nodes, node)
+	}
+
+	return groups
+}
+
+// Matches return true when the node has the instance type and exactly the labels
+func (node ClusterNode) Matches(instanceType string, labels map[string]string) bool {
+	if node.InstanceType != instanceType || len(node.Labels) != len(labels) {
+		return false
+	}
+
+	for key, value := range node.Labels {
+		if otherValue, ok := labels[key]; !ok || otherValue != value {
+			return false
+		}
+	}
+
+	return true
+}
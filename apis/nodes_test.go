@@ -0,0 +1,153 @@
+package apis
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestGroupNodes(t *testing.T) {
+	nodes := []ClusterNode{
+		ClusterNode{Id: 1, InstanceType: "t2.large"},
+		ClusterNode{Id: 2, InstanceType: "t2.large", Labels: map[string]string{"role": "db"}},
+		ClusterNode{Id: 3, InstanceType: "t2.large"},
+		ClusterNode{Id: 4, InstanceType: "m4.large"},
+	}
+
+	newName := func(instanceType string, usedNames map[string]bool) string {
+		name := instanceType
+		for i := 2; usedNames[name]; i++ {
+			name = instanceType + "-" + strconv.Itoa(i)
+		}
+		return name
+	}
+
+	groups := GroupNodes(nodes, []string{"t2.large"}, newName)
+	expected := []struct {
+		name    string
+		nodeIds []int
+	}{
+		{"t2.large-2", []int{1, 3}},
+		{"t2.large-3", []int{2}},
+		{"m4.large", []int{4}},
+	}
+
+	if len(groups) != len(expected) {
+		t.Fatalf("Unexpected %d node groups", len(groups))
+	}
+
+	for i, group := range groups {
+		if group.Name != expected[i].name {
+			t.Errorf("Unexpected name %s of node group %d", group.Name, i)
+		}
+
+		nodeIds := []int{}
+		for _, node := range group.Nodes {
+			nodeIds = append(nodeIds, node.Id)
+		}
+		if len(nodeIds) != len(expected[i].nodeIds) {
+			t.Errorf("Unexpected nodes %v of node group %s", nodeIds, group.Name)
+			continue
+		}
+		for j := range nodeIds {
+			if nodeIds[j] != expected[i].nodeIds[j] {
+				t.Errorf("Unexpected nodes %v of node group %s", nodeIds, group.Name)
+				break
+			}
+		}
+	}
+}
+
+func TestValidateNewNodes(t *testing.T) {
+	ecsDeployment := &ECSDeployment{
+		Network: &ECSNetworkDefinition{AvailabilityZones: []string{"us-east-1a", "us-east-1b"}},
+	}
+
+	tests := []struct {
+		name            string
+		ecsDeployment   *ECSDeployment
+		existingNetwork *ExistingNetwork
+		nodes           []ClusterNode
+		valid           bool
+	}{
+		{"new nodes", nil, nil, []ClusterNode{{Id: 3, InstanceType: "t2.large"}, {Id: 4, InstanceType: "m4.large"}}, true},
+		{"no nodes", nil, nil, []ClusterNode{}, false},
+		{"invalid id", nil, nil, []ClusterNode{{Id: 0, InstanceType: "t2.large"}}, false},
+		{"no instance type", nil, nil, []ClusterNode{{Id: 3}}, false},
+		{"existing id", nil, nil, []ClusterNode{{Id: 2, InstanceType: "t2.large"}}, false},
+		{"duplicate new id", nil, nil, []ClusterNode{{Id: 3, InstanceType: "t2.large"},
+			{Id: 3, InstanceType: "t2.large"}}, false},
+		{"zone of the deployment", ecsDeployment, nil,
+			[]ClusterNode{{Id: 3, InstanceType: "t2.large", AvailabilityZone: "us-east-1b"}}, true},
+		{"zone outside of the deployment", ecsDeployment, nil,
+			[]ClusterNode{{Id: 3, InstanceType: "t2.large", AvailabilityZone: "us-east-1c"}}, false},
+		{"zone of an existing vpc", ecsDeployment, &ExistingNetwork{VpcId: "vpc-1"},
+			[]ClusterNode{{Id: 3, InstanceType: "t2.large", AvailabilityZone: "us-east-1c"}}, true},
+	}
+
+	for _, test := range tests {
+		deployment := &Deployment{
+			Region: "us-east-1",
+			ClusterDefinition: ClusterDefinition{
+				Nodes: []ClusterNode{{Id: 1, InstanceType: "t2.large"}, {Id: 2, InstanceType: "t2.large"}},
+			},
+			ECSDeployment:   test.ecsDeployment,
+			ExistingNetwork: test.existingNetwork,
+		}
+
+		err := deployment.ValidateNewNodes(test.nodes)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected nodes to be rejected", test.name)
+		}
+	}
+}
+
+func TestValidateRemovedNodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodeIds []int
+		valid   bool
+	}{
+		{"unused node", []int{3}, true},
+		{"node of fargate task only", []int{4}, true},
+		{"unused nodes", []int{3, 4}, true},
+		{"no nodes", []int{}, false},
+		{"unknown node", []int{5}, false},
+		{"duplicate node", []int{3, 3}, false},
+		{"node still mapped", []int{1}, false},
+		{"unused and mapped nodes", []int{3, 2}, false},
+		{"every node", []int{1, 2, 3, 4}, false},
+	}
+
+	for _, test := range tests {
+		deployment := &Deployment{
+			ClusterDefinition: ClusterDefinition{
+				Nodes: []ClusterNode{{Id: 1}, {Id: 2}, {Id: 3}, {Id: 4}},
+			},
+			NodeMapping: NodeMappings{
+				{Id: 1, Task: "app"},
+				{Id: 2, Task: "mysql"},
+				{Id: 4, Task: "worker", LaunchType: LaunchTypeFargate},
+			},
+		}
+
+		err := deployment.ValidateRemovedNodes(test.nodeIds)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected nodes to be rejected", test.name)
+		}
+	}
+}
+
+func TestRemoveNodes(t *testing.T) {
+	clusterDefinition := &ClusterDefinition{
+		Nodes: []ClusterNode{{Id: 1}, {Id: 2}, {Id: 3}},
+	}
+	clusterDefinition.RemoveNodes([]int{2, 5})
+
+	if len(clusterDefinition.Nodes) != 2 || clusterDefinition.Nodes[0].Id != 1 || clusterDefinition.Nodes[1].Id != 3 {
+		t.Errorf("Unexpected nodes %+v", clusterDefinition.Nodes)
+	}
+}
@@ -0,0 +1,193 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	PeeringProviderAWS = "aws"
+	PeeringProviderGCP = "gcp"
+)
+
+var awsAccountIdPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// PeeringPort is a protocol and port range opened to the peered network. Ports are ignored
+// for icmp, which is opened entirely.
+type PeeringPort struct {
+	// tcp, udp or icmp
+	Protocol string `json:"protocol"`
+	FromPort int    `json:"fromPort,omitempty"`
+	// Last port of the range, defaults to the from port
+	ToPort int `json:"toPort,omitempty"`
+}
+
+// PortRange return the first and last port opened, -1 for both with icmp
+func (port PeeringPort) PortRange() (int, int) {
+	if port.Protocol == "icmp" {
+		return -1, -1
+	}
+
+	if port.ToPort == 0 {
+		return port.FromPort, port.FromPort
+	}
+
+	return port.FromPort, port.ToPort
+}
+
+func validatePeeringPorts(ports []PeeringPort) error {
+	if len(ports) == 0 {
+		return errors.New("At least one port has to be opened to the peered network")
+	}
+
+	for _, port := range ports {
+		switch port.Protocol {
+		case "icmp":
+			continue
+		case "tcp", "udp":
+		default:
+			return fmt.Errorf("Unsupported peering protocol %s", port.Protocol)
+		}
+
+		fromPort, toPort := port.PortRange()
+		if fromPort < 1 || toPort > 65535 || toPort < fromPort {
+			return fmt.Errorf("Invalid %s port range %d-%d", port.Protocol, fromPort, toPort)
+		}
+	}
+
+	return nil
+}
+
+// VPCPeeringStatus reports the peering connection of a deployment and what was set up for it
+type VPCPeeringStatus struct {
+	Provider     string `json:"provider"`
+	ConnectionId string `json:"connectionId"`
+	// Status code of the peering connection, e.g. pending-acceptance, active or deleted on
+	// aws, and ACTIVE or INACTIVE on gcp
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+
+	// Name of the gcp peering created back from the target network, when the deployer is
+	// allowed to, otherwise the target project has to peer with the deployment network
+	TargetConnectionId string `json:"targetConnectionId,omitempty"`
+	// Name of the gcp firewall rule opening the cluster nodes to the target subnetworks
+	FirewallName     string   `json:"firewallName,omitempty"`
+	TargetCidrBlocks []string `json:"targetCidrBlocks,omitempty"`
+
+	TargetRegion       string `json:"targetRegion"`
+	RequesterCidrBlock string `json:"requesterCidrBlock,omitempty"`
+	AccepterCidrBlock  string `json:"accepterCidrBlock,omitempty"`
+
+	// Route tables and security groups on both sides updated for the peered traffic
+	RouteTableIds          []string `json:"routeTableIds,omitempty"`
+	TargetRouteTableIds    []string `json:"targetRouteTableIds,omitempty"`
+	SecurityGroupIds       []string `json:"securityGroupIds,omitempty"`
+	TargetSecurityGroupIds []string `json:"targetSecurityGroupIds,omitempty"`
+
+	DnsResolution bool `json:"dnsResolution"`
+}
+
+// PeeringProvider return the cloud of the target VPC of the deployment
+func (deployment *Deployment) PeeringProvider() string {
+	if deployment.VPCPeering != nil && deployment.VPCPeering.Provider != "" {
+		return deployment.VPCPeering.Provider
+	}
+
+	if deployment.ClusterType == "GCP" {
+		return PeeringProviderGCP
+	}
+
+	return PeeringProviderAWS
+}
+
+// PeerRegion return the region of the target VPC
+func (peering *VPCPeering) PeerRegion(deploymentRegion string) string {
+	if peering.TargetRegion == "" {
+		return deploymentRegion
+	}
+
+	return peering.TargetRegion
+}
+
+// ValidateVPCPeering checks the ids of the target VPC resources
+func (deployment *Deployment) ValidateVPCPeering() error {
+	peering := deployment.VPCPeering
+	if peering == nil {
+		return nil
+	}
+
+	if err := validatePeeringPorts(peering.Ports); err != nil {
+		return err
+	}
+
+	switch deployment.PeeringProvider() {
+	case PeeringProviderAWS:
+		if deployment.ClusterType != "K8S" && deployment.ClusterType != "ECS" {
+			return errors.New("AWS VPC peering is only supported in K8S and ECS deployments")
+		}
+	case PeeringProviderGCP:
+		if deployment.ClusterType != "GCP" {
+			return errors.New("GCP network peering is only supported in GCP deployments")
+		}
+		return validateNetworkPeering(peering)
+	default:
+		return fmt.Errorf("Unsupported peering provider %s", peering.Provider)
+	}
+
+	if !awsAccountIdPattern.MatchString(peering.TargetOwnerId) {
+		return fmt.Errorf("Invalid target owner id %s", peering.TargetOwnerId)
+	}
+
+	if !strings.HasPrefix(peering.TargetVpcId, "vpc-") {
+		return fmt.Errorf("Invalid target VPC id %s", peering.TargetVpcId)
+	}
+
+	if len(peering.TargetRouteTableIds) == 0 {
+		return errors.New("At least one target route table is required")
+	}
+
+	for _, routeTableId := range peering.TargetRouteTableIds {
+		if !strings.HasPrefix(routeTableId, "rtb-") {
+			return fmt.Errorf("Invalid target route table id %s", routeTableId)
+		}
+	}
+
+	// Existing VPCs can route other networks through some of their route tables
+	if deployment.ExistingNetwork != nil && len(peering.RouteTableIds) == 0 {
+		return errors.New("Route tables are required to peer an existing VPC")
+	}
+
+	for _, routeTableId := range peering.RouteTableIds {
+		if !strings.HasPrefix(routeTableId, "rtb-") {
+			return fmt.Errorf("Invalid route table id %s", routeTableId)
+		}
+	}
+
+	for _, groupId := range peering.TargetSecurityGroupIds {
+		if !strings.HasPrefix(groupId, "sg-") {
+			return fmt.Errorf("Invalid target security group id %s", groupId)
+		}
+	}
+
+	return nil
+}
+
+// validateNetworkPeering checks the target project and network of a gcp network peering
+func validateNetworkPeering(peering *VPCPeering) error {
+	if peering.TargetOwnerId == "" {
+		return errors.New("Target project is required")
+	}
+
+	if peering.TargetVpcId == "" {
+		return errors.New("Target network is required")
+	}
+
+	if peering.TargetRegion != "" || peering.TargetProfile != "" || len(peering.RouteTableIds) > 0 ||
+		len(peering.TargetRouteTableIds) > 0 || len(peering.TargetSecurityGroupIds) > 0 {
+		return errors.New("Target region, profile, route tables and security groups are only supported by aws peering")
+	}
+
+	return nil
+}
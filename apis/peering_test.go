@@ -0,0 +1,81 @@
+package apis
+
+import (
+	"testing"
+)
+
+func TestValidateVPCPeering(t *testing.T) {
+	tcp := []PeeringPort{{Protocol: "tcp", FromPort: 443}}
+	routeTables := []string{"rtb-1"}
+
+	tests := []struct {
+		name        string
+		clusterType string
+		peering     *VPCPeering
+		existing    bool
+		valid       bool
+	}{
+		{"aws peering", "ECS", &VPCPeering{TargetOwnerId: "123456789012", TargetVpcId: "vpc-1",
+			TargetRouteTableIds: routeTables, Ports: tcp}, false, true},
+		{"no ports", "ECS", &VPCPeering{TargetOwnerId: "123456789012", TargetVpcId: "vpc-1",
+			TargetRouteTableIds: routeTables}, false, false},
+		{"no target route tables", "K8S", &VPCPeering{TargetOwnerId: "123456789012", TargetVpcId: "vpc-1",
+			Ports: tcp}, false, false},
+		{"existing vpc without route tables", "ECS", &VPCPeering{TargetOwnerId: "123456789012",
+			TargetVpcId: "vpc-1", TargetRouteTableIds: routeTables, Ports: tcp}, true, false},
+		{"existing vpc with route tables", "ECS", &VPCPeering{TargetOwnerId: "123456789012",
+			TargetVpcId: "vpc-1", TargetRouteTableIds: routeTables, RouteTableIds: []string{"rtb-2"}, Ports: tcp},
+			true, true},
+		{"port range", "ECS", &VPCPeering{TargetOwnerId: "123456789012", TargetVpcId: "vpc-1",
+			TargetRouteTableIds: routeTables, Ports: []PeeringPort{{Protocol: "udp", FromPort: 8000, ToPort: 8100}}},
+			false, true},
+		{"reversed port range", "ECS", &VPCPeering{TargetOwnerId: "123456789012", TargetVpcId: "vpc-1",
+			TargetRouteTableIds: routeTables, Ports: []PeeringPort{{Protocol: "tcp", FromPort: 8100, ToPort: 8000}}},
+			false, false},
+		{"every protocol", "ECS", &VPCPeering{TargetOwnerId: "123456789012", TargetVpcId: "vpc-1",
+			TargetRouteTableIds: routeTables, Ports: []PeeringPort{{Protocol: "-1"}}}, false, false},
+		{"icmp", "ECS", &VPCPeering{TargetOwnerId: "123456789012", TargetVpcId: "vpc-1",
+			TargetRouteTableIds: routeTables, Ports: []PeeringPort{{Protocol: "icmp"}}}, false, true},
+		{"gcp peering", "GCP", &VPCPeering{TargetOwnerId: "project", TargetVpcId: "network", Ports: tcp},
+			false, true},
+		{"gcp peering with route tables", "GCP", &VPCPeering{TargetOwnerId: "project", TargetVpcId: "network",
+			RouteTableIds: routeTables, Ports: tcp}, false, false},
+	}
+
+	for _, test := range tests {
+		deployment := &Deployment{
+			ClusterType: test.clusterType,
+			VPCPeering:  test.peering,
+		}
+		if test.existing {
+			deployment.ExistingNetwork = &ExistingNetwork{VpcId: "vpc-2", SubnetIds: []string{"subnet-1"}}
+		}
+
+		err := deployment.ValidateVPCPeering()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestPeeringPortRange(t *testing.T) {
+	tests := []struct {
+		port     PeeringPort
+		fromPort int
+		toPort   int
+	}{
+		{PeeringPort{Protocol: "tcp", FromPort: 80}, 80, 80},
+		{PeeringPort{Protocol: "tcp", FromPort: 80, ToPort: 90}, 80, 90},
+		{PeeringPort{Protocol: "icmp", FromPort: 80}, -1, -1},
+	}
+
+	for _, test := range tests {
+		fromPort, toPort := test.port.PortRange()
+		if fromPort != test.fromPort || toPort != test.toPort {
+			t.Errorf("Port range of %+v is %d-%d, expected %d-%d",
+				test.port, fromPort, toPort, test.fromPort, test.toPort)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package apis
+
+// SplitReplicas splits the replicas of a task across the given number of objects created for
+// its node mappings, the first objects run one more replica when they don't divide evenly
+func SplitReplicas(replicas int, count int) []int {
+	split := []int{}
+	for i := 0; i < count; i++ {
+		share := replicas / count
+		if i < replicas%count {
+			share += 1
+		}
+		split = append(split, share)
+	}
+
+	return split
+}
+
+// TaskMappingCount return the number of node mappings of a task family
+func (deployment *Deployment) TaskMappingCount(family string) int {
+	count := 0
+	for _, mapping := range deployment.NodeMapping {
+		if mapping.Task == family {
+			count += 1
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,39 @@
+package apis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitReplicas(t *testing.T) {
+	tests := []struct {
+		replicas int
+		count    int
+		expected []int
+	}{
+		{3, 1, []int{3}},
+		{4, 2, []int{2, 2}},
+		{5, 3, []int{2, 2, 1}},
+		{1, 3, []int{1, 0, 0}},
+		{0, 2, []int{0, 0}},
+		{3, 0, []int{}},
+	}
+
+	for _, test := range tests {
+		split := SplitReplicas(test.replicas, test.count)
+		if !reflect.DeepEqual(test.expected, split) {
+			t.Errorf("Unexpected split of %d replicas across %d: %v", test.replicas, test.count, split)
+		}
+	}
+}
+
+func TestServiceDesiredCounts(t *testing.T) {
+	ecsDeployment := &ECSDeployment{DesiredCounts: map[string]int{"app": 5}}
+	if counts := ecsDeployment.ServiceDesiredCounts("app", 2); !reflect.DeepEqual([]int{3, 2}, counts) {
+		t.Errorf("Unexpected desired counts of app: %v", counts)
+	}
+
+	if counts := ecsDeployment.ServiceDesiredCounts("redis", 2); !reflect.DeepEqual([]int{1, 1}, counts) {
+		t.Errorf("Unexpected default desired counts of redis: %v", counts)
+	}
+}
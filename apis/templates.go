@@ -0,0 +1,332 @@
+package apis
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	VariableTypeString = "string"
+	VariableTypeInt    = "int"
+	VariableTypeBool   = "bool"
+
+	// Integers beyond 2^53 can't be told apart from their neighbours as float64
+	maxExactFloatInt = 1 << 53
+)
+
+var (
+	variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// A reference is ${name}, $${ escapes a literal ${
+	variableReferencePattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	wholeReferencePattern    = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+)
+
+// TemplateVariable is a variable of a deployment template, referenced as ${name} in the string
+// values of the deployment. A string that is only the reference is replaced by the typed value,
+// so "replicas": "${replicas}" becomes a number, otherwise the value is formatted into the string.
+type TemplateVariable struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	// Variables without default require a value
+	Default interface{} `json:"default,omitempty"`
+}
+
+// normalize return the value as the type of the variable
+func (variable *TemplateVariable) normalize(value interface{}) (interface{}, error) {
+	switch variable.Type {
+	case VariableTypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case VariableTypeInt:
+		switch n := value.(type) {
+		case json.Number:
+			if i, err := n.Int64(); err == nil {
+				return i, nil
+			}
+		case float64:
+			if n == math.Trunc(n) && math.Abs(n) <= maxExactFloatInt {
+				return int64(n), nil
+			}
+		case int:
+			return int64(n), nil
+		case int64:
+			return n, nil
+		}
+	case VariableTypeBool:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported type %s", variable.Type)
+	}
+
+	return nil, fmt.Errorf("Expected %s value, got %v", variable.Type, value)
+}
+
+func (variable *TemplateVariable) zeroValue() interface{} {
+	switch variable.Type {
+	case VariableTypeInt:
+		return int64(0)
+	case VariableTypeBool:
+		return false
+	default:
+		return ""
+	}
+}
+
+// DeploymentTemplate is a stored template deployment with the variables it declares. Templates
+// without variables are used as is, so their strings can hold ${ without escaping.
+type DeploymentTemplate struct {
+	Variables []TemplateVariable
+
+	// Decoded JSON of the deployment, without the variables
+	deployment map[string]interface{}
+}
+
+// decodeNumbers decodes JSON keeping numbers as json.Number, so integers don't lose precision
+// as float64
+func decodeNumbers(content []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("Unexpected data after JSON value")
+	}
+
+	return nil
+}
+
+// ParseDeploymentTemplate decodes the JSON of a deployment with an optional variables list
+func ParseDeploymentTemplate(content []byte) (*DeploymentTemplate, error) {
+	deployment := map[string]interface{}{}
+	if err := decodeNumbers(content, &deployment); err != nil {
+		return nil, errors.New("Unable to decode template: " + err.Error())
+	}
+
+	template := &DeploymentTemplate{
+		deployment: deployment,
+	}
+
+	if variables, ok := deployment["variables"]; ok {
+		b, err := json.Marshal(variables)
+		if err != nil {
+			return nil, errors.New("Unable to encode variables: " + err.Error())
+		}
+
+		if err := decodeNumbers(b, &template.Variables); err != nil {
+			return nil, errors.New("Unable to decode variables: " + err.Error())
+		}
+		delete(deployment, "variables")
+	}
+
+	return template, nil
+}
+
+// NewDeploymentTemplate return the template of a deployment without variables
+func NewDeploymentTemplate(deployment *Deployment) (*DeploymentTemplate, error) {
+	b, err := json.Marshal(deployment)
+	if err != nil {
+		return nil, errors.New("Unable to marshal deployment to json: " + err.Error())
+	}
+
+	return ParseDeploymentTemplate(b)
+}
+
+func (template *DeploymentTemplate) MarshalJSON() ([]byte, error) {
+	deployment := map[string]interface{}{}
+	for key, value := range template.deployment {
+		deployment[key] = value
+	}
+
+	if len(template.Variables) > 0 {
+		deployment["variables"] = template.Variables
+	}
+
+	return json.Marshal(deployment)
+}
+
+// Validate checks the variable declarations and references. The rendered deployment is
+// validated as well when every variable has a default.
+func (template *DeploymentTemplate) Validate() error {
+	names := map[string]bool{}
+	values := map[string]interface{}{}
+	allDefaults := true
+	for _, variable := range template.Variables {
+		if !variableNamePattern.MatchString(variable.Name) {
+			return fmt.Errorf("Invalid variable name %s", variable.Name)
+		}
+
+		if names[variable.Name] {
+			return fmt.Errorf("Duplicate variable %s", variable.Name)
+		}
+		names[variable.Name] = true
+
+		switch variable.Type {
+		case VariableTypeString, VariableTypeInt, VariableTypeBool:
+		default:
+			return fmt.Errorf("Unsupported type %s of variable %s", variable.Type, variable.Name)
+		}
+
+		// References are checked with zero values of the variables without defaults
+		if variable.Default == nil {
+			allDefaults = false
+			values[variable.Name] = variable.zeroValue()
+			continue
+		}
+
+		if _, err := variable.normalize(variable.Default); err != nil {
+			return fmt.Errorf("Invalid default of variable %s: %s", variable.Name, err.Error())
+		}
+	}
+
+	deployment, err := template.Render(values)
+	if err != nil {
+		return err
+	}
+
+	if allDefaults {
+		return deployment.Validate()
+	}
+
+	return nil
+}
+
+// Render return the deployment of the template with its variables replaced by the values,
+// or their defaults when they have no value
+func (template *DeploymentTemplate) Render(values map[string]interface{}) (*Deployment, error) {
+	var rendered interface{} = template.deployment
+	if len(template.Variables) > 0 {
+		resolvedValues, err := template.resolveValues(values)
+		if err != nil {
+			return nil, err
+		}
+
+		if rendered, err = substituteVariables(template.deployment, resolvedValues); err != nil {
+			return nil, err
+		}
+	} else if len(values) > 0 {
+		return nil, errors.New("Template has no variables to set values of")
+	}
+
+	b, err := json.Marshal(rendered)
+	if err != nil {
+		return nil, errors.New("Unable to encode rendered deployment: " + err.Error())
+	}
+
+	deployment := &Deployment{}
+	if err := json.Unmarshal(b, deployment); err != nil {
+		return nil, errors.New("Unable to decode rendered deployment: " + err.Error())
+	}
+
+	return deployment, nil
+}
+
+func (template *DeploymentTemplate) resolveValues(values map[string]interface{}) (map[string]interface{}, error) {
+	variables := map[string]*TemplateVariable{}
+	for i := range template.Variables {
+		variables[template.Variables[i].Name] = &template.Variables[i]
+	}
+
+	for name := range values {
+		if _, ok := variables[name]; !ok {
+			return nil, fmt.Errorf("Unknown variable %s", name)
+		}
+	}
+
+	resolvedValues := map[string]interface{}{}
+	missing := []string{}
+	for name, variable := range variables {
+		value, ok := values[name]
+		if !ok {
+			if variable.Default == nil {
+				missing = append(missing, name)
+				continue
+			}
+			value = variable.Default
+		}
+
+		normalizedValue, err := variable.normalize(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value of variable %s: %s", name, err.Error())
+		}
+		resolvedValues[name] = normalizedValue
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, errors.New("Missing values of variables: " + strings.Join(missing, ", "))
+	}
+
+	return resolvedValues, nil
+}
+
+func substituteVariables(value interface{}, values map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		substituted := map[string]interface{}{}
+		for key, item := range v {
+			substitutedItem, err := substituteVariables(item, values)
+			if err != nil {
+				return nil, err
+			}
+			substituted[key] = substitutedItem
+		}
+		return substituted, nil
+	case []interface{}:
+		substituted := []interface{}{}
+		for _, item := range v {
+			substitutedItem, err := substituteVariables(item, values)
+			if err != nil {
+				return nil, err
+			}
+			substituted = append(substituted, substitutedItem)
+		}
+		return substituted, nil
+	case string:
+		return substituteString(v, values)
+	default:
+		return value, nil
+	}
+}
+
+func substituteString(s string, values map[string]interface{}) (interface{}, error) {
+	if match := wholeReferencePattern.FindStringSubmatch(s); match != nil {
+		value, ok := values[match[1]]
+		if !ok {
+			return nil, fmt.Errorf("Undefined variable %s", match[1])
+		}
+		return value, nil
+	}
+
+	var err error
+	substituted := variableReferencePattern.ReplaceAllStringFunc(s, func(reference string) string {
+		if reference == "$${" {
+			return "${"
+		}
+
+		name := reference[2 : len(reference)-1]
+		value, ok := values[name]
+		if !ok {
+			err = fmt.Errorf("Undefined variable %s", name)
+			return reference
+		}
+		return fmt.Sprint(value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return substituted, nil
+}
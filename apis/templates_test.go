@@ -0,0 +1,120 @@
+package apis
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testTemplate = `{
+	"name": "test",
+	"region": "${region}",
+	"clusterDefinition": {
+		"nodes": [{"id": 1, "instanceType": "${instanceType}"}]
+	},
+	"shutDownTime": "${hours}h",
+	"imageId": "$${literal}",
+	"variables": [
+		{"name": "region", "type": "string", "default": "us-east-1"},
+		{"name": "instanceType", "type": "string"},
+		{"name": "hours", "type": "int", "default": 12}
+	]
+}`
+
+func TestRenderDeploymentTemplate(t *testing.T) {
+	template, err := ParseDeploymentTemplate([]byte(testTemplate))
+	if err != nil {
+		t.Fatalf("Unable to parse template: %s", err.Error())
+	}
+
+	deployment, err := template.Render(map[string]interface{}{
+		"instanceType": "t2.large",
+		"hours":        float64(2),
+	})
+	if err != nil {
+		t.Fatalf("Unable to render template: %s", err.Error())
+	}
+
+	if deployment.Region != "us-east-1" {
+		t.Errorf("Unexpected region %s", deployment.Region)
+	}
+	if len(deployment.ClusterDefinition.Nodes) != 1 ||
+		deployment.ClusterDefinition.Nodes[0].InstanceType != "t2.large" {
+		t.Errorf("Unexpected nodes %+v", deployment.ClusterDefinition.Nodes)
+	}
+	if deployment.ShutDownTime != "2h" {
+		t.Errorf("Unexpected shut down time %s", deployment.ShutDownTime)
+	}
+	if deployment.ImageId != "${literal}" {
+		t.Errorf("Unexpected escaped image id %s", deployment.ImageId)
+	}
+}
+
+func TestRenderDeploymentTemplateRejectsInvalidValues(t *testing.T) {
+	template, err := ParseDeploymentTemplate([]byte(testTemplate))
+	if err != nil {
+		t.Fatalf("Unable to parse template: %s", err.Error())
+	}
+
+	for _, values := range []map[string]interface{}{
+		{},
+		{"instanceType": "t2.large", "unknown": "value"},
+		{"instanceType": "t2.large", "hours": "2"},
+		{"instanceType": "t2.large", "hours": 1.5},
+	} {
+		if _, err := template.Render(values); err == nil {
+			t.Errorf("Expected values %v to be rejected", values)
+		}
+	}
+}
+
+func TestValidateDeploymentTemplateRejectsUndefinedVariable(t *testing.T) {
+	template, err := ParseDeploymentTemplate([]byte(`{
+		"name": "test",
+		"region": "${zone}",
+		"variables": [{"name": "region", "type": "string"}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unable to parse template: %s", err.Error())
+	}
+
+	if err := template.Validate(); err == nil {
+		t.Error("Expected undefined variable to be rejected")
+	}
+}
+
+func TestRenderDeploymentTemplateKeepsLargeIntegers(t *testing.T) {
+	template, err := ParseDeploymentTemplate([]byte(`{
+		"name": "test",
+		"shutDownTime": "${seconds}s",
+		"variables": [{"name": "seconds", "type": "int", "default": 9007199254740993}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unable to parse template: %s", err.Error())
+	}
+
+	if err := template.Validate(); err != nil {
+		t.Fatalf("Unable to validate template: %s", err.Error())
+	}
+
+	deployment, err := template.Render(nil)
+	if err != nil {
+		t.Fatalf("Unable to render template: %s", err.Error())
+	}
+	if deployment.ShutDownTime != "9007199254740993s" {
+		t.Errorf("Unexpected shut down time %s", deployment.ShutDownTime)
+	}
+
+	deployment, err = template.Render(map[string]interface{}{"seconds": json.Number("9223372036854775807")})
+	if err != nil {
+		t.Fatalf("Unable to render template: %s", err.Error())
+	}
+	if deployment.ShutDownTime != "9223372036854775807s" {
+		t.Errorf("Unexpected shut down time %s", deployment.ShutDownTime)
+	}
+
+	for _, value := range []interface{}{float64(1 << 54), json.Number("1.5"), json.Number("9223372036854775808")} {
+		if _, err := template.Render(map[string]interface{}{"seconds": value}); err == nil {
+			t.Errorf("Expected value %v to be rejected", value)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package awsecs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+	logging "github.com/op/go-logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+const (
+	// Public parameter AWS keeps pointing to the recommended ECS optimized image of each region
+	ecsAmiParameterName = "/aws/service/ecs/optimized-ami/amazon-linux-2/recommended/image_id"
+	// Name of the ECS optimized images, used when the parameter can't be read
+	ecsAmiNamePattern = "amzn2-ami-ecs-hvm-*-x86_64-ebs"
+	// Recommended images are only updated every few weeks
+	ecsAmiCacheExpiration = 6 * time.Hour
+)
+
+type cachedImage struct {
+	ImageId    string
+	ResolvedAt time.Time
+}
+
+// imageCache stores the ECS optimized image id resolved for each region
+type imageCache struct {
+	mutex  sync.Mutex
+	images map[string]cachedImage
+}
+
+var ecsImageCache = &imageCache{
+	images: map[string]cachedImage{},
+}
+
+func (cache *imageCache) get(region string) (string, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	image, ok := cache.images[region]
+	if !ok || time.Since(image.ResolvedAt) > ecsAmiCacheExpiration {
+		return "", false
+	}
+
+	return image.ImageId, true
+}
+
+func (cache *imageCache) set(region string, imageId string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.images[region] = cachedImage{
+		ImageId:    imageId,
+		ResolvedAt: time.Now(),
+	}
+}
+
+// resolveImageId return the image of the container instances of a deployment, which is either
+// the image set in the deployment, or the current ECS optimized image of the region. Unlike the
+// fixed images used before, the ECS optimized image doesn't ship Weave.
+func resolveImageId(sess *session.Session, imageId string, region string, log *logging.Logger) (string, error) {
+	ec2Svc := ec2.New(sess)
+	if imageId != "" {
+		if err := checkImageAvailable(ec2Svc, imageId); err != nil {
+			return "", err
+		}
+		return imageId, nil
+	}
+
+	if imageId, ok := ecsImageCache.get(region); ok {
+		return imageId, nil
+	}
+
+	imageId, err := lookupECSImageParameter(ssm.New(sess))
+	if err != nil {
+		log.Warningf("Unable to read ECS optimized image parameter, searching images instead: %s", err.Error())
+		imageId, err = describeLatestECSImage(ec2Svc)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	log.Infof("Using ECS optimized image %s in region %s", imageId, region)
+	ecsImageCache.set(region, imageId)
+
+	return imageId, nil
+}
+
+// clusterImageId return the image the existing container instances of the cluster run, so nodes
+// added later don't pick up a newer ECS optimized image. It's empty when there are no instances.
+func clusterImageId(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster) (string, error) {
+	for _, nodeInfo := range awsCluster.NodeInfos {
+		if nodeInfo.Instance != nil && aws.StringValue(nodeInfo.Instance.ImageId) != "" {
+			return aws.StringValue(nodeInfo.Instance.ImageId), nil
+		}
+	}
+
+	if len(awsCluster.InstanceIds) == 0 {
+		return "", nil
+	}
+
+	output, err := ec2Svc.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: awsCluster.InstanceIds,
+	})
+	if err != nil {
+		return "", errors.New("Unable to describe instances: " + err.Error())
+	}
+
+	for _, reservation := range output.Reservations {
+		for _, instance := range reservation.Instances {
+			if imageId := aws.StringValue(instance.ImageId); imageId != "" {
+				return imageId, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// lookupECSImageParameter reads the recommended ECS optimized image from the public SSM parameter
+func lookupECSImageParameter(ssmSvc *ssm.SSM) (string, error) {
+	output, err := ssmSvc.GetParameter(&ssm.GetParameterInput{
+		Name: aws.String(ecsAmiParameterName),
+	})
+	if err != nil {
+		return "", errors.New("Unable to get parameter " + ecsAmiParameterName + ": " + err.Error())
+	}
+
+	imageId := aws.StringValue(output.Parameter.Value)
+	if imageId == "" {
+		return "", errors.New("Empty parameter " + ecsAmiParameterName)
+	}
+
+	return imageId, nil
+}
+
+// describeLatestECSImage return the most recently created ECS optimized image owned by amazon
+func describeLatestECSImage(ec2Svc *ec2.EC2) (string, error) {
+	output, err := ec2Svc.DescribeImages(&ec2.DescribeImagesInput{
+		Owners: []*string{aws.String("amazon")},
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("name"),
+				Values: []*string{aws.String(ecsAmiNamePattern)},
+			},
+			{
+				Name:   aws.String("state"),
+				Values: []*string{aws.String("available")},
+			},
+		},
+	})
+	if err != nil {
+		return "", errors.New("Unable to describe ECS optimized images: " + err.Error())
+	}
+
+	var latest *ec2.Image
+	for _, image := range output.Images {
+		// Creation dates are ISO 8601 strings, so they sort lexically
+		if latest == nil || aws.StringValue(image.CreationDate) > aws.StringValue(latest.CreationDate) {
+			latest = image
+		}
+	}
+
+	if latest == nil {
+		return "", errors.New("Unable to find any ECS optimized image")
+	}
+
+	return aws.StringValue(latest.ImageId), nil
+}
+
+// checkImageAvailable checks the image exists in the region of the session
+func checkImageAvailable(ec2Svc *ec2.EC2, imageId string) error {
+	output, err := ec2Svc.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String(imageId)},
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to describe image %s: %s", imageId, err.Error())
+	}
+
+	if len(output.Images) == 0 || aws.StringValue(output.Images[0].State) != ec2.ImageStateAvailable {
+		return fmt.Errorf("Image %s is not available", imageId)
+	}
+
+	return nil
+}
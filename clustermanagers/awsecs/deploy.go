@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -68,26 +69,43 @@ func (ecsDeployer *ECSDeployer) ReloadClusterState(storeInfo interface{}) error
 		return fmt.Errorf("Unable to list container instances: %s", err.Error())
 	}
 
-	ecsDescribeInstancesInput := &ecs.DescribeContainerInstancesInput{
-		Cluster:            aws.String(deploymentName),
-		ContainerInstances: listContainerInstancesOutput.ContainerInstanceArns,
-	}
+	// Deployments only running Fargate tasks have no container instances
+	var instanceIds []*string
+	if len(listContainerInstancesOutput.ContainerInstanceArns) > 0 {
+		ecsDescribeInstancesInput := &ecs.DescribeContainerInstancesInput{
+			Cluster:            aws.String(deploymentName),
+			ContainerInstances: listContainerInstancesOutput.ContainerInstanceArns,
+		}
 
-	ecsDescribeInstancesOutput, err := ecsSvc.DescribeContainerInstances(ecsDescribeInstancesInput)
-	if err != nil {
-		return fmt.Errorf("Unable to describe container instances: %s", err.Error())
-	}
+		ecsDescribeInstancesOutput, err := ecsSvc.DescribeContainerInstances(ecsDescribeInstancesInput)
+		if err != nil {
+			return fmt.Errorf("Unable to describe container instances: %s", err.Error())
+		}
 
-	var instanceIds []*string
-	for _, containerInstance := range ecsDescribeInstancesOutput.ContainerInstances {
-		instanceIds = append(instanceIds, containerInstance.Ec2InstanceId)
+		for _, containerInstance := range ecsDescribeInstancesOutput.ContainerInstances {
+			instanceIds = append(instanceIds, containerInstance.Ec2InstanceId)
+		}
 	}
 	awsCluster.InstanceIds = instanceIds
 
+	var existingSubnetIds []string
+	if existingNetwork := ecsDeployer.Deployment.ExistingNetwork; existingNetwork != nil {
+		awsCluster.VpcId = existingNetwork.VpcId
+		existingSubnetIds = existingNetwork.SubnetIds
+	}
+
 	if err := checkVPC(ec2Svc, awsCluster); err != nil {
 		return fmt.Errorf("Unable to find VPC: %s", err.Error())
 	}
 
+	if err := loadNetwork(ec2Svc, awsCluster, existingSubnetIds); err != nil {
+		return fmt.Errorf("Unable to load network: %s", err.Error())
+	}
+
+	if ecsStoreInfo, ok := storeInfo.(*StoreInfo); ok && ecsStoreInfo != nil {
+		ecsDeployer.VpcPeering = ecsStoreInfo.VpcPeering
+	}
+
 	return nil
 }
 
@@ -133,17 +151,46 @@ func (ecsDeployer *ECSDeployer) CreateDeployment(uploadedFiles map[string]string
 		return nil, errors.New("Unable to setup AWS Log Group for container: " + err.Error())
 	}
 
+	executionRoleArn := ""
+	if len(deployment.FargateTasks()) > 0 {
+		log.Infof("Setting up task execution role")
+		roleArn, err := setupExecutionRole(iamSvc, awsCluster, log)
+		if err != nil {
+			ecsDeployer.DeleteDeployment()
+			return nil, errors.New("Unable to setup task execution role: " + err.Error())
+		}
+		executionRoleArn = roleArn
+	}
+
 	log.Infof("Setting up ECS cluster")
-	if err := setupECS(ecsSvc, awsCluster, deployment); err != nil {
+	if err := setupECS(ecsSvc, awsCluster, deployment, executionRoleArn); err != nil {
 		ecsDeployer.DeleteDeployment()
 		return nil, errors.New("Unable to setup ECS: " + err.Error())
 	}
 
-	if err := ecsDeployer.SetupEC2Infra("ec2-user", uploadedFiles, ec2Svc, iamSvc, ecsAmis); err != nil {
+	imageId := ""
+	if len(deployment.ClusterDefinition.Nodes) > 0 {
+		resolvedImageId, err := resolveImageId(sess, deployment.ImageId, awsCluster.Region, log)
+		if err != nil {
+			ecsDeployer.DeleteDeployment()
+			return nil, errors.New("Unable to resolve image of container instances: " + err.Error())
+		}
+		imageId = resolvedImageId
+	}
+
+	if err := ecsDeployer.SetupEC2Infra("ec2-user", uploadedFiles, ec2Svc, iamSvc, imageId); err != nil {
 		ecsDeployer.DeleteDeployment()
 		return nil, errors.New("Unable to setup EC2: " + err.Error())
 	}
 
+	if deployment.VPCPeering != nil {
+		log.Infof("Setting up VPC peering")
+		if err := ecsDeployer.setupVpcPeering(sess); err != nil {
+			ecsDeployer.DeleteDeployment()
+			return nil, errors.New("Unable to peer vpc: " + err.Error())
+		}
+	}
+
 	log.Infof("Waiting for ECS cluster to be ready")
 	if err := waitUntilECSClusterReady(ecsSvc, awsCluster, deployment, log); err != nil {
 		ecsDeployer.DeleteDeployment()
@@ -192,13 +239,34 @@ func (ecsDeployer *ECSDeployer) DeleteDeployment() error {
 	ec2Svc := ec2.New(sess)
 	ecsSvc := ecs.New(sess)
 
+	// Existing VPCs are shared with other resources, only the security group is deleted from them
+	existingNetwork := deployment.ExistingNetwork != nil
+	if existingNetwork {
+		awsCluster.VpcId = deployment.ExistingNetwork.VpcId
+	}
+
 	log.Infof("Checking VPC for deletion")
 	if err := checkVPC(ec2Svc, awsCluster); err != nil {
 		log.Errorf("Unable to find VPC: %s", err.Error())
 		return err
 	}
 
+	if ecsDeployer.VpcPeering != nil {
+		log.Infof("Deleting VPC peering")
+		if err := ecsDeployer.deleteVpcPeering(sess); err != nil {
+			log.Warningf("Unable to delete VPC peering: %s", err.Error())
+		}
+	}
+
+	fargateTaskArns := []*string{}
 	if ecsDeployer.Deployment.ECSDeployment != nil {
+		taskArns, err := listFargateTasks(ecsSvc, awsCluster, deployment)
+		if err != nil {
+			log.Warningf("Unable to list Fargate tasks: %s", err.Error())
+		} else {
+			fargateTaskArns = taskArns
+		}
+
 		// Stop all running tasks
 		log.Infof("Stopping all ECS services")
 		if err := stopECSServices(ecsSvc, awsCluster, deployment, log); err != nil {
@@ -206,6 +274,13 @@ func (ecsDeployer *ECSDeployer) DeleteDeployment() error {
 			return err
 		}
 
+		if len(fargateTaskArns) > 0 {
+			log.Infof("Waiting for %d Fargate tasks to stop", len(fargateTaskArns))
+			if err := waitUntilTasksStopped(ecsSvc, awsCluster, fargateTaskArns); err != nil {
+				log.Warningf("Unable to wait for Fargate tasks to stop: %s", err.Error())
+			}
+		}
+
 		// delete all the task definitions
 		log.Infof("Deleting task definitions")
 		if err := deleteTaskDefinitions(ecsSvc, awsCluster, deployment, log); err != nil {
@@ -227,10 +302,20 @@ func (ecsDeployer *ECSDeployer) DeleteDeployment() error {
 	// delete IAM role
 	iamSvc := iam.New(sess)
 
-	log.Infof("Deleting IAM role")
-	if err := deleteIAM(iamSvc, awsCluster, log); err != nil {
-		log.Errorf("Unable to delete IAM: %s", err.Error())
-		return err
+	// Deployments only running Fargate tasks have no instance profile
+	if len(deployment.ClusterDefinition.Nodes) > 0 {
+		log.Infof("Deleting IAM role")
+		if err := deleteIAM(iamSvc, awsCluster, log); err != nil {
+			log.Errorf("Unable to delete IAM: %s", err.Error())
+			return err
+		}
+	}
+
+	if len(deployment.FargateTasks()) > 0 {
+		log.Infof("Deleting task execution role")
+		if err := deleteExecutionRole(iamSvc, awsCluster, log); err != nil {
+			log.Warningf("Unable to delete task execution role: %s", err.Error())
+		}
 	}
 
 	// delete key pair
@@ -240,6 +325,21 @@ func (ecsDeployer *ECSDeployer) DeleteDeployment() error {
 		return err
 	}
 
+	if !existingNetwork {
+		log.Infof("Deleting NAT gateways")
+		if err := deleteNatGateways(ec2Svc, awsCluster, log); err != nil {
+			log.Errorf("Unable to delete NAT gateways: %s", err.Error())
+			return err
+		}
+	}
+
+	if len(fargateTaskArns) > 0 {
+		log.Infof("Waiting for network interfaces of Fargate tasks to be deleted")
+		if err := waitUntilSecurityGroupInterfacesDeleted(ec2Svc, awsCluster); err != nil {
+			log.Warningf("Unable to wait for network interfaces to be deleted: %s", err.Error())
+		}
+	}
+
 	// delete security group
 	log.Infof("Deleting security group")
 	if err := deleteSecurityGroup(ec2Svc, awsCluster, log); err != nil {
@@ -247,6 +347,10 @@ func (ecsDeployer *ECSDeployer) DeleteDeployment() error {
 		return err
 	}
 
+	if existingNetwork {
+		return ecsDeployer.deleteECSCluster(ecsSvc, log)
+	}
+
 	// delete internet gateway.
 	log.Infof("Deleting internet gateway")
 	if err := deleteInternetGateway(ec2Svc, awsCluster, log); err != nil {
@@ -255,12 +359,18 @@ func (ecsDeployer *ECSDeployer) DeleteDeployment() error {
 	}
 
 	// delete subnet.
-	log.Infof("Deleting subnet")
+	log.Infof("Deleting subnets")
 	if err := deleteSubnet(ec2Svc, awsCluster, log); err != nil {
 		log.Errorf("Unable to delete subnet: %s", err.Error())
 		return err
 	}
 
+	log.Infof("Deleting route tables")
+	if err := deleteRouteTables(ec2Svc, awsCluster, log); err != nil {
+		log.Errorf("Unable to delete route tables: %s", err.Error())
+		return err
+	}
+
 	// Delete VPC
 	log.Infof("Deleting VPC")
 	if err := deleteVPC(ec2Svc, awsCluster); err != nil {
@@ -268,10 +378,14 @@ func (ecsDeployer *ECSDeployer) DeleteDeployment() error {
 		return err
 	}
 
+	return ecsDeployer.deleteECSCluster(ecsSvc, log)
+}
+
+func (ecsDeployer *ECSDeployer) deleteECSCluster(ecsSvc *ecs.ECS, log *logging.Logger) error {
 	if ecsDeployer.Deployment.ECSDeployment != nil {
 		// Delete ecs cluster
 		log.Infof("Deleting ECS cluster")
-		if err := deleteCluster(ecsSvc, awsCluster); err != nil {
+		if err := deleteCluster(ecsSvc, ecsDeployer.AWSCluster); err != nil {
 			log.Errorf("Unable to delete ECS cluster: %s", err)
 			return err
 		}
@@ -303,7 +417,7 @@ func createTag(ec2Svc *ec2.EC2, resources []*string, key string, value string) e
 	return createTags(ec2Svc, resources, tags)
 }
 
-func setupECS(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster, deployment *apis.Deployment) error {
+func setupECS(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster, deployment *apis.Deployment, executionRoleArn string) error {
 	// FIXME check if the cluster exists or not
 	clusterParams := &ecs.CreateClusterInput{
 		ClusterName: aws.String(awsCluster.Name),
@@ -313,8 +427,14 @@ func setupECS(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster, deployment *apis.De
 		return errors.New("Unable to create cluster: " + err.Error())
 	}
 
-	for _, taskDefinition := range deployment.ECSDeployment.TaskDefinitions {
-		if _, err := ecsSvc.RegisterTaskDefinition(&taskDefinition); err != nil {
+	fargateTasks := deployment.FargateTasks()
+	for i := range deployment.ECSDeployment.TaskDefinitions {
+		taskDefinition := &deployment.ECSDeployment.TaskDefinitions[i]
+		if fargateTasks[aws.StringValue(taskDefinition.Family)] {
+			setFargateCompatibility(taskDefinition, executionRoleArn)
+		}
+
+		if _, err := ecsSvc.RegisterTaskDefinition(taskDefinition); err != nil {
 			return errors.New("Unable to register task definition: " + err.Error())
 		}
 	}
@@ -323,9 +443,19 @@ func setupECS(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster, deployment *apis.De
 }
 
 func setupNetwork(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, deployment *apis.Deployment, log *logging.Logger) error {
+	if existingNetwork := deployment.ExistingNetwork; existingNetwork != nil {
+		log.Infof("Using existing VPC %s", existingNetwork.VpcId)
+		awsCluster.VpcId = existingNetwork.VpcId
+		if err := loadNetwork(ec2Svc, awsCluster, existingNetwork.SubnetIds); err != nil {
+			return errors.New("Unable to use existing VPC: " + err.Error())
+		}
+
+		return createSecurityGroup(ec2Svc, awsCluster, deployment, log)
+	}
+
 	log.Infof("Creating VPC")
 	createVpcInput := &ec2.CreateVpcInput{
-		CidrBlock: aws.String("172.31.0.0/28"),
+		CidrBlock: aws.String(deployment.ECSVpcCidrBlock()),
 	}
 
 	if createVpcResponse, err := ec2Svc.CreateVpc(createVpcInput); err != nil {
@@ -362,29 +492,9 @@ func setupNetwork(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, deployment *api
 		return errors.New("Unable to tag VPC: " + err.Error())
 	}
 
-	createSubnetInput := &ec2.CreateSubnetInput{
-		VpcId:     aws.String(awsCluster.VpcId),
-		CidrBlock: aws.String("172.31.0.0/28"),
-	}
-
-	// NOTE Unhandled case:
-	// 1. if subnet created successfuly but aws sdk connection broke or request reached timeout
-	if subnetResponse, err := ec2Svc.CreateSubnet(createSubnetInput); err != nil {
-		return errors.New("Unable to create subnet: " + err.Error())
-	} else {
-		awsCluster.SubnetId = *subnetResponse.Subnet.SubnetId
-	}
-
-	describeSubnetsInput := &ec2.DescribeSubnetsInput{
-		SubnetIds: []*string{aws.String(awsCluster.SubnetId)},
-	}
-
-	if err := ec2Svc.WaitUntilSubnetAvailable(describeSubnetsInput); err != nil {
-		return errors.New("Unable to wait until subnet available: " + err.Error())
-	}
-
-	if err := createTag(ec2Svc, []*string{&awsCluster.SubnetId}, "Name", awsCluster.SubnetName()); err != nil {
-		return errors.New("Unable to tag subnet: " + err.Error())
+	log.Infof("Creating subnets")
+	if err := createSubnets(ec2Svc, awsCluster, deployment, log); err != nil {
+		return err
 	}
 
 	if gatewayResponse, err := ec2Svc.CreateInternetGateway(&ec2.CreateInternetGatewayInput{}); err != nil {
@@ -437,6 +547,18 @@ func setupNetwork(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, deployment *api
 		return errors.New("Unable to create route")
 	}
 
+	if len(awsCluster.PrivateSubnetIds) > 0 {
+		log.Infof("Creating NAT gateways")
+		if err := createNatGateways(ec2Svc, awsCluster, log); err != nil {
+			return err
+		}
+	}
+
+	return createSecurityGroup(ec2Svc, awsCluster, deployment, log)
+}
+
+// createSecurityGroup creates the security group of the nodes in the VPC of the cluster
+func createSecurityGroup(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, deployment *apis.Deployment, log *logging.Logger) error {
 	securityGroupParams := &ec2.CreateSecurityGroupInput{
 		Description: aws.String(awsCluster.Name),
 		GroupName:   aws.String(awsCluster.Name),
@@ -483,7 +605,25 @@ func setupNetwork(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, deployment *api
 	return nil
 }
 
-func uploadFiles(user string, ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, deployment *apis.Deployment, uploadedFiles map[string]string) error {
+func uploadFiles(
+	config *viper.Viper,
+	user string,
+	awsCluster *hpaws.AWSCluster,
+	deployment *apis.Deployment,
+	uploadedFiles map[string]string,
+	log *logging.Logger) error {
+	return uploadFilesToNodes(config, user, awsCluster, awsCluster.NodeInfos, deployment, uploadedFiles, log)
+}
+
+func uploadFilesToNodes(
+	config *viper.Viper,
+	user string,
+	awsCluster *hpaws.AWSCluster,
+	nodeInfos map[int]*hpaws.NodeInfo,
+	deployment *apis.Deployment,
+	uploadedFiles map[string]string,
+	log *logging.Logger) error {
+	awsCluster.PinConsoleHostKeys(nodeInfos)
 	if len(deployment.Files) == 0 {
 		return nil
 	}
@@ -493,53 +633,126 @@ func uploadFiles(user string, ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, dep
 		return errors.New("Unable to create ssh config: " + err.Error())
 	}
 
-	for _, nodeInfo := range awsCluster.NodeInfos {
-		address := nodeInfo.PublicDnsName + ":22"
-		scpClient := common.NewSshClient(address, clientConfig, "")
-		for _, deployFile := range deployment.Files {
-			// TODO: Bulk upload all files, where ssh client needs to support multiple files transfer
-			// in the same connection
-			location, ok := uploadedFiles[deployment.UserId+"_"+deployFile.FileId]
-			if !ok {
-				return errors.New("Unable to find uploaded file " + deployFile.FileId)
-			}
+	// Service mappings aren't available for ECS, so templates only get the node variables
+	nodes := []common.NodeUpload{}
+	for nodeId, nodeInfo := range nodeInfos {
+		nodes = append(nodes, common.NodeUpload{
+			Client: common.NewSshClient(nodeInfo.PublicDnsName+":22", clientConfig, ""),
+			Variables: &common.FileVariables{
+				DeploymentName: deployment.Name,
+				Region:         awsCluster.Region,
+				NodeId:         nodeId,
+				PrivateIp:      nodeInfo.PrivateIp,
+				PublicIp:       aws.StringValue(nodeInfo.Instance.PublicIpAddress),
+				Services:       map[string]interface{}{},
+			},
+		})
+	}
 
-			if err := scpClient.CopyLocalFileToRemote(location, deployFile.Path); err != nil {
-				errorMsg := fmt.Sprintf("Unable to upload file %s to server %s: %s",
-					deployFile.FileId, address, err.Error())
-				return errors.New(errorMsg)
-			}
+	return common.UploadFilesToNodes(config, nodes, deployment, uploadedFiles, nil, log)
+}
+
+// RotateSshKey replaces the key pair of the cluster on its container instances. Instances in
+// private subnets can't be reached and keep the old key authorized, they are listed in the
+// returned error.
+func (ecsDeployer *ECSDeployer) RotateSshKey(storeKey func() error) error {
+	targets := func() ([]common.NodeTarget, error) {
+		sshClients, err := ecsDeployer.GetNodeSshClients()
+		if err != nil {
+			return nil, err
 		}
+		return common.SshTargets(sshClients), nil
+	}
+
+	if err := hpaws.RotateKeyPair(ecsDeployer.AWSCluster, targets, common.SshConcurrency(ecsDeployer.Config),
+		storeKey, ecsDeployer.GetLog().Logger); err != nil {
+		return err
+	}
+
+	skippedNodeIds := []int{}
+	for nodeId, nodeInfo := range ecsDeployer.AWSCluster.NodeInfos {
+		if nodeInfo.PublicDnsName == "" {
+			skippedNodeIds = append(skippedNodeIds, nodeId)
+		}
+	}
+
+	if len(skippedNodeIds) > 0 {
+		sort.Ints(skippedNodeIds)
+		return fmt.Errorf("Key pair is rotated, but nodes %v in private subnets can't be reached and keep the old key",
+			skippedNodeIds)
 	}
 
 	return nil
 }
 
-func setupEC2(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, deployment *apis.Deployment, log *logging.Logger, images map[string]string) error {
+// GetNodeSshClients return ssh clients of the container instances, except the ones in private
+// subnets which can't be reached
+func (ecsDeployer *ECSDeployer) GetNodeSshClients() (map[int]common.SshClient, error) {
+	awsCluster := ecsDeployer.AWSCluster
+	clientConfig, err := awsCluster.SshConfig("ec2-user")
+	if err != nil {
+		return nil, errors.New("Unable to create ssh config: " + err.Error())
+	}
+
+	sshClients := map[int]common.SshClient{}
+	for nodeId, nodeInfo := range awsCluster.NodeInfos {
+		if nodeInfo.PublicDnsName == "" {
+			continue
+		}
+		sshClients[nodeId] = common.NewSshClient(nodeInfo.PublicDnsName+":22", clientConfig, "")
+	}
+
+	return sshClients, nil
+}
+
+func setupEC2(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, deployment *apis.Deployment, log *logging.Logger, imageId string) error {
 	if keyOutput, err := hpaws.CreateKeypair(ec2Svc, awsCluster.KeyName()); err != nil {
 		return err
 	} else {
 		awsCluster.KeyPair = keyOutput
 	}
 
+	if len(deployment.ClusterDefinition.Nodes) == 0 {
+		return nil
+	}
+
+	return launchEC2Instances(ec2Svc, awsCluster, deployment.ClusterDefinition.Nodes, log, imageId)
+}
+
+// launchEC2Instances launches an ECS container instance for each node, and waits until
+// all of them are status ok
+func launchEC2Instances(
+	ec2Svc *ec2.EC2,
+	awsCluster *hpaws.AWSCluster,
+	nodes []apis.ClusterNode,
+	log *logging.Logger,
+	imageId string) error {
+	// Weave is only launched on images that ship it
 	userData := base64.StdEncoding.EncodeToString([]byte(
 		fmt.Sprintf(`#!/bin/bash
 echo ECS_CLUSTER=%s >> /etc/ecs/ecs.config
-echo manual > /etc/weave/scope.override
-weave launch`, awsCluster.Name)))
+if command -v weave > /dev/null; then
+  echo manual > /etc/weave/scope.override
+  weave launch
+fi`, awsCluster.Name)))
+
+	instanceIds := []*string{}
+	for _, node := range nodes {
+		subnetId, associatePublic, err := nodeSubnetId(awsCluster, node)
+		if err != nil {
+			return fmt.Errorf("Unable to find subnet of node %d: %s", node.Id, err.Error())
+		}
 
-	associatePublic := true
-	for _, node := range deployment.ClusterDefinition.Nodes {
 		runResult, runErr := ec2Svc.RunInstances(&ec2.RunInstancesInput{
 			KeyName: aws.String(*awsCluster.KeyPair.KeyName),
-			ImageId: aws.String(images[awsCluster.Region]),
+			ImageId: aws.String(imageId),
 			NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{
 				&ec2.InstanceNetworkInterfaceSpecification{
-					AssociatePublicIpAddress: &associatePublic,
-					DeleteOnTermination:      &associatePublic,
+					AssociatePublicIpAddress: aws.Bool(associatePublic),
+					DeleteOnTermination:      aws.Bool(true),
 					DeviceIndex:              aws.Int64(0),
 					Groups:                   []*string{&awsCluster.SecurityGroupId},
-					SubnetId:                 aws.String(awsCluster.SubnetId),
+					SubnetId:                 aws.String(subnetId),
 				},
 			},
 			InstanceType: aws.String(node.InstanceType),
@@ -559,6 +772,7 @@ weave launch`, awsCluster.Name)))
 			Instance: runResult.Instances[0],
 		}
 		awsCluster.InstanceIds = append(awsCluster.InstanceIds, runResult.Instances[0].InstanceId)
+		instanceIds = append(instanceIds, runResult.Instances[0].InstanceId)
 	}
 
 	tags := []*ec2.Tag{
@@ -572,11 +786,11 @@ weave launch`, awsCluster.Name)))
 		},
 	}
 
-	nodeCount := len(awsCluster.InstanceIds)
+	nodeCount := len(instanceIds)
 	log.Infof("Waitng for %d EC2 instances to exist", nodeCount)
 
 	describeInstancesInput := &ec2.DescribeInstancesInput{
-		InstanceIds: awsCluster.InstanceIds,
+		InstanceIds: instanceIds,
 	}
 
 	if err := ec2Svc.WaitUntilInstanceExists(describeInstancesInput); err != nil {
@@ -585,12 +799,12 @@ weave launch`, awsCluster.Name)))
 
 	// We are trying to tag before it's running as weave requires the tag to function,
 	// so the earlier we tag the better chance we have to see the cluster ready in ECS
-	if err := createTags(ec2Svc, awsCluster.InstanceIds, tags); err != nil {
+	if err := createTags(ec2Svc, instanceIds, tags); err != nil {
 		return errors.New("Unable to create tags for instances: " + err.Error())
 	}
 
 	describeInstanceStatusInput := &ec2.DescribeInstanceStatusInput{
-		InstanceIds: awsCluster.InstanceIds,
+		InstanceIds: instanceIds,
 	}
 
 	log.Infof("Waitng for %d EC2 instances to be status ok", nodeCount)
@@ -724,18 +938,29 @@ func Max(x, y int) int {
 	return y
 }
 
-func startService(awsCluster *hpaws.AWSCluster, mapping *apis.NodeMapping, ecsSvc *ecs.ECS, log *logging.Logger) error {
+func startService(
+	awsCluster *hpaws.AWSCluster,
+	mapping *apis.NodeMapping,
+	desiredCount int,
+	ecsSvc *ecs.ECS,
+	log *logging.Logger) error {
 	serviceInput := &ecs.CreateServiceInput{
-		DesiredCount:   aws.Int64(int64(1)),
+		DesiredCount:   aws.Int64(int64(desiredCount)),
 		ServiceName:    aws.String(mapping.Service()),
 		TaskDefinition: aws.String(mapping.Task),
 		Cluster:        aws.String(awsCluster.Name),
-		PlacementConstraints: []*ecs.PlacementConstraint{
+	}
+
+	if mapping.IsFargate() {
+		serviceInput.LaunchType = aws.String(ecs.LaunchTypeFargate)
+		serviceInput.NetworkConfiguration = fargateNetworkConfiguration(awsCluster)
+	} else {
+		serviceInput.PlacementConstraints = []*ecs.PlacementConstraint{
 			{
 				Expression: aws.String(fmt.Sprintf("attribute:imageId == %s", mapping.ImageIdAttribute())),
 				Type:       aws.String("memberOf"),
 			},
-		},
+		}
 	}
 
 	log.Infof("Starting service %v\n", serviceInput)
@@ -747,8 +972,57 @@ func startService(awsCluster *hpaws.AWSCluster, mapping *apis.NodeMapping, ecsSv
 }
 
 func createServices(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster, deployment *apis.Deployment, log *logging.Logger) error {
-	for _, mapping := range deployment.NodeMapping {
-		startService(awsCluster, &mapping, ecsSvc, log)
+	phases, err := apis.DeployPhases(deployment.ECSDeployment.TaskDependencies())
+	if err != nil {
+		return errors.New("Unable to resolve task dependencies: " + err.Error())
+	}
+
+	for i, phase := range phases {
+		if len(phases) > 1 {
+			log.Infof("Starting phase %d of %d with tasks %s", i+1, len(phases), phase)
+		}
+
+		serviceNames := []*string{}
+		for _, family := range phase {
+			desiredCounts := deployment.ECSDeployment.ServiceDesiredCounts(family, deployment.TaskMappingCount(family))
+			serviceCount := 0
+			for _, mapping := range deployment.NodeMapping {
+				if mapping.Task != family {
+					continue
+				}
+				startService(awsCluster, &mapping, desiredCounts[serviceCount], ecsSvc, log)
+				serviceNames = append(serviceNames, aws.String(mapping.Service()))
+				serviceCount += 1
+			}
+		}
+
+		// Tasks in later phases depend on this phase, so wait for it to be stable first
+		if i < len(phases)-1 && len(serviceNames) > 0 {
+			log.Infof("Waiting for services of tasks %s to be stable", phase)
+			if err := waitUntilServicesStable(ecsSvc, awsCluster, serviceNames); err != nil {
+				return fmt.Errorf("Unable to wait for tasks %s to be stable: %s", phase, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+func waitUntilServicesStable(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster, serviceNames []*string) error {
+	// DescribeServices only accepts up to 10 services in a single request
+	for start := 0; start < len(serviceNames); start += 10 {
+		end := start + 10
+		if end > len(serviceNames) {
+			end = len(serviceNames)
+		}
+
+		describeServicesInput := &ecs.DescribeServicesInput{
+			Cluster:  aws.String(awsCluster.Name),
+			Services: serviceNames[start:end],
+		}
+		if err := ecsSvc.WaitUntilServicesStable(describeServicesInput); err != nil {
+			return errors.New("Unable to wait until services stable: " + err.Error())
+		}
 	}
 
 	return nil
@@ -784,6 +1058,10 @@ func waitUntilECSClusterReady(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster, dep
 }
 
 func populatePublicDnsNames(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, log *logging.Logger) error {
+	if len(awsCluster.InstanceIds) == 0 {
+		return nil
+	}
+
 	// We need to describe instances again to obtain the PublicDnsAddresses for ssh.
 	describeInstanceOutput, err := ec2Svc.DescribeInstances(&ec2.DescribeInstancesInput{
 		InstanceIds: awsCluster.InstanceIds,
@@ -794,14 +1072,19 @@ func populatePublicDnsNames(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, log *
 
 	for _, reservation := range describeInstanceOutput.Reservations {
 		for _, instance := range reservation.Instances {
-			if instance.PublicDnsName != nil && *instance.PublicDnsName != "" {
-				for nodeId, nodeInfo := range awsCluster.NodeInfos {
-					if *nodeInfo.Instance.InstanceId == *instance.InstanceId {
-						log.Infof("Assigning public dns name %s to node %d",
-							*instance.PublicDnsName, nodeId)
-						nodeInfo.PublicDnsName = *instance.PublicDnsName
-						nodeInfo.PrivateIp = *instance.PrivateIpAddress
-					}
+			for nodeId, nodeInfo := range awsCluster.NodeInfos {
+				if *nodeInfo.Instance.InstanceId != *instance.InstanceId {
+					continue
+				}
+
+				// Refresh the instance, which only has its public ip once running
+				nodeInfo.Instance = instance
+				// Nodes in private subnets only have a private ip
+				nodeInfo.PrivateIp = aws.StringValue(instance.PrivateIpAddress)
+				if instance.PublicDnsName != nil && *instance.PublicDnsName != "" {
+					log.Infof("Assigning public dns name %s to node %d",
+						*instance.PublicDnsName, nodeId)
+					nodeInfo.PublicDnsName = *instance.PublicDnsName
 				}
 			}
 		}
@@ -811,6 +1094,43 @@ func populatePublicDnsNames(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, log *
 }
 
 func setupInstanceAttribute(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster, deployment *apis.Deployment) error {
+	if err := populateContainerInstanceArns(ecsSvc, awsCluster); err != nil {
+		return err
+	}
+
+	for _, mapping := range deployment.NodeMapping {
+		if mapping.IsFargate() {
+			continue
+		}
+
+		nodeInfo, ok := awsCluster.NodeInfos[mapping.Id]
+		if !ok {
+			return fmt.Errorf("Unable to find Node id %d in instance map", mapping.Id)
+		}
+
+		params := &ecs.PutAttributesInput{
+			Attributes: []*ecs.Attribute{
+				{
+					Name:       aws.String("imageId"),
+					TargetId:   aws.String(nodeInfo.Arn),
+					TargetType: aws.String("container-instance"),
+					Value:      aws.String(mapping.ImageIdAttribute()),
+				},
+			},
+			Cluster: aws.String(awsCluster.Name),
+		}
+
+		_, err := ecsSvc.PutAttributes(params)
+
+		if err != nil {
+			return fmt.Errorf("Unable to put attribute on ECS instance: %v\nMessage:%s\n", params, err.Error())
+		}
+	}
+	return nil
+}
+
+// populateContainerInstanceArns records the container instance arn of every node
+func populateContainerInstanceArns(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster) error {
 	var containerInstances []*string
 	listInstancesInput := &ecs.ListContainerInstancesInput{
 		Cluster: aws.String(awsCluster.Name),
@@ -823,6 +1143,9 @@ func setupInstanceAttribute(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster, deplo
 	}
 
 	containerInstances = listInstancesOutput.ContainerInstanceArns
+	if len(containerInstances) == 0 {
+		return nil
+	}
 
 	describeInstancesInput := &ecs.DescribeContainerInstancesInput{
 		Cluster:            aws.String(awsCluster.Name),
@@ -844,30 +1167,6 @@ func setupInstanceAttribute(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster, deplo
 		}
 	}
 
-	for _, mapping := range deployment.NodeMapping {
-		nodeInfo, ok := awsCluster.NodeInfos[mapping.Id]
-		if !ok {
-			return fmt.Errorf("Unable to find Node id %d in instance map", mapping.Id)
-		}
-
-		params := &ecs.PutAttributesInput{
-			Attributes: []*ecs.Attribute{
-				{
-					Name:       aws.String("imageId"),
-					TargetId:   aws.String(nodeInfo.Arn),
-					TargetType: aws.String("container-instance"),
-					Value:      aws.String(mapping.ImageIdAttribute()),
-				},
-			},
-			Cluster: aws.String(awsCluster.Name),
-		}
-
-		_, err := ecsSvc.PutAttributes(params)
-
-		if err != nil {
-			return fmt.Errorf("Unable to put attribute on ECS instance: %v\nMessage:%s\n", params, err.Error())
-		}
-	}
 	return nil
 }
 
@@ -924,15 +1223,18 @@ func setupAWSLogsGroup(sess *session.Session, deployment *apis.Deployment) error
 	return nil
 }
 
-func (ecsDeployer *ECSDeployer) SetupEC2Infra(user string, uploadedFiles map[string]string, ec2Svc *ec2.EC2, iamSvc *iam.IAM, images map[string]string) error {
+func (ecsDeployer *ECSDeployer) SetupEC2Infra(user string, uploadedFiles map[string]string, ec2Svc *ec2.EC2, iamSvc *iam.IAM, imageId string) error {
 	awsCluster := ecsDeployer.AWSCluster
 	deployment := ecsDeployer.Deployment
 	log := ecsDeployer.DeploymentLog.Logger
 
-	log.Infof("Setting up IAM Role")
-	if err := setupIAM(iamSvc, awsCluster, deployment, log); err != nil {
-		ecsDeployer.DeleteDeployment()
-		return errors.New("Unable to setup IAM: " + err.Error())
+	// Fargate tasks don't need container instances, nor their instance profile
+	if len(deployment.ClusterDefinition.Nodes) > 0 {
+		log.Infof("Setting up IAM Role")
+		if err := setupIAM(iamSvc, awsCluster, deployment, log); err != nil {
+			ecsDeployer.DeleteDeployment()
+			return errors.New("Unable to setup IAM: " + err.Error())
+		}
 	}
 
 	log.Infof("Setting up Network")
@@ -942,7 +1244,7 @@ func (ecsDeployer *ECSDeployer) SetupEC2Infra(user string, uploadedFiles map[str
 	}
 
 	log.Infof("Launching EC2 instances")
-	if err := setupEC2(ec2Svc, awsCluster, deployment, log, images); err != nil {
+	if err := setupEC2(ec2Svc, awsCluster, deployment, log, imageId); err != nil {
 		ecsDeployer.DeleteDeployment()
 		return errors.New("Unable to setup EC2: " + err.Error())
 	}
@@ -954,18 +1256,13 @@ func (ecsDeployer *ECSDeployer) SetupEC2Infra(user string, uploadedFiles map[str
 	}
 
 	log.Infof("Uploading files to EC2 Instances")
-	if err := uploadFiles(user, ec2Svc, awsCluster, deployment, uploadedFiles); err != nil {
+	if err := uploadFiles(ecsDeployer.Config, user, awsCluster, deployment, uploadedFiles, log); err != nil {
 		return errors.New("Unable to upload files to EC2: " + err.Error())
 	}
 
 	return nil
 }
 
-func isRegionValid(region string, images map[string]string) bool {
-	_, ok := images[region]
-	return ok
-}
-
 func stopECSTasks(svc *ecs.ECS, awsCluster *hpaws.AWSCluster, log *logging.Logger) error {
 	errMsg := false
 	params := &ecs.ListTasksInput{
@@ -1148,6 +1445,12 @@ func deleteSecurityGroup(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, log *log
 					aws.String(awsCluster.Name),
 				},
 			},
+			{
+				Name: aws.String("vpc-id"),
+				Values: []*string{
+					aws.String(awsCluster.VpcId),
+				},
+			},
 		},
 	}
 
@@ -1175,36 +1478,30 @@ func deleteSecurityGroup(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, log *log
 
 func deleteSubnet(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, log *logging.Logger) error {
 	errBool := false
-	params := &ec2.DescribeTagsInput{
+	params := &ec2.DescribeSubnetsInput{
 		Filters: []*ec2.Filter{
 			{
-				Name: aws.String("resource-type"),
+				Name: aws.String("vpc-id"),
 				Values: []*string{
-					aws.String("subnet"),
-				},
-			},
-			{
-				Name: aws.String("tag:Name"),
-				Values: []*string{
-					aws.String(awsCluster.SubnetName()),
+					aws.String(awsCluster.VpcId),
 				},
 			},
 		},
 	}
 
-	resp, err := ec2Svc.DescribeTags(params)
+	resp, err := ec2Svc.DescribeSubnets(params)
 
 	if err != nil {
-		return fmt.Errorf("Unable to describe tags of subnet: %s\n", err.Error())
+		return fmt.Errorf("Unable to describe subnets of VPC: %s\n", err.Error())
 	}
 
-	for _, subnet := range resp.Tags {
+	for _, subnet := range resp.Subnets {
 		params := &ec2.DeleteSubnetInput{
-			SubnetId: subnet.ResourceId,
+			SubnetId: subnet.SubnetId,
 		}
 		_, err := ec2Svc.DeleteSubnet(params)
 		if err != nil {
-			log.Warningf("Unable to delete subnet (%s) %s\n", *subnet.ResourceId, err.Error())
+			log.Warningf("Unable to delete subnet (%s) %s\n", *subnet.SubnetId, err.Error())
 			errBool = true
 		}
 	}
@@ -1305,6 +1602,10 @@ func deleteEC2(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster) error {
 		instanceIds = append(instanceIds, id)
 	}
 
+	if len(instanceIds) == 0 {
+		return nil
+	}
+
 	params := &ec2.TerminateInstancesInput{
 		InstanceIds: instanceIds,
 	}
@@ -1335,6 +1636,50 @@ func deleteCluster(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster) error {
 	return nil
 }
 
+// ScaleTask updates the desired count of the services of a running task
+func (ecsDeployer *ECSDeployer) ScaleTask(taskName string, replicas int) error {
+	if replicas < 0 {
+		return fmt.Errorf("Invalid desired count %d", replicas)
+	}
+
+	awsCluster := ecsDeployer.AWSCluster
+	deployment := ecsDeployer.Deployment
+	log := ecsDeployer.DeploymentLog.Logger
+
+	sess, sessionErr := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		return fmt.Errorf("Unable to create session: %s", sessionErr.Error())
+	}
+	ecsSvc := ecs.New(sess)
+
+	serviceCount := deployment.TaskMappingCount(taskName)
+	if serviceCount == 0 {
+		return fmt.Errorf("Unable to find node mapping for task %s", taskName)
+	}
+
+	// The desired count is of the whole task, so it's split across its services
+	desiredCounts := apis.SplitReplicas(replicas, serviceCount)
+	i := 0
+	for _, mapping := range deployment.NodeMapping {
+		if mapping.Task != taskName {
+			continue
+		}
+
+		if err := updateECSService(ecsSvc, &mapping, awsCluster.Name, desiredCounts[i]); err != nil {
+			return err
+		}
+		log.Infof("Updated service %s desired count to %d", mapping.Service(), desiredCounts[i])
+		i += 1
+	}
+
+	if deployment.ECSDeployment.DesiredCounts == nil {
+		deployment.ECSDeployment.DesiredCounts = map[string]int{}
+	}
+	deployment.ECSDeployment.DesiredCounts[taskName] = replicas
+
+	return nil
+}
+
 func (ecsDeployer *ECSDeployer) GetServiceMappings() (map[string]interface{}, error) {
 	return nil, errors.New("Unimplemented")
 }
@@ -1356,6 +1701,14 @@ func (ecsDeployer *ECSDeployer) GetServiceUrl(serviceName string) (string, error
 		return "", errors.New("Unable to find container in deployment container defintiions")
 	}
 
+	if ecsDeployer.Deployment.FargateTasks()[taskFamilyName] {
+		host, err := ecsDeployer.fargateServiceHost(taskFamilyName)
+		if err != nil {
+			return "", err
+		}
+		return host + ":" + nodePort, nil
+	}
+
 	nodeId := -1
 	for _, nodeMapping := range ecsDeployer.Deployment.NodeMapping {
 		if nodeMapping.Task == taskFamilyName {
@@ -1373,7 +1726,7 @@ func (ecsDeployer *ECSDeployer) GetServiceUrl(serviceName string) (string, error
 		return "", errors.New("Unable to find node in cluster")
 	}
 
-	return nodeInfo.PublicDnsName + ":" + nodePort, nil
+	return nodeHost(nodeInfo) + ":" + nodePort, nil
 }
 
 func (ecsDeployer *ECSDeployer) GetServiceAddress(serviceName string) (*apis.ServiceAddress, error) {
@@ -1393,6 +1746,14 @@ func (ecsDeployer *ECSDeployer) GetServiceAddress(serviceName string) (*apis.Ser
 		return nil, errors.New("Unable to find container in deployment container defintiions")
 	}
 
+	if ecsDeployer.Deployment.FargateTasks()[taskFamilyName] {
+		host, err := ecsDeployer.fargateServiceHost(taskFamilyName)
+		if err != nil {
+			return nil, err
+		}
+		return &apis.ServiceAddress{Host: host, Port: nodePort}, nil
+	}
+
 	nodeId := -1
 	for _, nodeMapping := range ecsDeployer.Deployment.NodeMapping {
 		if nodeMapping.Task == taskFamilyName {
@@ -1410,13 +1771,15 @@ func (ecsDeployer *ECSDeployer) GetServiceAddress(serviceName string) (*apis.Ser
 		return nil, errors.New("Unable to find node in cluster")
 	}
 
-	return &apis.ServiceAddress{Host: nodeInfo.PublicDnsName, Port: nodePort}, nil
+	return &apis.ServiceAddress{Host: nodeHost(nodeInfo), Port: nodePort}, nil
 }
 
 func (ecsDeployer *ECSDeployer) GetStoreInfo() interface{} {
-	return nil
+	return &StoreInfo{
+		VpcPeering: ecsDeployer.VpcPeering,
+	}
 }
 
 func (ecsDeployer *ECSDeployer) NewStoreInfo() interface{} {
-	return nil
+	return &StoreInfo{}
 }
@@ -0,0 +1,114 @@
+package awsecs
+
+import (
+	"encoding/json"
+	"errors"
+
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+	"github.com/hyperpilotio/deployer/common"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// CollectDiagnostics adds the logs of the container instances, and the services with their
+// events and the stopped tasks of the cluster to the bundle
+func (ecsDeployer *ECSDeployer) CollectDiagnostics(diagnostics *common.Diagnostics) error {
+	if sshClients, err := ecsDeployer.GetNodeSshClients(); err != nil {
+		diagnostics.AddError("nodes", err)
+	} else {
+		common.CollectNodeLogs(diagnostics, sshClients, common.ECSNodeLogCommands,
+			common.SshConcurrency(ecsDeployer.Config))
+	}
+
+	awsCluster := ecsDeployer.AWSCluster
+	sess, err := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if err != nil {
+		diagnostics.AddError("ecs", errors.New("Unable to create session: "+err.Error()))
+		return nil
+	}
+	ecsSvc := ecs.New(sess)
+
+	if services, err := describeClusterServices(ecsSvc, awsCluster); err != nil {
+		diagnostics.AddError("ecs/services.json", err)
+	} else if content, err := json.MarshalIndent(services, "", "  "); err != nil {
+		diagnostics.AddError("ecs/services.json", err)
+	} else if err := diagnostics.AddFile("ecs/services.json", content); err != nil {
+		return err
+	}
+
+	if tasks, err := describeStoppedTasks(ecsSvc, awsCluster); err != nil {
+		diagnostics.AddError("ecs/stopped-tasks.json", err)
+	} else if content, err := json.MarshalIndent(tasks, "", "  "); err != nil {
+		diagnostics.AddError("ecs/stopped-tasks.json", err)
+	} else if err := diagnostics.AddFile("ecs/stopped-tasks.json", content); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func describeClusterServices(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster) ([]*ecs.Service, error) {
+	serviceArns := []*string{}
+	if err := ecsSvc.ListServicesPages(&ecs.ListServicesInput{
+		Cluster: aws.String(awsCluster.Name),
+	}, func(output *ecs.ListServicesOutput, lastPage bool) bool {
+		serviceArns = append(serviceArns, output.ServiceArns...)
+		return true
+	}); err != nil {
+		return nil, errors.New("Unable to list services: " + err.Error())
+	}
+
+	services := []*ecs.Service{}
+	// DescribeServices only accepts up to 10 services in a single request
+	for start := 0; start < len(serviceArns); start += 10 {
+		end := start + 10
+		if end > len(serviceArns) {
+			end = len(serviceArns)
+		}
+
+		output, err := ecsSvc.DescribeServices(&ecs.DescribeServicesInput{
+			Cluster:  aws.String(awsCluster.Name),
+			Services: serviceArns[start:end],
+		})
+		if err != nil {
+			return nil, errors.New("Unable to describe services: " + err.Error())
+		}
+		services = append(services, output.Services...)
+	}
+
+	return services, nil
+}
+
+func describeStoppedTasks(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster) ([]*ecs.Task, error) {
+	taskArns := []*string{}
+	if err := ecsSvc.ListTasksPages(&ecs.ListTasksInput{
+		Cluster:       aws.String(awsCluster.Name),
+		DesiredStatus: aws.String(ecs.DesiredStatusStopped),
+	}, func(output *ecs.ListTasksOutput, lastPage bool) bool {
+		taskArns = append(taskArns, output.TaskArns...)
+		return true
+	}); err != nil {
+		return nil, errors.New("Unable to list stopped tasks: " + err.Error())
+	}
+
+	tasks := []*ecs.Task{}
+	// DescribeTasks only accepts up to 100 tasks in a single request
+	for start := 0; start < len(taskArns); start += 100 {
+		end := start + 100
+		if end > len(taskArns) {
+			end = len(taskArns)
+		}
+
+		output, err := ecsSvc.DescribeTasks(&ecs.DescribeTasksInput{
+			Cluster: aws.String(awsCluster.Name),
+			Tasks:   taskArns[start:end],
+		})
+		if err != nil {
+			return nil, errors.New("Unable to describe stopped tasks: " + err.Error())
+		}
+		tasks = append(tasks, output.Tasks...)
+	}
+
+	return tasks, nil
+}
@@ -0,0 +1,244 @@
+package awsecs
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperpilotio/deployer/apis"
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+	"github.com/hyperpilotio/go-utils/funcs"
+	logging "github.com/op/go-logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+const taskExecutionPolicyArn = "arn:aws:iam::aws:policy/service-role/AmazonECSTaskExecutionRolePolicy"
+
+// setupExecutionRole creates the role ECS uses to pull images and send the awslogs of
+// Fargate tasks, and return its arn
+func setupExecutionRole(iamSvc *iam.IAM, awsCluster *hpaws.AWSCluster, log *logging.Logger) (string, error) {
+	roleName := awsCluster.ExecutionRoleName()
+	log.Infof("Creating task execution role %s", roleName)
+	createOutput, err := iamSvc.CreateRole(&iam.CreateRoleInput{
+		AssumeRolePolicyDocument: aws.String(executionTrustDocument),
+		RoleName:                 aws.String(roleName),
+	})
+	if err != nil {
+		return "", errors.New("Unable to create task execution role: " + err.Error())
+	}
+
+	if _, err := iamSvc.AttachRolePolicy(&iam.AttachRolePolicyInput{
+		PolicyArn: aws.String(taskExecutionPolicyArn),
+		RoleName:  aws.String(roleName),
+	}); err != nil {
+		return "", errors.New("Unable to attach task execution policy: " + err.Error())
+	}
+
+	if err := iamSvc.WaitUntilRoleExists(&iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	}); err != nil {
+		return "", errors.New("Unable to wait for task execution role to exist: " + err.Error())
+	}
+
+	return aws.StringValue(createOutput.Role.Arn), nil
+}
+
+// deleteExecutionRole deletes the task execution role of the Fargate tasks
+func deleteExecutionRole(iamSvc *iam.IAM, awsCluster *hpaws.AWSCluster, log *logging.Logger) error {
+	roleName := awsCluster.ExecutionRoleName()
+	if _, err := iamSvc.DetachRolePolicy(&iam.DetachRolePolicyInput{
+		PolicyArn: aws.String(taskExecutionPolicyArn),
+		RoleName:  aws.String(roleName),
+	}); err != nil {
+		log.Warningf("Unable to detach task execution policy: %s", err.Error())
+	}
+
+	if _, err := iamSvc.DeleteRole(&iam.DeleteRoleInput{
+		RoleName: aws.String(roleName),
+	}); err != nil {
+		return errors.New("Unable to delete task execution role: " + err.Error())
+	}
+
+	return nil
+}
+
+// setFargateCompatibility makes the task definition runnable on Fargate. Fargate tasks only
+// support awsvpc networking, where host ports have to be the same as the container ports.
+func setFargateCompatibility(taskDefinition *ecs.RegisterTaskDefinitionInput, executionRoleArn string) {
+	taskDefinition.RequiresCompatibilities = []*string{aws.String(ecs.CompatibilityFargate)}
+	taskDefinition.NetworkMode = aws.String(ecs.NetworkModeAwsvpc)
+	if aws.StringValue(taskDefinition.ExecutionRoleArn) == "" {
+		taskDefinition.ExecutionRoleArn = aws.String(executionRoleArn)
+	}
+
+	for _, container := range taskDefinition.ContainerDefinitions {
+		for _, portMapping := range container.PortMappings {
+			portMapping.HostPort = portMapping.ContainerPort
+		}
+	}
+}
+
+// fargateNetworkConfiguration return the network configuration of Fargate services, which
+// spread their tasks over the node subnets, with a public ip in public subnets
+func fargateNetworkConfiguration(awsCluster *hpaws.AWSCluster) *ecs.NetworkConfiguration {
+	subnetIds, public := taskSubnetIds(awsCluster)
+	assignPublicIp := ecs.AssignPublicIpDisabled
+	if public {
+		assignPublicIp = ecs.AssignPublicIpEnabled
+	}
+
+	return &ecs.NetworkConfiguration{
+		AwsvpcConfiguration: &ecs.AwsVpcConfiguration{
+			AssignPublicIp: aws.String(assignPublicIp),
+			SecurityGroups: []*string{aws.String(awsCluster.SecurityGroupId)},
+			Subnets:        subnetIds,
+		},
+	}
+}
+
+// listFargateTasks return the arns of the running tasks of the Fargate services
+func listFargateTasks(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster, deployment *apis.Deployment) ([]*string, error) {
+	taskArns := []*string{}
+	for _, mapping := range deployment.NodeMapping {
+		if !mapping.IsFargate() {
+			continue
+		}
+
+		output, err := ecsSvc.ListTasks(&ecs.ListTasksInput{
+			Cluster:     aws.String(awsCluster.Name),
+			ServiceName: aws.String(mapping.Service()),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list tasks of service %s: %s", mapping.Service(), err.Error())
+		}
+		taskArns = append(taskArns, output.TaskArns...)
+	}
+
+	return taskArns, nil
+}
+
+// waitUntilTasksStopped waits until the given tasks are stopped, which also releases their
+// network interfaces
+func waitUntilTasksStopped(ecsSvc *ecs.ECS, awsCluster *hpaws.AWSCluster, taskArns []*string) error {
+	// DescribeTasks only accepts up to 100 tasks in a single request
+	for start := 0; start < len(taskArns); start += 100 {
+		end := start + 100
+		if end > len(taskArns) {
+			end = len(taskArns)
+		}
+
+		if err := ecsSvc.WaitUntilTasksStopped(&ecs.DescribeTasksInput{
+			Cluster: aws.String(awsCluster.Name),
+			Tasks:   taskArns[start:end],
+		}); err != nil {
+			return errors.New("Unable to wait until tasks stopped: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// waitUntilSecurityGroupInterfacesDeleted waits until the network interfaces of stopped
+// Fargate tasks are deleted, as they keep the security group and subnet from being deleted
+func waitUntilSecurityGroupInterfacesDeleted(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster) error {
+	return funcs.LoopUntil(time.Minute*5, time.Second*10, func() (bool, error) {
+		output, err := ec2Svc.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("vpc-id"),
+					Values: []*string{aws.String(awsCluster.VpcId)},
+				},
+				{
+					Name:   aws.String("group-name"),
+					Values: []*string{aws.String(awsCluster.Name)},
+				},
+			},
+		})
+		if err != nil {
+			return false, errors.New("Unable to describe network interfaces: " + err.Error())
+		}
+
+		return len(output.NetworkInterfaces) == 0, nil
+	})
+}
+
+// fargateServiceHost return the public ip, or private ip in private subnets, of a running task of the Fargate service of a task family
+func (ecsDeployer *ECSDeployer) fargateServiceHost(family string) (string, error) {
+	awsCluster := ecsDeployer.AWSCluster
+	sess, sessionErr := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		return "", errors.New("Unable to create session: " + sessionErr.Error())
+	}
+
+	ecsSvc := ecs.New(sess)
+	ec2Svc := ec2.New(sess)
+
+	serviceName := apis.NodeMapping{Task: family}.Service()
+	listOutput, err := ecsSvc.ListTasks(&ecs.ListTasksInput{
+		Cluster:       aws.String(awsCluster.Name),
+		ServiceName:   aws.String(serviceName),
+		DesiredStatus: aws.String(ecs.DesiredStatusRunning),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Unable to list tasks of service %s: %s", serviceName, err.Error())
+	}
+
+	if len(listOutput.TaskArns) == 0 {
+		return "", fmt.Errorf("Unable to find running tasks of service %s", serviceName)
+	}
+
+	describeOutput, err := ecsSvc.DescribeTasks(&ecs.DescribeTasksInput{
+		Cluster: aws.String(awsCluster.Name),
+		Tasks:   listOutput.TaskArns,
+	})
+	if err != nil {
+		return "", errors.New("Unable to describe tasks: " + err.Error())
+	}
+
+	networkInterfaceIds := []*string{}
+	for _, task := range describeOutput.Tasks {
+		for _, attachment := range task.Attachments {
+			if aws.StringValue(attachment.Type) != "ElasticNetworkInterface" {
+				continue
+			}
+
+			for _, detail := range attachment.Details {
+				if aws.StringValue(detail.Name) == "networkInterfaceId" {
+					networkInterfaceIds = append(networkInterfaceIds, detail.Value)
+				}
+			}
+		}
+	}
+
+	if len(networkInterfaceIds) == 0 {
+		return "", fmt.Errorf("Unable to find network interfaces of service %s", serviceName)
+	}
+
+	interfacesOutput, err := ec2Svc.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+		NetworkInterfaceIds: networkInterfaceIds,
+	})
+	if err != nil {
+		return "", errors.New("Unable to describe network interfaces: " + err.Error())
+	}
+
+	privateIp := ""
+	for _, networkInterface := range interfacesOutput.NetworkInterfaces {
+		if networkInterface.Association != nil && aws.StringValue(networkInterface.Association.PublicIp) != "" {
+			return aws.StringValue(networkInterface.Association.PublicIp), nil
+		}
+
+		if privateIp == "" {
+			privateIp = aws.StringValue(networkInterface.PrivateIpAddress)
+		}
+	}
+
+	if privateIp == "" {
+		return "", fmt.Errorf("Unable to find ip of service %s", serviceName)
+	}
+
+	return privateIp, nil
+}
@@ -17,6 +17,12 @@ type ECSDeployer struct {
 	Deployment    *apis.Deployment
 	DeploymentLog *log.FileLog
 	Scheduler     *job.Scheduler
+
+	VpcPeering *apis.VPCPeeringStatus
+}
+
+type StoreInfo struct {
+	VpcPeering *apis.VPCPeeringStatus
 }
 
 type ClusterInfo struct {
@@ -0,0 +1,488 @@
+package awsecs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/hyperpilotio/deployer/apis"
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+	"github.com/hyperpilotio/go-utils/funcs"
+	logging "github.com/op/go-logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Tag telling public and private subnets apart when the cluster state is reloaded
+const subnetTierTag = "hyperpilot/subnet-tier"
+
+// subnetCidrBlock return the range of the subnet of the zone with the given index in the /16
+// VPC range. Public subnets take the lower half of the VPC range and private subnets the upper
+// half, as /20 blocks for up to apis.MaxECSAvailabilityZones zones.
+func subnetCidrBlock(vpcCidrBlock string, index int, private bool) (string, error) {
+	if index < 0 || index >= apis.MaxECSAvailabilityZones {
+		return "", fmt.Errorf("Zone index %d is out of the VPC range", index)
+	}
+
+	_, vpcNet, err := net.ParseCIDR(vpcCidrBlock)
+	if err != nil || vpcNet.IP.To4() == nil {
+		return "", fmt.Errorf("Invalid VPC range %s", vpcCidrBlock)
+	}
+
+	if ones, _ := vpcNet.Mask.Size(); ones != 16 {
+		return "", fmt.Errorf("VPC range %s is not a /16 block", vpcCidrBlock)
+	}
+
+	offset := index * 16
+	if private {
+		offset += 128
+	}
+
+	ip := vpcNet.IP.To4()
+	return fmt.Sprintf("%d.%d.%d.0/20", ip[0], ip[1], offset), nil
+}
+
+// createSubnets creates a public subnet, and a private one if requested, in each availability
+// zone of the deployment
+func createSubnets(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, deployment *apis.Deployment, log *logging.Logger) error {
+	zones := deployment.ECSAvailabilityZones()
+	if len(zones) == 0 {
+		// Let AWS pick the zone, private subnets follow the zone of the public one
+		zones = []string{""}
+	}
+
+	vpcCidrBlock := deployment.ECSVpcCidrBlock()
+	awsCluster.PublicSubnetIds = map[string]string{}
+	awsCluster.PrivateSubnetIds = map[string]string{}
+	for i, zone := range zones {
+		cidrBlock, err := subnetCidrBlock(vpcCidrBlock, i, false)
+		if err != nil {
+			return err
+		}
+
+		subnet, err := createSubnet(ec2Svc, awsCluster, zone, cidrBlock, "public")
+		if err != nil {
+			return err
+		}
+		zone = aws.StringValue(subnet.AvailabilityZone)
+		awsCluster.PublicSubnetIds[zone] = aws.StringValue(subnet.SubnetId)
+		if i == 0 {
+			awsCluster.SubnetId = aws.StringValue(subnet.SubnetId)
+		}
+		log.Infof("Created public subnet %s in %s", aws.StringValue(subnet.SubnetId), zone)
+
+		if !deployment.HasPrivateSubnets() {
+			continue
+		}
+
+		cidrBlock, err = subnetCidrBlock(vpcCidrBlock, i, true)
+		if err != nil {
+			return err
+		}
+
+		subnet, err = createSubnet(ec2Svc, awsCluster, zone, cidrBlock, "private")
+		if err != nil {
+			return err
+		}
+		awsCluster.PrivateSubnetIds[zone] = aws.StringValue(subnet.SubnetId)
+		log.Infof("Created private subnet %s in %s", aws.StringValue(subnet.SubnetId), zone)
+	}
+
+	return nil
+}
+
+func createSubnet(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, zone string, cidrBlock string, tier string) (*ec2.Subnet, error) {
+	createSubnetInput := &ec2.CreateSubnetInput{
+		VpcId:     aws.String(awsCluster.VpcId),
+		CidrBlock: aws.String(cidrBlock),
+	}
+	if zone != "" {
+		createSubnetInput.AvailabilityZone = aws.String(zone)
+	}
+
+	// NOTE Unhandled case:
+	// 1. if subnet created successfuly but aws sdk connection broke or request reached timeout
+	subnetResponse, err := ec2Svc.CreateSubnet(createSubnetInput)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create %s subnet: %s", tier, err.Error())
+	}
+	subnet := subnetResponse.Subnet
+
+	describeSubnetsInput := &ec2.DescribeSubnetsInput{
+		SubnetIds: []*string{subnet.SubnetId},
+	}
+
+	if err := ec2Svc.WaitUntilSubnetAvailable(describeSubnetsInput); err != nil {
+		return nil, errors.New("Unable to wait until subnet available: " + err.Error())
+	}
+
+	subnetName := awsCluster.SubnetName()
+	if tier == "private" {
+		subnetName = awsCluster.Name + "-private-subnet"
+	}
+
+	tags := []*ec2.Tag{
+		{
+			Key:   aws.String("Name"),
+			Value: aws.String(subnetName),
+		},
+		{
+			Key:   aws.String(subnetTierTag),
+			Value: aws.String(tier),
+		},
+	}
+	if err := createTags(ec2Svc, []*string{subnet.SubnetId}, tags); err != nil {
+		return nil, errors.New("Unable to tag subnet: " + err.Error())
+	}
+
+	return subnet, nil
+}
+
+// createNatGateways creates a NAT gateway in the public subnet of each zone, and routes the
+// outgoing traffic of the private subnet of the zone through it
+func createNatGateways(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, log *logging.Logger) (err error) {
+	allocationIds := []*string{}
+	// Elastic ips held by NAT gateways that are still up fail to release here, those are
+	// released once the NAT gateways are deleted with the deployment
+	defer func() {
+		if err != nil {
+			releaseAddresses(ec2Svc, allocationIds, log)
+		}
+	}()
+
+	natGatewayIds := map[string]*string{}
+	for zone := range awsCluster.PrivateSubnetIds {
+		allocateOutput, err := ec2Svc.AllocateAddress(&ec2.AllocateAddressInput{
+			Domain: aws.String(ec2.DomainTypeVpc),
+		})
+		if err != nil {
+			return errors.New("Unable to allocate elastic ip: " + err.Error())
+		}
+		allocationIds = append(allocationIds, allocateOutput.AllocationId)
+
+		natOutput, err := ec2Svc.CreateNatGateway(&ec2.CreateNatGatewayInput{
+			AllocationId: allocateOutput.AllocationId,
+			SubnetId:     aws.String(awsCluster.PublicSubnetIds[zone]),
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to create NAT gateway in %s: %s", zone, err.Error())
+		}
+
+		natGatewayId := natOutput.NatGateway.NatGatewayId
+		if err := createTag(ec2Svc, []*string{natGatewayId}, "Name", awsCluster.Name); err != nil {
+			return errors.New("Unable to tag NAT gateway: " + err.Error())
+		}
+		natGatewayIds[zone] = natGatewayId
+	}
+
+	ids := []*string{}
+	for _, natGatewayId := range natGatewayIds {
+		ids = append(ids, natGatewayId)
+	}
+
+	log.Infof("Waiting for %d NAT gateways to be available", len(ids))
+	if err := ec2Svc.WaitUntilNatGatewayAvailable(&ec2.DescribeNatGatewaysInput{
+		NatGatewayIds: ids,
+	}); err != nil {
+		return errors.New("Unable to wait until NAT gateways available: " + err.Error())
+	}
+
+	for zone, natGatewayId := range natGatewayIds {
+		routeTableOutput, err := ec2Svc.CreateRouteTable(&ec2.CreateRouteTableInput{
+			VpcId: aws.String(awsCluster.VpcId),
+		})
+		if err != nil {
+			return errors.New("Unable to create route table: " + err.Error())
+		}
+
+		routeTableId := routeTableOutput.RouteTable.RouteTableId
+		if err := createTag(ec2Svc, []*string{routeTableId}, "Name", awsCluster.Name+"-private"); err != nil {
+			return errors.New("Unable to tag route table: " + err.Error())
+		}
+
+		if _, err := ec2Svc.CreateRoute(&ec2.CreateRouteInput{
+			RouteTableId:         routeTableId,
+			DestinationCidrBlock: aws.String("0.0.0.0/0"),
+			NatGatewayId:         natGatewayId,
+		}); err != nil {
+			return errors.New("Unable to create route to NAT gateway: " + err.Error())
+		}
+
+		if _, err := ec2Svc.AssociateRouteTable(&ec2.AssociateRouteTableInput{
+			RouteTableId: routeTableId,
+			SubnetId:     aws.String(awsCluster.PrivateSubnetIds[zone]),
+		}); err != nil {
+			return errors.New("Unable to associate route table with private subnet: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// loadNetwork finds the subnets and security group of the cluster in its VPC. Only the given
+// subnets are used in existing VPCs, where subnets not mapping public ips count as private.
+func loadNetwork(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, existingSubnetIds []string) error {
+	vpcFilter := &ec2.Filter{
+		Name:   aws.String("vpc-id"),
+		Values: []*string{aws.String(awsCluster.VpcId)},
+	}
+
+	subnetFilters := []*ec2.Filter{vpcFilter}
+	if len(existingSubnetIds) > 0 {
+		subnetFilters = append(subnetFilters, &ec2.Filter{
+			Name:   aws.String("subnet-id"),
+			Values: aws.StringSlice(existingSubnetIds),
+		})
+	}
+
+	subnetsOutput, err := ec2Svc.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		Filters: subnetFilters,
+	})
+	if err != nil {
+		return errors.New("Unable to describe subnets: " + err.Error())
+	}
+
+	if len(existingSubnetIds) > 0 && len(subnetsOutput.Subnets) != len(existingSubnetIds) {
+		return fmt.Errorf("Unable to find all of subnets %s in VPC %s", existingSubnetIds, awsCluster.VpcId)
+	}
+
+	awsCluster.PublicSubnetIds = map[string]string{}
+	awsCluster.PrivateSubnetIds = map[string]string{}
+	for _, subnet := range subnetsOutput.Subnets {
+		zone := aws.StringValue(subnet.AvailabilityZone)
+		subnetId := aws.StringValue(subnet.SubnetId)
+		private := len(existingSubnetIds) > 0 && !aws.BoolValue(subnet.MapPublicIpOnLaunch)
+		for _, tag := range subnet.Tags {
+			if aws.StringValue(tag.Key) == subnetTierTag && aws.StringValue(tag.Value) == "private" {
+				private = true
+			}
+		}
+
+		// Nodes are spread by zone, so a zone can't have two subnets of the same kind
+		if _, ok := awsCluster.PublicSubnetIds[zone]; ok && !private {
+			return fmt.Errorf("Found more than one public subnet in availability zone %s", zone)
+		} else if _, ok := awsCluster.PrivateSubnetIds[zone]; ok && private {
+			return fmt.Errorf("Found more than one private subnet in availability zone %s", zone)
+		}
+
+		if private {
+			awsCluster.PrivateSubnetIds[zone] = subnetId
+		} else {
+			awsCluster.PublicSubnetIds[zone] = subnetId
+		}
+	}
+
+	if zones := sortedZones(awsCluster.PublicSubnetIds); len(zones) > 0 {
+		awsCluster.SubnetId = awsCluster.PublicSubnetIds[zones[0]]
+	}
+
+	groupsOutput, err := ec2Svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			vpcFilter,
+			{
+				Name:   aws.String("group-name"),
+				Values: []*string{aws.String(awsCluster.Name)},
+			},
+		},
+	})
+	if err != nil {
+		return errors.New("Unable to describe security groups: " + err.Error())
+	}
+
+	if len(groupsOutput.SecurityGroups) > 0 {
+		awsCluster.SecurityGroupId = aws.StringValue(groupsOutput.SecurityGroups[0].GroupId)
+	}
+
+	return nil
+}
+
+func sortedZones(subnetIds map[string]string) []string {
+	zones := []string{}
+	for zone := range subnetIds {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	return zones
+}
+
+// nodeSubnetId return the subnet a node is launched in, and whether it's a public subnet.
+// Nodes without an availability zone are spread over the zones in turn.
+func nodeSubnetId(awsCluster *hpaws.AWSCluster, node apis.ClusterNode) (string, bool, error) {
+	subnetIds := awsCluster.PublicSubnetIds
+	public := true
+	if len(awsCluster.PrivateSubnetIds) > 0 {
+		subnetIds = awsCluster.PrivateSubnetIds
+		public = false
+	}
+
+	// Clusters deployed before subnets were recorded by zone only have a single subnet
+	if len(subnetIds) == 0 && node.AvailabilityZone == "" && awsCluster.SubnetId != "" {
+		return awsCluster.SubnetId, true, nil
+	}
+
+	zone := node.AvailabilityZone
+	if zone == "" {
+		zones := sortedZones(subnetIds)
+		if len(zones) == 0 {
+			return "", false, errors.New("Unable to find any subnet")
+		}
+		zone = zones[len(awsCluster.NodeInfos)%len(zones)]
+	}
+
+	subnetId, ok := subnetIds[zone]
+	if !ok {
+		return "", false, fmt.Errorf("Unable to find subnet in availability zone %s", zone)
+	}
+
+	return subnetId, public, nil
+}
+
+// nodeHost return the public dns name of a node, or its private ip in private subnets
+func nodeHost(nodeInfo *hpaws.NodeInfo) string {
+	if nodeInfo.PublicDnsName != "" {
+		return nodeInfo.PublicDnsName
+	}
+
+	return nodeInfo.PrivateIp
+}
+
+// taskSubnetIds return the subnets Fargate tasks are spread over, and whether they're public
+func taskSubnetIds(awsCluster *hpaws.AWSCluster) ([]*string, bool) {
+	subnetIds := awsCluster.PublicSubnetIds
+	public := true
+	if len(awsCluster.PrivateSubnetIds) > 0 {
+		subnetIds = awsCluster.PrivateSubnetIds
+		public = false
+	}
+
+	ids := []*string{}
+	for _, zone := range sortedZones(subnetIds) {
+		ids = append(ids, aws.String(subnetIds[zone]))
+	}
+
+	if len(ids) == 0 && awsCluster.SubnetId != "" {
+		ids = append(ids, aws.String(awsCluster.SubnetId))
+	}
+
+	return ids, public
+}
+
+// deleteNatGateways deletes the NAT gateways of the VPC and releases their elastic ips
+func deleteNatGateways(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, log *logging.Logger) error {
+	describeInput := &ec2.DescribeNatGatewaysInput{
+		Filter: []*ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(awsCluster.VpcId)},
+			},
+			{
+				Name:   aws.String("state"),
+				Values: []*string{aws.String("pending"), aws.String("available")},
+			},
+		},
+	}
+
+	describeOutput, err := ec2Svc.DescribeNatGateways(describeInput)
+	if err != nil {
+		return errors.New("Unable to describe NAT gateways: " + err.Error())
+	}
+
+	if len(describeOutput.NatGateways) == 0 {
+		return nil
+	}
+
+	allocationIds := []*string{}
+	for _, natGateway := range describeOutput.NatGateways {
+		for _, address := range natGateway.NatGatewayAddresses {
+			allocationIds = append(allocationIds, address.AllocationId)
+		}
+
+		log.Infof("Deleting NAT gateway %s", aws.StringValue(natGateway.NatGatewayId))
+		if _, err := ec2Svc.DeleteNatGateway(&ec2.DeleteNatGatewayInput{
+			NatGatewayId: natGateway.NatGatewayId,
+		}); err != nil {
+			return fmt.Errorf("Unable to delete NAT gateway %s: %s", aws.StringValue(natGateway.NatGatewayId), err.Error())
+		}
+	}
+
+	// Elastic ips are only released once their NAT gateway is deleted
+	err = funcs.LoopUntil(time.Minute*10, time.Second*15, func() (bool, error) {
+		output, err := ec2Svc.DescribeNatGateways(describeInput)
+		if err != nil {
+			return false, errors.New("Unable to describe NAT gateways: " + err.Error())
+		}
+
+		return len(output.NatGateways) == 0, nil
+	})
+	if err != nil {
+		return errors.New("Unable to wait until NAT gateways deleted: " + err.Error())
+	}
+
+	releaseAddresses(ec2Svc, allocationIds, log)
+
+	return nil
+}
+
+func releaseAddresses(ec2Svc *ec2.EC2, allocationIds []*string, log *logging.Logger) {
+	for _, allocationId := range allocationIds {
+		if _, err := ec2Svc.ReleaseAddress(&ec2.ReleaseAddressInput{
+			AllocationId: allocationId,
+		}); err != nil {
+			log.Warningf("Unable to release elastic ip %s: %s", aws.StringValue(allocationId), err.Error())
+		}
+	}
+}
+
+// deleteRouteTables deletes the route tables created for the private subnets of the VPC, the
+// main route table is deleted along with the VPC
+func deleteRouteTables(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, log *logging.Logger) error {
+	describeOutput, err := ec2Svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(awsCluster.VpcId)},
+			},
+		},
+	})
+	if err != nil {
+		return errors.New("Unable to describe route tables: " + err.Error())
+	}
+
+	errBool := false
+	for _, routeTable := range describeOutput.RouteTables {
+		isMain := false
+		for _, association := range routeTable.Associations {
+			if aws.BoolValue(association.Main) {
+				isMain = true
+				continue
+			}
+
+			if _, err := ec2Svc.DisassociateRouteTable(&ec2.DisassociateRouteTableInput{
+				AssociationId: association.RouteTableAssociationId,
+			}); err != nil {
+				log.Warningf("Unable to disassociate route table: %s", err.Error())
+			}
+		}
+
+		if isMain {
+			continue
+		}
+
+		if _, err := ec2Svc.DeleteRouteTable(&ec2.DeleteRouteTableInput{
+			RouteTableId: routeTable.RouteTableId,
+		}); err != nil {
+			log.Warningf("Unable to delete route table %s: %s", aws.StringValue(routeTable.RouteTableId), err.Error())
+			errBool = true
+		}
+	}
+
+	if errBool {
+		return errors.New("Unable to clean up route tables")
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package awsecs
+
+import (
+	"testing"
+
+	"github.com/hyperpilotio/deployer/apis"
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+)
+
+func TestSubnetCidrBlock(t *testing.T) {
+	tests := []struct {
+		vpcCidrBlock string
+		index        int
+		private      bool
+		expected     string
+	}{
+		{"172.31.0.0/16", 0, false, "172.31.0.0/20"},
+		{"172.31.0.0/16", 1, false, "172.31.16.0/20"},
+		{"172.31.0.0/16", 7, false, "172.31.112.0/20"},
+		{"172.31.0.0/16", 0, true, "172.31.128.0/20"},
+		{"172.31.0.0/16", 7, true, "172.31.240.0/20"},
+		{"10.20.0.0/16", 2, true, "10.20.160.0/20"},
+	}
+
+	for _, test := range tests {
+		cidrBlock, err := subnetCidrBlock(test.vpcCidrBlock, test.index, test.private)
+		if err != nil {
+			t.Errorf("Unable to get subnet %d of %s: %s", test.index, test.vpcCidrBlock, err.Error())
+			continue
+		}
+
+		if cidrBlock != test.expected {
+			t.Errorf("Unexpected subnet %d of %s (private %t): %s",
+				test.index, test.vpcCidrBlock, test.private, cidrBlock)
+		}
+	}
+
+	for _, vpcCidrBlock := range []string{"172.31.0.0/20", "172.31.0.0", "fd00::/16"} {
+		if _, err := subnetCidrBlock(vpcCidrBlock, 0, false); err == nil {
+			t.Errorf("Expected VPC range %s to be rejected", vpcCidrBlock)
+		}
+	}
+
+	if _, err := subnetCidrBlock("172.31.0.0/16", apis.MaxECSAvailabilityZones, false); err == nil {
+		t.Error("Expected zone index out of the VPC range to be rejected")
+	}
+}
+
+func TestNodeSubnetId(t *testing.T) {
+	publicSubnetIds := map[string]string{"us-east-1a": "subnet-a", "us-east-1b": "subnet-b"}
+	privateSubnetIds := map[string]string{"us-east-1a": "subnet-pa", "us-east-1b": "subnet-pb"}
+
+	tests := []struct {
+		name             string
+		privateSubnetIds map[string]string
+		launchedNodes    int
+		zone             string
+		expectedSubnetId string
+		expectedPublic   bool
+	}{
+		{"first node", nil, 0, "", "subnet-a", true},
+		{"second node", nil, 1, "", "subnet-b", true},
+		{"third node", nil, 2, "", "subnet-a", true},
+		{"zone of node", nil, 0, "us-east-1b", "subnet-b", true},
+		{"private subnets", privateSubnetIds, 1, "", "subnet-pb", false},
+		{"zone of node in private subnets", privateSubnetIds, 1, "us-east-1a", "subnet-pa", false},
+	}
+
+	for _, test := range tests {
+		awsCluster := &hpaws.AWSCluster{
+			PublicSubnetIds:  publicSubnetIds,
+			PrivateSubnetIds: test.privateSubnetIds,
+			NodeInfos:        map[int]*hpaws.NodeInfo{},
+		}
+		for i := 0; i < test.launchedNodes; i++ {
+			awsCluster.NodeInfos[i+1] = &hpaws.NodeInfo{}
+		}
+
+		node := apis.ClusterNode{Id: 10, AvailabilityZone: test.zone}
+		subnetId, public, err := nodeSubnetId(awsCluster, node)
+		if err != nil {
+			t.Errorf("%s: unable to get subnet: %s", test.name, err.Error())
+			continue
+		}
+
+		if subnetId != test.expectedSubnetId || public != test.expectedPublic {
+			t.Errorf("%s: unexpected subnet %s (public %t)", test.name, subnetId, public)
+		}
+	}
+}
+
+func TestNodeSubnetIdErrors(t *testing.T) {
+	awsCluster := &hpaws.AWSCluster{
+		PublicSubnetIds: map[string]string{"us-east-1a": "subnet-a"},
+		NodeInfos:       map[int]*hpaws.NodeInfo{},
+	}
+	if _, _, err := nodeSubnetId(awsCluster, apis.ClusterNode{Id: 1, AvailabilityZone: "us-east-1c"}); err == nil {
+		t.Error("Expected node in a zone without subnet to be rejected")
+	}
+
+	// Clusters deployed before subnets were recorded by zone only know their single subnet
+	legacyCluster := &hpaws.AWSCluster{
+		SubnetId:  "subnet-legacy",
+		NodeInfos: map[int]*hpaws.NodeInfo{},
+	}
+	subnetId, public, err := nodeSubnetId(legacyCluster, apis.ClusterNode{Id: 1})
+	if err != nil || subnetId != "subnet-legacy" || !public {
+		t.Errorf("Unexpected subnet %s of legacy cluster: %v", subnetId, err)
+	}
+}
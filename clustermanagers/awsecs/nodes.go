@@ -0,0 +1,154 @@
+package awsecs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperpilotio/deployer/apis"
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// AddNodes launches an ECS container instance for each new node and waits until they
+// joined the ECS cluster
+func (ecsDeployer *ECSDeployer) AddNodes(nodes []apis.ClusterNode, uploadedFiles map[string]string) error {
+	awsCluster := ecsDeployer.AWSCluster
+	deployment := ecsDeployer.Deployment
+	log := ecsDeployer.DeploymentLog.Logger
+
+	sess, sessionErr := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		return errors.New("Unable to create session: " + sessionErr.Error())
+	}
+
+	ecsSvc := ecs.New(sess)
+	ec2Svc := ec2.New(sess)
+
+	// Deployments only running Fargate tasks were created without the instance profile
+	if len(deployment.ClusterDefinition.Nodes) == 0 {
+		log.Infof("Setting up IAM Role")
+		if err := setupIAM(iam.New(sess), awsCluster, deployment, log); err != nil {
+			return errors.New("Unable to setup IAM: " + err.Error())
+		}
+	}
+
+	// New nodes run the image of the existing ones, as the cached ECS optimized image
+	// of the region may have changed since they were launched
+	imageId, err := clusterImageId(ec2Svc, awsCluster)
+	if err != nil {
+		return errors.New("Unable to get image of existing container instances: " + err.Error())
+	}
+
+	if imageId == "" {
+		imageId, err = resolveImageId(sess, deployment.ImageId, awsCluster.Region, log)
+		if err != nil {
+			return errors.New("Unable to resolve image of container instances: " + err.Error())
+		}
+	}
+
+	log.Infof("Launching %d EC2 instances", len(nodes))
+	if err := launchEC2Instances(ec2Svc, awsCluster, nodes, log, imageId); err != nil {
+		return errors.New("Unable to launch EC2 instances: " + err.Error())
+	}
+	deployment.ClusterDefinition.Nodes = append(deployment.ClusterDefinition.Nodes, nodes...)
+
+	log.Infof("Populating public dns names")
+	if err := populatePublicDnsNames(ec2Svc, awsCluster, log); err != nil {
+		return errors.New("Unable to populate public dns names: " + err.Error())
+	}
+
+	newNodeInfos := map[int]*hpaws.NodeInfo{}
+	for _, node := range nodes {
+		newNodeInfos[node.Id] = awsCluster.NodeInfos[node.Id]
+	}
+
+	log.Infof("Uploading files to new EC2 Instances")
+	if err := uploadFilesToNodes(ecsDeployer.Config, "ec2-user", awsCluster, newNodeInfos,
+		deployment, uploadedFiles, log); err != nil {
+		return errors.New("Unable to upload files to EC2: " + err.Error())
+	}
+
+	log.Infof("Waiting for new instances to join ECS cluster")
+	if err := waitUntilECSClusterReady(ecsSvc, awsCluster, deployment, log); err != nil {
+		return errors.New("Unable to wait until ECS cluster ready: " + err.Error())
+	}
+
+	if err := populateContainerInstanceArns(ecsSvc, awsCluster); err != nil {
+		return errors.New("Unable to populate container instance arns: " + err.Error())
+	}
+
+	return nil
+}
+
+// RemoveNodes deregisters the container instances of the given nodes from the ECS cluster
+// and terminates them
+func (ecsDeployer *ECSDeployer) RemoveNodes(nodeIds []int) error {
+	awsCluster := ecsDeployer.AWSCluster
+	deployment := ecsDeployer.Deployment
+	log := ecsDeployer.DeploymentLog.Logger
+
+	sess, sessionErr := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		return errors.New("Unable to create session: " + sessionErr.Error())
+	}
+
+	ecsSvc := ecs.New(sess)
+	ec2Svc := ec2.New(sess)
+
+	instanceIds := []*string{}
+	removedInstanceIds := map[string]bool{}
+	for _, nodeId := range nodeIds {
+		nodeInfo, ok := awsCluster.NodeInfos[nodeId]
+		if !ok {
+			return fmt.Errorf("Unable to find instance of node %d", nodeId)
+		}
+
+		instanceIds = append(instanceIds, nodeInfo.Instance.InstanceId)
+		removedInstanceIds[aws.StringValue(nodeInfo.Instance.InstanceId)] = true
+	}
+
+	for _, nodeId := range nodeIds {
+		nodeInfo := awsCluster.NodeInfos[nodeId]
+		if nodeInfo.Arn == "" {
+			continue
+		}
+
+		_, err := ecsSvc.DeregisterContainerInstance(&ecs.DeregisterContainerInstanceInput{
+			Cluster:           aws.String(awsCluster.Name),
+			ContainerInstance: aws.String(nodeInfo.Arn),
+			Force:             aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to deregister container instance of node %d: %s", nodeId, err.Error())
+		}
+		log.Infof("Deregistered container instance %s", nodeInfo.Arn)
+	}
+
+	if _, err := ec2Svc.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: instanceIds}); err != nil {
+		return errors.New("Unable to terminate EC2 instances: " + err.Error())
+	}
+
+	log.Infof("Waiting for %d EC2 instances to be terminated", len(instanceIds))
+	if err := ec2Svc.WaitUntilInstanceTerminated(&ec2.DescribeInstancesInput{InstanceIds: instanceIds}); err != nil {
+		return errors.New("Unable to wait for EC2 instances to be terminated: " + err.Error())
+	}
+
+	remainingInstanceIds := []*string{}
+	for _, instanceId := range awsCluster.InstanceIds {
+		if !removedInstanceIds[aws.StringValue(instanceId)] {
+			remainingInstanceIds = append(remainingInstanceIds, instanceId)
+		}
+	}
+	awsCluster.InstanceIds = remainingInstanceIds
+
+	for _, nodeId := range nodeIds {
+		delete(awsCluster.NodeInfos, nodeId)
+	}
+	deployment.ClusterDefinition.RemoveNodes(nodeIds)
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package awsecs
+
+import (
+	"github.com/hyperpilotio/deployer/apis"
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// setupVpcPeering peers the cluster VPC with the target VPC of the deployment, and opens the
+// cluster security group to it
+func (ecsDeployer *ECSDeployer) setupVpcPeering(sess *session.Session) error {
+	awsCluster := ecsDeployer.AWSCluster
+	status, err := hpaws.SetupClusterVpcPeering(ecsDeployer.Config, sess, awsCluster,
+		ecsDeployer.Deployment.VPCPeering, []string{awsCluster.SecurityGroupId}, ecsDeployer.DeploymentLog.Logger)
+	// Keep what was set up so far, for it to be removed along with the deployment
+	if status != nil {
+		ecsDeployer.VpcPeering = status
+	}
+
+	return err
+}
+
+// deleteVpcPeering removes the peering connection and the routes and rules set up for it
+func (ecsDeployer *ECSDeployer) deleteVpcPeering(sess *session.Session) error {
+	if err := hpaws.DeleteClusterVpcPeering(ecsDeployer.Config, sess, ecsDeployer.Deployment.VPCPeering,
+		ecsDeployer.VpcPeering, ecsDeployer.DeploymentLog.Logger); err != nil {
+		return err
+	}
+	ecsDeployer.VpcPeering = nil
+
+	return nil
+}
+
+// GetVPCPeering return the current status of the vpc peering connection of the deployment
+func (ecsDeployer *ECSDeployer) GetVPCPeering() (*apis.VPCPeeringStatus, error) {
+	return hpaws.GetClusterVpcPeering(ecsDeployer.AWSCluster, ecsDeployer.VpcPeering)
+}
@@ -0,0 +1,699 @@
+package awseks
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hyperpilotio/deployer/apis"
+	"github.com/hyperpilotio/deployer/clustermanagers/awsecs"
+	k8sUtil "github.com/hyperpilotio/deployer/clustermanagers/kubernetes"
+	"github.com/hyperpilotio/deployer/clusters"
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+	"github.com/hyperpilotio/deployer/common"
+	"github.com/hyperpilotio/deployer/job"
+	"github.com/hyperpilotio/go-utils/funcs"
+	"github.com/hyperpilotio/go-utils/log"
+	logging "github.com/op/go-logging"
+	"github.com/spf13/viper"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/elb"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// Nodes of EKS managed node groups run the Amazon Linux EKS image
+const nodeUserName = "ec2-user"
+
+// NewDeployer return the EKS of Deployer
+func NewDeployer(
+	config *viper.Viper,
+	cluster clusters.Cluster,
+	deployment *apis.Deployment) (*EKSDeployer, error) {
+	log, err := log.NewLogger(config.GetString("filesPath"), deployment.Name)
+	if err != nil {
+		return nil, errors.New("Error creating deployment logger: " + err.Error())
+	}
+
+	deployer := &EKSDeployer{
+		Config:        config,
+		AWSCluster:    cluster.(*hpaws.AWSCluster),
+		Deployment:    deployment,
+		DeploymentLog: log,
+		Services:      make(map[string]k8sUtil.ServiceMapping),
+	}
+
+	return deployer, nil
+}
+
+func (deployer *EKSDeployer) GetLog() *log.FileLog {
+	return deployer.DeploymentLog
+}
+
+func (deployer *EKSDeployer) GetScheduler() *job.Scheduler {
+	return deployer.Scheduler
+}
+
+func (deployer *EKSDeployer) SetScheduler(sheduler *job.Scheduler) {
+	deployer.Scheduler = sheduler
+}
+
+func (deployer *EKSDeployer) GetKubeConfigPath() (string, error) {
+	return deployer.KubeConfigPath, nil
+}
+
+func (deployer *EKSDeployer) GetVPCPeering() (*apis.VPCPeeringStatus, error) {
+	return nil, errors.New("Unsupported vpc peering")
+}
+
+func (deployer *EKSDeployer) GetCluster() clusters.Cluster {
+	return deployer.AWSCluster
+}
+
+// CreateDeployment start a deployment
+func (deployer *EKSDeployer) CreateDeployment(uploadedFiles map[string]string) (interface{}, error) {
+	if err := deployCluster(deployer, uploadedFiles); err != nil {
+		return nil, errors.New("Unable to deploy kubernetes: " + err.Error())
+	}
+
+	response := &CreateDeploymentResponse{
+		Name:            deployer.Deployment.Name,
+		ClusterEndpoint: deployer.ClusterEndpoint,
+		Services:        deployer.Services,
+	}
+
+	return response, nil
+}
+
+// UpdateDeployment replaces the kubernetes objects of the deployment
+func (deployer *EKSDeployer) UpdateDeployment(deployment *apis.Deployment) error {
+	deployer.Deployment = deployment
+	log := deployer.DeploymentLog.Logger
+	log.Info("Updating kubernetes deployment")
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes during update: " + err.Error())
+	}
+
+	if err := k8sUtil.DeleteK8S(k8sUtil.GetAllDeployedNamespaces(deployment), deployer.KubeConfig, log); err != nil {
+		log.Warningf("Unable to delete k8s objects in update: " + err.Error())
+	}
+
+	serviceMappings, err := k8sUtil.DeployKubernetesObjects(deployer.Config, k8sClient, deployment, nodeUserName, log)
+	if err != nil {
+		log.Warningf("Unable to deploy k8s objects in update: " + err.Error())
+	}
+	deployer.Services = serviceMappings
+	deployer.recordPublicEndpoints(k8sClient)
+
+	return nil
+}
+
+func (deployer *EKSDeployer) DeployExtensions(
+	extensions *apis.Deployment,
+	newDeployment *apis.Deployment) error {
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes: " + err.Error())
+	}
+
+	originalDeployment := deployer.Deployment
+	deployer.Deployment = extensions
+	serviceMappings, err := k8sUtil.DeployKubernetesObjects(
+		deployer.Config,
+		k8sClient,
+		deployer.Deployment,
+		nodeUserName,
+		deployer.GetLog().Logger)
+	if err != nil {
+		deployer.Deployment = originalDeployment
+		return errors.New("Unable to deploy k8s objects: " + err.Error())
+	}
+
+	deployer.Services = serviceMappings
+	deployer.Deployment = newDeployment
+	return nil
+}
+
+// ScaleTask updates the replicas of a running task
+func (deployer *EKSDeployer) ScaleTask(taskName string, replicas int) error {
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes: " + err.Error())
+	}
+
+	return k8sUtil.ScaleTask(deployer.Config, k8sClient, deployer.Deployment, "", taskName,
+		int32(replicas), deployer.Services, deployer.DeploymentLog.Logger)
+}
+
+// DeleteDeployment clean up the node groups, the control plane and the network of the cluster
+func (deployer *EKSDeployer) DeleteDeployment() error {
+	awsCluster := deployer.AWSCluster
+	clusterName := awsCluster.Name
+	stackName := awsCluster.StackName()
+	log := deployer.DeploymentLog.Logger
+
+	if deployer.KubeConfig != nil {
+		log.Infof("Deleting kubernetes deployment...")
+		if err := k8sUtil.DeleteK8S(k8sUtil.GetAllDeployedNamespaces(deployer.Deployment), deployer.KubeConfig, log); err != nil {
+			log.Warningf("Unable to deleting kubernetes deployment: %s", err.Error())
+		}
+	}
+
+	sess, sessionErr := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		log.Warningf("Unable to create aws session for delete: %s", sessionErr.Error())
+		return nil
+	}
+
+	eksSvc := eks.New(sess)
+	ec2Svc := ec2.New(sess)
+	cfSvc := cloudformation.New(sess)
+
+	if nodeGroups, err := listNodeGroups(eksSvc, clusterName); err != nil {
+		log.Warningf("Unable to list node groups: %s", err.Error())
+	} else {
+		names := []string{}
+		for _, nodeGroup := range nodeGroups {
+			names = append(names, aws.StringValue(nodeGroup.NodegroupName))
+		}
+
+		if err := deleteNodeGroups(eksSvc, clusterName, names, log); err != nil {
+			log.Warningf("Unable to delete node groups: %s", err.Error())
+		}
+	}
+
+	log.Infof("Deleting EKS cluster %s...", clusterName)
+	if _, err := eksSvc.DeleteCluster(&eks.DeleteClusterInput{Name: aws.String(clusterName)}); err != nil {
+		log.Warningf("Unable to delete EKS cluster: %s", err.Error())
+	} else if err := eksSvc.WaitUntilClusterDeleted(&eks.DescribeClusterInput{Name: aws.String(clusterName)}); err != nil {
+		log.Warningf("Unable to wait until EKS cluster deleted: %s", err.Error())
+	}
+
+	if network, err := describeNetworkStack(cfSvc, stackName); err != nil {
+		log.Warningf("Unable to describe network stack: %s", err.Error())
+	} else if err := deleteLoadBalancers(elb.New(sess), ec2Svc, clusterName, network.VpcId, log); err != nil {
+		log.Warningf("Unable to delete load balancers: %s", err.Error())
+	}
+
+	log.Infof("Deleting network stack: %s", stackName)
+	if err := deleteNetworkStack(cfSvc, stackName, log); err != nil {
+		log.Warningf("Unable to delete network stack: %s", err.Error())
+	}
+
+	log.Infof("Deleting KeyPair...")
+	if err := awsecs.DeleteKeyPair(ec2Svc, awsCluster); err != nil {
+		log.Warning("Unable to delete key pair: " + err.Error())
+	}
+
+	return nil
+}
+
+func deployCluster(deployer *EKSDeployer, uploadedFiles map[string]string) error {
+	deployment := deployer.Deployment
+	awsCluster := deployer.AWSCluster
+	log := deployer.DeploymentLog.Logger
+
+	sess, sessionErr := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		return errors.New("Unable to create session: " + sessionErr.Error())
+	}
+
+	ec2Svc := ec2.New(sess)
+	eksSvc := eks.New(sess)
+
+	if keyOutput, err := hpaws.CreateKeypair(ec2Svc, awsCluster.KeyName()); err != nil {
+		return errors.New("Unable to create key pair: " + err.Error())
+	} else {
+		awsCluster.KeyPair = keyOutput
+	}
+
+	network, err := createNetworkStack(cloudformation.New(sess), awsCluster, deployment.ExistingNetwork, log)
+	if err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to create network: " + err.Error())
+	}
+	awsCluster.VpcId = network.VpcId
+
+	if err := createControlPlane(eksSvc, awsCluster, deployment, network, log); err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to create EKS control plane: " + err.Error())
+	}
+
+	if err := deployer.setKubeConfig(sess); err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to set kube config: " + err.Error())
+	}
+
+	if err := deployer.DownloadKubeConfig(); err != nil {
+		log.Warningf("Unable to write kubeconfig: %s", err.Error())
+	}
+
+	specs := buildNodeGroupSpecs(deployment.ClusterDefinition.Nodes, []string{})
+	if err := createNodeGroups(eksSvc, awsCluster, network, specs, diskSizeGb(deployment), log); err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to create node groups: " + err.Error())
+	}
+
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to connect to kubernetes: " + err.Error())
+	}
+
+	nodeInfos, err := assignNodeInstances(eksSvc, autoscaling.New(sess), ec2Svc, k8sClient, awsCluster,
+		deployment.ClusterDefinition.Nodes)
+	if err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to populate node infos: " + err.Error())
+	}
+
+	plainFiles, templateFiles := common.SplitTemplateFiles(deployment)
+	awsCluster.PinConsoleHostKeys(nodeInfos)
+	if err := deployer.uploadDeploymentFiles(nodeInfos, plainFiles, uploadedFiles); err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to upload files to cluster: " + err.Error())
+	}
+
+	if err := tagKubeNodes(k8sClient, awsCluster, deployment, deployment.ClusterDefinition.Nodes, log); err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to tag Kubernetes nodes: " + err.Error())
+	}
+
+	serviceMapping, err := k8sUtil.DeployKubernetesObjects(deployer.Config, k8sClient, deployment, nodeUserName, log)
+	if err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to deploy kubernetes objects: " + err.Error())
+	}
+	deployer.Services = serviceMapping
+
+	if err := deployer.uploadDeploymentFiles(nodeInfos, templateFiles, uploadedFiles); err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to upload template files to cluster: " + err.Error())
+	}
+	deployer.recordPublicEndpoints(k8sClient)
+
+	return nil
+}
+
+// createControlPlane creates the EKS cluster and waits until it's active
+func createControlPlane(
+	eksSvc *eks.EKS,
+	awsCluster *hpaws.AWSCluster,
+	deployment *apis.Deployment,
+	network *networkStack,
+	log *logging.Logger) error {
+	input := &eks.CreateClusterInput{
+		Name:    aws.String(awsCluster.Name),
+		RoleArn: aws.String(network.ClusterRoleArn),
+		ResourcesVpcConfig: &eks.VpcConfigRequest{
+			SubnetIds:        aws.StringSlice(network.SubnetIds),
+			SecurityGroupIds: aws.StringSlice([]string{network.SecurityGroupId}),
+		},
+		Tags: map[string]*string{
+			"deployment": aws.String(awsCluster.Name),
+		},
+	}
+
+	if definition := deployment.EKSDefinition; definition != nil && definition.ClusterVersion != "" {
+		input.Version = aws.String(definition.ClusterVersion)
+	}
+
+	log.Infof("Creating EKS cluster %s...", awsCluster.Name)
+	if _, err := eksSvc.CreateCluster(input); err != nil {
+		return errors.New("Unable to create EKS cluster: " + err.Error())
+	}
+
+	log.Info("Waiting until EKS cluster is active...")
+	if err := eksSvc.WaitUntilClusterActive(&eks.DescribeClusterInput{
+		Name: aws.String(awsCluster.Name),
+	}); err != nil {
+		return errors.New("Unable to wait until EKS cluster active: " + err.Error())
+	}
+	log.Info("EKS cluster is active")
+
+	return nil
+}
+
+func diskSizeGb(deployment *apis.Deployment) int {
+	if definition := deployment.EKSDefinition; definition != nil && definition.DiskSizeGb > 0 {
+		return definition.DiskSizeGb
+	}
+
+	return defaultDiskSizeGb
+}
+
+func deleteDeploymentOnFailure(deployer *EKSDeployer) {
+	log := deployer.DeploymentLog.Logger
+	if deployer.Deployment.KubernetesDeployment.SkipDeleteOnFailure {
+		log.Warning("Skipping delete deployment on failure")
+		return
+	}
+
+	deployer.DeleteDeployment()
+}
+
+// tagKubeNodes waits until the given nodes joined the cluster and labels them with their node ids
+func tagKubeNodes(
+	k8sClient *k8s.Clientset,
+	awsCluster *hpaws.AWSCluster,
+	deployment *apis.Deployment,
+	nodes []apis.ClusterNode,
+	log *logging.Logger) error {
+	nodeNames := map[int]string{}
+	kubeNodeNames := []string{}
+	for _, node := range nodes {
+		privateDnsName := aws.StringValue(awsCluster.NodeInfos[node.Id].Instance.PrivateDnsName)
+		nodeNames[node.Id] = privateDnsName
+		kubeNodeNames = append(kubeNodeNames, privateDnsName)
+	}
+
+	if err := k8sUtil.WaitUntilKubernetesNodeExists(k8sClient, kubeNodeNames, time.Duration(5)*time.Minute, log); err != nil {
+		return errors.New("Unable wait for kubernetes nodes to be exist: " + err.Error())
+	}
+
+	return k8sUtil.TagKubeNodes(k8sClient, deployment.Name, apis.ClusterDefinition{Nodes: nodes}, nodeNames, log)
+}
+
+func (deployer *EKSDeployer) uploadFilesToNodes(nodeInfos map[int]*hpaws.NodeInfo, uploadedFiles map[string]string) error {
+	deployer.AWSCluster.PinConsoleHostKeys(nodeInfos)
+	return deployer.uploadDeploymentFiles(nodeInfos, deployer.Deployment, uploadedFiles)
+}
+
+// uploadDeploymentFiles uploads the files of the deployment to the nodes, rendering template
+// files with the service mappings deployed so far
+func (deployer *EKSDeployer) uploadDeploymentFiles(
+	nodeInfos map[int]*hpaws.NodeInfo,
+	deployment *apis.Deployment,
+	uploadedFiles map[string]string) error {
+	awsCluster := deployer.AWSCluster
+	log := deployer.GetLog().Logger
+	if len(deployment.Files) == 0 {
+		return nil
+	}
+
+	clientConfig, clientConfigErr := awsCluster.SshConfig(nodeUserName)
+	if clientConfigErr != nil {
+		return errors.New("Unable to create ssh config: " + clientConfigErr.Error())
+	}
+
+	services := map[string]interface{}{}
+	if common.HasTemplateFiles(deployment) {
+		if serviceMappings, err := deployer.GetServiceMappings(); err != nil {
+			log.Warningf("Unable to get service mappings for template files: %s", err.Error())
+		} else {
+			services = serviceMappings
+		}
+	}
+
+	nodes := []common.NodeUpload{}
+	for nodeId, nodeInfo := range nodeInfos {
+		nodes = append(nodes, common.NodeUpload{
+			Client: common.NewSshClient(nodeInfo.PublicDnsName+":22", clientConfig, ""),
+			Variables: &common.FileVariables{
+				DeploymentName: deployment.Name,
+				Region:         awsCluster.Region,
+				NodeId:         nodeId,
+				PrivateIp:      nodeInfo.PrivateIp,
+				PublicIp:       aws.StringValue(nodeInfo.Instance.PublicIpAddress),
+				Services:       services,
+			},
+		})
+	}
+	if err := common.UploadFilesToNodes(deployer.Config, nodes, deployment, uploadedFiles, nil, log); err != nil {
+		return errors.New("Unable to upload all files: " + err.Error())
+	}
+	log.Info("Uploaded all files")
+
+	return nil
+}
+
+// GetNodeSshClients return ssh clients of the cluster nodes
+func (deployer *EKSDeployer) GetNodeSshClients() (map[int]common.SshClient, error) {
+	clientConfig, err := deployer.AWSCluster.SshConfig(nodeUserName)
+	if err != nil {
+		return nil, errors.New("Unable to create ssh config: " + err.Error())
+	}
+
+	sshClients := map[int]common.SshClient{}
+	for nodeId, nodeInfo := range deployer.AWSCluster.NodeInfos {
+		sshClients[nodeId] = common.NewSshClient(nodeInfo.PublicDnsName+":22", clientConfig, "")
+	}
+
+	return sshClients, nil
+}
+
+// CollectDiagnostics adds the logs of the cluster nodes, and the pods, events and node
+// conditions of the cluster to the bundle
+func (deployer *EKSDeployer) CollectDiagnostics(diagnostics *common.Diagnostics) error {
+	if sshClients, err := deployer.GetNodeSshClients(); err != nil {
+		diagnostics.AddError("nodes", err)
+	} else {
+		common.CollectNodeLogs(diagnostics, sshClients, common.KubernetesNodeLogCommands,
+			common.SshConcurrency(deployer.Config))
+	}
+
+	if deployer.KubeConfig == nil {
+		diagnostics.AddError("kubernetes", errors.New("Kubernetes config of the cluster is unknown"))
+		return nil
+	}
+
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		diagnostics.AddError("kubernetes", errors.New("Unable to connect to Kubernetes: "+err.Error()))
+		return nil
+	}
+
+	return k8sUtil.CollectDiagnostics(k8sClient, "", diagnostics)
+}
+
+// RotateSshKey replaces the key pair of the cluster on its nodes
+func (deployer *EKSDeployer) RotateSshKey(storeKey func() error) error {
+	targets := func() ([]common.NodeTarget, error) {
+		sshClients, err := deployer.GetNodeSshClients()
+		if err != nil {
+			return nil, err
+		}
+		return common.SshTargets(sshClients), nil
+	}
+
+	return hpaws.RotateKeyPair(deployer.AWSCluster, targets, common.SshConcurrency(deployer.Config), storeKey,
+		deployer.GetLog().Logger)
+}
+
+// CheckClusterState check EKS cluster is active
+func (deployer *EKSDeployer) CheckClusterState() error {
+	awsCluster := deployer.AWSCluster
+	sess, sessionErr := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		return fmt.Errorf("Unable to create session: %s", sessionErr.Error())
+	}
+
+	output, err := eks.New(sess).DescribeCluster(&eks.DescribeClusterInput{
+		Name: aws.String(awsCluster.Name),
+	})
+	if err != nil {
+		return errors.New("Unable to describe EKS cluster: " + err.Error())
+	}
+
+	status := aws.StringValue(output.Cluster.Status)
+	if status != eks.ClusterStatusActive {
+		return errors.New("Unable to reload cluster because status is not active, current status: " + status)
+	}
+
+	return nil
+}
+
+// ReloadClusterState reloads kubernetes cluster state
+func (deployer *EKSDeployer) ReloadClusterState(storeInfo interface{}) error {
+	awsCluster := deployer.AWSCluster
+	if eksStoreInfo, ok := storeInfo.(*StoreInfo); ok && eksStoreInfo.ClusterName != "" {
+		awsCluster.Name = eksStoreInfo.ClusterName
+	}
+	deploymentName := awsCluster.Name
+
+	if err := deployer.CheckClusterState(); err != nil {
+		return fmt.Errorf("Skipping reloading because unable to load %s cluster: %s", deploymentName, err.Error())
+	}
+
+	sess, sessionErr := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		return fmt.Errorf("Unable to create session: %s", sessionErr.Error())
+	}
+
+	if network, err := describeNetworkStack(cloudformation.New(sess), awsCluster.StackName()); err != nil {
+		return fmt.Errorf("Unable to load %s network: %s", deploymentName, err.Error())
+	} else {
+		awsCluster.VpcId = network.VpcId
+	}
+
+	if err := deployer.setKubeConfig(sess); err != nil {
+		return fmt.Errorf("Unable to set %s kube config: %s", deploymentName, err.Error())
+	}
+
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes during reload: " + err.Error())
+	}
+
+	if _, err := assignNodeInstances(eks.New(sess), autoscaling.New(sess), ec2.New(sess), k8sClient, awsCluster,
+		deployer.Deployment.ClusterDefinition.Nodes); err != nil {
+		return fmt.Errorf("Unable to reload %s node infos: %s", deploymentName, err.Error())
+	}
+	deployer.recordPublicEndpoints(k8sClient)
+
+	glog.Infof("Reloading kube config for %s...", deploymentName)
+	if err := deployer.DownloadKubeConfig(); err != nil {
+		return fmt.Errorf("Unable to write %s kubeconfig: %s", deploymentName, err.Error())
+	}
+	glog.Infof("Reloaded %s kube config at %s", deploymentName, deployer.KubeConfigPath)
+
+	return nil
+}
+
+// recordPublicEndpoints records the public urls of the load balancers, or the ingress controller,
+// exposing public ports of the deployment
+func (deployer *EKSDeployer) recordPublicEndpoints(k8sClient *k8s.Clientset) {
+	deployment := deployer.Deployment
+	log := deployer.DeploymentLog.Logger
+	err := funcs.LoopUntil(time.Minute*2, time.Second*5, func() (bool, error) {
+		if deployment.KubernetesDeployment.Ingress != nil {
+			publicUrls, ready, err := k8sUtil.GetIngressPublicUrls(k8sClient, deployment)
+			if err != nil || !ready {
+				return false, err
+			}
+
+			for serviceName, publicUrl := range publicUrls {
+				deployer.recordServiceUrl(serviceName, publicUrl)
+			}
+			return true, nil
+		}
+
+		publicUrls := map[string]string{}
+		for _, namespace := range k8sUtil.GetAllDeployedNamespaces(deployment) {
+			services, err := k8sClient.CoreV1().Services(namespace).List(metav1.ListOptions{})
+			if err != nil {
+				return false, fmt.Errorf("Unable to list services for namespace '%s': %s", namespace, err.Error())
+			}
+
+			for _, service := range services.Items {
+				serviceName := service.GetObjectMeta().GetName()
+				if strings.Index(serviceName, "-public") == -1 {
+					continue
+				}
+
+				if len(service.Status.LoadBalancer.Ingress) == 0 {
+					return false, nil
+				}
+
+				hostname := service.Status.LoadBalancer.Ingress[0].Hostname
+				port := service.Spec.Ports[0].Port
+				familyName := serviceName[:strings.Index(serviceName, "-public")]
+				publicUrls[familyName] = hostname + ":" + strconv.FormatInt(int64(port), 10)
+			}
+		}
+
+		for serviceName, publicUrl := range publicUrls {
+			deployer.recordServiceUrl(serviceName, publicUrl)
+		}
+		return true, nil
+	})
+	if err != nil {
+		log.Warningf("Unable to record public endpoints: %s", err.Error())
+		return
+	}
+
+	log.Info("All public endpoints recorded.")
+}
+
+// recordServiceUrl sets the public url of the service, keeping the rest of its mapping
+func (deployer *EKSDeployer) recordServiceUrl(serviceName string, publicUrl string) {
+	serviceMapping := deployer.Services[serviceName]
+	serviceMapping.PublicUrl = publicUrl
+	if serviceMapping.NodeId == 0 {
+		serviceMapping.NodeId, _ = k8sUtil.FindNodeIdFromServiceName(deployer.Deployment, serviceName)
+	}
+	deployer.Services[serviceName] = serviceMapping
+}
+
+func (deployer *EKSDeployer) GetServiceMappings() (map[string]interface{}, error) {
+	nodeNameInfos := map[int]string{}
+	for id, nodeInfo := range deployer.AWSCluster.NodeInfos {
+		nodeNameInfos[id] = aws.StringValue(nodeInfo.Instance.PrivateDnsName)
+	}
+
+	serviceMappings := make(map[string]interface{})
+	for serviceName, serviceMapping := range deployer.Services {
+		if serviceMapping.NodeId == 0 {
+			serviceNodeId, err := k8sUtil.FindNodeIdFromServiceName(deployer.Deployment, serviceName)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to find %s node id: %s", serviceName, err.Error())
+			}
+			serviceMapping.NodeId = serviceNodeId
+		}
+		serviceMapping.NodeName = nodeNameInfos[serviceMapping.NodeId]
+		serviceMappings[serviceName] = serviceMapping
+	}
+
+	return serviceMappings, nil
+}
+
+// GetServiceAddress return ServiceAddress object
+func (deployer *EKSDeployer) GetServiceAddress(serviceName string) (*apis.ServiceAddress, error) {
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return nil, errors.New("Unable to connect to Kubernetes during get service url: " + err.Error())
+	}
+
+	services, err := k8sClient.CoreV1().Services("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.New("Unable to list services in the cluster: " + err.Error())
+	}
+
+	for _, service := range services.Items {
+		if (service.ObjectMeta.Name == serviceName || service.ObjectMeta.Name == serviceName+"-publicport0") &&
+			string(service.Spec.Type) == "LoadBalancer" && len(service.Status.LoadBalancer.Ingress) > 0 {
+			port := service.Spec.Ports[0].Port
+			hostname := service.Status.LoadBalancer.Ingress[0].Hostname
+			return &apis.ServiceAddress{Host: hostname, Port: port}, nil
+		}
+	}
+
+	return nil, errors.New("Service not found in endpoints")
+}
+
+func (deployer *EKSDeployer) GetServiceUrl(serviceName string) (string, error) {
+	// Mappings of scaled services can be recorded before their url
+	if info, ok := deployer.Services[serviceName]; ok && info.PublicUrl != "" {
+		return info.PublicUrl, nil
+	}
+
+	address, err := deployer.GetServiceAddress(serviceName)
+	if err != nil {
+		return "", err
+	}
+
+	serviceUrl := address.Host + ":" + strconv.FormatInt(int64(address.Port), 10)
+	deployer.recordServiceUrl(serviceName, serviceUrl)
+
+	return serviceUrl, nil
+}
+
+func (deployer *EKSDeployer) GetStoreInfo() interface{} {
+	return &StoreInfo{
+		ClusterName: deployer.AWSCluster.Name,
+	}
+}
+
+func (deployer *EKSDeployer) NewStoreInfo() interface{} {
+	return &StoreInfo{}
+}
@@ -0,0 +1,181 @@
+package awseks
+
+// Network stack of an EKS cluster: a VPC with two public subnets in different availability
+// zones, as EKS requires, and the IAM roles assumed by the control plane and the nodes.
+// The VPC is only created when no existing VpcId and SubnetIds are passed.
+var networkStackTemplate = `{
+  "AWSTemplateFormatVersion": "2010-09-09",
+  "Description": "VPC and IAM roles of an EKS cluster",
+  "Parameters": {
+    "VpcId": {"Type": "String", "Default": ""},
+    "SubnetIds": {"Type": "CommaDelimitedList", "Default": ""}
+  },
+  "Conditions": {
+    "CreateVpc": {"Fn::Equals": [{"Ref": "VpcId"}, ""]}
+  },
+  "Resources": {
+    "VPC": {
+      "Condition": "CreateVpc",
+      "Type": "AWS::EC2::VPC",
+      "Properties": {
+        "CidrBlock": "10.0.0.0/16",
+        "EnableDnsSupport": true,
+        "EnableDnsHostnames": true,
+        "Tags": [{"Key": "Name", "Value": {"Fn::Sub": "${AWS::StackName}-vpc"}}]
+      }
+    },
+    "InternetGateway": {
+      "Condition": "CreateVpc",
+      "Type": "AWS::EC2::InternetGateway"
+    },
+    "VPCGatewayAttachment": {
+      "Condition": "CreateVpc",
+      "Type": "AWS::EC2::VPCGatewayAttachment",
+      "Properties": {
+        "InternetGatewayId": {"Ref": "InternetGateway"},
+        "VpcId": {"Ref": "VPC"}
+      }
+    },
+    "RouteTable": {
+      "Condition": "CreateVpc",
+      "Type": "AWS::EC2::RouteTable",
+      "Properties": {
+        "VpcId": {"Ref": "VPC"}
+      }
+    },
+    "Route": {
+      "Condition": "CreateVpc",
+      "Type": "AWS::EC2::Route",
+      "DependsOn": "VPCGatewayAttachment",
+      "Properties": {
+        "RouteTableId": {"Ref": "RouteTable"},
+        "DestinationCidrBlock": "0.0.0.0/0",
+        "GatewayId": {"Ref": "InternetGateway"}
+      }
+    },
+    "Subnet01": {
+      "Condition": "CreateVpc",
+      "Type": "AWS::EC2::Subnet",
+      "Properties": {
+        "AvailabilityZone": {"Fn::Select": ["0", {"Fn::GetAZs": ""}]},
+        "CidrBlock": "10.0.0.0/18",
+        "MapPublicIpOnLaunch": true,
+        "VpcId": {"Ref": "VPC"},
+        "Tags": [
+          {"Key": "Name", "Value": {"Fn::Sub": "${AWS::StackName}-subnet-01"}},
+          {"Key": "kubernetes.io/role/elb", "Value": "1"}
+        ]
+      }
+    },
+    "Subnet02": {
+      "Condition": "CreateVpc",
+      "Type": "AWS::EC2::Subnet",
+      "Properties": {
+        "AvailabilityZone": {"Fn::Select": ["1", {"Fn::GetAZs": ""}]},
+        "CidrBlock": "10.0.64.0/18",
+        "MapPublicIpOnLaunch": true,
+        "VpcId": {"Ref": "VPC"},
+        "Tags": [
+          {"Key": "Name", "Value": {"Fn::Sub": "${AWS::StackName}-subnet-02"}},
+          {"Key": "kubernetes.io/role/elb", "Value": "1"}
+        ]
+      }
+    },
+    "Subnet01RouteTableAssociation": {
+      "Condition": "CreateVpc",
+      "Type": "AWS::EC2::SubnetRouteTableAssociation",
+      "Properties": {
+        "SubnetId": {"Ref": "Subnet01"},
+        "RouteTableId": {"Ref": "RouteTable"}
+      }
+    },
+    "Subnet02RouteTableAssociation": {
+      "Condition": "CreateVpc",
+      "Type": "AWS::EC2::SubnetRouteTableAssociation",
+      "Properties": {
+        "SubnetId": {"Ref": "Subnet02"},
+        "RouteTableId": {"Ref": "RouteTable"}
+      }
+    },
+    "ControlPlaneSecurityGroup": {
+      "Type": "AWS::EC2::SecurityGroup",
+      "Properties": {
+        "GroupDescription": "Cluster communication with worker nodes",
+        "VpcId": {"Fn::If": ["CreateVpc", {"Ref": "VPC"}, {"Ref": "VpcId"}]}
+      }
+    },
+    "ClusterRole": {
+      "Type": "AWS::IAM::Role",
+      "Properties": {
+        "AssumeRolePolicyDocument": {
+          "Version": "2012-10-17",
+          "Statement": [{
+            "Effect": "Allow",
+            "Principal": {"Service": ["eks.amazonaws.com"]},
+            "Action": ["sts:AssumeRole"]
+          }]
+        },
+        "ManagedPolicyArns": [
+          {"Fn::Sub": "arn:${AWS::Partition}:iam::aws:policy/AmazonEKSClusterPolicy"}
+        ]
+      }
+    },
+    "NodeRole": {
+      "Type": "AWS::IAM::Role",
+      "Properties": {
+        "AssumeRolePolicyDocument": {
+          "Version": "2012-10-17",
+          "Statement": [{
+            "Effect": "Allow",
+            "Principal": {"Service": ["ec2.amazonaws.com"]},
+            "Action": ["sts:AssumeRole"]
+          }]
+        },
+        "ManagedPolicyArns": [
+          {"Fn::Sub": "arn:${AWS::Partition}:iam::aws:policy/AmazonEKSWorkerNodePolicy"},
+          {"Fn::Sub": "arn:${AWS::Partition}:iam::aws:policy/AmazonEKS_CNI_Policy"},
+          {"Fn::Sub": "arn:${AWS::Partition}:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly"}
+        ]
+      }
+    }
+  },
+  "Outputs": {
+    "VpcId": {"Value": {"Fn::If": ["CreateVpc", {"Ref": "VPC"}, {"Ref": "VpcId"}]}},
+    "SubnetIds": {"Value": {"Fn::If": ["CreateVpc",
+      {"Fn::Join": [",", [{"Ref": "Subnet01"}, {"Ref": "Subnet02"}]]},
+      {"Fn::Join": [",", {"Ref": "SubnetIds"}]}]}},
+    "SecurityGroupId": {"Value": {"Ref": "ControlPlaneSecurityGroup"}},
+    "ClusterRoleArn": {"Value": {"Fn::GetAtt": ["ClusterRole", "Arn"]}},
+    "NodeRoleArn": {"Value": {"Fn::GetAtt": ["NodeRole", "Arn"]}}
+  }
+}`
+
+// Kubeconfig for kubectl, tokens are generated by the aws cli with the credentials of the caller
+var kubeconfigYamlTemplate = `apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: $CA_CERT
+    server: $ENDPOINT
+  name: $CLUSTER_NAME
+contexts:
+- context:
+    cluster: $CLUSTER_NAME
+    user: $CLUSTER_NAME
+  name: $CLUSTER_NAME
+current-context: $CLUSTER_NAME
+kind: Config
+preferences: {}
+users:
+- name: $CLUSTER_NAME
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1alpha1
+      command: aws
+      args:
+      - eks
+      - get-token
+      - --region
+      - $REGION
+      - --cluster-name
+      - $CLUSTER_NAME
+`
@@ -0,0 +1,150 @@
+package awseks
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/sts"
+
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// EKS authenticates a presigned sts GetCallerIdentity url, bound to the cluster by this header
+	clusterIdHeader = "x-k8s-aws-id"
+	tokenPrefix     = "k8s-aws-v1."
+	// Tokens are accepted for 15 minutes, they are renewed before that
+	tokenRefreshInterval = 10 * time.Minute
+)
+
+// generateToken return a bearer token authenticating the session credentials to the cluster
+func generateToken(sess *session.Session, clusterName string) (string, error) {
+	request, _ := sts.New(sess).GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	request.HTTPRequest.Header.Add(clusterIdHeader, clusterName)
+	presignedUrl, err := request.Presign(60 * time.Second)
+	if err != nil {
+		return "", errors.New("Unable to presign sts request: " + err.Error())
+	}
+
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(presignedUrl)), nil
+}
+
+// tokenRoundTripper adds a bearer token to kubernetes requests, renewing it when it gets old
+type tokenRoundTripper struct {
+	sess        *session.Session
+	clusterName string
+	next        http.RoundTripper
+
+	mutex     sync.Mutex
+	token     string
+	generated time.Time
+}
+
+func (rt *tokenRoundTripper) currentToken() (string, error) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	if rt.token == "" || time.Since(rt.generated) > tokenRefreshInterval {
+		token, err := generateToken(rt.sess, rt.clusterName)
+		if err != nil {
+			return "", err
+		}
+		rt.token = token
+		rt.generated = time.Now()
+	}
+
+	return rt.token, nil
+}
+
+func (rt *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := rt.currentToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// Round trippers must not modify the given request
+	authReq := new(http.Request)
+	*authReq = *req
+	authReq.Header = make(http.Header, len(req.Header))
+	for key, values := range req.Header {
+		authReq.Header[key] = append([]string(nil), values...)
+	}
+	authReq.Header.Set("Authorization", "Bearer "+token)
+
+	return rt.next.RoundTrip(authReq)
+}
+
+// setKubeConfig builds the kubernetes client config of the cluster from its endpoint and
+// certificate authority, authenticated with the credentials of the deployment user
+func (deployer *EKSDeployer) setKubeConfig(sess *session.Session) error {
+	clusterName := deployer.AWSCluster.Name
+	output, err := eks.New(sess).DescribeCluster(&eks.DescribeClusterInput{
+		Name: aws.String(clusterName),
+	})
+	if err != nil {
+		return errors.New("Unable to describe EKS cluster: " + err.Error())
+	}
+
+	cluster := output.Cluster
+	if cluster.CertificateAuthority == nil {
+		return errors.New("Unable to find certificate authority of EKS cluster " + clusterName)
+	}
+
+	ca, err := base64.StdEncoding.DecodeString(aws.StringValue(cluster.CertificateAuthority.Data))
+	if err != nil {
+		return errors.New("Unable to decode certificate authority: " + err.Error())
+	}
+
+	deployer.ClusterEndpoint = aws.StringValue(cluster.Endpoint)
+	deployer.KubeConfig = &rest.Config{
+		Host:            deployer.ClusterEndpoint,
+		TLSClientConfig: rest.TLSClientConfig{CAData: ca},
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return &tokenRoundTripper{
+				sess:        sess,
+				clusterName: clusterName,
+				next:        rt,
+			}
+		},
+	}
+
+	return nil
+}
+
+// DownloadKubeConfig writes a kubeconfig of the cluster, used by kubectl with the aws cli
+func (deployer *EKSDeployer) DownloadKubeConfig() error {
+	awsCluster := deployer.AWSCluster
+	baseDir := awsCluster.Name + "_kubeconfig"
+	basePath := "/tmp/" + baseDir
+	kubeconfigFilePath := basePath + "/kubeconfig"
+
+	if _, err := os.Stat(basePath); os.IsNotExist(err) {
+		os.Mkdir(basePath, os.ModePerm)
+	}
+
+	os.Remove(kubeconfigFilePath)
+
+	kubeconfigYaml := kubeconfigYamlTemplate
+	kubeconfigYaml = strings.Replace(kubeconfigYaml, "$CA_CERT",
+		base64.StdEncoding.EncodeToString(deployer.KubeConfig.TLSClientConfig.CAData), -1)
+	kubeconfigYaml = strings.Replace(kubeconfigYaml, "$ENDPOINT", deployer.ClusterEndpoint, -1)
+	kubeconfigYaml = strings.Replace(kubeconfigYaml, "$CLUSTER_NAME", awsCluster.Name, -1)
+	kubeconfigYaml = strings.Replace(kubeconfigYaml, "$REGION", awsCluster.Region, -1)
+
+	if err := ioutil.WriteFile(kubeconfigFilePath, []byte(kubeconfigYaml), 0666); err != nil {
+		return fmt.Errorf("Unable to create %s kubeconfig file: %s", awsCluster.Name, err.Error())
+	}
+
+	deployer.KubeConfigPath = kubeconfigFilePath
+	return nil
+}
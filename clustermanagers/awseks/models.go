@@ -0,0 +1,45 @@
+package awseks
+
+import (
+	"github.com/hyperpilotio/deployer/apis"
+	"github.com/hyperpilotio/deployer/clustermanagers/kubernetes"
+	"github.com/hyperpilotio/deployer/clusters/aws"
+	"github.com/hyperpilotio/deployer/job"
+	"github.com/hyperpilotio/go-utils/log"
+	"github.com/spf13/viper"
+
+	"k8s.io/client-go/rest"
+)
+
+type EKSDeployer struct {
+	Config     *viper.Viper
+	AWSCluster *aws.AWSCluster
+
+	DeploymentLog *log.FileLog
+	Deployment    *apis.Deployment
+	Scheduler     *job.Scheduler
+
+	ClusterEndpoint string
+	KubeConfigPath  string
+	KubeConfig      *rest.Config
+	Services        map[string]kubernetes.ServiceMapping
+}
+
+type CreateDeploymentResponse struct {
+	Name            string                               `json:"name"`
+	ClusterEndpoint string                               `json:"clusterEndpoint"`
+	Services        map[string]kubernetes.ServiceMapping `json:"services"`
+}
+
+type StoreInfo struct {
+	ClusterName string
+}
+
+// networkStack stores the outputs of the stack holding the VPC and IAM roles of the cluster
+type networkStack struct {
+	VpcId           string
+	SubnetIds       []string
+	SecurityGroupId string
+	ClusterRoleArn  string
+	NodeRoleArn     string
+}
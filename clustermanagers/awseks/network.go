@@ -0,0 +1,256 @@
+package awseks
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hyperpilotio/deployer/apis"
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+	"github.com/hyperpilotio/go-utils/funcs"
+	logging "github.com/op/go-logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+// createNetworkStack creates the VPC and IAM roles of the cluster and waits until they're ready.
+// Only the security group and IAM roles are created when launching in an existing network.
+func createNetworkStack(
+	cfSvc *cloudformation.CloudFormation,
+	awsCluster *hpaws.AWSCluster,
+	existingNetwork *apis.ExistingNetwork,
+	log *logging.Logger) (*networkStack, error) {
+	stackName := awsCluster.StackName()
+	parameters := []*cloudformation.Parameter{}
+	if existingNetwork != nil {
+		parameters = append(parameters,
+			&cloudformation.Parameter{
+				ParameterKey:   aws.String("VpcId"),
+				ParameterValue: aws.String(existingNetwork.VpcId),
+			},
+			&cloudformation.Parameter{
+				ParameterKey:   aws.String("SubnetIds"),
+				ParameterValue: aws.String(strings.Join(existingNetwork.SubnetIds, ",")),
+			})
+	}
+
+	log.Infof("Creating network stack %s...", stackName)
+	_, err := cfSvc.CreateStack(&cloudformation.CreateStackInput{
+		Parameters: parameters,
+		StackName:  aws.String(stackName),
+		Capabilities: []*string{
+			aws.String("CAPABILITY_IAM"),
+		},
+		Tags: []*cloudformation.Tag{
+			{
+				Key:   aws.String("deployment"),
+				Value: aws.String(awsCluster.Name),
+			},
+		},
+		TemplateBody:     aws.String(networkStackTemplate),
+		TimeoutInMinutes: aws.Int64(30),
+	})
+	if err != nil {
+		return nil, errors.New("Unable to create network stack: " + err.Error())
+	}
+
+	if err := cfSvc.WaitUntilStackCreateComplete(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	}); err != nil {
+		return nil, errors.New("Unable to wait until network stack complete: " + err.Error())
+	}
+	log.Info("Network stack completed")
+
+	return describeNetworkStack(cfSvc, stackName)
+}
+
+// describeNetworkStack return the outputs of the network stack
+func describeNetworkStack(cfSvc *cloudformation.CloudFormation, stackName string) (*networkStack, error) {
+	output, err := cfSvc.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		return nil, errors.New("Unable to describe network stack: " + err.Error())
+	}
+
+	if len(output.Stacks) == 0 {
+		return nil, errors.New("Unable to find network stack " + stackName)
+	}
+
+	network := &networkStack{}
+	for _, output := range output.Stacks[0].Outputs {
+		value := aws.StringValue(output.OutputValue)
+		switch aws.StringValue(output.OutputKey) {
+		case "VpcId":
+			network.VpcId = value
+		case "SubnetIds":
+			network.SubnetIds = strings.Split(value, ",")
+		case "SecurityGroupId":
+			network.SecurityGroupId = value
+		case "ClusterRoleArn":
+			network.ClusterRoleArn = value
+		case "NodeRoleArn":
+			network.NodeRoleArn = value
+		}
+	}
+
+	if network.VpcId == "" || len(network.SubnetIds) == 0 || network.ClusterRoleArn == "" || network.NodeRoleArn == "" {
+		return nil, errors.New("Unable to find all outputs of network stack " + stackName)
+	}
+
+	return network, nil
+}
+
+// deleteNetworkStack deletes the VPC and IAM roles of the cluster and waits until they're gone
+func deleteNetworkStack(cfSvc *cloudformation.CloudFormation, stackName string, log *logging.Logger) error {
+	if _, err := cfSvc.DeleteStack(&cloudformation.DeleteStackInput{
+		StackName: aws.String(stackName),
+	}); err != nil {
+		return errors.New("Unable to delete network stack: " + err.Error())
+	}
+
+	log.Infof("Waiting until network stack %s is deleted...", stackName)
+	if err := cfSvc.WaitUntilStackDeleteComplete(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	}); err != nil {
+		return errors.New("Unable to wait until network stack deleted: " + err.Error())
+	}
+
+	return nil
+}
+
+// clusterTagKey return the tag kubernetes puts on the AWS resources it creates for the cluster
+func clusterTagKey(clusterName string) string {
+	return "kubernetes.io/cluster/" + clusterName
+}
+
+// deleteLoadBalancers deletes the load balancers, and their security groups, left behind by
+// kubernetes services of the cluster, as they would keep the VPC from being deleted. Only the
+// resources tagged with the cluster are deleted, as the VPC may be shared with other clusters.
+func deleteLoadBalancers(elbSvc *elb.ELB, ec2Svc *ec2.EC2, clusterName string, vpcId string, log *logging.Logger) error {
+	loadBalancerNames, err := listClusterLoadBalancers(elbSvc, clusterName, vpcId)
+	if err != nil {
+		return err
+	}
+
+	for _, loadBalancerName := range loadBalancerNames {
+		log.Infof("Deleting load balancer %s", aws.StringValue(loadBalancerName))
+		if _, err := elbSvc.DeleteLoadBalancer(&elb.DeleteLoadBalancerInput{
+			LoadBalancerName: loadBalancerName,
+		}); err != nil {
+			log.Warningf("Unable to delete load balancer %s: %s", aws.StringValue(loadBalancerName), err.Error())
+		}
+	}
+
+	if len(loadBalancerNames) > 0 {
+		// Network interfaces of deleted load balancers are released asynchronously
+		descriptions := []*string{}
+		for _, loadBalancerName := range loadBalancerNames {
+			descriptions = append(descriptions, aws.String("ELB "+aws.StringValue(loadBalancerName)))
+		}
+		filters := []*ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(vpcId)},
+			},
+			{
+				Name:   aws.String("requester-id"),
+				Values: []*string{aws.String("amazon-elb")},
+			},
+			{
+				Name:   aws.String("description"),
+				Values: descriptions,
+			},
+		}
+		if err := waitUntilNetworkInterfacesDeleted(ec2Svc, filters); err != nil {
+			log.Warningf("Unable to wait until load balancer network interfaces deleted: %s", err.Error())
+		}
+	}
+
+	groupsOutput, err := ec2Svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(vpcId)},
+			},
+			{
+				Name:   aws.String("tag-key"),
+				Values: []*string{aws.String(clusterTagKey(clusterName))},
+			},
+		},
+	})
+	if err != nil {
+		return errors.New("Unable to describe security groups: " + err.Error())
+	}
+
+	for _, group := range groupsOutput.SecurityGroups {
+		log.Infof("Deleting security group %s", aws.StringValue(group.GroupId))
+		if _, err := ec2Svc.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{
+			GroupId: group.GroupId,
+		}); err != nil {
+			log.Warningf("Unable to delete security group %s: %s", aws.StringValue(group.GroupId), err.Error())
+		}
+	}
+
+	return nil
+}
+
+// listClusterLoadBalancers return the names of the load balancers in the VPC tagged with the cluster
+func listClusterLoadBalancers(elbSvc *elb.ELB, clusterName string, vpcId string) ([]*string, error) {
+	describeOutput, err := elbSvc.DescribeLoadBalancers(&elb.DescribeLoadBalancersInput{})
+	if err != nil {
+		return nil, errors.New("Unable to describe load balancers: " + err.Error())
+	}
+
+	vpcLoadBalancerNames := []*string{}
+	for _, loadBalancer := range describeOutput.LoadBalancerDescriptions {
+		if aws.StringValue(loadBalancer.VPCId) == vpcId {
+			vpcLoadBalancerNames = append(vpcLoadBalancerNames, loadBalancer.LoadBalancerName)
+		}
+	}
+
+	loadBalancerNames := []*string{}
+	tagKey := clusterTagKey(clusterName)
+	// DescribeTags only accepts up to 20 load balancers in a single request
+	for start := 0; start < len(vpcLoadBalancerNames); start += 20 {
+		end := start + 20
+		if end > len(vpcLoadBalancerNames) {
+			end = len(vpcLoadBalancerNames)
+		}
+
+		tagsOutput, err := elbSvc.DescribeTags(&elb.DescribeTagsInput{
+			LoadBalancerNames: vpcLoadBalancerNames[start:end],
+		})
+		if err != nil {
+			return nil, errors.New("Unable to describe load balancer tags: " + err.Error())
+		}
+
+		for _, description := range tagsOutput.TagDescriptions {
+			for _, tag := range description.Tags {
+				if aws.StringValue(tag.Key) == tagKey {
+					loadBalancerNames = append(loadBalancerNames, description.LoadBalancerName)
+					break
+				}
+			}
+		}
+	}
+
+	return loadBalancerNames, nil
+}
+
+// waitUntilNetworkInterfacesDeleted waits until no network interfaces match the filters
+func waitUntilNetworkInterfacesDeleted(ec2Svc *ec2.EC2, filters []*ec2.Filter) error {
+	return funcs.LoopUntil(time.Minute*5, time.Second*10, func() (bool, error) {
+		output, err := ec2Svc.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+			Filters: filters,
+		})
+		if err != nil {
+			return false, errors.New("Unable to describe network interfaces: " + err.Error())
+		}
+
+		return len(output.NetworkInterfaces) == 0, nil
+	})
+}
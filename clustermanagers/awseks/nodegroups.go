@@ -0,0 +1,386 @@
+package awseks
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperpilotio/deployer/apis"
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+	"github.com/hyperpilotio/go-utils/funcs"
+	logging "github.com/op/go-logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultDiskSizeGb = 20
+	// EKS tags the instances of a managed node group with the name of the group
+	nodeGroupNameTagKey = "eks:nodegroup-name"
+)
+
+var invalidNodeGroupNameChars = regexp.MustCompile("[^a-zA-Z0-9_-]")
+
+// buildNodeGroupSpecs groups the nodes into managed node groups, new node group names never
+// collide with the given existing ones
+func buildNodeGroupSpecs(nodes []apis.ClusterNode, existingNames []string) []*apis.NodeGroup {
+	return apis.GroupNodes(nodes, existingNames, newNodeGroupName)
+}
+
+// newNodeGroupName return a node group name named after the instance type, node group names
+// can only have letters, numbers, hyphens and underscores
+func newNodeGroupName(instanceType string, usedNames map[string]bool) string {
+	prefix := invalidNodeGroupNameChars.ReplaceAllString(instanceType, "-")
+	if len(prefix) > 40 {
+		prefix = prefix[:40]
+	}
+
+	name := prefix + "-group"
+	for i := 2; usedNames[name]; i++ {
+		name = prefix + "-group-" + strconv.Itoa(i)
+	}
+
+	return name
+}
+
+// nodeGroupMatches return true when the node has the instance type and labels of the node group
+func nodeGroupMatches(nodeGroup *eks.Nodegroup, node apis.ClusterNode) bool {
+	if len(nodeGroup.InstanceTypes) == 0 {
+		return false
+	}
+
+	return node.Matches(aws.StringValue(nodeGroup.InstanceTypes[0]), aws.StringValueMap(nodeGroup.Labels))
+}
+
+// createNodeGroups creates a managed node group for each spec and waits until they're all active
+func createNodeGroups(
+	eksSvc *eks.EKS,
+	awsCluster *hpaws.AWSCluster,
+	network *networkStack,
+	specs []*apis.NodeGroup,
+	diskSizeGb int,
+	log *logging.Logger) error {
+	clusterName := awsCluster.Name
+	for _, spec := range specs {
+		log.Infof("Creating node group %s with %d %s nodes...", spec.Name, len(spec.Nodes), spec.InstanceType)
+		_, err := eksSvc.CreateNodegroup(&eks.CreateNodegroupInput{
+			ClusterName:   aws.String(clusterName),
+			NodegroupName: aws.String(spec.Name),
+			NodeRole:      aws.String(network.NodeRoleArn),
+			Subnets:       aws.StringSlice(network.SubnetIds),
+			InstanceTypes: aws.StringSlice([]string{spec.InstanceType}),
+			DiskSize:      aws.Int64(int64(diskSizeGb)),
+			Labels:        aws.StringMap(spec.Labels),
+			ScalingConfig: &eks.NodegroupScalingConfig{
+				DesiredSize: aws.Int64(int64(len(spec.Nodes))),
+				MinSize:     aws.Int64(0),
+				MaxSize:     aws.Int64(int64(len(spec.Nodes))),
+			},
+			// Files are uploaded to the nodes over ssh
+			RemoteAccess: &eks.RemoteAccessConfig{
+				Ec2SshKey: aws.String(awsCluster.KeyName()),
+			},
+			Tags: map[string]*string{
+				"deployment": aws.String(clusterName),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to create %s node group: %s", spec.Name, err.Error())
+		}
+	}
+
+	for _, spec := range specs {
+		if err := eksSvc.WaitUntilNodegroupActive(&eks.DescribeNodegroupInput{
+			ClusterName:   aws.String(clusterName),
+			NodegroupName: aws.String(spec.Name),
+		}); err != nil {
+			return fmt.Errorf("Unable to wait until %s node group is active: %s", spec.Name, err.Error())
+		}
+		log.Infof("Node group %s is active", spec.Name)
+	}
+
+	return nil
+}
+
+// listNodeGroups return the managed node groups of the cluster
+func listNodeGroups(eksSvc *eks.EKS, clusterName string) ([]*eks.Nodegroup, error) {
+	names := []*string{}
+	err := eksSvc.ListNodegroupsPages(&eks.ListNodegroupsInput{
+		ClusterName: aws.String(clusterName),
+	}, func(output *eks.ListNodegroupsOutput, lastPage bool) bool {
+		names = append(names, output.Nodegroups...)
+		return true
+	})
+	if err != nil {
+		return nil, errors.New("Unable to list node groups: " + err.Error())
+	}
+
+	nodeGroups := []*eks.Nodegroup{}
+	for _, name := range names {
+		output, err := eksSvc.DescribeNodegroup(&eks.DescribeNodegroupInput{
+			ClusterName:   aws.String(clusterName),
+			NodegroupName: name,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to describe %s node group: %s", aws.StringValue(name), err.Error())
+		}
+		nodeGroups = append(nodeGroups, output.Nodegroup)
+	}
+
+	return nodeGroups, nil
+}
+
+// resizeNodeGroup sets the desired size of the node group and waits until the update is done
+func resizeNodeGroup(eksSvc *eks.EKS, nodeGroup *eks.Nodegroup, size int64, log *logging.Logger) error {
+	maxSize := size
+	if maxSize < 1 {
+		maxSize = 1
+	}
+
+	log.Infof("Resizing node group %s to %d nodes...", aws.StringValue(nodeGroup.NodegroupName), size)
+	output, err := eksSvc.UpdateNodegroupConfig(&eks.UpdateNodegroupConfigInput{
+		ClusterName:   nodeGroup.ClusterName,
+		NodegroupName: nodeGroup.NodegroupName,
+		ScalingConfig: &eks.NodegroupScalingConfig{
+			DesiredSize: aws.Int64(size),
+			MinSize:     aws.Int64(0),
+			MaxSize:     aws.Int64(maxSize),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to resize %s node group: %s", aws.StringValue(nodeGroup.NodegroupName), err.Error())
+	}
+
+	return funcs.LoopUntil(time.Minute*15, time.Second*15, func() (bool, error) {
+		updateOutput, err := eksSvc.DescribeUpdate(&eks.DescribeUpdateInput{
+			Name:          nodeGroup.ClusterName,
+			NodegroupName: nodeGroup.NodegroupName,
+			UpdateId:      output.Update.Id,
+		})
+		if err != nil {
+			return false, errors.New("Unable to describe node group update: " + err.Error())
+		}
+
+		switch status := aws.StringValue(updateOutput.Update.Status); status {
+		case eks.UpdateStatusSuccessful:
+			return true, nil
+		case eks.UpdateStatusFailed, eks.UpdateStatusCancelled:
+			return false, fmt.Errorf("Node group %s update %s", aws.StringValue(nodeGroup.NodegroupName),
+				strings.ToLower(status))
+		}
+		return false, nil
+	})
+}
+
+// deleteNodeGroups deletes the given node groups and waits until they're all deleted
+func deleteNodeGroups(eksSvc *eks.EKS, clusterName string, names []string, log *logging.Logger) error {
+	for _, name := range names {
+		log.Infof("Deleting node group %s", name)
+		if _, err := eksSvc.DeleteNodegroup(&eks.DeleteNodegroupInput{
+			ClusterName:   aws.String(clusterName),
+			NodegroupName: aws.String(name),
+		}); err != nil {
+			return fmt.Errorf("Unable to delete %s node group: %s", name, err.Error())
+		}
+	}
+
+	for _, name := range names {
+		if err := eksSvc.WaitUntilNodegroupDeleted(&eks.DescribeNodegroupInput{
+			ClusterName:   aws.String(clusterName),
+			NodegroupName: aws.String(name),
+		}); err != nil {
+			return fmt.Errorf("Unable to wait until %s node group is deleted: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// describeNodeGroupInstances return the running instances of the node group, sorted by instance id
+func describeNodeGroupInstances(
+	autoscalingSvc *autoscaling.AutoScaling,
+	ec2Svc *ec2.EC2,
+	nodeGroup *eks.Nodegroup) ([]*ec2.Instance, error) {
+	if nodeGroup.Resources == nil || len(nodeGroup.Resources.AutoScalingGroups) == 0 {
+		return []*ec2.Instance{}, nil
+	}
+
+	groupNames := []*string{}
+	for _, group := range nodeGroup.Resources.AutoScalingGroups {
+		groupNames = append(groupNames, group.Name)
+	}
+
+	groupsOutput, err := autoscalingSvc.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: groupNames,
+	})
+	if err != nil {
+		return nil, errors.New("Unable to describe auto scaling groups: " + err.Error())
+	}
+
+	instanceIds := []*string{}
+	for _, group := range groupsOutput.AutoScalingGroups {
+		for _, instance := range group.Instances {
+			instanceIds = append(instanceIds, instance.InstanceId)
+		}
+	}
+
+	if len(instanceIds) == 0 {
+		return []*ec2.Instance{}, nil
+	}
+
+	instancesOutput, err := ec2Svc.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: instanceIds,
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []*string{aws.String("running")},
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.New("Unable to describe ec2 instances: " + err.Error())
+	}
+
+	instances := []*ec2.Instance{}
+	for _, reservation := range instancesOutput.Reservations {
+		instances = append(instances, reservation.Instances...)
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return aws.StringValue(instances[i].InstanceId) < aws.StringValue(instances[j].InstanceId)
+	})
+
+	return instances, nil
+}
+
+// waitUntilNodeGroupInstancesRunning waits until the node group runs at least count instances
+func waitUntilNodeGroupInstancesRunning(
+	autoscalingSvc *autoscaling.AutoScaling,
+	ec2Svc *ec2.EC2,
+	nodeGroup *eks.Nodegroup,
+	count int) error {
+	return funcs.LoopUntil(time.Minute*10, time.Second*10, func() (bool, error) {
+		instances, err := describeNodeGroupInstances(autoscalingSvc, ec2Svc, nodeGroup)
+		if err != nil {
+			return false, err
+		}
+		return len(instances) >= count, nil
+	})
+}
+
+// assignNodeInstances assigns a running instance of the matching node group to each of the given
+// nodes. Instances of kubernetes nodes already labeled with a node id keep it, so reloading a
+// deployment finds the same instances for its nodes.
+func assignNodeInstances(
+	eksSvc *eks.EKS,
+	autoscalingSvc *autoscaling.AutoScaling,
+	ec2Svc *ec2.EC2,
+	k8sClient *k8s.Clientset,
+	awsCluster *hpaws.AWSCluster,
+	nodes []apis.ClusterNode) (map[int]*hpaws.NodeInfo, error) {
+	nodeGroups, err := listNodeGroups(eksSvc, awsCluster.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeNodes, err := k8sClient.CoreV1().Nodes().List(metav1.ListOptions{
+		LabelSelector: "hyperpilot/deployment=" + awsCluster.Name,
+	})
+	if err != nil {
+		return nil, errors.New("Unable to list kubernetes nodes: " + err.Error())
+	}
+
+	labeledNodeIds := map[string]int{}
+	for _, kubeNode := range kubeNodes.Items {
+		if nodeId, err := strconv.Atoi(kubeNode.Labels["hyperpilot/node-id"]); err == nil {
+			labeledNodeIds[kubeNode.Name] = nodeId
+		}
+	}
+
+	assignedInstances := map[string]bool{}
+	for _, nodeInfo := range awsCluster.NodeInfos {
+		assignedInstances[aws.StringValue(nodeInfo.Instance.InstanceId)] = true
+	}
+
+	groupInstances := map[string][]*ec2.Instance{}
+	for _, nodeGroup := range nodeGroups {
+		instances, err := describeNodeGroupInstances(autoscalingSvc, ec2Svc, nodeGroup)
+		if err != nil {
+			return nil, err
+		}
+		groupInstances[aws.StringValue(nodeGroup.NodegroupName)] = instances
+	}
+
+	findInstance := func(node apis.ClusterNode, labeledOnly bool) *ec2.Instance {
+		for _, nodeGroup := range nodeGroups {
+			if !nodeGroupMatches(nodeGroup, node) {
+				continue
+			}
+
+			for _, instance := range groupInstances[aws.StringValue(nodeGroup.NodegroupName)] {
+				if assignedInstances[aws.StringValue(instance.InstanceId)] {
+					continue
+				}
+
+				nodeId, labeled := labeledNodeIds[aws.StringValue(instance.PrivateDnsName)]
+				if (labeledOnly && labeled && nodeId == node.Id) || (!labeledOnly && !labeled) {
+					return instance
+				}
+			}
+		}
+		return nil
+	}
+
+	// Labeled instances are assigned first, so they're not handed out to other nodes
+	nodeInfos := map[int]*hpaws.NodeInfo{}
+	for _, labeledOnly := range []bool{true, false} {
+		for _, node := range nodes {
+			if _, ok := nodeInfos[node.Id]; ok {
+				continue
+			}
+
+			instance := findInstance(node, labeledOnly)
+			if instance == nil {
+				continue
+			}
+
+			assignedInstances[aws.StringValue(instance.InstanceId)] = true
+			nodeInfos[node.Id] = &hpaws.NodeInfo{
+				Instance:      instance,
+				PublicDnsName: aws.StringValue(instance.PublicDnsName),
+				PrivateIp:     aws.StringValue(instance.PrivateIpAddress),
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		nodeInfo, ok := nodeInfos[node.Id]
+		if !ok {
+			return nil, fmt.Errorf("Unable to find %s instance for node %d", node.InstanceType, node.Id)
+		}
+		awsCluster.NodeInfos[node.Id] = nodeInfo
+	}
+
+	return nodeInfos, nil
+}
+
+// instanceNodeGroupName return the name of the managed node group running the instance
+func instanceNodeGroupName(instance *ec2.Instance) string {
+	for _, tag := range instance.Tags {
+		if aws.StringValue(tag.Key) == nodeGroupNameTagKey {
+			return aws.StringValue(tag.Value)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,208 @@
+package awseks
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperpilotio/deployer/apis"
+	k8sUtil "github.com/hyperpilotio/deployer/clustermanagers/kubernetes"
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// AddNodes grows the managed node group matching each new node, and creates node groups for
+// instance types and labels not running in the cluster yet. The new kubernetes nodes are
+// labeled with the given node ids.
+func (deployer *EKSDeployer) AddNodes(nodes []apis.ClusterNode, uploadedFiles map[string]string) error {
+	awsCluster := deployer.AWSCluster
+	deployment := deployer.Deployment
+	log := deployer.DeploymentLog.Logger
+
+	sess, sessionErr := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		return errors.New("Unable to create session: " + sessionErr.Error())
+	}
+
+	eksSvc := eks.New(sess)
+	ec2Svc := ec2.New(sess)
+	autoscalingSvc := autoscaling.New(sess)
+
+	nodeGroups, err := listNodeGroups(eksSvc, awsCluster.Name)
+	if err != nil {
+		return err
+	}
+
+	groupNodeCounts := map[*eks.Nodegroup]int{}
+	newGroupNodes := []apis.ClusterNode{}
+	nodeGroupNames := []string{}
+	for _, nodeGroup := range nodeGroups {
+		nodeGroupNames = append(nodeGroupNames, aws.StringValue(nodeGroup.NodegroupName))
+	}
+
+	for _, node := range nodes {
+		var matchingGroup *eks.Nodegroup
+		for _, nodeGroup := range nodeGroups {
+			if nodeGroupMatches(nodeGroup, node) {
+				matchingGroup = nodeGroup
+				break
+			}
+		}
+
+		if matchingGroup == nil {
+			newGroupNodes = append(newGroupNodes, node)
+		} else {
+			groupNodeCounts[matchingGroup]++
+		}
+	}
+
+	for nodeGroup, count := range groupNodeCounts {
+		size := aws.Int64Value(nodeGroup.ScalingConfig.DesiredSize) + int64(count)
+		if err := resizeNodeGroup(eksSvc, nodeGroup, size, log); err != nil {
+			return err
+		}
+
+		if err := waitUntilNodeGroupInstancesRunning(autoscalingSvc, ec2Svc, nodeGroup, int(size)); err != nil {
+			return fmt.Errorf("Unable to wait for %s node group instances: %s",
+				aws.StringValue(nodeGroup.NodegroupName), err.Error())
+		}
+	}
+
+	if len(newGroupNodes) > 0 {
+		network, err := describeNetworkStack(cloudformation.New(sess), awsCluster.StackName())
+		if err != nil {
+			return err
+		}
+
+		specs := buildNodeGroupSpecs(newGroupNodes, nodeGroupNames)
+		if err := createNodeGroups(eksSvc, awsCluster, network, specs, diskSizeGb(deployment), log); err != nil {
+			return err
+		}
+	}
+
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes: " + err.Error())
+	}
+
+	newNodeInfos, err := assignNodeInstances(eksSvc, autoscalingSvc, ec2Svc, k8sClient, awsCluster, nodes)
+	if err != nil {
+		return errors.New("Unable to assign instances to new nodes: " + err.Error())
+	}
+	deployment.ClusterDefinition.Nodes = append(deployment.ClusterDefinition.Nodes, nodes...)
+
+	if err := deployer.uploadFilesToNodes(newNodeInfos, uploadedFiles); err != nil {
+		return errors.New("Unable to upload files to new nodes: " + err.Error())
+	}
+
+	if err := tagKubeNodes(k8sClient, awsCluster, deployment, nodes, log); err != nil {
+		return errors.New("Unable to tag Kubernetes nodes: " + err.Error())
+	}
+
+	return nil
+}
+
+// RemoveNodes terminates the instances of the given nodes and shrinks their node groups
+// accordingly. Node groups left without instances are deleted.
+func (deployer *EKSDeployer) RemoveNodes(nodeIds []int) error {
+	awsCluster := deployer.AWSCluster
+	deployment := deployer.Deployment
+	log := deployer.DeploymentLog.Logger
+
+	sess, sessionErr := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		return errors.New("Unable to create session: " + sessionErr.Error())
+	}
+
+	eksSvc := eks.New(sess)
+	ec2Svc := ec2.New(sess)
+	autoscalingSvc := autoscaling.New(sess)
+
+	nodeGroups, err := listNodeGroups(eksSvc, awsCluster.Name)
+	if err != nil {
+		return err
+	}
+
+	groupInstanceIds := map[string][]*string{}
+	instanceIds := []*string{}
+	kubeNodeNames := []string{}
+	for _, nodeId := range nodeIds {
+		nodeInfo, ok := awsCluster.NodeInfos[nodeId]
+		if !ok {
+			return fmt.Errorf("Unable to find instance of node %d", nodeId)
+		}
+
+		nodeGroupName := instanceNodeGroupName(nodeInfo.Instance)
+		if nodeGroupName == "" {
+			return fmt.Errorf("Unable to find node group of instance %s", aws.StringValue(nodeInfo.Instance.InstanceId))
+		}
+
+		groupInstanceIds[nodeGroupName] = append(groupInstanceIds[nodeGroupName], nodeInfo.Instance.InstanceId)
+		instanceIds = append(instanceIds, nodeInfo.Instance.InstanceId)
+		kubeNodeNames = append(kubeNodeNames, aws.StringValue(nodeInfo.Instance.PrivateDnsName))
+	}
+
+	emptyNodeGroupNames := []string{}
+	for _, nodeGroup := range nodeGroups {
+		nodeGroupName := aws.StringValue(nodeGroup.NodegroupName)
+		removedInstanceIds, ok := groupInstanceIds[nodeGroupName]
+		if !ok {
+			continue
+		}
+
+		size := aws.Int64Value(nodeGroup.ScalingConfig.DesiredSize) - int64(len(removedInstanceIds))
+		if size <= 0 {
+			emptyNodeGroupNames = append(emptyNodeGroupNames, nodeGroupName)
+			continue
+		}
+
+		// Terminating through the auto scaling group picks the instances to remove, the node
+		// group is then resized to the decremented capacity so EKS doesn't replace them
+		for _, instanceId := range removedInstanceIds {
+			_, err := autoscalingSvc.TerminateInstanceInAutoScalingGroup(&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+				InstanceId:                     instanceId,
+				ShouldDecrementDesiredCapacity: aws.Bool(true),
+			})
+			if err != nil {
+				return fmt.Errorf("Unable to terminate instance %s: %s", aws.StringValue(instanceId), err.Error())
+			}
+			log.Infof("Terminating instance %s of node group %s", aws.StringValue(instanceId), nodeGroupName)
+		}
+
+		if err := resizeNodeGroup(eksSvc, nodeGroup, size, log); err != nil {
+			return err
+		}
+	}
+
+	if err := deleteNodeGroups(eksSvc, awsCluster.Name, emptyNodeGroupNames, log); err != nil {
+		return err
+	}
+
+	log.Infof("Waiting for %d instances to be terminated", len(instanceIds))
+	if err := ec2Svc.WaitUntilInstanceTerminated(&ec2.DescribeInstancesInput{InstanceIds: instanceIds}); err != nil {
+		return errors.New("Unable to wait for instances to be terminated: " + err.Error())
+	}
+
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes: " + err.Error())
+	}
+
+	// Nodes of terminated instances are usually removed by the cloud controller already
+	if err := k8sUtil.DeleteKubeNodes(k8sClient, kubeNodeNames, log); err != nil {
+		log.Warningf("Unable to delete kubernetes nodes: %s", err.Error())
+	}
+
+	for _, nodeId := range nodeIds {
+		delete(awsCluster.NodeInfos, nodeId)
+	}
+	deployment.ClusterDefinition.RemoveNodes(nodeIds)
+
+	return nil
+}
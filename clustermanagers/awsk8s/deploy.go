@@ -24,6 +24,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
@@ -33,6 +34,12 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// Node ids of the bastion and master in ssh key rotation results, as they aren't cluster nodes
+const (
+	bastionNodeId = -1
+	masterNodeId  = -2
+)
+
 // NewDeployer return the K8S of Deployer
 func NewDeployer(
 	config *viper.Viper,
@@ -178,11 +185,8 @@ func (deployer *K8SDeployer) DeleteDeployment() error {
 	}
 
 	ec2Svc := ec2.New(sess)
-	if deployer.VpcPeeringConnectionId != "" {
-		_, err := ec2Svc.DeleteVpcPeeringConnection(&ec2.DeleteVpcPeeringConnectionInput{
-			VpcPeeringConnectionId: aws.String(deployer.VpcPeeringConnectionId),
-		})
-		if err != nil {
+	if deployer.VpcPeering != nil {
+		if err := deployer.deleteVpcPeering(sess); err != nil {
 			log.Warningf("Unable to remove Vpc peering: " + err.Error())
 		}
 	}
@@ -201,7 +205,62 @@ func (deployer *K8SDeployer) DeleteDeployment() error {
 	return nil
 }
 
-func populateNodeInfos(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster) error {
+func populateNodeInfos(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster, clusterDefinition apis.ClusterDefinition) error {
+	instances, err := describeNodeInstances(ec2Svc, awsCluster.StackName())
+	if err != nil {
+		return err
+	}
+
+	// Standalone workers are tagged with their node id, the autoscaling group instances are
+	// assigned to the remaining nodes by instance type
+	groupInstances := []*ec2.Instance{}
+	for _, instance := range instances {
+		nodeId := -1
+		for _, tag := range instance.Tags {
+			if aws.StringValue(tag.Key) == nodeIdTagKey {
+				if id, err := strconv.Atoi(aws.StringValue(tag.Value)); err == nil {
+					nodeId = id
+				}
+			}
+		}
+
+		if nodeId == -1 {
+			groupInstances = append(groupInstances, instance)
+			continue
+		}
+
+		awsCluster.NodeInfos[nodeId] = &hpaws.NodeInfo{
+			Instance:  instance,
+			PrivateIp: aws.StringValue(instance.PrivateIpAddress),
+		}
+	}
+
+	for _, node := range clusterDefinition.Nodes {
+		if _, ok := awsCluster.NodeInfos[node.Id]; ok {
+			continue
+		}
+
+		for i, instance := range groupInstances {
+			if aws.StringValue(instance.InstanceType) == node.InstanceType {
+				awsCluster.NodeInfos[node.Id] = &hpaws.NodeInfo{
+					Instance:  instance,
+					PrivateIp: aws.StringValue(instance.PrivateIpAddress),
+				}
+				groupInstances = append(groupInstances[:i], groupInstances[i+1:]...)
+				break
+			}
+		}
+
+		if _, ok := awsCluster.NodeInfos[node.Id]; !ok {
+			return fmt.Errorf("Unable to find %s instance for node %d", node.InstanceType, node.Id)
+		}
+	}
+
+	return nil
+}
+
+// describeNodeInstances return the running kubernetes node instances of the stack
+func describeNodeInstances(ec2Svc *ec2.EC2, stackName string) ([]*ec2.Instance, error) {
 	describeInstancesInput := &ec2.DescribeInstancesInput{
 		Filters: []*ec2.Filter{
 			{
@@ -213,7 +272,7 @@ func populateNodeInfos(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster) error {
 			{
 				Name: aws.String("tag:KubernetesCluster"),
 				Values: []*string{
-					aws.String(awsCluster.StackName()),
+					aws.String(stackName),
 				},
 			},
 			{
@@ -226,22 +285,15 @@ func populateNodeInfos(ec2Svc *ec2.EC2, awsCluster *hpaws.AWSCluster) error {
 	}
 	describeInstancesOutput, describeErr := ec2Svc.DescribeInstances(describeInstancesInput)
 	if describeErr != nil {
-		return errors.New("Unable to describe ec2 instances: " + describeErr.Error())
+		return nil, errors.New("Unable to describe ec2 instances: " + describeErr.Error())
 	}
 
-	i := 1
+	instances := []*ec2.Instance{}
 	for _, reservation := range describeInstancesOutput.Reservations {
-		for _, instance := range reservation.Instances {
-			nodeInfo := &hpaws.NodeInfo{
-				Instance:  instance,
-				PrivateIp: *instance.PrivateIpAddress,
-			}
-			awsCluster.NodeInfos[i] = nodeInfo
-			i += 1
-		}
+		instances = append(instances, reservation.Instances...)
 	}
 
-	return nil
+	return instances, nil
 }
 
 func deployCluster(deployer *K8SDeployer, uploadedFiles map[string]string) error {
@@ -267,11 +319,26 @@ func deployCluster(deployer *K8SDeployer, uploadedFiles map[string]string) error
 		return errors.New("Unable to deploy kubernetes custer: " + err.Error())
 	}
 
-	if err := populateNodeInfos(ec2Svc, awsCluster); err != nil {
+	_, workerNodes := splitNodesByInstanceType(deployment.ClusterDefinition.Nodes,
+		stackInstanceType(deployment.ClusterDefinition))
+	if err := launchWorkerInstances(ec2Svc, autoscaling.New(sess), awsCluster, deployment.Name, workerNodes, log); err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to launch worker instances: " + err.Error())
+	}
+
+	if err := populateNodeInfos(ec2Svc, awsCluster, deployment.ClusterDefinition); err != nil {
+		deleteDeploymentOnFailure(deployer)
 		return errors.New("Unable to populate node infos: " + err.Error())
 	}
 
-	if err := deployer.uploadFiles(uploadedFiles); err != nil {
+	if err := deployer.setupVpcPeering(sess); err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to peer vpc: " + err.Error())
+	}
+
+	plainFiles, templateFiles := common.SplitTemplateFiles(deployment)
+	awsCluster.PinConsoleHostKeys(awsCluster.NodeInfos)
+	if err := deployer.uploadDeploymentFiles(awsCluster.NodeInfos, plainFiles, uploadedFiles); err != nil {
 		deleteDeploymentOnFailure(deployer)
 		return errors.New("Unable to upload files to cluster: " + err.Error())
 	}
@@ -281,6 +348,15 @@ func deployCluster(deployer *K8SDeployer, uploadedFiles map[string]string) error
 		return errors.New("Unable to connect to kubernetes during delete: " + err.Error())
 	}
 
+	nodeNames := []string{}
+	for _, nodeInfo := range awsCluster.NodeInfos {
+		nodeNames = append(nodeNames, aws.StringValue(nodeInfo.Instance.PrivateDnsName))
+	}
+	if err := k8sUtil.WaitUntilKubernetesNodeExists(k8sClient, nodeNames, time.Duration(5)*time.Minute, log); err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable wait for kubernetes nodes to be exist: " + err.Error())
+	}
+
 	if err := tagKubeNodes(k8sClient, awsCluster, deployment, log); err != nil {
 		deleteDeploymentOnFailure(deployer)
 		return errors.New("Unable to tag Kubernetes nodes: " + err.Error())
@@ -292,6 +368,11 @@ func deployCluster(deployer *K8SDeployer, uploadedFiles map[string]string) error
 		return errors.New("Unable to deploy kubernetes objects: " + err.Error())
 	}
 	deployer.Services = serviceMapping
+
+	if err := deployer.uploadDeploymentFiles(awsCluster.NodeInfos, templateFiles, uploadedFiles); err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to upload template files to cluster: " + err.Error())
+	}
 	deployer.recordPublicEndpoints(k8sClient)
 
 	return nil
@@ -302,66 +383,48 @@ func deployKubernetes(sess *session.Session, deployer *K8SDeployer) error {
 	deployment := deployer.Deployment
 	log := deployer.DeploymentLog.Logger
 
+	// Nodes of other instance types are launched as standalone workers after the stack is created
+	instanceType := stackInstanceType(deployment.ClusterDefinition)
+	groupNodes, _ := splitNodesByInstanceType(deployment.ClusterDefinition.Nodes, instanceType)
+
+	definition := deployment.GetAWSK8SDefinition(awsCluster.Region)
+
 	cfSvc := cloudformation.New(sess)
 	params := &cloudformation.CreateStackInput{
 		StackName: aws.String(awsCluster.StackName()),
 		Capabilities: []*string{
 			aws.String("CAPABILITY_NAMED_IAM"),
 		},
-		Parameters: []*cloudformation.Parameter{
-			{
-				ParameterKey:   aws.String("AdminIngressLocation"),
-				ParameterValue: aws.String("0.0.0.0/0"),
-			},
-			{
-				ParameterKey:   aws.String("KeyName"),
-				ParameterValue: aws.String(awsCluster.KeyName()),
-			},
-			{
-				ParameterKey:   aws.String("NetworkingProvider"),
-				ParameterValue: aws.String("weave"),
-			},
-			{
-				ParameterKey: aws.String("K8sNodeCapacity"),
-				ParameterValue: aws.String(
-					strconv.Itoa(len(deployment.ClusterDefinition.Nodes))),
-			},
-			{
-				ParameterKey: aws.String("InstanceType"),
-				ParameterValue: aws.String(
-					deployment.ClusterDefinition.Nodes[0].InstanceType),
-			},
-			{
-				ParameterKey:   aws.String("DiskSizeGb"),
-				ParameterValue: aws.String("40"),
-			},
-			{
-				ParameterKey:   aws.String("BastionInstanceType"),
-				ParameterValue: aws.String("t2.micro"),
-			},
-			{
-				ParameterKey:   aws.String("QSS3BucketName"),
-				ParameterValue: aws.String("heptio-aws-quickstart-test"),
-			},
-			{
-				ParameterKey:   aws.String("QSS3KeyPrefix"),
-				ParameterValue: aws.String("heptio/kubernetes/master"),
-			},
-			{
-				ParameterKey:   aws.String("AvailabilityZone"),
-				ParameterValue: aws.String(awsCluster.Region + "a"),
-			},
-		},
 		Tags: []*cloudformation.Tag{
 			{
 				Key:   aws.String("deployment"),
 				Value: aws.String(awsCluster.Name),
 			},
 		},
-		TemplateURL:      aws.String("https://hyperpilot-snap-collectors.s3.amazonaws.com/kubernetes-cluster-with-new-vpc-1.7.2.template"),
 		TimeoutInMinutes: aws.Int64(60),
 	}
-	log.Info("Creating kubernetes stack...")
+
+	if err := setStackTemplate(deployer.Config, definition, deployment.ExistingNetwork != nil, params); err != nil {
+		return err
+	}
+
+	parameterValues := map[string]string{
+		"AdminIngressLocation": definition.AdminIngressLocation,
+		"KeyName":              awsCluster.KeyName(),
+		"NetworkingProvider":   definition.NetworkingProvider,
+		"K8sNodeCapacity":      strconv.Itoa(len(groupNodes)),
+		"InstanceType":         instanceType,
+		"DiskSizeGb":           strconv.Itoa(definition.DiskSizeGb),
+		"BastionInstanceType":  definition.BastionInstanceType,
+		"QSS3BucketName":       "heptio-aws-quickstart-test",
+		"QSS3KeyPrefix":        "heptio/kubernetes/master",
+	}
+	if err := setStackParameters(cfSvc, definition, deployment.ExistingNetwork, parameterValues, params); err != nil {
+		return err
+	}
+
+	log.Infof("Creating kubernetes %s stack in %s...", definition.KubernetesVersion,
+		strings.Join(definition.AvailabilityZones, ","))
 	if _, err := cfSvc.CreateStack(params); err != nil {
 		return errors.New("Unable to create stack: " + err.Error())
 	}
@@ -385,6 +448,9 @@ func deployKubernetes(sess *session.Session, deployer *K8SDeployer) error {
 	sshProxyCommand := ""
 	getKubeConfigCommand := ""
 	vpcId := ""
+	if deployment.ExistingNetwork != nil {
+		vpcId = deployment.ExistingNetwork.VpcId
+	}
 	for _, output := range outputs {
 		switch *output.OutputKey {
 		case "SSHProxyCommand":
@@ -403,6 +469,7 @@ func deployKubernetes(sess *session.Session, deployer *K8SDeployer) error {
 	} else if vpcId == "" {
 		return errors.New("Unable to find VPCID in stack output")
 	}
+	awsCluster.VpcId = vpcId
 
 	// We expect the SSHProxyCommand to be in the following format:
 	//"ssh -A -L8080:localhost:8080 -o ProxyCommand='ssh ubuntu@111.111.111.111 nc 10.0.0.0 22' ubuntu@10.0.0.0"
@@ -436,49 +503,6 @@ func deployKubernetes(sess *session.Session, deployer *K8SDeployer) error {
 		return errors.New("Unable to upload sshKey: " + err.Error())
 	}
 
-	if deployer.Deployment.VPCPeering != nil {
-		ec2Svc := ec2.New(sess)
-		vpcPeering, err := ec2Svc.CreateVpcPeeringConnection(&ec2.CreateVpcPeeringConnectionInput{
-			PeerOwnerId: aws.String(deployer.Deployment.VPCPeering.TargetOwnerId),
-			PeerVpcId:   aws.String(deployer.Deployment.VPCPeering.TargetVpcId),
-			VpcId:       aws.String(vpcId),
-		})
-		if err != nil {
-			return errors.New("Unable to peer vpc: " + err.Error())
-		}
-
-		vpcPeeringConnectionId := vpcPeering.VpcPeeringConnection.VpcPeeringConnectionId
-		deployer.VpcPeeringConnectionId = *vpcPeeringConnectionId
-
-		err = acceptVpcPeeringConnection(vpcPeeringConnectionId, deployer.Config,
-			deployer.Deployment.Region)
-		if err != nil {
-			return errors.New("Unable to auto accept peer vpc: " + err.Error())
-		}
-	}
-
-	return nil
-}
-
-func acceptVpcPeeringConnection(vpcPeeringConnectionId *string, config *viper.Viper, region string) error {
-	awsProfile := &hpaws.AWSProfile{
-		AwsId:     config.GetString("awsId"),
-		AwsSecret: config.GetString("awsSecret"),
-	}
-
-	sess, sessionErr := hpaws.CreateSession(awsProfile, region)
-	if sessionErr != nil {
-		return errors.New("Unable to create session: " + sessionErr.Error())
-	}
-
-	ec2Svc := ec2.New(sess)
-	_, err := ec2Svc.AcceptVpcPeeringConnection(&ec2.AcceptVpcPeeringConnectionInput{
-		VpcPeeringConnectionId: vpcPeeringConnectionId,
-	})
-	if err != nil {
-		return errors.New("Unable to accept vpc peering connection: " + err.Error())
-	}
-
 	return nil
 }
 
@@ -835,6 +859,11 @@ func (deployer *K8SDeployer) recordPublicEndpoints(k8sClient *k8s.Clientset) {
 	deployment := deployer.Deployment
 	log := deployer.DeploymentLog.Logger
 
+	if deployment.KubernetesDeployment.Ingress != nil {
+		deployer.recordIngressEndpoints(k8sClient)
+		return
+	}
+
 	allNamespaces := k8sUtil.GetAllDeployedNamespaces(deployment)
 	c := make(chan bool, 1)
 	quit := make(chan bool)
@@ -855,15 +884,9 @@ func (deployer *K8SDeployer) recordPublicEndpoints(k8sClient *k8s.Clientset) {
 							hostname := service.Status.LoadBalancer.Ingress[0].Hostname
 							port := service.Spec.Ports[0].Port
 
-							serviceMapping := k8sUtil.ServiceMapping{
-								PublicUrl: hostname + ":" + strconv.FormatInt(int64(port), 10),
-							}
-
 							familyName := serviceName[:strings.Index(serviceName, "-public")]
-							if mapping, ok := deployer.Services[familyName]; ok {
-								serviceMapping.NodeId = mapping.NodeId
-							}
-							deployer.Services[familyName] = serviceMapping
+							deployer.recordServiceUrl(familyName,
+								hostname+":"+strconv.FormatInt(int64(port), 10))
 						} else {
 							allElbsTagged = false
 							break
@@ -926,9 +949,18 @@ func (deployer *K8SDeployer) DownloadKubeConfig() error {
 	return nil
 }
 
-func (deployer *K8SDeployer) uploadFiles(uploadedFiles map[string]string) error {
+func (deployer *K8SDeployer) uploadFilesToNodes(nodeInfos map[int]*hpaws.NodeInfo, uploadedFiles map[string]string) error {
+	deployer.AWSCluster.PinConsoleHostKeys(nodeInfos)
+	return deployer.uploadDeploymentFiles(nodeInfos, deployer.Deployment, uploadedFiles)
+}
+
+// uploadDeploymentFiles uploads the files of the deployment to the nodes, rendering template
+// files with the service mappings deployed so far
+func (deployer *K8SDeployer) uploadDeploymentFiles(
+	nodeInfos map[int]*hpaws.NodeInfo,
+	deployment *apis.Deployment,
+	uploadedFiles map[string]string) error {
 	awsCluster := deployer.AWSCluster
-	deployment := deployer.Deployment
 	bastionIp := deployer.BastionIp
 	log := deployer.GetLog().Logger
 	if len(deployment.Files) == 0 {
@@ -940,21 +972,114 @@ func (deployer *K8SDeployer) uploadFiles(uploadedFiles map[string]string) error
 		return errors.New("Unable to create ssh config: " + clientConfigErr.Error())
 	}
 
-	var errBool bool
-	for _, nodeInfo := range awsCluster.NodeInfos {
-		sshClient := common.NewSshClient(nodeInfo.PrivateIp+":22", clientConfig, bastionIp+":22")
-		if err := common.UploadFiles(deployer.Config, sshClient, deployment, uploadedFiles, log); err != nil {
-			errBool = true
+	services := map[string]interface{}{}
+	if common.HasTemplateFiles(deployment) {
+		if serviceMappings, err := deployer.GetServiceMappings(); err != nil {
+			log.Warningf("Unable to get service mappings for template files: %s", err.Error())
+		} else {
+			services = serviceMappings
 		}
 	}
-	if errBool {
-		return errors.New("Unable to uploaded all files")
+
+	nodes := []common.NodeUpload{}
+	for nodeId, nodeInfo := range nodeInfos {
+		nodes = append(nodes, common.NodeUpload{
+			Client: common.NewSshClient(nodeInfo.PrivateIp+":22", clientConfig, bastionIp+":22"),
+			Variables: &common.FileVariables{
+				DeploymentName: deployment.Name,
+				Region:         awsCluster.Region,
+				NodeId:         nodeId,
+				PrivateIp:      nodeInfo.PrivateIp,
+				PublicIp:       aws.StringValue(nodeInfo.Instance.PublicIpAddress),
+				Services:       services,
+			},
+		})
+	}
+	if err := common.UploadFilesToNodes(deployer.Config, nodes, deployment, uploadedFiles, nil, log); err != nil {
+		return errors.New("Unable to upload all files: " + err.Error())
 	}
 	log.Info("Uploaded all files")
 
 	return nil
 }
 
+// GetNodeSshClients return ssh clients of the cluster nodes, which are reached through the bastion
+func (deployer *K8SDeployer) GetNodeSshClients() (map[int]common.SshClient, error) {
+	clientConfig, err := deployer.AWSCluster.SshConfig("ubuntu")
+	if err != nil {
+		return nil, errors.New("Unable to create ssh config: " + err.Error())
+	}
+
+	sshClients := map[int]common.SshClient{}
+	for nodeId, nodeInfo := range deployer.AWSCluster.NodeInfos {
+		sshClients[nodeId] = common.NewSshClient(nodeInfo.PrivateIp+":22", clientConfig, deployer.BastionIp+":22")
+	}
+
+	return sshClients, nil
+}
+
+// CollectDiagnostics adds the logs of the cluster nodes, and the pods, events and node
+// conditions of the cluster to the bundle
+func (deployer *K8SDeployer) CollectDiagnostics(diagnostics *common.Diagnostics) error {
+	if sshClients, err := deployer.GetNodeSshClients(); err != nil {
+		diagnostics.AddError("nodes", err)
+	} else {
+		common.CollectNodeLogs(diagnostics, sshClients, common.KubernetesNodeLogCommands,
+			common.SshConcurrency(deployer.Config))
+	}
+
+	if deployer.KubeConfig == nil {
+		diagnostics.AddError("kubernetes", errors.New("Kubernetes config of the cluster is unknown"))
+		return nil
+	}
+
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		diagnostics.AddError("kubernetes", errors.New("Unable to connect to Kubernetes: "+err.Error()))
+		return nil
+	}
+
+	return k8sUtil.CollectDiagnostics(k8sClient, "", diagnostics)
+}
+
+// RotateSshKey replaces the key pair of the cluster on its nodes, master and bastion, and
+// uploads the new private key to the bastion
+func (deployer *K8SDeployer) RotateSshKey(storeKey func() error) error {
+	awsCluster := deployer.AWSCluster
+	oldKeyMaterial := awsCluster.GetKeyMaterial()
+	targets := func() ([]common.NodeTarget, error) {
+		sshClients, err := deployer.GetNodeSshClients()
+		if err != nil {
+			return nil, err
+		}
+
+		clientConfig, err := awsCluster.SshConfig("ubuntu")
+		if err != nil {
+			return nil, errors.New("Unable to create ssh config: " + err.Error())
+		}
+
+		return append(common.SshTargets(sshClients),
+			common.NodeTarget{
+				NodeId: bastionNodeId,
+				Client: common.NewSshClient(deployer.BastionIp+":22", clientConfig, ""),
+			},
+			common.NodeTarget{
+				NodeId: masterNodeId,
+				Client: common.NewSshClient(deployer.MasterIp+":22", clientConfig, deployer.BastionIp+":22"),
+			}), nil
+	}
+
+	rotateErr := hpaws.RotateKeyPair(awsCluster, targets, common.SshConcurrency(deployer.Config), storeKey,
+		deployer.GetLog().Logger)
+	if awsCluster.GetKeyMaterial() != oldKeyMaterial {
+		if err := deployer.UploadSshKeyToBastion(); err != nil {
+			return errors.New("Unable to upload new ssh key to bastion: " + err.Error())
+		}
+	}
+
+	return rotateErr
+}
+
 // UploadSshKeyToBastion upload sshKey to bastion-host
 func (deployer *K8SDeployer) UploadSshKeyToBastion() error {
 	awsCluster := deployer.AWSCluster
@@ -1018,8 +1143,9 @@ func (deployer *K8SDeployer) CheckClusterState() error {
 		return errors.New("Unable to get stack outputs: " + err.Error())
 	}
 
+	// Stacks are updated when nodes are added or removed
 	stackStatus := aws.StringValue(describeStacksOutput.Stacks[0].StackStatus)
-	if stackStatus != "CREATE_COMPLETE" {
+	if stackStatus != "CREATE_COMPLETE" && stackStatus != "UPDATE_COMPLETE" {
 		return errors.New("Unable to reload stack because status is not ready, current status: " + stackStatus)
 	}
 
@@ -1036,7 +1162,14 @@ func (deployer *K8SDeployer) ReloadClusterState(storeInfo interface{}) error {
 	k8sStoreInfo := storeInfo.(*StoreInfo)
 	deployer.BastionIp = k8sStoreInfo.BastionIp
 	deployer.MasterIp = k8sStoreInfo.MasterIp
-	deployer.VpcPeeringConnectionId = k8sStoreInfo.VpcPeeringConnectionId
+	deployer.VpcPeering = k8sStoreInfo.VpcPeering
+	if deployer.VpcPeering == nil && k8sStoreInfo.VpcPeeringConnectionId != "" {
+		deployer.VpcPeering = &apis.VPCPeeringStatus{
+			Provider:     apis.PeeringProviderAWS,
+			ConnectionId: k8sStoreInfo.VpcPeeringConnectionId,
+			TargetRegion: deployer.AWSCluster.Region,
+		}
+	}
 
 	glog.Infof("Reloading kube config for %s...", deployer.AWSCluster.Name)
 	if err := deployer.DownloadKubeConfig(); err != nil {
@@ -1059,6 +1192,50 @@ func (deployer *K8SDeployer) ReloadClusterState(storeInfo interface{}) error {
 	return nil
 }
 
+// ScaleTask updates the replicas of a running task
+func (deployer *K8SDeployer) ScaleTask(taskName string, replicas int) error {
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes: " + err.Error())
+	}
+
+	return k8sUtil.ScaleTask(deployer.Config, k8sClient, deployer.Deployment, "", taskName,
+		int32(replicas), deployer.Services, deployer.DeploymentLog.Logger)
+}
+
+// recordIngressEndpoints records the public urls of services exposed through the ingress controller
+func (deployer *K8SDeployer) recordIngressEndpoints(k8sClient *k8s.Clientset) {
+	log := deployer.DeploymentLog.Logger
+	err := funcs.LoopUntil(time.Minute*2, time.Second*5, func() (bool, error) {
+		publicUrls, ready, err := k8sUtil.GetIngressPublicUrls(k8sClient, deployer.Deployment)
+		if err != nil || !ready {
+			return false, err
+		}
+
+		for serviceName, publicUrl := range publicUrls {
+			deployer.recordServiceUrl(serviceName, publicUrl)
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		log.Warningf("Unable to record ingress endpoints: %s", err.Error())
+		return
+	}
+
+	log.Info("All ingress endpoints recorded.")
+}
+
+// recordServiceUrl sets the public url of the service, keeping the rest of its mapping
+func (deployer *K8SDeployer) recordServiceUrl(serviceName string, publicUrl string) {
+	serviceMapping := deployer.Services[serviceName]
+	serviceMapping.PublicUrl = publicUrl
+	if serviceMapping.NodeId == 0 {
+		serviceMapping.NodeId, _ = k8sUtil.FindNodeIdFromServiceName(deployer.Deployment, serviceName)
+	}
+	deployer.Services[serviceName] = serviceMapping
+}
+
 func (deployer *K8SDeployer) GetServiceMappings() (map[string]interface{}, error) {
 	nodeNameInfos := map[string]string{}
 	if len(deployer.AWSCluster.NodeInfos) > 0 {
@@ -1124,7 +1301,8 @@ func (deployer *K8SDeployer) GetServiceAddress(serviceName string) (*apis.Servic
 }
 
 func (deployer *K8SDeployer) GetServiceUrl(serviceName string) (string, error) {
-	if info, ok := deployer.Services[serviceName]; ok {
+	// Mappings of scaled services can be recorded before their url
+	if info, ok := deployer.Services[serviceName]; ok && info.PublicUrl != "" {
 		return info.PublicUrl, nil
 	}
 
@@ -1133,6 +1311,18 @@ func (deployer *K8SDeployer) GetServiceUrl(serviceName string) (string, error) {
 		return "", errors.New("Unable to connect to Kubernetes during get service url: " + err.Error())
 	}
 
+	if deployer.Deployment.KubernetesDeployment.Ingress != nil {
+		publicUrls, _, err := k8sUtil.GetIngressPublicUrls(k8sClient, deployer.Deployment)
+		if err != nil {
+			return "", errors.New("Unable to get ingress urls: " + err.Error())
+		}
+
+		if serviceUrl, ok := publicUrls[serviceName]; ok {
+			deployer.recordServiceUrl(serviceName, serviceUrl)
+			return serviceUrl, nil
+		}
+	}
+
 	services, err := k8sClient.CoreV1().Services("").List(metav1.ListOptions{})
 	if err != nil {
 		return "", errors.New("Unable to list services in the cluster: " + err.Error())
@@ -1141,14 +1331,10 @@ func (deployer *K8SDeployer) GetServiceUrl(serviceName string) (string, error) {
 	for _, service := range services.Items {
 		if (service.ObjectMeta.Name == serviceName || service.ObjectMeta.Name == serviceName+"-publicport0") &&
 			string(service.Spec.Type) == "LoadBalancer" {
-			nodeId, _ := k8sUtil.FindNodeIdFromServiceName(deployer.Deployment, serviceName)
 			port := service.Spec.Ports[0].Port
 			hostname := service.Status.LoadBalancer.Ingress[0].Hostname
 			serviceUrl := hostname + ":" + strconv.FormatInt(int64(port), 10)
-			deployer.Services[serviceName] = k8sUtil.ServiceMapping{
-				PublicUrl: serviceUrl,
-				NodeId:    nodeId,
-			}
+			deployer.recordServiceUrl(serviceName, serviceUrl)
 			return serviceUrl, nil
 		}
 	}
@@ -1158,9 +1344,9 @@ func (deployer *K8SDeployer) GetServiceUrl(serviceName string) (string, error) {
 
 func (deployer *K8SDeployer) GetStoreInfo() interface{} {
 	return &StoreInfo{
-		BastionIp:              deployer.BastionIp,
-		MasterIp:               deployer.MasterIp,
-		VpcPeeringConnectionId: deployer.VpcPeeringConnectionId,
+		BastionIp:  deployer.BastionIp,
+		MasterIp:   deployer.MasterIp,
+		VpcPeering: deployer.VpcPeering,
 	}
 }
 
@@ -16,6 +16,7 @@ import (
 	"github.com/hyperpilotio/deployer/apis"
 	k8sUtil "github.com/hyperpilotio/deployer/clustermanagers/kubernetes"
 	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+	"github.com/hyperpilotio/deployer/common"
 	"github.com/hyperpilotio/go-utils/log"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -533,6 +534,27 @@ func (deployer *InClusterK8SDeployer) DeployExtensions(
 	return nil
 }
 
+// ScaleTask updates the replicas of a running task in the deployment namespace
+func (deployer *InClusterK8SDeployer) ScaleTask(taskName string, replicas int) error {
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes: " + err.Error())
+	}
+
+	return k8sUtil.ScaleTask(deployer.Config, k8sClient, deployer.Deployment, deployer.getNamespace(), taskName,
+		int32(replicas), deployer.Services, deployer.DeploymentLog.Logger)
+}
+
+// AddNodes is not supported, in cluster deployments don't own the autoscaling group of the cluster
+func (deployer *InClusterK8SDeployer) AddNodes(nodes []apis.ClusterNode, uploadedFiles map[string]string) error {
+	return errors.New("Unsupported adding nodes to in cluster deployment")
+}
+
+// RemoveNodes is not supported, in cluster deployments don't own the autoscaling group of the cluster
+func (deployer *InClusterK8SDeployer) RemoveNodes(nodeIds []int) error {
+	return errors.New("Unsupported removing nodes from in cluster deployment")
+}
+
 func deleteInClusterDeploymentOnFailure(deployer *InClusterK8SDeployer) {
 	log := deployer.GetLog().Logger
 	if deployer.Deployment.KubernetesDeployment.SkipDeleteOnFailure {
@@ -769,3 +791,25 @@ func (deployer *InClusterK8SDeployer) GetServiceMappings() (map[string]interface
 
 	return serviceMappings, nil
 }
+
+// GetNodeSshClients isn't supported in cluster, where the deployer has no key of the nodes
+func (deployer *InClusterK8SDeployer) GetNodeSshClients() (map[int]common.SshClient, error) {
+	return nil, errors.New("Unsupported ssh access to nodes in cluster")
+}
+
+// CollectDiagnostics adds the pods and events of the deployment namespace to the bundle, node
+// logs can't be collected without access to the nodes
+func (deployer *InClusterK8SDeployer) CollectDiagnostics(diagnostics *common.Diagnostics) error {
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		diagnostics.AddError("kubernetes", errors.New("Unable to connect to Kubernetes: "+err.Error()))
+		return nil
+	}
+
+	return k8sUtil.CollectDiagnostics(k8sClient, deployer.getNamespace(), diagnostics)
+}
+
+// RotateSshKey isn't supported in cluster, where the deployer has no key of the nodes
+func (deployer *InClusterK8SDeployer) RotateSshKey(storeKey func() error) error {
+	return errors.New("Unsupported ssh key rotation in cluster")
+}
@@ -19,12 +19,12 @@ type K8SDeployer struct {
 	Deployment    *apis.Deployment
 	Scheduler     *job.Scheduler
 
-	BastionIp              string
-	MasterIp               string
-	KubeConfigPath         string
-	Services               map[string]kubernetes.ServiceMapping
-	KubeConfig             *rest.Config
-	VpcPeeringConnectionId string
+	BastionIp      string
+	MasterIp       string
+	KubeConfigPath string
+	Services       map[string]kubernetes.ServiceMapping
+	KubeConfig     *rest.Config
+	VpcPeering     *apis.VPCPeeringStatus
 }
 
 type CreateDeploymentResponse struct {
@@ -41,7 +41,9 @@ type DeploymentLoadBalancers struct {
 }
 
 type StoreInfo struct {
-	BastionIp              string
-	MasterIp               string
+	BastionIp  string
+	MasterIp   string
+	VpcPeering *apis.VPCPeeringStatus
+	// Peering connection stored by older deployers, which didn't set up routes or security groups
 	VpcPeeringConnectionId string
 }
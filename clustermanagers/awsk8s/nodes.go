@@ -0,0 +1,360 @@
+package awsk8s
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperpilotio/deployer/apis"
+	k8sUtil "github.com/hyperpilotio/deployer/clustermanagers/kubernetes"
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+	"github.com/hyperpilotio/go-utils/funcs"
+	logging "github.com/op/go-logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// AddNodes grows the kubernetes node autoscaling group of the stack for nodes of the stack
+// instance type, launches standalone workers for the other nodes, and labels the new kubernetes
+// nodes with the given node ids
+func (deployer *K8SDeployer) AddNodes(nodes []apis.ClusterNode, uploadedFiles map[string]string) error {
+	awsCluster := deployer.AWSCluster
+	deployment := deployer.Deployment
+	log := deployer.DeploymentLog.Logger
+
+	sess, sessionErr := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		return errors.New("Unable to create session: " + sessionErr.Error())
+	}
+
+	ec2Svc := ec2.New(sess)
+	cfSvc := cloudformation.New(sess)
+	autoscalingSvc := autoscaling.New(sess)
+
+	parameters, err := describeStackParameters(cfSvc, awsCluster.StackName())
+	if err != nil {
+		return err
+	}
+
+	groupNodes, workerNodes := splitNodesByInstanceType(nodes, parameters["InstanceType"])
+	if len(groupNodes) > 0 {
+		instances, err := describeNodeInstances(ec2Svc, awsCluster.StackName())
+		if err != nil {
+			return err
+		}
+
+		existingInstanceIds := map[string]bool{}
+		for _, instance := range instances {
+			existingInstanceIds[aws.StringValue(instance.InstanceId)] = true
+		}
+
+		currentCapacity, err := strconv.Atoi(parameters["K8sNodeCapacity"])
+		if err != nil {
+			return errors.New("Unable to parse stack node capacity: " + err.Error())
+		}
+
+		if err := updateNodeCapacity(cfSvc, awsCluster.StackName(), currentCapacity+len(groupNodes), log); err != nil {
+			return err
+		}
+
+		newInstances := []*ec2.Instance{}
+		log.Infof("Waiting for %d new kubernetes node instances to be running...", len(groupNodes))
+		err = funcs.LoopUntil(time.Minute*10, time.Second*10, func() (bool, error) {
+			instances, err := describeNodeInstances(ec2Svc, awsCluster.StackName())
+			if err != nil {
+				return false, err
+			}
+
+			newInstances = []*ec2.Instance{}
+			for _, instance := range instances {
+				if !existingInstanceIds[aws.StringValue(instance.InstanceId)] {
+					newInstances = append(newInstances, instance)
+				}
+			}
+
+			return len(newInstances) >= len(groupNodes), nil
+		})
+		if err != nil {
+			return errors.New("Unable to wait for new node instances: " + err.Error())
+		}
+
+		for i, node := range groupNodes {
+			awsCluster.NodeInfos[node.Id] = &hpaws.NodeInfo{
+				Instance:  newInstances[i],
+				PrivateIp: aws.StringValue(newInstances[i].PrivateIpAddress),
+			}
+		}
+	}
+
+	if err := launchWorkerInstances(ec2Svc, autoscalingSvc, awsCluster, deployment.Name, workerNodes, log); err != nil {
+		return errors.New("Unable to launch worker instances: " + err.Error())
+	}
+
+	newNodeInfos := map[int]*hpaws.NodeInfo{}
+	nodeNames := map[int]string{}
+	kubeNodeNames := []string{}
+	for _, node := range nodes {
+		nodeInfo := awsCluster.NodeInfos[node.Id]
+		newNodeInfos[node.Id] = nodeInfo
+		nodeNames[node.Id] = aws.StringValue(nodeInfo.Instance.PrivateDnsName)
+		kubeNodeNames = append(kubeNodeNames, nodeNames[node.Id])
+	}
+	deployment.ClusterDefinition.Nodes = append(deployment.ClusterDefinition.Nodes, nodes...)
+
+	if err := deployer.uploadFilesToNodes(newNodeInfos, uploadedFiles); err != nil {
+		return errors.New("Unable to upload files to new nodes: " + err.Error())
+	}
+
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes: " + err.Error())
+	}
+
+	if err := k8sUtil.WaitUntilKubernetesNodeExists(k8sClient, kubeNodeNames, time.Duration(5)*time.Minute, log); err != nil {
+		return errors.New("Unable to wait for kubernetes nodes to be exist: " + err.Error())
+	}
+
+	newClusterDefinition := apis.ClusterDefinition{Nodes: nodes}
+	if err := k8sUtil.TagKubeNodes(k8sClient, deployment.Name, newClusterDefinition, nodeNames, log); err != nil {
+		return errors.New("Unable to tag Kubernetes nodes: " + err.Error())
+	}
+
+	return nil
+}
+
+// RemoveNodes terminates the instances of the given nodes and shrinks the kubernetes
+// node autoscaling group of the stack
+func (deployer *K8SDeployer) RemoveNodes(nodeIds []int) error {
+	awsCluster := deployer.AWSCluster
+	deployment := deployer.Deployment
+	log := deployer.DeploymentLog.Logger
+
+	sess, sessionErr := hpaws.CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		return errors.New("Unable to create session: " + sessionErr.Error())
+	}
+
+	ec2Svc := ec2.New(sess)
+	cfSvc := cloudformation.New(sess)
+	autoscalingSvc := autoscaling.New(sess)
+
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes: " + err.Error())
+	}
+
+	// Node infos are not kept across reloads, so find the instances from the node labels
+	nodeNames, err := k8sUtil.GetKubeNodeNames(k8sClient, deployment.Name, nodeIds)
+	if err != nil {
+		return err
+	}
+
+	kubeNodeNames := []string{}
+	for _, nodeName := range nodeNames {
+		kubeNodeNames = append(kubeNodeNames, nodeName)
+	}
+
+	describeInstancesOutput, err := ec2Svc.DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("private-dns-name"),
+				Values: aws.StringSlice(kubeNodeNames),
+			},
+			{
+				Name:   aws.String("tag:KubernetesCluster"),
+				Values: []*string{aws.String(awsCluster.StackName())},
+			},
+		},
+	})
+	if err != nil {
+		return errors.New("Unable to describe ec2 instances: " + err.Error())
+	}
+
+	// Standalone workers are not part of the autoscaling group and are terminated directly
+	groupInstanceIds := []*string{}
+	workerInstanceIds := []*string{}
+	groupName := ""
+	for _, reservation := range describeInstancesOutput.Reservations {
+		for _, instance := range reservation.Instances {
+			instanceGroupName := ""
+			for _, tag := range instance.Tags {
+				if aws.StringValue(tag.Key) == "aws:autoscaling:groupName" {
+					instanceGroupName = aws.StringValue(tag.Value)
+				}
+			}
+
+			if instanceGroupName == "" {
+				workerInstanceIds = append(workerInstanceIds, instance.InstanceId)
+			} else {
+				groupName = instanceGroupName
+				groupInstanceIds = append(groupInstanceIds, instance.InstanceId)
+			}
+		}
+	}
+
+	instanceIds := append(groupInstanceIds, workerInstanceIds...)
+	if len(instanceIds) != len(nodeIds) {
+		return fmt.Errorf("Unable to find instances of all %d nodes, found %d", len(nodeIds), len(instanceIds))
+	}
+
+	capacity := 0
+	if len(groupInstanceIds) > 0 {
+		parameters, err := describeStackParameters(cfSvc, awsCluster.StackName())
+		if err != nil {
+			return err
+		}
+
+		currentCapacity, err := strconv.Atoi(parameters["K8sNodeCapacity"])
+		if err != nil {
+			return errors.New("Unable to parse stack node capacity: " + err.Error())
+		}
+
+		capacity = currentCapacity - len(groupInstanceIds)
+		if err := lowerAutoScalingGroupMinSize(autoscalingSvc, groupName, capacity); err != nil {
+			return err
+		}
+	}
+
+	for _, instanceId := range groupInstanceIds {
+		_, err := autoscalingSvc.TerminateInstanceInAutoScalingGroup(&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+			InstanceId:                     instanceId,
+			ShouldDecrementDesiredCapacity: aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to terminate instance %s: %s", aws.StringValue(instanceId), err.Error())
+		}
+		log.Infof("Terminating instance %s", aws.StringValue(instanceId))
+	}
+
+	if len(workerInstanceIds) > 0 {
+		if _, err := ec2Svc.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: workerInstanceIds}); err != nil {
+			return errors.New("Unable to terminate worker instances: " + err.Error())
+		}
+		log.Infof("Terminating worker instances %s", aws.StringValueSlice(workerInstanceIds))
+	}
+
+	if err := k8sUtil.DeleteKubeNodes(k8sClient, kubeNodeNames, log); err != nil {
+		log.Warningf("Unable to delete kubernetes nodes: %s", err.Error())
+	}
+
+	log.Info("Waiting until node instances are terminated...")
+	if err := ec2Svc.WaitUntilInstanceTerminated(&ec2.DescribeInstancesInput{InstanceIds: instanceIds}); err != nil {
+		return errors.New("Unable to wait until instances terminated: " + err.Error())
+	}
+
+	for _, nodeId := range nodeIds {
+		delete(awsCluster.NodeInfos, nodeId)
+	}
+	deployment.ClusterDefinition.RemoveNodes(nodeIds)
+
+	if len(groupInstanceIds) == 0 {
+		return nil
+	}
+
+	// Keep the stack in sync with the autoscaling group, so later stack updates don't bring the nodes back
+	return updateNodeCapacity(cfSvc, awsCluster.StackName(), capacity, log)
+}
+
+// describeStackParameters return the current parameter values of the stack
+func describeStackParameters(cfSvc *cloudformation.CloudFormation, stackName string) (map[string]string, error) {
+	describeStacksOutput, err := cfSvc.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		return nil, errors.New("Unable to describe stack: " + err.Error())
+	}
+
+	parameters := map[string]string{}
+	for _, parameter := range describeStacksOutput.Stacks[0].Parameters {
+		parameters[aws.StringValue(parameter.ParameterKey)] = aws.StringValue(parameter.ParameterValue)
+	}
+
+	return parameters, nil
+}
+
+// updateNodeCapacity updates the K8sNodeCapacity parameter of the kubernetes stack and
+// waits for the update to complete
+func updateNodeCapacity(cfSvc *cloudformation.CloudFormation, stackName string, capacity int, log *logging.Logger) error {
+	describeStacksInput := &cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	}
+
+	describeStacksOutput, err := cfSvc.DescribeStacks(describeStacksInput)
+	if err != nil {
+		return errors.New("Unable to describe stack: " + err.Error())
+	}
+
+	parameters := []*cloudformation.Parameter{}
+	for _, parameter := range describeStacksOutput.Stacks[0].Parameters {
+		key := aws.StringValue(parameter.ParameterKey)
+		if key == "K8sNodeCapacity" {
+			if aws.StringValue(parameter.ParameterValue) == strconv.Itoa(capacity) {
+				return nil
+			}
+
+			parameters = append(parameters, &cloudformation.Parameter{
+				ParameterKey:   aws.String(key),
+				ParameterValue: aws.String(strconv.Itoa(capacity)),
+			})
+		} else {
+			parameters = append(parameters, &cloudformation.Parameter{
+				ParameterKey:     aws.String(key),
+				UsePreviousValue: aws.Bool(true),
+			})
+		}
+	}
+
+	log.Infof("Updating kubernetes stack node capacity to %d...", capacity)
+	_, err = cfSvc.UpdateStack(&cloudformation.UpdateStackInput{
+		StackName:           aws.String(stackName),
+		UsePreviousTemplate: aws.Bool(true),
+		Capabilities: []*string{
+			aws.String("CAPABILITY_NAMED_IAM"),
+		},
+		Parameters: parameters,
+	})
+	if err != nil {
+		return errors.New("Unable to update stack: " + err.Error())
+	}
+
+	if err := cfSvc.WaitUntilStackUpdateComplete(describeStacksInput); err != nil {
+		return errors.New("Unable to wait until stack update complete: " + err.Error())
+	}
+	log.Info("Kubernetes stack updated")
+
+	return nil
+}
+
+// lowerAutoScalingGroupMinSize makes sure the autoscaling group can be shrunk to the given capacity
+func lowerAutoScalingGroupMinSize(autoscalingSvc *autoscaling.AutoScaling, groupName string, capacity int) error {
+	output, err := autoscalingSvc.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(groupName)},
+	})
+	if err != nil {
+		return errors.New("Unable to describe autoscaling group: " + err.Error())
+	}
+
+	if len(output.AutoScalingGroups) == 0 {
+		return errors.New("Unable to find autoscaling group " + groupName)
+	}
+
+	if aws.Int64Value(output.AutoScalingGroups[0].MinSize) <= int64(capacity) {
+		return nil
+	}
+
+	_, err = autoscalingSvc.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(groupName),
+		MinSize:              aws.Int64(int64(capacity)),
+	})
+	if err != nil {
+		return errors.New("Unable to update autoscaling group min size: " + err.Error())
+	}
+
+	return nil
+}
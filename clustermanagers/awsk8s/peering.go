@@ -0,0 +1,61 @@
+package awsk8s
+
+import (
+	"github.com/hyperpilotio/deployer/apis"
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// setupVpcPeering peers the cluster VPC with the target VPC of the deployment, and opens the
+// security groups of the cluster nodes to it
+func (deployer *K8SDeployer) setupVpcPeering(sess *session.Session) error {
+	peering := deployer.Deployment.VPCPeering
+	if peering == nil {
+		return nil
+	}
+
+	awsCluster := deployer.AWSCluster
+	status, err := hpaws.SetupClusterVpcPeering(deployer.Config, sess, awsCluster, peering,
+		nodeSecurityGroupIds(awsCluster), deployer.DeploymentLog.Logger)
+	// Keep what was set up so far, for it to be removed along with the deployment
+	if status != nil {
+		deployer.VpcPeering = status
+	}
+
+	return err
+}
+
+// deleteVpcPeering removes the peering connection and the routes and rules set up for it
+func (deployer *K8SDeployer) deleteVpcPeering(sess *session.Session) error {
+	if err := hpaws.DeleteClusterVpcPeering(deployer.Config, sess, deployer.Deployment.VPCPeering,
+		deployer.VpcPeering, deployer.DeploymentLog.Logger); err != nil {
+		return err
+	}
+	deployer.VpcPeering = nil
+
+	return nil
+}
+
+// GetVPCPeering return the current status of the vpc peering connection of the deployment
+func (deployer *K8SDeployer) GetVPCPeering() (*apis.VPCPeeringStatus, error) {
+	return hpaws.GetClusterVpcPeering(deployer.AWSCluster, deployer.VpcPeering)
+}
+
+// nodeSecurityGroupIds return the distinct security groups of the cluster node instances
+func nodeSecurityGroupIds(awsCluster *hpaws.AWSCluster) []string {
+	groupIds := []string{}
+	found := map[string]bool{}
+	for _, nodeInfo := range awsCluster.NodeInfos {
+		for _, group := range nodeInfo.Instance.SecurityGroups {
+			groupId := aws.StringValue(group.GroupId)
+			if !found[groupId] {
+				found[groupId] = true
+				groupIds = append(groupIds, groupId)
+			}
+		}
+	}
+
+	return groupIds
+}
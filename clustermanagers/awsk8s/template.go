@@ -0,0 +1,121 @@
+package awsk8s
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/hyperpilotio/deployer/apis"
+	"github.com/spf13/viper"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+// Cloudformation rejects template bodies larger than this, bigger templates have to be uploaded to S3
+const maxTemplateBodySize = 51200
+
+// stackTemplateURL return the published kubernetes stack template of the Kubernetes version
+func stackTemplateURL(kubernetesVersion string) string {
+	return fmt.Sprintf("https://hyperpilot-snap-collectors.s3.amazonaws.com/kubernetes-cluster-with-new-vpc-%s.template",
+		kubernetesVersion)
+}
+
+// setStackTemplate points the stack to the template bundled in the configured templates
+// directory when the definition names one, or to the published template otherwise.
+// The published templates always create a new VPC, so existing VPCs need a bundled template.
+func setStackTemplate(
+	config *viper.Viper,
+	definition apis.AWSK8SDefinition,
+	existingVpc bool,
+	input *cloudformation.CreateStackInput) error {
+	if definition.TemplateFile == "" {
+		if existingVpc {
+			return errors.New("Unable to launch in an existing VPC: the published templates create a new VPC, " +
+				"a template file taking VPCID is required")
+		}
+		input.TemplateURL = aws.String(stackTemplateURL(definition.KubernetesVersion))
+		return nil
+	}
+
+	templatesPath := config.GetString("awsK8sTemplatesPath")
+	if templatesPath == "" {
+		return errors.New("Unable to use template file " + definition.TemplateFile + ": awsK8sTemplatesPath is not configured")
+	}
+
+	body, err := ioutil.ReadFile(filepath.Join(templatesPath, filepath.Base(definition.TemplateFile)))
+	if err != nil {
+		return errors.New("Unable to read template file: " + err.Error())
+	}
+
+	if len(body) > maxTemplateBodySize {
+		return fmt.Errorf("Template file %s is larger than %d bytes", definition.TemplateFile, maxTemplateBodySize)
+	}
+
+	input.TemplateBody = aws.String(string(body))
+	return nil
+}
+
+// setStackParameters passes the given parameter values declared by the stack template.
+// Templates either take a single AvailabilityZone or a comma separated AvailabilityZones list.
+// Existing networks are passed as VPCID, and either a SubnetIds list or the ClusterSubnetId and
+// LoadBalancerSubnetId taken from the first and last subnets.
+func setStackParameters(
+	cfSvc *cloudformation.CloudFormation,
+	definition apis.AWSK8SDefinition,
+	network *apis.ExistingNetwork,
+	values map[string]string,
+	input *cloudformation.CreateStackInput) error {
+	summary, err := cfSvc.GetTemplateSummary(&cloudformation.GetTemplateSummaryInput{
+		TemplateBody: input.TemplateBody,
+		TemplateURL:  input.TemplateURL,
+	})
+	if err != nil {
+		return errors.New("Unable to get template summary: " + err.Error())
+	}
+
+	declared := map[string]bool{}
+	for _, parameter := range summary.Parameters {
+		declared[aws.StringValue(parameter.ParameterKey)] = true
+	}
+
+	if declared["AvailabilityZones"] {
+		values["AvailabilityZones"] = strings.Join(definition.AvailabilityZones, ",")
+	} else if len(definition.AvailabilityZones) > 1 {
+		return errors.New("Stack template only supports a single availability zone")
+	} else {
+		values["AvailabilityZone"] = definition.AvailabilityZones[0]
+	}
+
+	if network != nil {
+		if !declared["VPCID"] {
+			return errors.New("Stack template doesn't support existing VPCs")
+		}
+		values["VPCID"] = network.VpcId
+
+		if declared["SubnetIds"] {
+			values["SubnetIds"] = strings.Join(network.SubnetIds, ",")
+		} else if declared["ClusterSubnetId"] {
+			values["ClusterSubnetId"] = network.SubnetIds[0]
+			values["LoadBalancerSubnetId"] = network.SubnetIds[len(network.SubnetIds)-1]
+		} else {
+			return errors.New("Stack template doesn't take the subnets of existing VPCs")
+		}
+	}
+
+	input.Parameters = []*cloudformation.Parameter{}
+	for key, value := range values {
+		if !declared[key] {
+			continue
+		}
+
+		input.Parameters = append(input.Parameters, &cloudformation.Parameter{
+			ParameterKey:   aws.String(key),
+			ParameterValue: aws.String(value),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package awsk8s
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperpilotio/deployer/apis"
+	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
+	logging "github.com/op/go-logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The stack node autoscaling group only runs a single instance type, nodes of other instance
+// types are launched as standalone workers and tagged with their node id
+const nodeIdTagKey = "NodeId"
+
+// stackInstanceType return the instance type of the stack node autoscaling group
+func stackInstanceType(clusterDefinition apis.ClusterDefinition) string {
+	return clusterDefinition.Nodes[0].InstanceType
+}
+
+// splitNodesByInstanceType splits the nodes run by the stack node autoscaling group from the
+// nodes that have to be launched as standalone workers
+func splitNodesByInstanceType(nodes []apis.ClusterNode, instanceType string) ([]apis.ClusterNode, []apis.ClusterNode) {
+	groupNodes := []apis.ClusterNode{}
+	workerNodes := []apis.ClusterNode{}
+	for _, node := range nodes {
+		if node.InstanceType == instanceType {
+			groupNodes = append(groupNodes, node)
+		} else {
+			workerNodes = append(workerNodes, node)
+		}
+	}
+
+	return groupNodes, workerNodes
+}
+
+// findNodeAutoScalingGroup return the kubernetes node autoscaling group created by the stack
+func findNodeAutoScalingGroup(autoscalingSvc *autoscaling.AutoScaling, stackName string) (*autoscaling.Group, error) {
+	var nodeGroup *autoscaling.Group
+	err := autoscalingSvc.DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{},
+		func(output *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+			for _, group := range output.AutoScalingGroups {
+				for _, tag := range group.Tags {
+					if aws.StringValue(tag.Key) == "KubernetesCluster" && aws.StringValue(tag.Value) == stackName {
+						nodeGroup = group
+						return false
+					}
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, errors.New("Unable to describe auto scaling groups: " + err.Error())
+	}
+
+	if nodeGroup == nil {
+		return nil, errors.New("Unable to find auto scaling group for stack: " + stackName)
+	}
+
+	return nodeGroup, nil
+}
+
+// launchWorkerInstances launches kubernetes workers outside of the stack node autoscaling group,
+// using the launch configuration of the group so they join the cluster the same way
+func launchWorkerInstances(
+	ec2Svc *ec2.EC2,
+	autoscalingSvc *autoscaling.AutoScaling,
+	awsCluster *hpaws.AWSCluster,
+	deploymentName string,
+	nodes []apis.ClusterNode,
+	log *logging.Logger) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	stackName := awsCluster.StackName()
+	nodeGroup, err := findNodeAutoScalingGroup(autoscalingSvc, stackName)
+	if err != nil {
+		return err
+	}
+
+	output, err := autoscalingSvc.DescribeLaunchConfigurations(&autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{nodeGroup.LaunchConfigurationName},
+	})
+	if err != nil {
+		return errors.New("Unable to describe launch configuration: " + err.Error())
+	}
+
+	if len(output.LaunchConfigurations) == 0 {
+		return errors.New("No launch configurations found for auto scaling group: " +
+			aws.StringValue(nodeGroup.AutoScalingGroupName))
+	}
+	launchConfig := output.LaunchConfigurations[0]
+
+	subnetIds := strings.Split(aws.StringValue(nodeGroup.VPCZoneIdentifier), ",")
+	if subnetIds[0] == "" {
+		return errors.New("Unable to find subnet of auto scaling group")
+	}
+
+	blockDeviceMappings := []*ec2.BlockDeviceMapping{}
+	for _, mapping := range launchConfig.BlockDeviceMappings {
+		if mapping.Ebs == nil {
+			continue
+		}
+
+		blockDeviceMappings = append(blockDeviceMappings, &ec2.BlockDeviceMapping{
+			DeviceName: mapping.DeviceName,
+			Ebs: &ec2.EbsBlockDevice{
+				VolumeSize:          mapping.Ebs.VolumeSize,
+				VolumeType:          mapping.Ebs.VolumeType,
+				DeleteOnTermination: aws.Bool(true),
+			},
+		})
+	}
+
+	instanceIds := []*string{}
+	for _, node := range nodes {
+		// Instances are tagged at launch, so instances that fail to start later are still
+		// found and terminated with the stack
+		tags := []*ec2.Tag{
+			{
+				Key:   aws.String("Name"),
+				Value: aws.String("k8s-node"),
+			},
+			{
+				Key:   aws.String("KubernetesCluster"),
+				Value: aws.String(stackName),
+			},
+			{
+				Key:   aws.String("deployment"),
+				Value: aws.String(deploymentName),
+			},
+			{
+				Key:   aws.String(nodeIdTagKey),
+				Value: aws.String(strconv.Itoa(node.Id)),
+			},
+		}
+
+		runResult, err := ec2Svc.RunInstances(&ec2.RunInstancesInput{
+			KeyName:             launchConfig.KeyName,
+			ImageId:             launchConfig.ImageId,
+			BlockDeviceMappings: blockDeviceMappings,
+			IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
+				Name: launchConfig.IamInstanceProfile,
+			},
+			UserData: launchConfig.UserData,
+			NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{
+				&ec2.InstanceNetworkInterfaceSpecification{
+					DeviceIndex:              aws.Int64(0),
+					DeleteOnTermination:      aws.Bool(true),
+					AssociatePublicIpAddress: launchConfig.AssociatePublicIpAddress,
+					Groups:                   launchConfig.SecurityGroups,
+					SubnetId:                 aws.String(subnetIds[0]),
+				},
+			},
+			TagSpecifications: []*ec2.TagSpecification{
+				&ec2.TagSpecification{
+					ResourceType: aws.String(ec2.ResourceTypeInstance),
+					Tags:         tags,
+				},
+			},
+			InstanceType: aws.String(node.InstanceType),
+			MinCount:     aws.Int64(1),
+			MaxCount:     aws.Int64(1),
+		})
+		if err != nil {
+			return errors.New("Unable to run ec2 instance '" + strconv.Itoa(node.Id) + "': " + err.Error())
+		}
+
+		instance := runResult.Instances[0]
+		log.Infof("Launched %s worker instance %s for node %d",
+			node.InstanceType, aws.StringValue(instance.InstanceId), node.Id)
+
+		awsCluster.NodeInfos[node.Id] = &hpaws.NodeInfo{
+			Instance:  instance,
+			PrivateIp: aws.StringValue(instance.PrivateIpAddress),
+		}
+		instanceIds = append(instanceIds, instance.InstanceId)
+	}
+
+	log.Infof("Waiting for %d worker instances to be status ok", len(instanceIds))
+	if err := ec2Svc.WaitUntilInstanceStatusOk(&ec2.DescribeInstanceStatusInput{
+		InstanceIds: instanceIds,
+	}); err != nil {
+		return errors.New("Unable to wait for ec2 instances be status ok: " + err.Error())
+	}
+
+	return nil
+}
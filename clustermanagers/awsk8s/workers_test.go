@@ -0,0 +1,48 @@
+package awsk8s
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hyperpilotio/deployer/apis"
+)
+
+func TestSplitNodesByInstanceType(t *testing.T) {
+	nodes := []apis.ClusterNode{
+		{Id: 1, InstanceType: "t2.medium"},
+		{Id: 2, InstanceType: "m4.large"},
+		{Id: 3, InstanceType: "t2.medium"},
+		{Id: 4, InstanceType: "c4.xlarge"},
+	}
+
+	tests := []struct {
+		name         string
+		nodes        []apis.ClusterNode
+		instanceType string
+		groupIds     []int
+		workerIds    []int
+	}{
+		{"mixed instance types", nodes, "t2.medium", []int{1, 3}, []int{2, 4}},
+		{"single node of the group type", nodes, "m4.large", []int{2}, []int{1, 3, 4}},
+		{"no node of the group type", nodes, "r4.large", []int{}, []int{1, 2, 3, 4}},
+		{"no nodes", []apis.ClusterNode{}, "t2.medium", []int{}, []int{}},
+	}
+
+	nodeIds := func(nodes []apis.ClusterNode) []int {
+		ids := []int{}
+		for _, node := range nodes {
+			ids = append(ids, node.Id)
+		}
+		return ids
+	}
+
+	for _, test := range tests {
+		groupNodes, workerNodes := splitNodesByInstanceType(test.nodes, test.instanceType)
+		if !reflect.DeepEqual(nodeIds(groupNodes), test.groupIds) {
+			t.Errorf("%s: expected group nodes %v, got %v", test.name, test.groupIds, nodeIds(groupNodes))
+		}
+		if !reflect.DeepEqual(nodeIds(workerNodes), test.workerIds) {
+			t.Errorf("%s: expected worker nodes %v, got %v", test.name, test.workerIds, nodeIds(workerNodes))
+		}
+	}
+}
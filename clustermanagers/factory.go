@@ -7,9 +7,11 @@ import (
 
 	"github.com/hyperpilotio/deployer/apis"
 	"github.com/hyperpilotio/deployer/clustermanagers/awsecs"
+	"github.com/hyperpilotio/deployer/clustermanagers/awseks"
 	"github.com/hyperpilotio/deployer/clustermanagers/awsk8s"
 	"github.com/hyperpilotio/deployer/clustermanagers/gcpgke"
 	"github.com/hyperpilotio/deployer/clusters"
+	"github.com/hyperpilotio/deployer/common"
 	"github.com/hyperpilotio/deployer/job"
 	"github.com/hyperpilotio/go-utils/log"
 	"github.com/pborman/uuid"
@@ -22,6 +24,9 @@ type Deployer interface {
 	UpdateDeployment(updateDeployment *apis.Deployment) error
 	DeployExtensions(extensions *apis.Deployment, mergedDeployment *apis.Deployment) error
 	DeleteDeployment() error
+	ScaleTask(taskName string, replicas int) error
+	AddNodes(nodes []apis.ClusterNode, uploadedFiles map[string]string) error
+	RemoveNodes(nodeIds []int) error
 	ReloadClusterState(storeInfo interface{}) error
 	GetStoreInfo() interface{}
 	NewStoreInfo() interface{}
@@ -34,6 +39,10 @@ type Deployer interface {
 	GetServiceAddress(serviceName string) (*apis.ServiceAddress, error)
 	GetServiceMappings() (map[string]interface{}, error)
 	GetKubeConfigPath() (string, error)
+	GetVPCPeering() (*apis.VPCPeeringStatus, error)
+	GetNodeSshClients() (map[int]common.SshClient, error)
+	CollectDiagnostics(diagnostics *common.Diagnostics) error
+	RotateSshKey(storeKey func() error) error
 }
 
 func NewDeployer(
@@ -46,7 +55,14 @@ func NewDeployer(
 		deployment.Name = CreateUniqueDeploymentName(deployment.Name)
 	}
 
+	// Ingress public urls are only recorded by the aws kubernetes deployer
+	usesIngress := deployment.KubernetesDeployment != nil && deployment.KubernetesDeployment.Ingress != nil
+
 	if config.GetBool("inCluster") {
+		if usesIngress {
+			return nil, errors.New("Ingress is not supported by in cluster deployments")
+		}
+
 		switch deployType {
 		case "K8S":
 			return awsk8s.NewInClusterDeployer(config, deployment)
@@ -56,6 +72,9 @@ func NewDeployer(
 	}
 
 	if config.GetBool("hyperpilot-shared-gcp.use") {
+		if usesIngress {
+			return nil, errors.New("Ingress is not supported by shared gcp deployments")
+		}
 		return gcpgke.NewSharedDeployer(config, deployment)
 	}
 
@@ -65,6 +84,8 @@ func NewDeployer(
 		return awsecs.NewDeployer(config, cluster, deployment)
 	case "K8S":
 		return awsk8s.NewDeployer(config, cluster, deployment)
+	case "EKS":
+		return awseks.NewDeployer(config, cluster, deployment)
 	case "GCP":
 		return gcpgke.NewDeployer(config, cluster, deployment)
 	default:
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -20,8 +21,10 @@ import (
 	k8sUtil "github.com/hyperpilotio/deployer/clustermanagers/kubernetes"
 	"github.com/hyperpilotio/deployer/clusters"
 	hpgcp "github.com/hyperpilotio/deployer/clusters/gcp"
+	"github.com/hyperpilotio/deployer/clusters/hostkeys"
 	"github.com/hyperpilotio/deployer/common"
 	"github.com/hyperpilotio/deployer/job"
+	"github.com/hyperpilotio/go-utils/funcs"
 	"github.com/hyperpilotio/go-utils/log"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -95,6 +98,7 @@ func NewSharedDeployer(config *viper.Viper, deployment *apis.Deployment) (*GCPDe
 				Pub:     config.GetString("hyperpilot-shared-gcp.publicKey"),
 			},
 			NodeInfos: make(map[int]*hpgcp.NodeInfo),
+			HostKeys:  hostkeys.New(),
 		},
 		Deployment:    deployment,
 		DeploymentLog: log,
@@ -188,6 +192,17 @@ func (deployer *GCPDeployer) DeployExtensions(
 	return nil
 }
 
+// ScaleTask updates the replicas of a running task
+func (deployer *GCPDeployer) ScaleTask(taskName string, replicas int) error {
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes: " + err.Error())
+	}
+
+	return k8sUtil.ScaleTask(deployer.Config, k8sClient, deployer.Deployment, "", taskName,
+		int32(replicas), deployer.Services, deployer.DeploymentLog.Logger)
+}
+
 // DeleteDeployment clean up the cluster from kubenetes.
 func (deployer *GCPDeployer) DeleteDeployment() error {
 	if err := deployer.deleteDeployment(); err != nil {
@@ -236,6 +251,12 @@ func (deployer *GCPDeployer) deleteDeployment() error {
 		log.Warningf("Unable to delete loadBalancing: " + err.Error())
 	}
 
+	if deployer.VpcPeering != nil {
+		if err := deployer.deleteNetworkPeering(client, log); err != nil {
+			log.Warningf("Unable to delete network peering: " + err.Error())
+		}
+	}
+
 	return nil
 }
 
@@ -258,9 +279,8 @@ func deployCluster(deployer *GCPDeployer, uploadedFiles map[string]string) error
 		return errors.New("Unable to set GCP deployer kubeconfig: " + err.Error())
 	}
 
-	nodePoolName := []string{"default-pool"}
 	if err := populateNodeInfos(client, gcpProfile.ProjectId, gcpCluster.Zone, gcpCluster.ClusterId,
-		nodePoolName, gcpCluster, deployment.ClusterDefinition, log); err != nil {
+		gcpCluster.NodePoolIds, gcpCluster, deployment.ClusterDefinition, log); err != nil {
 		deleteDeploymentOnFailure(deployer)
 		return errors.New("Unable to populate node infos: " + err.Error())
 	}
@@ -281,7 +301,9 @@ func deployCluster(deployer *GCPDeployer, uploadedFiles map[string]string) error
 	}
 	log.Infof("Downloaded kube config at %s", deployer.KubeConfigPath)
 
-	if err := deployer.uploadFiles(uploadedFiles); err != nil {
+	plainFiles, templateFiles := common.SplitTemplateFiles(deployment)
+	gcpCluster.PinSerialHostKeys(gcpCluster.NodeInfos)
+	if err := deployer.uploadDeploymentFiles(gcpCluster.NodeInfos, plainFiles, uploadedFiles); err != nil {
 		deleteDeploymentOnFailure(deployer)
 		return errors.New("Unable to upload files to cluster: " + err.Error())
 	}
@@ -305,6 +327,14 @@ func deployCluster(deployer *GCPDeployer, uploadedFiles map[string]string) error
 		return errors.New("Unable to tag Kubernetes nodes: " + err.Error())
 	}
 
+	// Services may reach the peered network as soon as they start
+	if deployment.VPCPeering != nil {
+		if err := deployer.setupNetworkPeering(client); err != nil {
+			deleteDeploymentOnFailure(deployer)
+			return errors.New("Unable to peer network: " + err.Error())
+		}
+	}
+
 	userName := strings.ToLower(gcpCluster.GCPProfile.ServiceAccount)
 	serviceMappings, err := k8sUtil.DeployKubernetesObjects(deployer.Config, k8sClient, deployment, userName, log)
 	if err != nil {
@@ -313,10 +343,16 @@ func deployCluster(deployer *GCPDeployer, uploadedFiles map[string]string) error
 	}
 	deployer.Services = serviceMappings
 
+	if err := deployer.uploadDeploymentFiles(gcpCluster.NodeInfos, templateFiles, uploadedFiles); err != nil {
+		deleteDeploymentOnFailure(deployer)
+		return errors.New("Unable to upload template files to cluster: " + err.Error())
+	}
+
 	if err := insertFirewallIngressRules(client, gcpCluster, deployment, log); err != nil {
 		deleteDeploymentOnFailure(deployer)
 		return errors.New("Unable to insert firewall ingress rules: " + err.Error())
 	}
+
 	deployer.recordEndpoints(false)
 
 	return nil
@@ -333,58 +369,38 @@ func deployKubernetes(
 		return errors.New("Unable to create google cloud platform container service: " + err.Error())
 	}
 
-	initialNodeCount := 3
-	deployNodeCount := len(deployment.ClusterDefinition.Nodes)
-	if deployNodeCount > initialNodeCount {
-		initialNodeCount = deployNodeCount
+	// Every distinct instance type and labels of the cluster nodes runs in its own node pool
+	specs := buildNodePoolSpecs(deployment.ClusterDefinition.Nodes, []string{})
+	nodePools := []*container.NodePool{}
+	nodePoolIds := []string{}
+	for i, spec := range specs {
+		nodeCount := len(spec.Nodes)
+		if i == 0 {
+			nodeCount += minClusterNodeCount - len(deployment.ClusterDefinition.Nodes)
+			if nodeCount < len(spec.Nodes) {
+				nodeCount = len(spec.Nodes)
+			}
+		}
+		nodePools = append(nodePools, newNodePool(gcpCluster, spec, nodeCount))
+		nodePoolIds = append(nodePoolIds, spec.Name)
 	}
 
+	network, subnetwork := deploymentNetwork(deployment)
 	createClusterRequest := &container.CreateClusterRequest{
 		Cluster: &container.Cluster{
 			Name:              gcpCluster.ClusterId,
 			Zone:              gcpCluster.Zone,
-			Network:           "default",
+			Network:           network,
 			LoggingService:    "logging.googleapis.com",
 			MonitoringService: "monitoring.googleapis.com",
-			NodePools: []*container.NodePool{
-				&container.NodePool{
-					Name:             "default-pool",
-					InitialNodeCount: int64(initialNodeCount),
-					Config: &container.NodeConfig{
-						MachineType: deployment.ClusterDefinition.Nodes[0].InstanceType,
-						ImageType:   "COS",
-						DiskSizeGb:  int64(60),
-						Preemptible: false,
-						OauthScopes: []string{
-							"https://www.googleapis.com/auth/compute",
-							"https://www.googleapis.com/auth/devstorage.read_only",
-							"https://www.googleapis.com/auth/logging.write",
-							"https://www.googleapis.com/auth/monitoring.write",
-							"https://www.googleapis.com/auth/servicecontrol",
-							"https://www.googleapis.com/auth/service.management.readonly",
-							"https://www.googleapis.com/auth/trace.append",
-						},
-						Tags: []string{
-							fmt.Sprintf("gke-%s-http-server", gcpCluster.ClusterId),
-						},
-					},
-					Autoscaling: &container.NodePoolAutoscaling{
-						Enabled: false,
-					},
-					Management: &container.NodeManagement{
-						AutoUpgrade:    false,
-						AutoRepair:     false,
-						UpgradeOptions: &container.AutoUpgradeOptions{},
-					},
-				},
-			},
+			NodePools:         nodePools,
 			MasterAuth: &container.MasterAuth{
 				Username: "admin",
 				ClientCertificateConfig: &container.ClientCertificateConfig{
 					IssueClientCertificate: true,
 				},
 			},
-			Subnetwork: "default",
+			Subnetwork: subnetwork,
 			LegacyAbac: &container.LegacyAbac{
 				Enabled: true,
 			},
@@ -410,6 +426,7 @@ func deployKubernetes(
 		gcpCluster.ClusterId, time.Duration(10)*time.Minute, log); err != nil {
 		return fmt.Errorf("Unable to wait until cluster complete: %s\n", err.Error())
 	}
+	gcpCluster.NodePoolIds = nodePoolIds
 	log.Info("Kuberenete cluster completed")
 
 	return nil
@@ -426,6 +443,7 @@ func deleteDeploymentOnFailure(deployer *GCPDeployer) {
 }
 
 func (deployer *GCPDeployer) recordEndpoints(reset bool) {
+	log := deployer.DeploymentLog.Logger
 	if reset {
 		deployer.Services = map[string]k8sUtil.ServiceMapping{}
 	}
@@ -436,13 +454,21 @@ func (deployer *GCPDeployer) recordEndpoints(reset bool) {
 		}
 		ports := task.GetPorts()
 		for i, portType := range task.PortTypes {
+			if _, explicit := task.GetPortExposure(i); explicit {
+				continue
+			}
+
 			hostPort := ports[i].HostPort
 			taskFamilyName := task.Family
 			for _, nodeMapping := range deployment.NodeMapping {
 				if nodeMapping.Task == taskFamilyName {
 					nodeInfo, ok := deployer.GCPCluster.NodeInfos[nodeMapping.Id]
 					if ok {
-						serviceName := nodeInfo.Instance.NetworkInterfaces[0].AccessConfigs[0].NatIP
+						serviceName := instanceNatIP(nodeInfo.Instance)
+						if serviceName == "" {
+							log.Warningf("Skip recording service %s, node %d has no external ip", taskFamilyName, nodeMapping.Id)
+							continue
+						}
 						servicePort := strconv.FormatInt(int64(hostPort), 10)
 						serviceMapping := k8sUtil.ServiceMapping{}
 						url := serviceName + ":" + servicePort
@@ -465,11 +491,130 @@ func (deployer *GCPDeployer) recordEndpoints(reset bool) {
 
 		}
 	}
+
+	deployer.recordServiceEndpoints()
 }
 
-func (deployer *GCPDeployer) uploadFiles(uploadedFiles map[string]string) error {
-	gcpCluster := deployer.GCPCluster
+// recordServiceEndpoints records the public urls of ports exposed through NodePort or LoadBalancer services
+func (deployer *GCPDeployer) recordServiceEndpoints() {
+	log := deployer.DeploymentLog.Logger
 	deployment := deployer.Deployment
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		log.Warningf("Unable to connect to kubernetes to record service endpoints: %s", err.Error())
+		return
+	}
+
+	tasks := map[string]apis.KubernetesTask{}
+	for _, task := range deployment.KubernetesDeployment.Kubernetes {
+		tasks[task.Family] = task
+	}
+
+	// Services are named the same way as k8sUtil.DeployServices names them from the sorted node mapping
+	sort.Sort(deployment.NodeMapping)
+	taskCount := map[string]int{}
+	for _, mapping := range deployment.NodeMapping {
+		task, ok := tasks[mapping.Task]
+		if !ok || task.Deployment == nil {
+			continue
+		}
+
+		serviceName := task.Family
+		taskCount[task.Family] += 1
+		if taskCount[task.Family] > 1 {
+			serviceName = task.Family + "-" + strconv.Itoa(taskCount[task.Family])
+		}
+		namespace := k8sUtil.GetNamespace(task.Deployment.ObjectMeta)
+
+		for i, exposure := range task.Exposures {
+			if !exposure.IsPublic() {
+				continue
+			}
+
+			publicUrl, err := deployer.getPublicServiceUrl(k8sClient, namespace, serviceName, i, exposure, mapping.Id)
+			if err != nil {
+				log.Warningf("Unable to get public url of service %s: %s", serviceName, err.Error())
+				continue
+			}
+
+			serviceMapping := deployer.Services[serviceName]
+			serviceMapping.NodeId = mapping.Id
+			if nodeInfo, ok := deployer.GCPCluster.NodeInfos[mapping.Id]; ok {
+				serviceMapping.NodeName = nodeInfo.Instance.Name
+			}
+			serviceMapping.PublicUrl = publicUrl
+			deployer.Services[serviceName] = serviceMapping
+		}
+	}
+}
+
+func (deployer *GCPDeployer) getPublicServiceUrl(
+	k8sClient *k8s.Clientset,
+	namespace string,
+	serviceName string,
+	portIndex int,
+	exposure apis.PortExposure,
+	nodeId int) (string, error) {
+	services := k8sClient.CoreV1().Services(namespace)
+	portName := "port" + strconv.Itoa(portIndex)
+
+	if exposure.Type == apis.ExposureNodePort {
+		// Only fixed node ports are opened in the firewall of the nodes
+		if exposure.NodePort == 0 {
+			return "", errors.New("Node port is not fixed, so it is not opened in the firewall")
+		}
+
+		service, err := services.Get(serviceName+"-nodeport"+portName, metav1.GetOptions{})
+		if err != nil {
+			return "", errors.New("Unable to get node port service: " + err.Error())
+		}
+
+		nodeInfo, ok := deployer.GCPCluster.NodeInfos[nodeId]
+		if !ok {
+			return "", fmt.Errorf("Unable to find node %d", nodeId)
+		}
+
+		natIP := instanceNatIP(nodeInfo.Instance)
+		if natIP == "" {
+			return "", fmt.Errorf("Node %d has no external ip", nodeId)
+		}
+		return natIP + ":" + strconv.FormatInt(int64(service.Spec.Ports[0].NodePort), 10), nil
+	}
+
+	publicUrl := ""
+	err := funcs.LoopUntil(time.Minute*5, time.Second*10, func() (bool, error) {
+		service, err := services.Get(serviceName+"-public"+portName, metav1.GetOptions{})
+		if err != nil {
+			return false, errors.New("Unable to get load balancer service: " + err.Error())
+		}
+
+		if len(service.Status.LoadBalancer.Ingress) == 0 {
+			return false, nil
+		}
+
+		port := strconv.FormatInt(int64(service.Spec.Ports[0].Port), 10)
+		publicUrl = service.Status.LoadBalancer.Ingress[0].IP + ":" + port
+		return true, nil
+	})
+	if err != nil {
+		return "", errors.New("Unable to wait for load balancer to be ready: " + err.Error())
+	}
+
+	return publicUrl, nil
+}
+
+func (deployer *GCPDeployer) uploadFilesToNodes(nodeInfos map[int]*hpgcp.NodeInfo, uploadedFiles map[string]string) error {
+	deployer.GCPCluster.PinSerialHostKeys(nodeInfos)
+	return deployer.uploadDeploymentFiles(nodeInfos, deployer.Deployment, uploadedFiles)
+}
+
+// uploadDeploymentFiles uploads the files of the deployment to the nodes, rendering template
+// files with the service mappings deployed so far
+func (deployer *GCPDeployer) uploadDeploymentFiles(
+	nodeInfos map[int]*hpgcp.NodeInfo,
+	deployment *apis.Deployment,
+	uploadedFiles map[string]string) error {
+	gcpCluster := deployer.GCPCluster
 	log := deployer.GetLog().Logger
 	if len(deployment.Files) == 0 {
 		return nil
@@ -481,7 +626,10 @@ func (deployer *GCPDeployer) uploadFiles(uploadedFiles map[string]string) error
 		return errors.New("Unable to create ssh config: " + clientConfigErr.Error())
 	}
 
-	newDeployment := &apis.Deployment{UserId: deployment.UserId}
+	newDeployment := &apis.Deployment{
+		UserId:            deployment.UserId,
+		ClusterDefinition: deployment.ClusterDefinition,
+	}
 	for _, file := range deployment.Files {
 		if strings.HasPrefix(file.Path, "~/") {
 			file.Path = strings.Replace(file.Path, "~/", "/home/"+userName+"/", 1)
@@ -489,17 +637,83 @@ func (deployer *GCPDeployer) uploadFiles(uploadedFiles map[string]string) error
 		newDeployment.Files = append(newDeployment.Files, file)
 	}
 
-	for _, nodeInfo := range gcpCluster.NodeInfos {
-		sshClient := common.NewSshClient(nodeInfo.PublicIp+":22", clientConfig, "")
-		if err := common.UploadFiles(deployer.Config, sshClient, newDeployment, uploadedFiles, log); err != nil {
-			return fmt.Errorf("Unable to upload file to node %s: %s", nodeInfo.PublicIp, err.Error())
+	services := map[string]interface{}{}
+	if common.HasTemplateFiles(deployment) {
+		if serviceMappings, err := deployer.GetServiceMappings(); err != nil {
+			log.Warningf("Unable to get service mappings for template files: %s", err.Error())
+		} else {
+			services = serviceMappings
 		}
 	}
 
+	nodes := []common.NodeUpload{}
+	for nodeId, nodeInfo := range nodeInfos {
+		nodes = append(nodes, common.NodeUpload{
+			Client: common.NewSshClient(nodeInfo.PublicIp+":22", clientConfig, ""),
+			Variables: &common.FileVariables{
+				DeploymentName: deployment.Name,
+				Region:         deployment.Region,
+				NodeId:         nodeId,
+				PrivateIp:      nodeInfo.PrivateIp,
+				PublicIp:       nodeInfo.PublicIp,
+				Services:       services,
+			},
+		})
+	}
+	if err := common.UploadFilesToNodes(deployer.Config, nodes, newDeployment, uploadedFiles,
+		gcpCluster.GCPProfile, log); err != nil {
+		return errors.New("Unable to upload all files: " + err.Error())
+	}
+
 	log.Info("Uploaded all files")
 	return nil
 }
 
+// GetNodeSshClients return ssh clients of the cluster nodes
+func (deployer *GCPDeployer) GetNodeSshClients() (map[int]common.SshClient, error) {
+	gcpCluster := deployer.GCPCluster
+	clientConfig, err := gcpCluster.SshConfig(strings.ToLower(gcpCluster.GCPProfile.ServiceAccount))
+	if err != nil {
+		return nil, errors.New("Unable to create ssh config: " + err.Error())
+	}
+
+	sshClients := map[int]common.SshClient{}
+	for nodeId, nodeInfo := range gcpCluster.NodeInfos {
+		sshClients[nodeId] = common.NewSshClient(nodeInfo.PublicIp+":22", clientConfig, "")
+	}
+
+	return sshClients, nil
+}
+
+// CollectDiagnostics adds the logs of the cluster nodes, and the pods, events and node
+// conditions of the cluster to the bundle
+func (deployer *GCPDeployer) CollectDiagnostics(diagnostics *common.Diagnostics) error {
+	if sshClients, err := deployer.GetNodeSshClients(); err != nil {
+		diagnostics.AddError("nodes", err)
+	} else {
+		common.CollectNodeLogs(diagnostics, sshClients, common.KubernetesNodeLogCommands,
+			common.SshConcurrency(deployer.Config))
+	}
+
+	if deployer.KubeConfig == nil {
+		diagnostics.AddError("kubernetes", errors.New("Kubernetes config of the cluster is unknown"))
+		return nil
+	}
+
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		diagnostics.AddError("kubernetes", errors.New("Unable to connect to Kubernetes: "+err.Error()))
+		return nil
+	}
+
+	return k8sUtil.CollectDiagnostics(k8sClient, "", diagnostics)
+}
+
+// RotateSshKey isn't supported, the key pair is shared by the deployments of the service account
+func (deployer *GCPDeployer) RotateSshKey(storeKey func() error) error {
+	return errors.New("Unsupported ssh key rotation of GCP deployments, the ssh key is shared by all of them")
+}
+
 func (deployer *GCPDeployer) DownloadKubeConfig() error {
 	gcpCluster := deployer.GCPCluster
 	projectId := gcpCluster.GCPProfile.ProjectId
@@ -648,6 +862,7 @@ func (deployer *GCPDeployer) ReloadClusterState(storeInfo interface{}) error {
 	gcpProfile := gcpCluster.GCPProfile
 	gcpCluster.ClusterId = gcpStoreInfo.ClusterId
 	gcpCluster.Name = gcpStoreInfo.ClusterId
+	deployer.VpcPeering = gcpStoreInfo.VpcPeering
 	deployer.Deployment.Name = gcpCluster.ClusterId
 	deploymentName := gcpCluster.ClusterId
 
@@ -798,8 +1013,8 @@ func (deployer *GCPDeployer) GetServiceUrl(serviceName string) (string, error) {
 				for _, nodeMapping := range deployer.Deployment.NodeMapping {
 					if nodeMapping.Task == serviceName {
 						nodeInfo, ok := deployer.GCPCluster.NodeInfos[nodeMapping.Id]
-						if ok {
-							serviceName := nodeInfo.Instance.NetworkInterfaces[0].AccessConfigs[0].NatIP
+						if ok && instanceNatIP(nodeInfo.Instance) != "" {
+							serviceName := instanceNatIP(nodeInfo.Instance)
 							servicePort := strconv.FormatInt(int64(hostPort), 10)
 							return serviceName + ":" + servicePort, nil
 						}
@@ -814,7 +1029,8 @@ func (deployer *GCPDeployer) GetServiceUrl(serviceName string) (string, error) {
 
 func (deployer *GCPDeployer) GetStoreInfo() interface{} {
 	return &StoreInfo{
-		ClusterId: deployer.GCPCluster.ClusterId,
+		ClusterId:  deployer.GCPCluster.ClusterId,
+		VpcPeering: deployer.VpcPeering,
 	}
 }
 
@@ -21,10 +21,12 @@ type GCPDeployer struct {
 	KubeConfigPath string
 	KubeConfig     *rest.Config
 	Services       map[string]kubernetes.ServiceMapping
+	VpcPeering     *apis.VPCPeeringStatus
 }
 
 type StoreInfo struct {
-	ClusterId string
+	ClusterId  string
+	VpcPeering *apis.VPCPeeringStatus
 }
 
 type CreateDeploymentResponse struct {
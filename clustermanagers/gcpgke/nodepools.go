@@ -0,0 +1,271 @@
+package gcpgke
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperpilotio/deployer/apis"
+	hpgcp "github.com/hyperpilotio/deployer/clusters/gcp"
+	"github.com/hyperpilotio/go-utils/funcs"
+
+	logging "github.com/op/go-logging"
+	compute "google.golang.org/api/compute/v1"
+	container "google.golang.org/api/container/v1"
+)
+
+// GKE keeps system pods running on the cluster, so the first node pool never has less nodes
+const minClusterNodeCount = 3
+
+var invalidNodePoolIdChars = regexp.MustCompile("[^a-z0-9-]")
+
+// buildNodePoolSpecs groups the nodes into node pools, new node pool ids never collide with the
+// given existing ones
+func buildNodePoolSpecs(nodes []apis.ClusterNode, existingNodePoolIds []string) []*apis.NodeGroup {
+	return apis.GroupNodes(nodes, existingNodePoolIds, newNodePoolId)
+}
+
+// newNodePoolId return a node pool id named after the instance type, node pool ids can only
+// have lowercase letters, numbers and hyphens
+func newNodePoolId(instanceType string, usedIds map[string]bool) string {
+	prefix := invalidNodePoolIdChars.ReplaceAllString(strings.ToLower(instanceType), "-")
+	if len(prefix) > 30 {
+		prefix = prefix[:30]
+	}
+
+	nodePoolId := prefix + "-pool"
+	for i := 2; usedIds[nodePoolId]; i++ {
+		nodePoolId = prefix + "-pool-" + strconv.Itoa(i)
+	}
+
+	return nodePoolId
+}
+
+// nodePoolMatches return true when the node has the machine type and labels of the node pool
+func nodePoolMatches(nodePool *container.NodePool, node apis.ClusterNode) bool {
+	if nodePool.Config == nil {
+		return false
+	}
+
+	return node.Matches(nodePool.Config.MachineType, nodePool.Config.Labels)
+}
+
+// newNodePool return the node pool definition of the spec with the given number of nodes
+func newNodePool(gcpCluster *hpgcp.GCPCluster, spec *apis.NodeGroup, nodeCount int) *container.NodePool {
+	return &container.NodePool{
+		Name:             spec.Name,
+		InitialNodeCount: int64(nodeCount),
+		Config: &container.NodeConfig{
+			MachineType: spec.InstanceType,
+			ImageType:   "COS",
+			DiskSizeGb:  int64(60),
+			Preemptible: false,
+			Labels:      spec.Labels,
+			OauthScopes: []string{
+				"https://www.googleapis.com/auth/compute",
+				"https://www.googleapis.com/auth/devstorage.read_only",
+				"https://www.googleapis.com/auth/logging.write",
+				"https://www.googleapis.com/auth/monitoring.write",
+				"https://www.googleapis.com/auth/servicecontrol",
+				"https://www.googleapis.com/auth/service.management.readonly",
+				"https://www.googleapis.com/auth/trace.append",
+			},
+			Tags: []string{
+				fmt.Sprintf("gke-%s-http-server", gcpCluster.ClusterId),
+			},
+		},
+		Autoscaling: &container.NodePoolAutoscaling{
+			Enabled: false,
+		},
+		Management: &container.NodeManagement{
+			AutoUpgrade:    false,
+			AutoRepair:     false,
+			UpgradeOptions: &container.AutoUpgradeOptions{},
+		},
+	}
+}
+
+// listNodePools return the node pools of the cluster, and refreshes the node pool ids of the cluster
+func listNodePools(containerSvc *container.Service, gcpCluster *hpgcp.GCPCluster) ([]*container.NodePool, error) {
+	resp, err := containerSvc.Projects.Zones.Clusters.NodePools.
+		List(gcpCluster.GCPProfile.ProjectId, gcpCluster.Zone, gcpCluster.ClusterId).
+		Do()
+	if err != nil {
+		return nil, errors.New("Unable to list node pools: " + err.Error())
+	}
+
+	gcpCluster.NodePoolIds = []string{}
+	for _, nodePool := range resp.NodePools {
+		gcpCluster.NodePoolIds = append(gcpCluster.NodePoolIds, nodePool.Name)
+	}
+
+	return resp.NodePools, nil
+}
+
+// createNodePool adds a node pool to the running cluster and waits until it's running
+func createNodePool(
+	containerSvc *container.Service,
+	gcpCluster *hpgcp.GCPCluster,
+	spec *apis.NodeGroup,
+	log *logging.Logger) error {
+	projectId := gcpCluster.GCPProfile.ProjectId
+	log.Infof("Creating node pool %s with %d %s nodes...", spec.Name, len(spec.Nodes), spec.InstanceType)
+	_, err := containerSvc.Projects.Zones.Clusters.NodePools.
+		Create(projectId, gcpCluster.Zone, gcpCluster.ClusterId, &container.CreateNodePoolRequest{
+			NodePool: newNodePool(gcpCluster, spec, len(spec.Nodes)),
+		}).
+		Do()
+	if err != nil {
+		return fmt.Errorf("Unable to create %s node pool: %s", spec.Name, err.Error())
+	}
+
+	err = funcs.LoopUntil(time.Minute*10, time.Second*10, func() (bool, error) {
+		nodePool, err := containerSvc.Projects.Zones.Clusters.NodePools.
+			Get(projectId, gcpCluster.Zone, gcpCluster.ClusterId, spec.Name).
+			Do()
+		if err != nil {
+			return false, nil
+		}
+		return nodePool.Status == "RUNNING", nil
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to wait until %s node pool is running: %s", spec.Name, err.Error())
+	}
+	gcpCluster.NodePoolIds = append(gcpCluster.NodePoolIds, spec.Name)
+	log.Infof("Node pool %s is running", spec.Name)
+
+	return nil
+}
+
+// deleteNodePool removes a node pool from the running cluster
+func deleteNodePool(containerSvc *container.Service, gcpCluster *hpgcp.GCPCluster, nodePoolId string) error {
+	_, err := containerSvc.Projects.Zones.Clusters.NodePools.
+		Delete(gcpCluster.GCPProfile.ProjectId, gcpCluster.Zone, gcpCluster.ClusterId, nodePoolId).
+		Do()
+	if err != nil {
+		return fmt.Errorf("Unable to delete %s node pool: %s", nodePoolId, err.Error())
+	}
+
+	nodePoolIds := []string{}
+	for _, id := range gcpCluster.NodePoolIds {
+		if id != nodePoolId {
+			nodePoolIds = append(nodePoolIds, id)
+		}
+	}
+	gcpCluster.NodePoolIds = nodePoolIds
+
+	return nil
+}
+
+// nodePoolSize return the target number of instances of the node pool
+func nodePoolSize(
+	containerSvc *container.Service,
+	computeSvc *compute.Service,
+	gcpCluster *hpgcp.GCPCluster,
+	nodePoolId string) (int, error) {
+	projectId := gcpCluster.GCPProfile.ProjectId
+	nodePool, err := containerSvc.Projects.Zones.Clusters.NodePools.
+		Get(projectId, gcpCluster.Zone, gcpCluster.ClusterId, nodePoolId).
+		Do()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to get %s node pool: %s", nodePoolId, err.Error())
+	}
+
+	size := 0
+	for _, instanceGroupUrl := range nodePool.InstanceGroupUrls {
+		urls := strings.Split(instanceGroupUrl, "/")
+		manager, err := computeSvc.InstanceGroupManagers.Get(projectId, gcpCluster.Zone, urls[len(urls)-1]).Do()
+		if err != nil {
+			return 0, errors.New("Unable to get instance group manager: " + err.Error())
+		}
+		size += int(manager.TargetSize)
+	}
+
+	return size, nil
+}
+
+// allocateNodePoolInstances return the names of count running instances of the node pool not
+// assigned to any node yet, resizing the node pool when there are not enough of them
+func allocateNodePoolInstances(
+	client *http.Client,
+	gcpCluster *hpgcp.GCPCluster,
+	nodePoolId string,
+	count int,
+	assignedInstances map[string]bool,
+	log *logging.Logger) ([]string, error) {
+	projectId := gcpCluster.GCPProfile.ProjectId
+	containerSvc, err := container.New(client)
+	if err != nil {
+		return nil, errors.New("Unable to create google cloud platform container service: " + err.Error())
+	}
+
+	computeSvc, err := compute.New(client)
+	if err != nil {
+		return nil, errors.New("Unable to create google cloud platform compute service: " + err.Error())
+	}
+
+	// Instances that are still starting count towards the target size of the node pool, but
+	// are not listed until they are running
+	unassignedInstanceNames := func() ([]string, int, error) {
+		poolInstances, err := listNodePoolInstances(client, projectId, gcpCluster.Zone,
+			gcpCluster.ClusterId, []string{nodePoolId})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		instanceNames := []string{}
+		for instanceName := range poolInstances {
+			if !assignedInstances[instanceName] {
+				instanceNames = append(instanceNames, instanceName)
+			}
+		}
+
+		return instanceNames, len(poolInstances) - len(instanceNames), nil
+	}
+
+	size, err := nodePoolSize(containerSvc, computeSvc, gcpCluster, nodePoolId)
+	if err != nil {
+		return nil, err
+	}
+
+	_, assignedCount, err := unassignedInstanceNames()
+	if err != nil {
+		return nil, err
+	}
+
+	if size-assignedCount < count {
+		newSize := assignedCount + count
+		log.Infof("Resizing node pool %s to %d nodes...", nodePoolId, newSize)
+		_, err := containerSvc.Projects.Zones.Clusters.NodePools.
+			SetSize(projectId, gcpCluster.Zone, gcpCluster.ClusterId, nodePoolId,
+				&container.SetNodePoolSizeRequest{NodeCount: int64(newSize)}).
+			Do()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to resize %s node pool: %s", nodePoolId, err.Error())
+		}
+	}
+
+	instanceNames := []string{}
+	err = funcs.LoopUntil(time.Minute*10, time.Second*10, func() (bool, error) {
+		names, _, err := unassignedInstanceNames()
+		if err != nil {
+			return false, err
+		}
+		instanceNames = names
+		return len(instanceNames) >= count, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to wait for %s node pool instances: %s", nodePoolId, err.Error())
+	}
+
+	if err := waitUntilClusterStatusRunning(containerSvc, projectId, gcpCluster.Zone,
+		gcpCluster.ClusterId, time.Duration(10)*time.Minute, log); err != nil {
+		return nil, errors.New("Unable to wait until cluster complete: " + err.Error())
+	}
+
+	return instanceNames[:count], nil
+}
@@ -0,0 +1,246 @@
+package gcpgke
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperpilotio/deployer/apis"
+	k8sUtil "github.com/hyperpilotio/deployer/clustermanagers/kubernetes"
+	hpgcp "github.com/hyperpilotio/deployer/clusters/gcp"
+	"github.com/hyperpilotio/go-utils/funcs"
+
+	compute "google.golang.org/api/compute/v1"
+	container "google.golang.org/api/container/v1"
+
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// AddNodes assigns unused instances of the node pool matching each new node, resizing the node
+// pool when there are not enough of them, and creates node pools for instance types and labels
+// not running in the cluster yet. The new kubernetes nodes are labeled with the given node ids.
+func (deployer *GCPDeployer) AddNodes(nodes []apis.ClusterNode, uploadedFiles map[string]string) error {
+	gcpCluster := deployer.GCPCluster
+	gcpProfile := gcpCluster.GCPProfile
+	projectId := gcpProfile.ProjectId
+	deployment := deployer.Deployment
+	log := deployer.GetLog().Logger
+
+	client, err := hpgcp.CreateClient(gcpProfile)
+	if err != nil {
+		return errors.New("Unable to create google cloud platform client: " + err.Error())
+	}
+
+	containerSvc, err := container.New(client)
+	if err != nil {
+		return errors.New("Unable to create google cloud platform container service: " + err.Error())
+	}
+
+	computeSvc, err := compute.New(client)
+	if err != nil {
+		return errors.New("Unable to create google cloud platform compute service: " + err.Error())
+	}
+
+	nodePools, err := listNodePools(containerSvc, gcpCluster)
+	if err != nil {
+		return err
+	}
+
+	poolNodes := map[string][]apis.ClusterNode{}
+	newPoolNodes := []apis.ClusterNode{}
+	for _, node := range nodes {
+		nodePoolId := ""
+		for _, nodePool := range nodePools {
+			if nodePoolMatches(nodePool, node) {
+				nodePoolId = nodePool.Name
+				break
+			}
+		}
+
+		if nodePoolId == "" {
+			newPoolNodes = append(newPoolNodes, node)
+		} else {
+			poolNodes[nodePoolId] = append(poolNodes[nodePoolId], node)
+		}
+	}
+
+	for _, spec := range buildNodePoolSpecs(newPoolNodes, gcpCluster.NodePoolIds) {
+		if err := createNodePool(containerSvc, gcpCluster, spec, log); err != nil {
+			return err
+		}
+		poolNodes[spec.Name] = spec.Nodes
+	}
+
+	assignedInstances := map[string]bool{}
+	for _, nodeInfo := range gcpCluster.NodeInfos {
+		assignedInstances[nodeInfo.Instance.Name] = true
+	}
+
+	instanceNames := map[int]string{}
+	for nodePoolId, nodePoolNodes := range poolNodes {
+		names, err := allocateNodePoolInstances(client, gcpCluster, nodePoolId, len(nodePoolNodes),
+			assignedInstances, log)
+		if err != nil {
+			return err
+		}
+
+		for i, node := range nodePoolNodes {
+			instanceNames[node.Id] = names[i]
+			assignedInstances[names[i]] = true
+		}
+	}
+
+	newNodeInfos := map[int]*hpgcp.NodeInfo{}
+	nodeNames := map[int]string{}
+	kubeNodeNames := []string{}
+	for _, node := range nodes {
+		instance, err := computeSvc.Instances.Get(projectId, gcpCluster.Zone, instanceNames[node.Id]).Do()
+		if err != nil {
+			return fmt.Errorf("Unable to get %s instances: %s", instanceNames[node.Id], err.Error())
+		}
+
+		log.Infof("Assigning instance %s to node %d", instance.Name, node.Id)
+		nodeInfo := &hpgcp.NodeInfo{
+			Instance:  instance,
+			PublicIp:  instanceNatIP(instance),
+			PrivateIp: instanceNetworkIP(instance),
+		}
+		gcpCluster.NodeInfos[node.Id] = nodeInfo
+		newNodeInfos[node.Id] = nodeInfo
+		nodeNames[node.Id] = instance.Name
+		kubeNodeNames = append(kubeNodeNames, instance.Name)
+	}
+	deployment.ClusterDefinition.Nodes = append(deployment.ClusterDefinition.Nodes, nodes...)
+
+	if err := deployer.uploadFilesToNodes(newNodeInfos, uploadedFiles); err != nil {
+		return errors.New("Unable to upload files to new nodes: " + err.Error())
+	}
+
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes: " + err.Error())
+	}
+
+	if err := k8sUtil.WaitUntilKubernetesNodeExists(k8sClient, kubeNodeNames, time.Duration(3)*time.Minute, log); err != nil {
+		return errors.New("Unable wait for kubernetes nodes to be exist: " + err.Error())
+	}
+
+	newClusterDefinition := apis.ClusterDefinition{Nodes: nodes}
+	if err := k8sUtil.TagKubeNodes(k8sClient, deployment.Name, newClusterDefinition, nodeNames, log); err != nil {
+		return errors.New("Unable to tag Kubernetes nodes: " + err.Error())
+	}
+
+	return nil
+}
+
+// RemoveNodes deletes the instances of the given nodes from their node pool instance group,
+// which shrinks the node pool accordingly. Node pools left without instances are deleted.
+func (deployer *GCPDeployer) RemoveNodes(nodeIds []int) error {
+	gcpCluster := deployer.GCPCluster
+	gcpProfile := gcpCluster.GCPProfile
+	projectId := gcpProfile.ProjectId
+	deployment := deployer.Deployment
+	log := deployer.GetLog().Logger
+
+	client, err := hpgcp.CreateClient(gcpProfile)
+	if err != nil {
+		return errors.New("Unable to create google cloud platform client: " + err.Error())
+	}
+
+	computeSvc, err := compute.New(client)
+	if err != nil {
+		return errors.New("Unable to create google cloud platform compute service: " + err.Error())
+	}
+
+	containerSvc, err := container.New(client)
+	if err != nil {
+		return errors.New("Unable to create google cloud platform container service: " + err.Error())
+	}
+
+	if _, err := listNodePools(containerSvc, gcpCluster); err != nil {
+		return err
+	}
+
+	poolInstances, err := listNodePoolInstances(client, projectId, gcpCluster.Zone,
+		gcpCluster.ClusterId, gcpCluster.NodePoolIds)
+	if err != nil {
+		return err
+	}
+
+	// GKE names the instance group managers after the instance groups they manage
+	groupInstances := map[string][]string{}
+	instanceNames := []string{}
+	for _, nodeId := range nodeIds {
+		nodeInfo, ok := gcpCluster.NodeInfos[nodeId]
+		if !ok {
+			return fmt.Errorf("Unable to find instance of node %d", nodeId)
+		}
+
+		instanceGroupName, ok := poolInstances[nodeInfo.Instance.Name]
+		if !ok {
+			return fmt.Errorf("Unable to find instance group of instance %s", nodeInfo.Instance.Name)
+		}
+
+		groupInstances[instanceGroupName] = append(groupInstances[instanceGroupName], nodeInfo.Instance.SelfLink)
+		instanceNames = append(instanceNames, nodeInfo.Instance.Name)
+	}
+
+	for instanceGroupName, instanceUrls := range groupInstances {
+		_, err := computeSvc.InstanceGroupManagers.DeleteInstances(projectId, gcpCluster.Zone, instanceGroupName,
+			&compute.InstanceGroupManagersDeleteInstancesRequest{Instances: instanceUrls}).Do()
+		if err != nil {
+			return fmt.Errorf("Unable to delete instances from %s: %s", instanceGroupName, err.Error())
+		}
+		log.Infof("Deleting instances %s from %s", instanceUrls, instanceGroupName)
+	}
+
+	k8sClient, err := k8s.NewForConfig(deployer.KubeConfig)
+	if err != nil {
+		return errors.New("Unable to connect to kubernetes: " + err.Error())
+	}
+
+	if err := k8sUtil.DeleteKubeNodes(k8sClient, instanceNames, log); err != nil {
+		log.Warningf("Unable to delete kubernetes nodes: %s", err.Error())
+	}
+
+	log.Info("Waiting until node instances are deleted...")
+	err = funcs.LoopUntil(time.Minute*10, time.Second*10, func() (bool, error) {
+		for _, instanceName := range instanceNames {
+			_, err := computeSvc.Instances.Get(projectId, gcpCluster.Zone, instanceName).Do()
+			if err == nil {
+				return false, nil
+			} else if !strings.Contains(err.Error(), "was not found") {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return errors.New("Unable to wait until instances deleted: " + err.Error())
+	}
+
+	for _, nodeId := range nodeIds {
+		delete(gcpCluster.NodeInfos, nodeId)
+	}
+	deployment.ClusterDefinition.RemoveNodes(nodeIds)
+
+	for _, nodePoolId := range gcpCluster.NodePoolIds {
+		size, err := nodePoolSize(containerSvc, computeSvc, gcpCluster, nodePoolId)
+		if err != nil {
+			log.Warningf("Unable to get size of node pool %s: %s", nodePoolId, err.Error())
+			continue
+		}
+
+		if size > 0 {
+			continue
+		}
+
+		log.Infof("Deleting empty node pool %s", nodePoolId)
+		if err := deleteNodePool(containerSvc, gcpCluster, nodePoolId); err != nil {
+			log.Warningf("Unable to delete empty node pool: %s", err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package gcpgke
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperpilotio/deployer/apis"
+	hpgcp "github.com/hyperpilotio/deployer/clusters/gcp"
+	"github.com/hyperpilotio/go-utils/funcs"
+	logging "github.com/op/go-logging"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func networkUrl(projectId string, network string) string {
+	return fmt.Sprintf("projects/%s/global/networks/%s", projectId, network)
+}
+
+func peeringName(gcpCluster *hpgcp.GCPCluster) string {
+	return gcpCluster.ClusterId + "-peering"
+}
+
+func peeringFirewallName(gcpCluster *hpgcp.GCPCluster) string {
+	return fmt.Sprintf("gke-%s-peering", gcpCluster.ClusterId)
+}
+
+// setupNetworkPeering peers the cluster network with the target network of the deployment,
+// and opens the ports of the peering on the cluster nodes to the target subnetworks. The peering only becomes active once
+// the target network peers back, which is done here when the deployer has access to the
+// target project.
+func (deployer *GCPDeployer) setupNetworkPeering(client *http.Client) error {
+	gcpCluster := deployer.GCPCluster
+	deployment := deployer.Deployment
+	peering := deployment.VPCPeering
+	projectId := gcpCluster.GCPProfile.ProjectId
+	log := deployer.GetLog().Logger
+	computeSvc, err := compute.New(client)
+	if err != nil {
+		return errors.New("Unable to create google cloud platform compute service: " + err.Error())
+	}
+
+	network, _ := deploymentNetwork(deployment)
+	name := peeringName(gcpCluster)
+	log.Infof("Peering network %s with %s", network, networkUrl(peering.TargetOwnerId, peering.TargetVpcId))
+	operation, err := computeSvc.Networks.AddPeering(projectId, network, &compute.NetworksAddPeeringRequest{
+		Name:             name,
+		PeerNetwork:      networkUrl(peering.TargetOwnerId, peering.TargetVpcId),
+		AutoCreateRoutes: true,
+	}).Do()
+	if err != nil {
+		return errors.New("Unable to add network peering: " + err.Error())
+	}
+
+	if err := waitUntilGlobalOperationDone(computeSvc, projectId, operation.Name); err != nil {
+		return errors.New("Unable to wait until network peering added: " + err.Error())
+	}
+
+	deployer.VpcPeering = &apis.VPCPeeringStatus{
+		Provider:     apis.PeeringProviderGCP,
+		ConnectionId: name,
+	}
+
+	operation, err = computeSvc.Networks.AddPeering(peering.TargetOwnerId, peering.TargetVpcId,
+		&compute.NetworksAddPeeringRequest{
+			Name:             name,
+			PeerNetwork:      networkUrl(projectId, network),
+			AutoCreateRoutes: true,
+		}).Do()
+	if err == nil {
+		err = waitUntilGlobalOperationDone(computeSvc, peering.TargetOwnerId, operation.Name)
+	}
+	if err != nil {
+		log.Warningf("Unable to peer back from target network, it has to be peered by its project: %s", err.Error())
+	} else {
+		deployer.VpcPeering.TargetConnectionId = name
+	}
+
+	sourceRanges, err := networkCidrBlocks(computeSvc, peering.TargetOwnerId, peering.TargetVpcId)
+	if err != nil {
+		log.Warningf("Unable to find target subnetworks, cluster nodes are not opened to them: %s", err.Error())
+		return nil
+	}
+	deployer.VpcPeering.TargetCidrBlocks = sourceRanges
+
+	firewallName := peeringFirewallName(gcpCluster)
+	if _, err := computeSvc.Firewalls.Insert(projectId, &compute.Firewall{
+		Allowed:      peeringFirewallAllowed(peering.Ports),
+		Description:  "PEERING",
+		Name:         firewallName,
+		Network:      firewallNetwork(deployment),
+		Priority:     int64(1000),
+		SourceRanges: sourceRanges,
+		TargetTags:   []string{fmt.Sprintf("gke-%s-http-server", gcpCluster.ClusterId)},
+	}).Do(); err != nil {
+		return errors.New("Unable to insert peering firewall rules: " + err.Error())
+	}
+	deployer.VpcPeering.FirewallName = firewallName
+
+	return nil
+}
+
+// peeringFirewallAllowed return the firewall allowed list of the peering ports, grouping the
+// port ranges of each protocol
+func peeringFirewallAllowed(ports []apis.PeeringPort) []*compute.FirewallAllowed {
+	allowed := []*compute.FirewallAllowed{}
+	protocols := map[string]*compute.FirewallAllowed{}
+	for _, port := range ports {
+		protocolAllowed, ok := protocols[port.Protocol]
+		if !ok {
+			protocolAllowed = &compute.FirewallAllowed{
+				IPProtocol: port.Protocol,
+			}
+			protocols[port.Protocol] = protocolAllowed
+			allowed = append(allowed, protocolAllowed)
+		}
+
+		// icmp has no ports
+		if port.Protocol == "icmp" {
+			continue
+		}
+
+		fromPort, toPort := port.PortRange()
+		if fromPort == toPort {
+			protocolAllowed.Ports = append(protocolAllowed.Ports, strconv.Itoa(fromPort))
+		} else {
+			protocolAllowed.Ports = append(protocolAllowed.Ports, fmt.Sprintf("%d-%d", fromPort, toPort))
+		}
+	}
+
+	return allowed
+}
+
+// deleteNetworkPeering removes the peering and firewall rules set up for the target network
+func (deployer *GCPDeployer) deleteNetworkPeering(client *http.Client, log *logging.Logger) error {
+	gcpCluster := deployer.GCPCluster
+	deployment := deployer.Deployment
+	status := deployer.VpcPeering
+	projectId := gcpCluster.GCPProfile.ProjectId
+	computeSvc, err := compute.New(client)
+	if err != nil {
+		return errors.New("Unable to create google cloud platform compute service: " + err.Error())
+	}
+
+	if status.FirewallName != "" {
+		if err := deleteFirewallRules(client, projectId, status.FirewallName, log); err != nil {
+			log.Warningf("Unable to delete peering firewall rules: %s", err.Error())
+		}
+	}
+
+	if status.TargetConnectionId != "" && deployment.VPCPeering != nil {
+		peering := deployment.VPCPeering
+		if _, err := computeSvc.Networks.RemovePeering(peering.TargetOwnerId, peering.TargetVpcId,
+			&compute.NetworksRemovePeeringRequest{
+				Name: status.TargetConnectionId,
+			}).Do(); err != nil {
+			log.Warningf("Unable to remove network peering from target network: %s", err.Error())
+		}
+	}
+
+	network, _ := deploymentNetwork(deployment)
+	log.Infof("Removing network peering %s from %s", status.ConnectionId, network)
+	operation, err := computeSvc.Networks.RemovePeering(projectId, network, &compute.NetworksRemovePeeringRequest{
+		Name: status.ConnectionId,
+	}).Do()
+	if err != nil {
+		return errors.New("Unable to remove network peering: " + err.Error())
+	}
+
+	if err := waitUntilGlobalOperationDone(computeSvc, projectId, operation.Name); err != nil {
+		return errors.New("Unable to wait until network peering removed: " + err.Error())
+	}
+	deployer.VpcPeering = nil
+
+	return nil
+}
+
+// GetVPCPeering return the current state of the network peering of the deployment
+func (deployer *GCPDeployer) GetVPCPeering() (*apis.VPCPeeringStatus, error) {
+	if deployer.VpcPeering == nil {
+		return nil, errors.New("Deployment has no network peering")
+	}
+
+	client, err := hpgcp.CreateClient(deployer.GCPCluster.GCPProfile)
+	if err != nil {
+		return nil, errors.New("Unable to create google cloud platform client: " + err.Error())
+	}
+
+	computeSvc, err := compute.New(client)
+	if err != nil {
+		return nil, errors.New("Unable to create google cloud platform compute service: " + err.Error())
+	}
+
+	network, _ := deploymentNetwork(deployer.Deployment)
+	computeNetwork, err := computeSvc.Networks.Get(deployer.GCPCluster.GCPProfile.ProjectId, network).Do()
+	if err != nil {
+		return nil, errors.New("Unable to get network: " + err.Error())
+	}
+
+	status := *deployer.VpcPeering
+	status.Status = "deleted"
+	status.Message = ""
+	for _, networkPeering := range computeNetwork.Peerings {
+		if networkPeering.Name == status.ConnectionId {
+			status.Status = networkPeering.State
+			status.Message = networkPeering.StateDetails
+			break
+		}
+	}
+
+	return &status, nil
+}
+
+// networkCidrBlocks return the ip ranges of the subnetworks of a network
+func networkCidrBlocks(computeSvc *compute.Service, projectId string, network string) ([]string, error) {
+	computeNetwork, err := computeSvc.Networks.Get(projectId, network).Do()
+	if err != nil {
+		return nil, errors.New("Unable to get network: " + err.Error())
+	}
+
+	// Legacy networks have a single range and no subnetworks
+	if computeNetwork.IPv4Range != "" {
+		return []string{computeNetwork.IPv4Range}, nil
+	}
+
+	cidrBlocks := []string{}
+	for _, subnetworkUrl := range computeNetwork.Subnetworks {
+		// Subnetwork urls end with regions/<region>/subnetworks/<name>
+		parts := strings.Split(subnetworkUrl, "/")
+		if len(parts) < 4 {
+			return nil, errors.New("Unexpected subnetwork url " + subnetworkUrl)
+		}
+
+		subnetwork, err := computeSvc.Subnetworks.
+			Get(projectId, parts[len(parts)-3], parts[len(parts)-1]).
+			Do()
+		if err != nil {
+			return nil, errors.New("Unable to get subnetwork: " + err.Error())
+		}
+		cidrBlocks = append(cidrBlocks, subnetwork.IpCidrRange)
+	}
+
+	if len(cidrBlocks) == 0 {
+		return nil, errors.New("Unable to find subnetworks of network " + network)
+	}
+
+	return cidrBlocks, nil
+}
+
+func waitUntilGlobalOperationDone(computeSvc *compute.Service, projectId string, operationName string) error {
+	return funcs.LoopUntil(time.Minute*5, time.Second*5, func() (bool, error) {
+		operation, err := computeSvc.GlobalOperations.Get(projectId, operationName).Do()
+		if err != nil {
+			return false, errors.New("Unable to get operation: " + err.Error())
+		}
+
+		if operation.Status != "DONE" {
+			return false, nil
+		}
+
+		if operation.Error != nil && len(operation.Error.Errors) > 0 {
+			return false, errors.New(operation.Error.Errors[0].Message)
+		}
+
+		return true, nil
+	})
+}
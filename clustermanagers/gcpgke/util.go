@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -33,81 +34,116 @@ func populateNodeInfos(
 	gcpCluster *hpgcp.GCPCluster,
 	clusterNodeDefinition apis.ClusterDefinition,
 	log *logging.Logger) error {
-	instanceGroupNames := []string{}
 	containerSvc, err := container.New(client)
+	if err != nil {
+		return errors.New("Unable to create google cloud platform container service: " + err.Error())
+	}
+
+	computeSvc, err := compute.New(client)
 	if err != nil {
 		return errors.New("Unable to create google cloud platform compute service: " + err.Error())
 	}
 
+	// Nodes are assigned to the instances of the node pool with their machine type and labels
 	log.Infof("Populate nodeInfos with nodePoolIds: %s", nodePoolIds)
 	for _, nodePoolId := range nodePoolIds {
-		resp, err := containerSvc.Projects.Zones.Clusters.NodePools.
+		nodePool, err := containerSvc.Projects.Zones.Clusters.NodePools.
 			Get(projectId, zone, clusterId, nodePoolId).
 			Do()
 		if err != nil {
 			return fmt.Errorf("Unable to get %s node pool: %s", nodePoolId, err.Error())
 		}
+
+		poolInstances, err := listNodePoolInstances(client, projectId, zone, clusterId, []string{nodePoolId})
+		if err != nil {
+			return err
+		}
+
+		instanceNames := []string{}
+		for instanceName := range poolInstances {
+			instanceNames = append(instanceNames, instanceName)
+		}
+		sort.Strings(instanceNames)
+
+		for _, node := range clusterNodeDefinition.Nodes {
+			if _, ok := gcpCluster.NodeInfos[node.Id]; ok || !nodePoolMatches(nodePool, node) {
+				continue
+			}
+
+			if len(instanceNames) == 0 {
+				return fmt.Errorf("Unable to find instance of node pool %s for node %d", nodePoolId, node.Id)
+			}
+
+			instance, err := computeSvc.Instances.Get(projectId, zone, instanceNames[0]).Do()
+			if err != nil {
+				return fmt.Errorf("Unable to get %s instances: %s", instanceNames[0], err.Error())
+			}
+			instanceNames = instanceNames[1:]
+
+			log.Infof("Assigning %s node pool instance %s to node %d", nodePoolId, instance.Name, node.Id)
+			gcpCluster.NodeInfos[node.Id] = &hpgcp.NodeInfo{
+				Instance:  instance,
+				PublicIp:  instanceNatIP(instance),
+				PrivateIp: instanceNetworkIP(instance),
+			}
+		}
+	}
+
+	if len(gcpCluster.NodeInfos) != len(clusterNodeDefinition.Nodes) {
+		return fmt.Errorf("Unexpected list of nodeInfos: %d", len(gcpCluster.NodeInfos))
+	}
+
+	return nil
+}
+
+// listNodePoolInstances return the names of the running instances of the node pools,
+// mapped to the name of the instance group they belong to
+func listNodePoolInstances(
+	client *http.Client,
+	projectId string,
+	zone string,
+	clusterId string,
+	nodePoolIds []string) (map[string]string, error) {
+	instanceGroupNames := []string{}
+	containerSvc, err := container.New(client)
+	if err != nil {
+		return nil, errors.New("Unable to create google cloud platform container service: " + err.Error())
+	}
+
+	for _, nodePoolId := range nodePoolIds {
+		resp, err := containerSvc.Projects.Zones.Clusters.NodePools.
+			Get(projectId, zone, clusterId, nodePoolId).
+			Do()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get %s node pool: %s", nodePoolId, err.Error())
+		}
 		for _, instanceGroupUrl := range resp.InstanceGroupUrls {
 			urls := strings.Split(instanceGroupUrl, "/")
 			instanceGroupNames = append(instanceGroupNames, urls[len(urls)-1])
 		}
 	}
 
-	instanceNames := []string{}
 	computeSvc, err := compute.New(client)
 	if err != nil {
-		return errors.New("Unable to create google cloud platform compute service: " + err.Error())
+		return nil, errors.New("Unable to create google cloud platform compute service: " + err.Error())
 	}
+
+	instances := map[string]string{}
 	for _, instanceGroupName := range instanceGroupNames {
 		resp, err := computeSvc.InstanceGroups.ListInstances(projectId, zone, instanceGroupName,
 			&compute.InstanceGroupsListInstancesRequest{
 				InstanceState: "RUNNING",
 			}).Do()
 		if err != nil {
-			return errors.New("Unable to list instance: " + err.Error())
+			return nil, errors.New("Unable to list instance: " + err.Error())
 		}
 		for _, item := range resp.Items {
 			urls := strings.Split(item.Instance, "/")
-			instanceNames = append(instanceNames, urls[len(urls)-1])
-		}
-	}
-
-	clusterInstancesMap := map[int]*compute.Instance{}
-	for i, instanceName := range instanceNames {
-		instance, err := computeSvc.Instances.Get(projectId, zone, instanceName).Do()
-		if err != nil {
-			return fmt.Errorf("Unable to get %s instances: %s", instanceName, err.Error())
-		}
-		clusterInstancesMap[i] = instance
-		log.Infof("Find %s node instance name: %s, instance type: %s",
-			gcpCluster.ClusterId, instanceName, instance.MachineType)
-	}
-
-	for _, node := range clusterNodeDefinition.Nodes {
-		assignedKey := -1
-		for key, instance := range clusterInstancesMap {
-			machineTypeUrls := strings.Split(instance.MachineType, "/")
-			if node.InstanceType == machineTypeUrls[len(machineTypeUrls)-1] {
-				log.Infof("Assigning public dns name %s to node %d", instance.Name, node.Id)
-				gcpCluster.NodeInfos[node.Id] = &hpgcp.NodeInfo{
-					Instance:  instance,
-					PublicIp:  instance.NetworkInterfaces[0].AccessConfigs[0].NatIP,
-					PrivateIp: instance.NetworkInterfaces[0].NetworkIP,
-				}
-				assignedKey = key
-				break
-			}
-		}
-		if assignedKey != -1 {
-			delete(clusterInstancesMap, assignedKey)
+			instances[urls[len(urls)-1]] = instanceGroupName
 		}
 	}
 
-	if len(gcpCluster.NodeInfos) != len(clusterNodeDefinition.Nodes) {
-		return fmt.Errorf("Unexpected list of nodeInfos: %d", len(gcpCluster.NodeInfos))
-	}
-
-	return nil
+	return instances, nil
 }
 
 func reloadNodeInfos(
@@ -118,6 +154,15 @@ func reloadNodeInfos(
 	clusterId string,
 	gcpCluster *hpgcp.GCPCluster,
 	log *logging.Logger) error {
+	containerSvc, err := container.New(client)
+	if err != nil {
+		return errors.New("Unable to create google cloud platform container service: " + err.Error())
+	}
+
+	if _, err := listNodePools(containerSvc, gcpCluster); err != nil {
+		return err
+	}
+
 	k8sClient, err := k8s.NewForConfig(kubeConfig)
 	if err != nil {
 		return errors.New("Unable to create in cluster k8s client: " + err.Error())
@@ -155,8 +200,8 @@ func reloadNodeInfos(
 		}
 		gcpCluster.NodeInfos[nodeId] = &hpgcp.NodeInfo{
 			Instance:  instance,
-			PublicIp:  instance.NetworkInterfaces[0].AccessConfigs[0].NatIP,
-			PrivateIp: instance.NetworkInterfaces[0].NetworkIP,
+			PublicIp:  instanceNatIP(instance),
+			PrivateIp: instanceNetworkIP(instance),
 		}
 	}
 
@@ -177,6 +222,28 @@ func tagKubeNodes(
 	return k8sUtil.TagKubeNodes(k8sClient, deployment.Name, deployment.ClusterDefinition, nodeNames, log)
 }
 
+// deploymentNetwork return the network and subnetwork the cluster is launched in, which is the
+// default network unless the deployment uses an existing one
+func deploymentNetwork(deployment *apis.Deployment) (string, string) {
+	existingNetwork := deployment.ExistingNetwork
+	if existingNetwork == nil {
+		return "default", "default"
+	}
+
+	subnetwork := existingNetwork.Subnetwork
+	if subnetwork == "" {
+		subnetwork = existingNetwork.Network
+	}
+
+	return existingNetwork.Network, subnetwork
+}
+
+// firewallNetwork return the network url of the firewall rules of the deployment
+func firewallNetwork(deployment *apis.Deployment) string {
+	network, _ := deploymentNetwork(deployment)
+	return "global/networks/" + network
+}
+
 func insertFirewallIngressRules(
 	client *http.Client,
 	gcpCluster *hpgcp.GCPCluster,
@@ -188,18 +255,13 @@ func insertFirewallIngressRules(
 		return errors.New("Unable to create google cloud platform compute service: " + err.Error())
 	}
 
-	allowedPorts := getDeploymentAllowedPorts(deployment, log)
 	firewallName := fmt.Sprintf("gke-%s-http", gcpCluster.ClusterId)
 	targetTagName := fmt.Sprintf("gke-%s-http-server", gcpCluster.ClusterId)
 	tagFirewall := &compute.Firewall{
-		Allowed: []*compute.FirewallAllowed{
-			&compute.FirewallAllowed{
-				IPProtocol: "tcp",
-				Ports:      allowedPorts,
-			},
-		},
+		Allowed:     getDeploymentFirewallAllowed(deployment, log),
 		Description: "INGRESS",
 		Name:        firewallName,
+		Network:     firewallNetwork(deployment),
 		Priority:    int64(1000),
 		TargetTags:  []string{targetTagName},
 	}
@@ -221,18 +283,13 @@ func updateFirewallIngressRules(
 		return errors.New("Unable to create google cloud platform compute service: " + err.Error())
 	}
 
-	allowedPorts := getDeploymentAllowedPorts(deployment, log)
 	firewallName := fmt.Sprintf("gke-%s-http", gcpCluster.ClusterId)
 	targetTagName := fmt.Sprintf("gke-%s-http-server", gcpCluster.ClusterId)
 	tagFirewall := &compute.Firewall{
-		Allowed: []*compute.FirewallAllowed{
-			&compute.FirewallAllowed{
-				IPProtocol: "tcp",
-				Ports:      allowedPorts,
-			},
-		},
+		Allowed:     getDeploymentFirewallAllowed(deployment, log),
 		Description: "INGRESS",
 		Name:        fmt.Sprintf("gke-%s-http", gcpCluster.ClusterId),
+		Network:     firewallNetwork(deployment),
 		Priority:    int64(1000),
 		TargetTags:  []string{targetTagName},
 	}
@@ -374,37 +431,77 @@ func deleteLoadBalancing(
 	return nil
 }
 
-func getDeploymentAllowedPorts(deployment *apis.Deployment, log *logging.Logger) []string {
-	allowedPorts := []string{}
-	for _, task := range deployment.KubernetesDeployment.Kubernetes {
-		if task.PortTypes == nil || len(task.PortTypes) == 0 {
-			continue
-		}
+// instanceNatIP return the external ip of the instance, which is empty for instances without an
+// access config
+func instanceNatIP(instance *compute.Instance) string {
+	if instance == nil || len(instance.NetworkInterfaces) == 0 ||
+		len(instance.NetworkInterfaces[0].AccessConfigs) == 0 {
+		return ""
+	}
+
+	return instance.NetworkInterfaces[0].AccessConfigs[0].NatIP
+}
 
+// instanceNetworkIP return the internal ip of the instance
+func instanceNetworkIP(instance *compute.Instance) string {
+	if instance == nil || len(instance.NetworkInterfaces) == 0 {
+		return ""
+	}
+
+	return instance.NetworkInterfaces[0].NetworkIP
+}
+
+// getDeploymentFirewallAllowed return the ports opened on the nodes for the public ports of a deployment,
+// which are host ports of ports set public by PortTypes and fixed node ports of NodePort ports
+func getDeploymentFirewallAllowed(deployment *apis.Deployment, log *logging.Logger) []*compute.FirewallAllowed {
+	allowedPorts := map[string][]string{"tcp": []string{}, "udp": []string{}}
+	for _, task := range deployment.KubernetesDeployment.Kubernetes {
 		ports := task.GetPorts()
-		for i, portType := range task.PortTypes {
-			if portType != publicPortType {
-				log.Infof("Skipping creating public endpoint for service %s as it's marked as private", task.Family)
+		for i, port := range ports {
+			exposure, explicit := task.GetPortExposure(i)
+			allowedPort := ""
+			if !explicit && exposure.Type == apis.ExposureLoadBalancer {
+				allowedPort = strconv.Itoa(int(port.HostPort))
+			} else if explicit && exposure.Type == apis.ExposureNodePort && exposure.NodePort != 0 {
+				allowedPort = strconv.Itoa(int(exposure.NodePort))
+			} else {
+				log.Infof("Skipping opening port %d of service %s in firewall", i, task.Family)
 				continue
 			}
 
-			hostPort := strconv.Itoa(int(ports[i].HostPort))
+			protocol := "tcp"
+			if strings.ToLower(exposure.Protocol) == "udp" {
+				protocol = "udp"
+			}
 
 			allowedPortExist := false
-			for _, allowedPort := range allowedPorts {
-				if allowedPort == hostPort {
+			for _, existingPort := range allowedPorts[protocol] {
+				if existingPort == allowedPort {
 					allowedPortExist = true
 					break
 				}
 			}
 
 			if !allowedPortExist {
-				allowedPorts = append(allowedPorts, hostPort)
+				allowedPorts[protocol] = append(allowedPorts[protocol], allowedPort)
 			}
 		}
 	}
 
-	return allowedPorts
+	firewallAllowed := []*compute.FirewallAllowed{
+		&compute.FirewallAllowed{
+			IPProtocol: "tcp",
+			Ports:      allowedPorts["tcp"],
+		},
+	}
+	if len(allowedPorts["udp"]) > 0 {
+		firewallAllowed = append(firewallAllowed, &compute.FirewallAllowed{
+			IPProtocol: "udp",
+			Ports:      allowedPorts["udp"],
+		})
+	}
+
+	return firewallAllowed
 }
 
 func tagPublicKey(
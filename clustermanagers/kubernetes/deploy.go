@@ -17,19 +17,30 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	k8s "k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/pkg/api"
 	"k8s.io/client-go/pkg/api/v1"
 	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
 	"k8s.io/client-go/rest"
 )
 
-var publicPortType = 1
-
 type ServiceMapping struct {
 	NodeId     int    `json:"nodeId"`
 	NodeName   string `json:"nodeName"`
 	PublicUrl  string `json:"publicUrl"`
 	PrivateUrl string `json:"privateUrl"`
+	Replicas   int32  `json:"replicas,omitempty"`
+}
+
+// daemonSetScheduleTimeout bounds the wait for a daemonset to be scheduled on any node, as
+// one whose node selector matches no nodes never becomes ready
+const daemonSetScheduleTimeout = time.Minute * 5
+
+// deployedObject identifies a kubernetes object created for a task
+type deployedObject struct {
+	Kind      string
+	Name      string
+	Namespace string
 }
 
 func DeployKubernetesObjects(
@@ -72,99 +83,88 @@ func DeployServices(
 	serviceMappings := map[string]ServiceMapping{}
 	taskCount := map[string]int{}
 
+	phases, err := apis.DeployPhases(deployment.KubernetesDeployment.TaskDependencies())
+	if err != nil {
+		return serviceMappings, errors.New("Unable to resolve task dependencies: " + err.Error())
+	}
+
 	// We sort before we create services because we want to have a deterministic way to assign
 	// service ids
 	sort.Sort(deployment.NodeMapping)
-
-	skipCreatePublicService := false
-	if deployment.ClusterType == "GCP" || config.GetBool("inCluster") {
-		skipCreatePublicService = true
-	}
-
 	for _, mapping := range deployment.NodeMapping {
-		log.Infof("Deploying task %s with mapping %d", mapping.Task, mapping.Id)
-
-		task, ok := tasks[mapping.Task]
-		if !ok {
+		if _, ok := tasks[mapping.Task]; !ok {
 			return serviceMappings, fmt.Errorf("Unable to find task %s in task definitions", mapping.Task)
 		}
+	}
 
-		deploySpec := task.Deployment
-		if deploySpec == nil {
-			return serviceMappings, fmt.Errorf("Unable to find deployment in task %s", mapping.Task)
+	skipCreatePublicService := skipPublicServices(config, deployment)
+	ingress := deployment.KubernetesDeployment.Ingress
+	if ingress != nil && !skipCreatePublicService {
+		if err := DeployIngressController(k8sClient, ingress, existingNamespaces, log); err != nil {
+			return serviceMappings, errors.New("Unable to deploy ingress controller: " + err.Error())
 		}
-		family := task.Family
+	} else {
+		ingress = nil
+	}
 
-		namespace := GetNamespace(deploySpec.ObjectMeta)
-		if deployNamespace != "" {
-			namespace = deployNamespace
-		}
-		if err := CreateNamespaceIfNotExist(namespace, existingNamespaces, k8sClient); err != nil {
-			return serviceMappings, err
+	for i, phase := range phases {
+		if len(phases) > 1 {
+			log.Infof("Deploying phase %d of %d with tasks %s", i+1, len(phases), phase)
 		}
 
-		originalFamily := family
-		count, ok := taskCount[family]
-		if !ok {
-			count = 1
-			deploySpec.Name = originalFamily
-			deploySpec.Labels["app"] = originalFamily
-			deploySpec.Spec.Template.Labels["app"] = originalFamily
-		} else {
-			// Update deploy spec to reflect multiple count of the same task
-			count += 1
-			family = family + "-" + strconv.Itoa(count)
-			deploySpec.Name = family
-			deploySpec.Labels["app"] = family
-			deploySpec.Spec.Template.Labels["app"] = family
+		phaseTasks := map[string]bool{}
+		for _, family := range phase {
+			phaseTasks[family] = true
 		}
+		deployedObjects := []deployedObject{}
 
-		if deploySpec.Spec.Selector != nil {
-			deploySpec.Spec.Selector.MatchLabels = deploySpec.Spec.Template.Labels
-		}
-		taskCount[originalFamily] = count
+		for _, mapping := range deployment.NodeMapping {
+			if !phaseTasks[mapping.Task] {
+				continue
+			}
 
-		// Assigning Pods to Nodes
-		nodeSelector := map[string]string{}
-		log.Infof("Selecting node %d for deployment %s", mapping.Id, family)
-		nodeSelector["hyperpilot/node-id"] = strconv.Itoa(mapping.Id)
-		nodeSelector["hyperpilot/deployment"] = deployment.Name
+			object, err := deployDeploymentTask(k8sClient, deployment, tasks[mapping.Task], mapping,
+				deployNamespace, existingNamespaces, taskCount, serviceMappings, userName, log,
+				skipCreatePublicService, ingress)
+			if err != nil {
+				return serviceMappings, err
+			}
+			deployedObjects = append(deployedObjects, *object)
+		}
 
-		deploySpec.Spec.Template.Spec.NodeSelector = nodeSelector
+		// Run daemonsets
+		for _, task := range deployment.KubernetesDeployment.Kubernetes {
+			if task.DaemonSet == nil || !phaseTasks[task.Family] {
+				continue
+			}
 
-		servicemapping := ServiceMapping{
-			NodeId: mapping.Id,
-		}
-		serviceMappings[family] = servicemapping
-		// Create service for each container that opens a port
-		for _, container := range deploySpec.Spec.Template.Spec.Containers {
-			err := CreateServiceForDeployment(
-				namespace,
-				family,
-				family,
-				k8sClient,
-				task,
-				container,
-				log,
-				skipCreatePublicService,
-				false)
+			object, err := deployDaemonSetTask(k8sClient, task, deployNamespace, existingNamespaces, log)
 			if err != nil {
-				return serviceMappings, fmt.Errorf("Unable to create service for deployment %s: %s", family, err.Error())
+				return serviceMappings, err
 			}
+			deployedObjects = append(deployedObjects, *object)
 		}
 
-		for _, mount := range deploySpec.Spec.Template.Spec.Volumes {
-			if mount.HostPath != nil && strings.HasPrefix(mount.HostPath.Path, "~/") {
-				mount.HostPath.Path = strings.Replace(mount.HostPath.Path, "~/", "/home/"+userName+"/", 1)
+		// Run statefulsets
+		for _, task := range deployment.KubernetesDeployment.Kubernetes {
+			if task.StatefulSet == nil || !phaseTasks[task.Family] {
+				continue
 			}
+
+			object, err := deployStatefulSetTask(k8sClient, task, deployNamespace, existingNamespaces, ingress, log)
+			if err != nil {
+				return serviceMappings, err
+			}
+			deployedObjects = append(deployedObjects, *object)
 		}
 
-		deploy := k8sClient.Extensions().Deployments(namespace)
-		_, err := deploy.Create(deploySpec)
-		if err != nil {
-			return serviceMappings, fmt.Errorf("Unable to create k8s deployment: %s", err)
+		// Tasks in later phases depend on this phase, so wait for it to be ready first
+		if i < len(phases)-1 {
+			log.Infof("Waiting for tasks %s to be ready", phase)
+			if err := waitUntilObjectsReady(k8sClient, deployedObjects, time.Minute*30, log); err != nil {
+				return serviceMappings, fmt.Errorf("Unable to wait for tasks %s to be ready: %s", phase, err.Error())
+			}
 		}
-		log.Infof("%s deployment created", family)
 	}
 
 	if deployNamespace != "" {
@@ -173,69 +173,249 @@ func DeployServices(
 		}
 	}
 
-	// Run daemonsets
-	for _, task := range deployment.KubernetesDeployment.Kubernetes {
-		if task.DaemonSet == nil {
-			continue
-		}
+	return serviceMappings, nil
+}
 
-		daemonSet := task.DaemonSet
-		namespace := GetNamespace(daemonSet.ObjectMeta)
-		if deployNamespace != "" {
-			namespace = deployNamespace
+// skipPublicServices return true when ports set public by PortTypes are not exposed by public services
+func skipPublicServices(config *viper.Viper, deployment *apis.Deployment) bool {
+	return deployment.ClusterType == "GCP" || config.GetBool("inCluster")
+}
+
+func deployDeploymentTask(
+	k8sClient *k8s.Clientset,
+	deployment *apis.Deployment,
+	task apis.KubernetesTask,
+	mapping apis.NodeMapping,
+	deployNamespace string,
+	existingNamespaces map[string]bool,
+	taskCount map[string]int,
+	serviceMappings map[string]ServiceMapping,
+	userName string,
+	log *logging.Logger,
+	skipCreatePublicService bool,
+	ingress *apis.IngressDefinition) (*deployedObject, error) {
+	log.Infof("Deploying task %s with mapping %d", mapping.Task, mapping.Id)
+
+	deploySpec := task.Deployment
+	if deploySpec == nil {
+		return nil, fmt.Errorf("Unable to find deployment in task %s", mapping.Task)
+	}
+	family := task.Family
+
+	namespace := GetNamespace(deploySpec.ObjectMeta)
+	if deployNamespace != "" {
+		namespace = deployNamespace
+	}
+	if err := CreateNamespaceIfNotExist(namespace, existingNamespaces, k8sClient); err != nil {
+		return nil, err
+	}
+
+	originalFamily := family
+	count, ok := taskCount[family]
+	if !ok {
+		count = 1
+		deploySpec.Name = originalFamily
+		deploySpec.Labels["app"] = originalFamily
+		deploySpec.Spec.Template.Labels["app"] = originalFamily
+	} else {
+		// Update deploy spec to reflect multiple count of the same task
+		count += 1
+		family = family + "-" + strconv.Itoa(count)
+		deploySpec.Name = family
+		deploySpec.Labels["app"] = family
+		deploySpec.Spec.Template.Labels["app"] = family
+	}
+
+	if deploySpec.Spec.Selector != nil {
+		deploySpec.Spec.Selector.MatchLabels = deploySpec.Spec.Template.Labels
+	}
+	taskCount[originalFamily] = count
+
+	// Assigning Pods to Nodes
+	nodeSelector := map[string]string{}
+	log.Infof("Selecting node %d for deployment %s", mapping.Id, family)
+	nodeSelector["hyperpilot/node-id"] = strconv.Itoa(mapping.Id)
+	nodeSelector["hyperpilot/deployment"] = deployment.Name
+
+	deploySpec.Spec.Template.Spec.NodeSelector = nodeSelector
+
+	// Replicas the task was scaled to are split across the deployments of its node mappings
+	if replicas, ok := deployment.KubernetesDeployment.TaskReplicas[originalFamily]; ok {
+		split := apis.SplitReplicas(int(replicas), deployment.TaskMappingCount(originalFamily))
+		mappingReplicas := int32(split[count-1])
+		deploySpec.Spec.Replicas = &mappingReplicas
+	}
+
+	servicemapping := ServiceMapping{
+		NodeId:   mapping.Id,
+		Replicas: 1,
+	}
+	if deploySpec.Spec.Replicas != nil {
+		servicemapping.Replicas = *deploySpec.Spec.Replicas
+	}
+	serviceMappings[family] = servicemapping
+	// Create service for each container that opens a port
+	for _, container := range deploySpec.Spec.Template.Spec.Containers {
+		err := CreateServiceForDeployment(
+			namespace,
+			family,
+			family,
+			k8sClient,
+			task,
+			container,
+			log,
+			skipCreatePublicService,
+			false,
+			ingress)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create service for deployment %s: %s", family, err.Error())
 		}
-		if err := CreateNamespaceIfNotExist(namespace, existingNamespaces, k8sClient); err != nil {
-			return serviceMappings, err
+	}
+
+	for _, mount := range deploySpec.Spec.Template.Spec.Volumes {
+		if mount.HostPath != nil && strings.HasPrefix(mount.HostPath.Path, "~/") {
+			mount.HostPath.Path = strings.Replace(mount.HostPath.Path, "~/", "/home/"+userName+"/", 1)
 		}
+	}
+
+	deploy := k8sClient.Extensions().Deployments(namespace)
+	if _, err := deploy.Create(deploySpec); err != nil {
+		return nil, fmt.Errorf("Unable to create k8s deployment: %s", err)
+	}
+	log.Infof("%s deployment created", family)
+
+	return &deployedObject{Kind: "Deployment", Name: family, Namespace: namespace}, nil
+}
+
+func deployDaemonSetTask(
+	k8sClient *k8s.Clientset,
+	task apis.KubernetesTask,
+	deployNamespace string,
+	existingNamespaces map[string]bool,
+	log *logging.Logger) (*deployedObject, error) {
+	daemonSet := task.DaemonSet
+	namespace := GetNamespace(daemonSet.ObjectMeta)
+	if deployNamespace != "" {
+		namespace = deployNamespace
+	}
+	if err := CreateNamespaceIfNotExist(namespace, existingNamespaces, k8sClient); err != nil {
+		return nil, err
+	}
+
+	daemonSets := k8sClient.Extensions().DaemonSets(namespace)
+	log.Infof("Creating daemonset %s", task.Family)
+	if _, err := daemonSets.Create(daemonSet); err != nil {
+		return nil, fmt.Errorf("Unable to create daemonset %s: %s", task.Family, err.Error())
+	}
+
+	return &deployedObject{Kind: "DaemonSet", Name: daemonSet.Name, Namespace: namespace}, nil
+}
+
+func deployStatefulSetTask(
+	k8sClient *k8s.Clientset,
+	task apis.KubernetesTask,
+	deployNamespace string,
+	existingNamespaces map[string]bool,
+	ingress *apis.IngressDefinition,
+	log *logging.Logger) (*deployedObject, error) {
+	statefulSet := task.StatefulSet
+	namespace := GetNamespace(statefulSet.ObjectMeta)
+	if deployNamespace != "" {
+		namespace = deployNamespace
+	}
+	if err := CreateNamespaceIfNotExist(namespace, existingNamespaces, k8sClient); err != nil {
+		return nil, err
+	}
+
+	statefulSets := k8sClient.StatefulSets(namespace)
+	log.Infof("Creating statefulset %s", task.Family)
+	if _, err := statefulSets.Create(statefulSet); err != nil {
+		return nil, fmt.Errorf("Unable to create statefulset %s: %s", task.Family, err.Error())
+	}
 
-		daemonSets := k8sClient.Extensions().DaemonSets(namespace)
-		log.Infof("Creating daemonset %s", task.Family)
-		if _, err := daemonSets.Create(daemonSet); err != nil {
-			return serviceMappings, fmt.Errorf("Unable to create daemonset %s: %s", task.Family, err.Error())
+	for i := int32(0); i < *task.StatefulSet.Spec.Replicas; i++ {
+		for _, container := range task.StatefulSet.Spec.Template.Spec.Containers {
+			err := CreateServiceForDeployment(
+				namespace,
+				task.Family+"-"+strconv.Itoa(int(i)),
+				task.Family,
+				k8sClient,
+				task,
+				container,
+				log,
+				false,
+				true,
+				ingress)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to create service for stateful set: " + err.Error())
+			}
 		}
 	}
 
-	// Run statefulsets
-	for _, task := range deployment.KubernetesDeployment.Kubernetes {
-		if task.StatefulSet == nil {
-			continue
+	return &deployedObject{Kind: "StatefulSet", Name: statefulSet.Name, Namespace: namespace}, nil
+}
+
+// waitUntilObjectsReady waits until all pods of the given deployed objects are ready
+func waitUntilObjectsReady(
+	k8sClient *k8s.Clientset,
+	objects []deployedObject,
+	timeout time.Duration,
+	log *logging.Logger) error {
+	scheduleDeadline := time.Now().Add(daemonSetScheduleTimeout)
+	return funcs.LoopUntil(timeout, time.Second*10, func() (bool, error) {
+		for _, object := range objects {
+			ready, err := isObjectReady(k8sClient, object, scheduleDeadline)
+			if err != nil {
+				return false, err
+			}
+
+			if !ready {
+				log.Infof("%s %s is not ready yet", object.Kind, object.Name)
+				return false, nil
+			}
 		}
 
-		statefulSet := task.StatefulSet
-		namespace := GetNamespace(statefulSet.ObjectMeta)
-		if deployNamespace != "" {
-			namespace = deployNamespace
+		return true, nil
+	})
+}
+
+// isObjectReady return true when all pods of the object are ready. Daemonsets not scheduled on
+// any node by the schedule deadline are reported as an error.
+func isObjectReady(k8sClient *k8s.Clientset, object deployedObject, scheduleDeadline time.Time) (bool, error) {
+	switch object.Kind {
+	case "Deployment":
+		deploy, err := k8sClient.Extensions().Deployments(object.Namespace).Get(object.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("Unable to get deployment %s: %s", object.Name, err.Error())
 		}
-		if err := CreateNamespaceIfNotExist(namespace, existingNamespaces, k8sClient); err != nil {
-			return serviceMappings, err
+		replicas := int32(1)
+		if deploy.Spec.Replicas != nil {
+			replicas = *deploy.Spec.Replicas
 		}
-
-		statefulSets := k8sClient.StatefulSets(namespace)
-		log.Infof("Creating statefulset %s", task.Family)
-		if _, err := statefulSets.Create(statefulSet); err != nil {
-			return serviceMappings, fmt.Errorf("Unable to create statefulset %s: %s", task.Family, err.Error())
-		}
-
-		for i := int32(0); i < *task.StatefulSet.Spec.Replicas; i++ {
-			for _, container := range task.StatefulSet.Spec.Template.Spec.Containers {
-				err := CreateServiceForDeployment(
-					namespace,
-					task.Family+"-"+strconv.Itoa(int(i)),
-					task.Family,
-					k8sClient,
-					task,
-					container,
-					log,
-					false,
-					true)
-				if err != nil {
-					return serviceMappings, fmt.Errorf("Unable to create service for stateful set: " + err.Error())
-				}
-			}
+		return deploy.Status.ReadyReplicas >= replicas, nil
+	case "DaemonSet":
+		daemonSet, err := k8sClient.Extensions().DaemonSets(object.Namespace).Get(object.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("Unable to get daemonset %s: %s", object.Name, err.Error())
+		}
+		desired := daemonSet.Status.DesiredNumberScheduled
+		if desired == 0 && time.Now().After(scheduleDeadline) {
+			return false, fmt.Errorf("Daemonset %s is not scheduled on any node, check its node selector", object.Name)
 		}
+		return desired > 0 && daemonSet.Status.NumberReady >= desired, nil
+	case "StatefulSet":
+		statefulSet, err := k8sClient.StatefulSets(object.Namespace).Get(object.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("Unable to get statefulset %s: %s", object.Name, err.Error())
+		}
+		replicas := int32(1)
+		if statefulSet.Spec.Replicas != nil {
+			replicas = *statefulSet.Spec.Replicas
+		}
+		return statefulSet.Status.ReadyReplicas >= replicas, nil
 	}
 
-	return serviceMappings, nil
+	return false, errors.New("Unsupported object kind: " + object.Kind)
 }
 
 func checkDeploymentReadyReplicas(
@@ -441,7 +621,8 @@ func CreateServiceForDeployment(
 	container v1.Container,
 	log *logging.Logger,
 	skipCreatePublicService bool,
-	internalHeadlessService bool) error {
+	internalHeadlessService bool,
+	ingress *apis.IngressDefinition) error {
 	if len(container.Ports) == 0 {
 		return nil
 	}
@@ -454,13 +635,38 @@ func CreateServiceForDeployment(
 	}
 
 	servicePorts := []v1.ServicePort{}
+	exposures := []apis.PortExposure{}
+	sessionAffinity := v1.ServiceAffinityNone
 	for i, port := range container.Ports {
+		exposure, explicit := task.GetPortExposure(i)
+		// Public ports only set through PortTypes are not exposed when public services are skipped
+		if !explicit && skipCreatePublicService {
+			exposure.Type = apis.ExposureClusterIP
+		}
+		exposures = append(exposures, exposure)
+
 		newPort := v1.ServicePort{
 			Port:       port.HostPort,
 			TargetPort: intstr.FromInt(int(port.ContainerPort)),
+			Protocol:   v1.ProtocolTCP,
 			Name:       "port" + strconv.Itoa(i),
 		}
+		if exposure.ServicePort != 0 {
+			newPort.Port = exposure.ServicePort
+		} else if newPort.Port == 0 {
+			newPort.Port = port.ContainerPort
+		}
+		if exposure.Protocol != "" {
+			newPort.Protocol = v1.Protocol(strings.ToUpper(exposure.Protocol))
+		}
 		servicePorts = append(servicePorts, newPort)
+
+		if exposure.Type == apis.ExposureHeadless {
+			internalHeadlessService = true
+		}
+		if exposure.SessionAffinity == string(v1.ServiceAffinityClientIP) {
+			sessionAffinity = v1.ServiceAffinityClientIP
+		}
 	}
 
 	clusterIp := ""
@@ -475,10 +681,11 @@ func CreateServiceForDeployment(
 			Namespace: namespace,
 		},
 		Spec: v1.ServiceSpec{
-			Type:      v1.ServiceTypeClusterIP,
-			ClusterIP: clusterIp,
-			Ports:     servicePorts,
-			Selector:  labels,
+			Type:            v1.ServiceTypeClusterIP,
+			ClusterIP:       clusterIp,
+			Ports:           servicePorts,
+			Selector:        labels,
+			SessionAffinity: sessionAffinity,
 		},
 	}
 	_, err := service.Create(internalService)
@@ -487,44 +694,79 @@ func CreateServiceForDeployment(
 	}
 	log.Infof("Created %s internal service", serviceName)
 
-	// Check the type of each port opened by the container; create a loadbalancer service to expose the public port
-	if skipCreatePublicService || task.PortTypes == nil || len(task.PortTypes) == 0 {
-		return nil
-	}
-
-	for i, portType := range task.PortTypes {
-		if portType != publicPortType {
-			log.Infof("Skipping creating public endpoint for service %s as it's marked as private", serviceName)
+	// Route load balancer ports through the shared ingress controller instead of a load balancer per port
+	ingressPorts := []int{}
+	for i, exposure := range exposures {
+		if !exposure.IsPublic() {
 			continue
 		}
-		// public port
-		publicServiceName := serviceName + "-public" + servicePorts[i].Name
-		publicService := &v1.Service{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      publicServiceName,
-				Labels:    labels,
-				Namespace: namespace,
-			},
-			Spec: v1.ServiceSpec{
-				Type: v1.ServiceTypeLoadBalancer,
-				Ports: []v1.ServicePort{
-					v1.ServicePort{
-						Port:       servicePorts[i].Port,
-						TargetPort: servicePorts[i].TargetPort,
-						Name:       "public-" + servicePorts[i].Name,
-					},
-				},
-				Selector: labels,
-			},
+
+		if exposure.Type == apis.ExposureLoadBalancer && ingress != nil {
+			ingressPorts = append(ingressPorts, i)
+			continue
 		}
-		_, err := service.Create(publicService)
-		if err != nil {
-			return fmt.Errorf("Unable to create public service %s: %s", publicServiceName, err)
+
+		if err := createPublicService(service, namespace, serviceName, labels, servicePorts[i], exposure, log); err != nil {
+			return err
 		}
+	}
 
-		log.Infof("Created a public service %s with port %d", publicServiceName, servicePorts[i].Port)
+	if len(ingressPorts) > 0 {
+		return createIngressForService(k8sClient, namespace, serviceName, labels, task, servicePorts, ingressPorts, ingress, log)
+	}
+
+	return nil
+}
+
+// createPublicService creates a NodePort or LoadBalancer service exposing a single port of a service
+func createPublicService(
+	service corev1.ServiceInterface,
+	namespace string,
+	serviceName string,
+	labels map[string]string,
+	servicePort v1.ServicePort,
+	exposure apis.PortExposure,
+	log *logging.Logger) error {
+	publicServiceName := serviceName + "-public" + servicePort.Name
+	serviceType := v1.ServiceTypeLoadBalancer
+	if exposure.Type == apis.ExposureNodePort {
+		publicServiceName = serviceName + "-nodeport" + servicePort.Name
+		serviceType = v1.ServiceTypeNodePort
+	}
+
+	sessionAffinity := v1.ServiceAffinityNone
+	if exposure.SessionAffinity == string(v1.ServiceAffinityClientIP) {
+		sessionAffinity = v1.ServiceAffinityClientIP
+	}
+
+	publicService := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      publicServiceName,
+			Labels:    labels,
+			Namespace: namespace,
+		},
+		Spec: v1.ServiceSpec{
+			Type: serviceType,
+			Ports: []v1.ServicePort{
+				v1.ServicePort{
+					Port:       servicePort.Port,
+					TargetPort: servicePort.TargetPort,
+					Protocol:   servicePort.Protocol,
+					NodePort:   exposure.NodePort,
+					Name:       "public-" + servicePort.Name,
+				},
+			},
+			Selector:                 labels,
+			SessionAffinity:          sessionAffinity,
+			LoadBalancerSourceRanges: exposure.SourceRanges,
+		},
+	}
+	if _, err := service.Create(publicService); err != nil {
+		return fmt.Errorf("Unable to create public service %s: %s", publicServiceName, err)
 	}
 
+	log.Infof("Created a %s public service %s with port %d", serviceType, publicServiceName, servicePort.Port)
+
 	return nil
 }
 
@@ -619,6 +861,19 @@ func DeleteK8S(namespaces []string, kubeConfig *rest.Config, log *logging.Logger
 				namespace, listError.Error())
 		}
 
+		ingresses := k8sClient.Extensions().Ingresses(namespace)
+		if ingressList, listError := ingresses.List(metav1.ListOptions{}); listError == nil {
+			for _, ingress := range ingressList.Items {
+				name := ingress.GetObjectMeta().GetName()
+				if err := ingresses.Delete(name, &metav1.DeleteOptions{}); err != nil {
+					log.Warningf("Unable to delete ingress %s: %s", name, err.Error())
+				}
+			}
+		} else {
+			return fmt.Errorf("Unable to list ingresses in namespace '%s' for deletion: \n%s",
+				namespace, listError.Error())
+		}
+
 		statefulSets := k8sClient.StatefulSets(namespace)
 		if statefulSetsList, listError := statefulSets.List(metav1.ListOptions{}); listError == nil {
 			for _, statefulSet := range statefulSetsList.Items {
@@ -674,6 +929,10 @@ func GetAllDeployedNamespaces(deployment *apis.Deployment) []string {
 		}
 	}
 
+	if deployment.KubernetesDeployment.Ingress != nil {
+		allNamespaces = append(allNamespaces, IngressNamespace)
+	}
+
 	return allNamespaces
 }
 
@@ -697,6 +956,7 @@ func TagKubeNodes(
 		if node, err := k8sClient.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{}); err == nil {
 			node.Labels["hyperpilot/node-id"] = strconv.Itoa(nodeInfo.Id)
 			node.Labels["hyperpilot/deployment"] = deploymentName
+			node.Labels["hyperpilot/instance-type"] = nodeInfo.InstanceType
 			for key, value := range nodeInfo.Labels {
 				node.Labels[key] = value
 			}
@@ -704,6 +964,7 @@ func TagKubeNodes(
 			if _, err := k8sClient.CoreV1().Nodes().Update(node); err == nil {
 				log.Infof("Added label hyperpilot/node-id:%s to Kubernetes node %s", strconv.Itoa(nodeInfo.Id), nodeName)
 				log.Infof("Added label hyperpilot/deployment:%s to Kubernetes node %s", deploymentName, nodeName)
+				log.Infof("Added label hyperpilot/instance-type:%s to Kubernetes node %s", nodeInfo.InstanceType, nodeName)
 			}
 		} else {
 			return fmt.Errorf("Unable to get Kubernetes node by name %s: %s", nodeName, err.Error())
@@ -836,3 +1097,34 @@ func WaitUntilKubernetesNodeExists(
 		return false, nil
 	})
 }
+
+// DeleteKubeNodes removes the given nodes from kubernetes, so pods are no longer scheduled to them
+func DeleteKubeNodes(k8sClient *k8s.Clientset, nodeNames []string, log *logging.Logger) error {
+	for _, nodeName := range nodeNames {
+		if err := k8sClient.CoreV1().Nodes().Delete(nodeName, &metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("Unable to delete Kubernetes node %s: %s", nodeName, err.Error())
+		}
+		log.Infof("Deleted Kubernetes node %s", nodeName)
+	}
+
+	return nil
+}
+
+// GetKubeNodeNames finds the names of the kubernetes nodes labeled with the given node ids of a deployment
+func GetKubeNodeNames(k8sClient *k8s.Clientset, deploymentName string, nodeIds []int) (map[int]string, error) {
+	nodeNames := map[int]string{}
+	for _, nodeId := range nodeIds {
+		selector := "hyperpilot/deployment=" + deploymentName + ",hyperpilot/node-id=" + strconv.Itoa(nodeId)
+		nodes, err := k8sClient.CoreV1().Nodes().List(metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list Kubernetes nodes: %s", err.Error())
+		}
+
+		if len(nodes.Items) == 0 {
+			return nil, fmt.Errorf("Unable to find Kubernetes node with node id %d", nodeId)
+		}
+		nodeNames[nodeId] = nodes.Items[0].Name
+	}
+
+	return nodeNames, nil
+}
@@ -0,0 +1,74 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/hyperpilotio/deployer/common"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// CollectDiagnostics adds the pods and events of the namespace, all of them when empty, and
+// the node conditions of the cluster to the bundle
+func CollectDiagnostics(k8sClient *k8s.Clientset, namespace string, diagnostics *common.Diagnostics) error {
+	// Pods are kept whole, as they hold everything kubectl describe shows besides events
+	if pods, err := k8sClient.CoreV1().Pods(namespace).List(metav1.ListOptions{}); err != nil {
+		diagnostics.AddError("kubernetes/pods.json", err)
+	} else if content, err := json.MarshalIndent(pods, "", "  "); err != nil {
+		diagnostics.AddError("kubernetes/pods.json", err)
+	} else if err := diagnostics.AddFile("kubernetes/pods.json", content); err != nil {
+		return err
+	}
+
+	if events, err := k8sClient.CoreV1().Events(namespace).List(metav1.ListOptions{}); err != nil {
+		diagnostics.AddError("kubernetes/events.txt", err)
+	} else {
+		items := events.Items
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].LastTimestamp.Time.Before(items[j].LastTimestamp.Time)
+		})
+
+		var buffer bytes.Buffer
+		writer := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "LAST SEEN\tNAMESPACE\tOBJECT\tTYPE\tREASON\tCOUNT\tMESSAGE")
+		for _, event := range items {
+			fmt.Fprintf(writer, "%s\t%s\t%s/%s\t%s\t%s\t%d\t%s\n",
+				event.LastTimestamp.UTC().Format("2006-01-02T15:04:05Z"), event.Namespace,
+				event.InvolvedObject.Kind, event.InvolvedObject.Name,
+				event.Type, event.Reason, event.Count, event.Message)
+		}
+		writer.Flush()
+
+		if err := diagnostics.AddFile("kubernetes/events.txt", buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if nodes, err := k8sClient.CoreV1().Nodes().List(metav1.ListOptions{}); err != nil {
+		diagnostics.AddError("kubernetes/nodes.txt", err)
+	} else {
+		var buffer bytes.Buffer
+		writer := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "NODE\tNODE ID\tCONDITION\tSTATUS\tLAST TRANSITION\tREASON\tMESSAGE")
+		for _, node := range nodes.Items {
+			for _, condition := range node.Status.Conditions {
+				fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					node.Name, node.Labels["hyperpilot/node-id"], condition.Type, condition.Status,
+					condition.LastTransitionTime.UTC().Format("2006-01-02T15:04:05Z"),
+					condition.Reason, condition.Message)
+			}
+		}
+		writer.Flush()
+
+		if err := diagnostics.AddFile("kubernetes/nodes.txt", buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperpilotio/deployer/apis"
+	logging "github.com/op/go-logging"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+const (
+	IngressNamespace = "hyperpilot-ingress"
+
+	ingressControllerName         = "ingress-controller"
+	ingressServiceAccountName     = "ingress-controller"
+	ingressClusterRoleName        = "hyperpilot-ingress-controller"
+	ingressDefaultBackendName     = "default-http-backend"
+	defaultIngressControllerImage = "gcr.io/google_containers/nginx-ingress-controller:0.9.0-beta.15"
+	ingressDefaultBackendImage    = "gcr.io/google_containers/defaultbackend:1.4"
+)
+
+// DeployIngressController deploys the nginx ingress controller shared by all services of a deployment,
+// exposed through a single load balancer service
+func DeployIngressController(
+	k8sClient *k8s.Clientset,
+	ingress *apis.IngressDefinition,
+	existingNamespaces map[string]bool,
+	log *logging.Logger) error {
+	if err := CreateNamespaceIfNotExist(IngressNamespace, existingNamespaces, k8sClient); err != nil {
+		return err
+	}
+
+	if err := createIngressServiceAccount(k8sClient, log); err != nil {
+		return err
+	}
+
+	controllerImage := ingress.ControllerImage
+	if controllerImage == "" {
+		controllerImage = defaultIngressControllerImage
+	}
+
+	backendContainer := v1.Container{
+		Name:  ingressDefaultBackendName,
+		Image: ingressDefaultBackendImage,
+		Ports: []v1.ContainerPort{
+			v1.ContainerPort{ContainerPort: 8080},
+		},
+	}
+
+	controllerContainer := v1.Container{
+		Name:  ingressControllerName,
+		Image: controllerImage,
+		Args: []string{
+			"/nginx-ingress-controller",
+			"--default-backend-service=" + IngressNamespace + "/" + ingressDefaultBackendName,
+		},
+		Env: []v1.EnvVar{
+			v1.EnvVar{
+				Name: "POD_NAME",
+				ValueFrom: &v1.EnvVarSource{
+					FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"},
+				},
+			},
+			v1.EnvVar{
+				Name: "POD_NAMESPACE",
+				ValueFrom: &v1.EnvVarSource{
+					FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+				},
+			},
+		},
+		Ports: []v1.ContainerPort{
+			v1.ContainerPort{ContainerPort: 80},
+		},
+	}
+
+	if err := createIngressDeployment(k8sClient, backendContainer, ""); err != nil {
+		return err
+	}
+
+	if err := createIngressDeployment(k8sClient, controllerContainer, ingressServiceAccountName); err != nil {
+		return err
+	}
+
+	services := k8sClient.CoreV1().Services(IngressNamespace)
+	backendService := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ingressDefaultBackendName,
+			Namespace: IngressNamespace,
+		},
+		Spec: v1.ServiceSpec{
+			Type: v1.ServiceTypeClusterIP,
+			Ports: []v1.ServicePort{
+				v1.ServicePort{Port: 80, TargetPort: intstr.FromInt(8080)},
+			},
+			Selector: map[string]string{"app": ingressDefaultBackendName},
+		},
+	}
+	if _, err := services.Create(backendService); err != nil {
+		return fmt.Errorf("Unable to create ingress default backend service: %s", err.Error())
+	}
+
+	controllerService := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ingressControllerName,
+			Namespace: IngressNamespace,
+		},
+		Spec: v1.ServiceSpec{
+			Type: v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{
+				v1.ServicePort{Name: "http", Port: 80, TargetPort: intstr.FromInt(80)},
+			},
+			Selector: map[string]string{"app": ingressControllerName},
+		},
+	}
+	if _, err := services.Create(controllerService); err != nil {
+		return fmt.Errorf("Unable to create ingress controller service: %s", err.Error())
+	}
+	log.Infof("Deployed ingress controller with image %s", controllerImage)
+
+	return nil
+}
+
+// createIngressServiceAccount creates the service account of the ingress controller, bound to
+// a cluster role that only reads what the controller watches across namespaces
+func createIngressServiceAccount(k8sClient *k8s.Clientset, log *logging.Logger) error {
+	serviceAccount := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ingressServiceAccountName,
+			Namespace: IngressNamespace,
+		},
+	}
+	if _, err := k8sClient.CoreV1().ServiceAccounts(IngressNamespace).Create(serviceAccount); err != nil {
+		return fmt.Errorf("Unable to create ingress service account: %s", err.Error())
+	}
+
+	clusterRole := &rbac.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: ingressClusterRoleName},
+		Rules: []rbac.PolicyRule{
+			rbac.PolicyRule{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps", "endpoints", "nodes", "pods", "secrets", "services"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
+			rbac.PolicyRule{
+				APIGroups: []string{"extensions"},
+				Resources: []string{"ingresses"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
+			rbac.PolicyRule{
+				APIGroups: []string{"extensions"},
+				Resources: []string{"ingresses/status"},
+				Verbs:     []string{"update"},
+			},
+			rbac.PolicyRule{
+				APIGroups: []string{""},
+				Resources: []string{"events"},
+				Verbs:     []string{"create", "patch"},
+			},
+			// The controller elects its leader through a configmap of its namespace
+			rbac.PolicyRule{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+				Verbs:     []string{"create", "update"},
+			},
+		},
+	}
+	if _, err := k8sClient.RbacV1beta1().ClusterRoles().Create(clusterRole); err != nil {
+		log.Warningf("Unable to create ingress cluster role: %s", err.Error())
+	}
+
+	roleBinding := &rbac.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: ingressClusterRoleName},
+		RoleRef: rbac.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "ClusterRole",
+			Name:     ingressClusterRoleName,
+		},
+		Subjects: []rbac.Subject{
+			rbac.Subject{
+				Kind:      rbac.ServiceAccountKind,
+				Name:      ingressServiceAccountName,
+				Namespace: IngressNamespace,
+			},
+		},
+	}
+	if _, err := k8sClient.RbacV1beta1().ClusterRoleBindings().Create(roleBinding); err != nil {
+		log.Warningf("Unable to create ingress role binding: %s", err.Error())
+	}
+
+	return nil
+}
+
+func createIngressDeployment(k8sClient *k8s.Clientset, container v1.Container, serviceAccountName string) error {
+	labels := map[string]string{"app": container.Name}
+	replicas := int32(1)
+	deploySpec := &v1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      container.Name,
+			Namespace: IngressNamespace,
+			Labels:    labels,
+		},
+		Spec: v1beta1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					Containers:         []v1.Container{container},
+					ServiceAccountName: serviceAccountName,
+				},
+			},
+		},
+	}
+
+	if _, err := k8sClient.Extensions().Deployments(IngressNamespace).Create(deploySpec); err != nil {
+		return fmt.Errorf("Unable to create %s deployment: %s", container.Name, err.Error())
+	}
+
+	return nil
+}
+
+// ingressPath return the ingress path of the port at the given index of a task
+func ingressPath(task apis.KubernetesTask, serviceName string, index int) string {
+	if index < len(task.IngressPaths) && task.IngressPaths[index] != "" {
+		return task.IngressPaths[index]
+	}
+
+	return "/" + serviceName + "/port" + strconv.Itoa(index)
+}
+
+// createIngressForService creates an ingress routing the given public ports of a service
+// through the ingress controller
+func createIngressForService(
+	k8sClient *k8s.Clientset,
+	namespace string,
+	serviceName string,
+	labels map[string]string,
+	task apis.KubernetesTask,
+	servicePorts []v1.ServicePort,
+	publicPorts []int,
+	ingress *apis.IngressDefinition,
+	log *logging.Logger) error {
+	paths := []v1beta1.HTTPIngressPath{}
+	for _, i := range publicPorts {
+		paths = append(paths, v1beta1.HTTPIngressPath{
+			Path: ingressPath(task, serviceName, i),
+			Backend: v1beta1.IngressBackend{
+				ServiceName: serviceName,
+				ServicePort: intstr.FromInt(int(servicePorts[i].Port)),
+			},
+		})
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	ingressName := serviceName + "-ingress"
+	serviceIngress := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ingressName,
+			Namespace: namespace,
+			Labels:    labels,
+			Annotations: map[string]string{
+				"kubernetes.io/ingress.class":          "nginx",
+				"ingress.kubernetes.io/rewrite-target": "/",
+			},
+		},
+		Spec: v1beta1.IngressSpec{
+			Rules: []v1beta1.IngressRule{
+				v1beta1.IngressRule{
+					Host: ingress.Host,
+					IngressRuleValue: v1beta1.IngressRuleValue{
+						HTTP: &v1beta1.HTTPIngressRuleValue{Paths: paths},
+					},
+				},
+			},
+		},
+	}
+
+	if _, err := k8sClient.Extensions().Ingresses(namespace).Create(serviceIngress); err != nil {
+		return fmt.Errorf("Unable to create ingress %s: %s", ingressName, err.Error())
+	}
+
+	for _, path := range paths {
+		log.Infof("Exposed service %s port %s through ingress path %s",
+			serviceName, path.Backend.ServicePort.String(), path.Path)
+	}
+
+	return nil
+}
+
+// GetIngressPublicUrls return the public url of every service exposed through the ingress
+// controller, keyed by service name. It returns false when the ingress controller load
+// balancer is not ready yet.
+func GetIngressPublicUrls(k8sClient *k8s.Clientset, deployment *apis.Deployment) (map[string]string, bool, error) {
+	publicUrls := map[string]string{}
+	ingress := deployment.KubernetesDeployment.Ingress
+	if ingress == nil {
+		return publicUrls, true, nil
+	}
+
+	controllerService, err := k8sClient.CoreV1().Services(IngressNamespace).Get(ingressControllerName, metav1.GetOptions{})
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to get ingress controller service: %s", err.Error())
+	}
+
+	if len(controllerService.Status.LoadBalancer.Ingress) == 0 {
+		return publicUrls, false, nil
+	}
+
+	host := ingress.Host
+	if host == "" {
+		host = controllerService.Status.LoadBalancer.Ingress[0].Hostname
+		if host == "" {
+			host = controllerService.Status.LoadBalancer.Ingress[0].IP
+		}
+	}
+
+	for _, namespace := range GetAllDeployedNamespaces(deployment) {
+		ingresses, err := k8sClient.Extensions().Ingresses(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, false, fmt.Errorf("Unable to list ingresses in namespace '%s': %s", namespace, err.Error())
+		}
+
+		for _, serviceIngress := range ingresses.Items {
+			if !strings.HasSuffix(serviceIngress.Name, "-ingress") || len(serviceIngress.Spec.Rules) == 0 {
+				continue
+			}
+
+			http := serviceIngress.Spec.Rules[0].HTTP
+			if http == nil || len(http.Paths) == 0 {
+				continue
+			}
+
+			serviceName := strings.TrimSuffix(serviceIngress.Name, "-ingress")
+			publicUrls[serviceName] = host + http.Paths[0].Path
+		}
+	}
+
+	return publicUrls, true, nil
+}
@@ -0,0 +1,212 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperpilotio/deployer/apis"
+	logging "github.com/op/go-logging"
+	"github.com/spf13/viper"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// ScaleTask updates the replicas of the kubernetes objects created for a task, and records the
+// new replica count in the deployment manifest and the service mappings of the task
+func ScaleTask(
+	config *viper.Viper,
+	k8sClient *k8s.Clientset,
+	deployment *apis.Deployment,
+	deployNamespace string,
+	taskName string,
+	replicas int32,
+	serviceMappings map[string]ServiceMapping,
+	log *logging.Logger) error {
+	if replicas < 0 {
+		return fmt.Errorf("Invalid replicas %d", replicas)
+	}
+
+	for i, task := range deployment.KubernetesDeployment.Kubernetes {
+		if task.Family != taskName {
+			continue
+		}
+
+		var err error
+		if task.Deployment != nil {
+			err = scaleDeploymentTask(k8sClient, deployment, task, deployNamespace, replicas, serviceMappings, log)
+		} else if task.StatefulSet != nil {
+			err = scaleStatefulSetTask(config, k8sClient, deployment, task, deployNamespace, replicas, log)
+		} else {
+			return fmt.Errorf("Unable to scale task %s, only deployments and statefulsets can be scaled", taskName)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Keep the manifest in sync so the new replicas survive reloads and updates
+		if task.Deployment != nil {
+			if deployment.KubernetesDeployment.TaskReplicas == nil {
+				deployment.KubernetesDeployment.TaskReplicas = map[string]int32{}
+			}
+			deployment.KubernetesDeployment.TaskReplicas[taskName] = replicas
+		} else {
+			deployment.KubernetesDeployment.Kubernetes[i].StatefulSet.Spec.Replicas = &replicas
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("Unable to find task %s in task definitions", taskName)
+}
+
+func scaleDeploymentTask(
+	k8sClient *k8s.Clientset,
+	deployment *apis.Deployment,
+	task apis.KubernetesTask,
+	deployNamespace string,
+	replicas int32,
+	serviceMappings map[string]ServiceMapping,
+	log *logging.Logger) error {
+	namespace := GetNamespace(task.Deployment.ObjectMeta)
+	if deployNamespace != "" {
+		namespace = deployNamespace
+	}
+
+	// Deployments are named the same way as DeployServices names them from the sorted node mapping
+	sort.Sort(deployment.NodeMapping)
+	names := []string{}
+	for _, mapping := range deployment.NodeMapping {
+		if mapping.Task != task.Family {
+			continue
+		}
+
+		name := task.Family
+		if len(names) > 0 {
+			name = task.Family + "-" + strconv.Itoa(len(names)+1)
+		}
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return fmt.Errorf("Unable to find node mapping for task %s", task.Family)
+	}
+
+	// The replicas are of the whole task, so they're split across its deployments
+	split := apis.SplitReplicas(int(replicas), len(names))
+	deploys := k8sClient.Extensions().Deployments(namespace)
+	for i, name := range names {
+		deploy, err := deploys.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("Unable to get deployment %s: %s", name, err.Error())
+		}
+
+		replicas := int32(split[i])
+		deploy.Spec.Replicas = &replicas
+		if _, err := deploys.Update(deploy); err != nil {
+			return fmt.Errorf("Unable to update deployment %s replicas: %s", name, err.Error())
+		}
+		log.Infof("Scaled deployment %s to %d replicas", name, replicas)
+
+		if serviceMapping, ok := serviceMappings[name]; ok {
+			serviceMapping.Replicas = replicas
+			serviceMappings[name] = serviceMapping
+		}
+	}
+
+	return nil
+}
+
+func scaleStatefulSetTask(
+	config *viper.Viper,
+	k8sClient *k8s.Clientset,
+	deployment *apis.Deployment,
+	task apis.KubernetesTask,
+	deployNamespace string,
+	replicas int32,
+	log *logging.Logger) error {
+	namespace := GetNamespace(task.StatefulSet.ObjectMeta)
+	if deployNamespace != "" {
+		namespace = deployNamespace
+	}
+
+	statefulSets := k8sClient.StatefulSets(namespace)
+	statefulSet, err := statefulSets.Get(task.StatefulSet.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("Unable to get statefulset %s: %s", task.StatefulSet.Name, err.Error())
+	}
+
+	currentReplicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		currentReplicas = *statefulSet.Spec.Replicas
+	}
+
+	statefulSet.Spec.Replicas = &replicas
+	if _, err := statefulSets.Update(statefulSet); err != nil {
+		return fmt.Errorf("Unable to update statefulset %s replicas: %s", task.StatefulSet.Name, err.Error())
+	}
+	log.Infof("Scaled statefulset %s from %d to %d replicas", task.StatefulSet.Name, currentReplicas, replicas)
+
+	// Every statefulset pod has its own service, so add or remove them with the pods
+	var ingress *apis.IngressDefinition
+	if !skipPublicServices(config, deployment) {
+		ingress = deployment.KubernetesDeployment.Ingress
+	}
+
+	for i := currentReplicas; i < replicas; i++ {
+		for _, container := range task.StatefulSet.Spec.Template.Spec.Containers {
+			err := CreateServiceForDeployment(
+				namespace,
+				task.Family+"-"+strconv.Itoa(int(i)),
+				task.Family,
+				k8sClient,
+				task,
+				container,
+				log,
+				false,
+				true,
+				ingress)
+			if err != nil {
+				return errors.New("Unable to create service for stateful set: " + err.Error())
+			}
+		}
+	}
+
+	services := k8sClient.CoreV1().Services(namespace)
+	for i := replicas; i < currentReplicas; i++ {
+		serviceName := task.Family + "-" + strconv.Itoa(int(i))
+		serviceList, err := services.List(metav1.ListOptions{LabelSelector: "name=" + serviceName})
+		if err != nil {
+			log.Warningf("Unable to list services of %s: %s", serviceName, err.Error())
+			continue
+		}
+
+		// Public services of the pod share the labels of its internal service
+		for _, service := range serviceList.Items {
+			if err := services.Delete(service.Name, &metav1.DeleteOptions{}); err != nil {
+				log.Warningf("Unable to delete service %s: %s", service.Name, err.Error())
+			}
+		}
+
+		if ingress == nil {
+			continue
+		}
+
+		ingresses := k8sClient.Extensions().Ingresses(namespace)
+		ingressList, err := ingresses.List(metav1.ListOptions{LabelSelector: "name=" + serviceName})
+		if err != nil {
+			log.Warningf("Unable to list ingresses of %s: %s", serviceName, err.Error())
+			continue
+		}
+
+		for _, serviceIngress := range ingressList.Items {
+			if err := ingresses.Delete(serviceIngress.Name, &metav1.DeleteOptions{}); err != nil {
+				log.Warningf("Unable to delete ingress %s: %s", serviceIngress.Name, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,11 @@
 package aws
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"strings"
@@ -11,6 +16,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 
 	"github.com/golang/glog"
+	"github.com/hyperpilotio/deployer/clusters/hostkeys"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -39,6 +45,14 @@ type AWSCluster struct {
 	NodeInfos         map[int]*NodeInfo
 	InstanceIds       []*string
 	VpcId             string
+
+	// Subnets of the cluster by availability zone, nodes are launched in the private
+	// subnets when there are any
+	PublicSubnetIds  map[string]string
+	PrivateSubnetIds map[string]string
+
+	// HostKeys are the ssh host keys pinned for the nodes and bastions of the cluster
+	HostKeys *hostkeys.HostKeys `json:"-"`
 }
 
 func CreateSession(awsProfile *AWSProfile, region string) (*session.Session, error) {
@@ -71,12 +85,62 @@ func CreateKeypair(ec2Svc *ec2.EC2, name string) (*ec2.CreateKeyPairOutput, erro
 	return keyOutput, nil
 }
 
+// GenerateKeypair return a new key pair of the name with its public key in authorized keys
+// format, to be imported with ReplaceKeypair
+func GenerateKeypair(name string) (*ec2.CreateKeyPairOutput, string, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, "", errors.New("Unable to create private key: " + err.Error())
+	}
+
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, "", errors.New("Unable to create public key: " + err.Error())
+	}
+
+	keyMaterial := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	})
+
+	keyPair := &ec2.CreateKeyPairOutput{
+		KeyName:     aws.String(name),
+		KeyMaterial: aws.String(string(keyMaterial)),
+	}
+
+	return keyPair, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))), nil
+}
+
+// ReplaceKeypair replaces the key pair of the same name with the generated one, so nodes
+// launched later get its public key
+func ReplaceKeypair(ec2Svc *ec2.EC2, keyPair *ec2.CreateKeyPairOutput, publicKey string) error {
+	if _, err := ec2Svc.DeleteKeyPair(&ec2.DeleteKeyPairInput{
+		KeyName: keyPair.KeyName,
+	}); err != nil {
+		return errors.New("Unable to delete key pair: " + err.Error())
+	}
+
+	output, err := ec2Svc.ImportKeyPair(&ec2.ImportKeyPairInput{
+		KeyName:           keyPair.KeyName,
+		PublicKeyMaterial: []byte(publicKey),
+	})
+	if err != nil {
+		return errors.New("Unable to import key pair: " + err.Error())
+	}
+	keyPair.KeyFingerprint = output.KeyFingerprint
+
+	return nil
+}
+
 func NewAWSCluster(name string, region string) *AWSCluster {
 	return &AWSCluster{
-		Name:        name,
-		Region:      region,
-		NodeInfos:   make(map[int]*NodeInfo),
-		InstanceIds: make([]*string, 0),
+		Name:             name,
+		Region:           region,
+		NodeInfos:        make(map[int]*NodeInfo),
+		InstanceIds:      make([]*string, 0),
+		PublicSubnetIds:  make(map[string]string),
+		PrivateSubnetIds: make(map[string]string),
+		HostKeys:         hostkeys.New(),
 	}
 }
 
@@ -99,6 +163,11 @@ func (awsCluster *AWSCluster) RoleName() string {
 	return awsCluster.Name + "-role"
 }
 
+// ExecutionRoleName return a role name according to the Name with suffix "-execution-role"
+func (awsCluster *AWSCluster) ExecutionRoleName() string {
+	return awsCluster.Name + "-execution-role"
+}
+
 // VPCName return a key name according to the Name with suffix "-vpc"
 func (awsCluster AWSCluster) VPCName() string {
 	return awsCluster.Name + "-vpc"
@@ -130,11 +199,71 @@ func (awsCluster AWSCluster) SshConfig(user string) (*ssh.ClientConfig, error) {
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(signer),
 		},
+		HostKeyCallback: awsCluster.HostKeys.Callback(),
 	}
 
 	return clientConfig, nil
 }
 
+func (awsCluster *AWSCluster) GetHostKeys() *hostkeys.HostKeys {
+	return awsCluster.HostKeys
+}
+
+// PinConsoleHostKeys forgets the host keys pinned for the addresses of new nodes, which can be
+// reused from removed instances, and pins the keys the nodes print to their console output.
+// Nodes without keys in their console output yet are trusted on first connection.
+func (awsCluster *AWSCluster) PinConsoleHostKeys(nodeInfos map[int]*NodeInfo) {
+	for _, nodeInfo := range nodeInfos {
+		if nodeInfo.Instance == nil {
+			continue
+		}
+		awsCluster.HostKeys.Forget(nodeInfo.PrivateIp, nodeInfo.PublicDnsName,
+			aws.StringValue(nodeInfo.Instance.PublicIpAddress))
+	}
+
+	sess, err := CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if err != nil {
+		glog.Warningf("Unable to create session to get console output: %s", err.Error())
+		return
+	}
+	ec2Svc := ec2.New(sess)
+
+	for nodeId, nodeInfo := range nodeInfos {
+		if nodeInfo.Instance == nil {
+			continue
+		}
+
+		output, err := ec2Svc.GetConsoleOutput(&ec2.GetConsoleOutputInput{
+			InstanceId: nodeInfo.Instance.InstanceId,
+		})
+		if err != nil {
+			glog.Warningf("Unable to get console output of node %d: %s", nodeId, err.Error())
+			continue
+		}
+
+		consoleOutput, err := base64.StdEncoding.DecodeString(aws.StringValue(output.Output))
+		if err != nil {
+			glog.Warningf("Unable to decode console output of node %d: %s", nodeId, err.Error())
+			continue
+		}
+
+		keys := hostkeys.ParseConsoleHostKeys(string(consoleOutput))
+		if len(keys) == 0 {
+			continue
+		}
+
+		hosts := []string{nodeInfo.PrivateIp, nodeInfo.PublicDnsName, aws.StringValue(nodeInfo.Instance.PublicIpAddress)}
+		for _, host := range hosts {
+			if host == "" {
+				continue
+			}
+			if err := awsCluster.HostKeys.Pin(host, keys); err != nil {
+				glog.Warningf("Unable to pin host keys of node %d: %s", nodeId, err.Error())
+			}
+		}
+	}
+}
+
 // ReloadKeyPair reload KeyPair by keyName
 func (awsCluster *AWSCluster) ReloadKeyPair(keyMaterial string) error {
 	awsProfile := awsCluster.AWSProfile
@@ -0,0 +1,456 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperpilotio/deployer/apis"
+	"github.com/hyperpilotio/go-utils/funcs"
+	logging "github.com/op/go-logging"
+	"github.com/spf13/viper"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// PeeringProfile return the aws profile accepting peering connections in the target account.
+// Named profiles are configured under peeringProfiles in the deployer config, the deployer
+// credentials are used when no name is given.
+func PeeringProfile(config *viper.Viper, name string) (*AWSProfile, error) {
+	if name == "" {
+		return &AWSProfile{
+			AwsId:     config.GetString("awsId"),
+			AwsSecret: config.GetString("awsSecret"),
+		}, nil
+	}
+
+	key := "peeringProfiles." + name
+	if !config.IsSet(key) {
+		return nil, errors.New("Unable to find peering profile " + name)
+	}
+
+	return &AWSProfile{
+		AwsId:     config.GetString(key + ".awsId"),
+		AwsSecret: config.GetString(key + ".awsSecret"),
+	}, nil
+}
+
+// SetupClusterVpcPeering peers the VPC of the cluster with the target VPC of the deployment,
+// accepted with the target profile named in it, and opens the given security groups to it.
+// The returned status is nil when no peering connection was created, otherwise it has to be
+// kept, also along with an error, for the peering to be deleted with the deployment.
+func SetupClusterVpcPeering(
+	config *viper.Viper,
+	sess *session.Session,
+	awsCluster *AWSCluster,
+	peering *apis.VPCPeering,
+	securityGroupIds []string,
+	log *logging.Logger) (*apis.VPCPeeringStatus, error) {
+	targetProfile, err := PeeringProfile(config, peering.TargetProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := SetupVpcPeering(sess, targetProfile, peering, awsCluster.VpcId, securityGroupIds, log)
+	if status.ConnectionId == "" {
+		return nil, err
+	}
+
+	return status, err
+}
+
+// DeleteClusterVpcPeering deletes the peering connection of the status, with the target
+// profile named in the peering of the deployment
+func DeleteClusterVpcPeering(
+	config *viper.Viper,
+	sess *session.Session,
+	peering *apis.VPCPeering,
+	status *apis.VPCPeeringStatus,
+	log *logging.Logger) error {
+	targetProfileName := ""
+	if peering != nil {
+		targetProfileName = peering.TargetProfile
+	}
+
+	targetProfile, err := PeeringProfile(config, targetProfileName)
+	if err != nil {
+		return err
+	}
+
+	return DeleteVpcPeering(sess, targetProfile, peering, status, log)
+}
+
+// GetClusterVpcPeering return the current status of the peering connection of the cluster
+func GetClusterVpcPeering(awsCluster *AWSCluster, status *apis.VPCPeeringStatus) (*apis.VPCPeeringStatus, error) {
+	if status == nil {
+		return nil, errors.New("Deployment has no vpc peering")
+	}
+
+	sess, sessionErr := CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if sessionErr != nil {
+		return nil, errors.New("Unable to create session: " + sessionErr.Error())
+	}
+
+	current := *status
+	if err := DescribeVpcPeering(sess, &current); err != nil {
+		return nil, err
+	}
+
+	return &current, nil
+}
+
+// SetupVpcPeering peers the VPC with the target VPC, accepts the connection with the target
+// profile and routes the traffic of both VPCs through it, in the route tables of the peering or
+// every route table of the VPC when it names none. The given security groups and the target
+// security groups are opened to the peered VPC on the ports of the peering. The returned status records what was
+// set up, also along with an error, so it can be cleaned up by DeleteVpcPeering.
+func SetupVpcPeering(
+	sess *session.Session,
+	targetProfile *AWSProfile,
+	peering *apis.VPCPeering,
+	vpcId string,
+	securityGroupIds []string,
+	log *logging.Logger) (*apis.VPCPeeringStatus, error) {
+	region := aws.StringValue(sess.Config.Region)
+	targetRegion := peering.PeerRegion(region)
+	status := &apis.VPCPeeringStatus{
+		Provider:     apis.PeeringProviderAWS,
+		TargetRegion: targetRegion,
+	}
+
+	targetSess, err := CreateSession(targetProfile, targetRegion)
+	if err != nil {
+		return status, errors.New("Unable to create target session: " + err.Error())
+	}
+
+	ec2Svc := ec2.New(sess)
+	targetEc2Svc := ec2.New(targetSess)
+
+	createInput := &ec2.CreateVpcPeeringConnectionInput{
+		PeerOwnerId: aws.String(peering.TargetOwnerId),
+		PeerVpcId:   aws.String(peering.TargetVpcId),
+		VpcId:       aws.String(vpcId),
+	}
+	if targetRegion != region {
+		createInput.PeerRegion = aws.String(targetRegion)
+	}
+
+	createOutput, err := ec2Svc.CreateVpcPeeringConnection(createInput)
+	if err != nil {
+		return status, errors.New("Unable to peer vpc: " + err.Error())
+	}
+
+	connectionId := createOutput.VpcPeeringConnection.VpcPeeringConnectionId
+	status.ConnectionId = aws.StringValue(connectionId)
+	log.Infof("Created vpc peering connection %s to %s in %s", status.ConnectionId, peering.TargetVpcId, targetRegion)
+
+	// Cross region connections take a while to show up in the target region
+	if err := targetEc2Svc.WaitUntilVpcPeeringConnectionExists(&ec2.DescribeVpcPeeringConnectionsInput{
+		VpcPeeringConnectionIds: []*string{connectionId},
+	}); err != nil {
+		return status, errors.New("Unable to wait for vpc peering connection in target region: " + err.Error())
+	}
+
+	if _, err := targetEc2Svc.AcceptVpcPeeringConnection(&ec2.AcceptVpcPeeringConnectionInput{
+		VpcPeeringConnectionId: connectionId,
+	}); err != nil {
+		return status, errors.New("Unable to accept vpc peering connection: " + err.Error())
+	}
+
+	if err := waitUntilVpcPeeringConnectionActive(ec2Svc, status); err != nil {
+		return status, err
+	}
+
+	routeTableIds := peering.RouteTableIds
+	if len(routeTableIds) == 0 {
+		routeTableIds, err = listRouteTableIds(ec2Svc, vpcId)
+		if err != nil {
+			return status, err
+		}
+	}
+
+	status.RouteTableIds, err = createPeeringRoutes(ec2Svc, routeTableIds, status.AccepterCidrBlock, connectionId)
+	if err != nil {
+		return status, err
+	}
+
+	status.TargetRouteTableIds, err = createPeeringRoutes(targetEc2Svc, peering.TargetRouteTableIds,
+		status.RequesterCidrBlock, connectionId)
+	if err != nil {
+		return status, err
+	}
+
+	status.SecurityGroupIds, err = authorizePeeringIngress(ec2Svc, securityGroupIds,
+		peeringIpPermissions(peering.Ports, status.AccepterCidrBlock))
+	if err != nil {
+		return status, err
+	}
+
+	status.TargetSecurityGroupIds, err = authorizePeeringIngress(targetEc2Svc, peering.TargetSecurityGroupIds,
+		peeringIpPermissions(peering.Ports, status.RequesterCidrBlock))
+	if err != nil {
+		return status, err
+	}
+
+	// Both VPCs need DNS hostnames enabled for private host names to resolve across the connection
+	if err := enablePeeringDnsResolution(ec2Svc, targetEc2Svc, connectionId); err != nil {
+		log.Warningf("Unable to enable dns resolution of vpc peering connection: %s", err.Error())
+	} else {
+		status.DnsResolution = true
+	}
+
+	return status, nil
+}
+
+// DescribeVpcPeering refreshes the status code and message of the peering connection
+func DescribeVpcPeering(sess *session.Session, status *apis.VPCPeeringStatus) error {
+	connection, err := describeVpcPeeringConnection(ec2.New(sess), status.ConnectionId)
+	if err != nil {
+		return err
+	}
+
+	updateVpcPeeringStatus(status, connection)
+	return nil
+}
+
+// DeleteVpcPeering removes the routes and security group rules set up for the peering
+// connection and deletes it
+func DeleteVpcPeering(
+	sess *session.Session,
+	targetProfile *AWSProfile,
+	peering *apis.VPCPeering,
+	status *apis.VPCPeeringStatus,
+	log *logging.Logger) error {
+	ec2Svc := ec2.New(sess)
+	connectionId := status.ConnectionId
+
+	// The rules were authorized with the ports of the peering, peerings stored before ports
+	// were explicit opened every protocol
+	ports := []apis.PeeringPort{{Protocol: "-1"}}
+	if peering != nil && len(peering.Ports) > 0 {
+		ports = peering.Ports
+	}
+
+	if targetSess, err := CreateSession(targetProfile, status.TargetRegion); err != nil {
+		log.Warningf("Unable to create target session: %s", err.Error())
+	} else {
+		targetEc2Svc := ec2.New(targetSess)
+		revokePeeringIngress(targetEc2Svc, status.TargetSecurityGroupIds,
+			peeringIpPermissions(ports, status.RequesterCidrBlock), log)
+		deletePeeringRoutes(targetEc2Svc, status.TargetRouteTableIds, status.RequesterCidrBlock, log)
+	}
+
+	revokePeeringIngress(ec2Svc, status.SecurityGroupIds, peeringIpPermissions(ports, status.AccepterCidrBlock), log)
+	deletePeeringRoutes(ec2Svc, status.RouteTableIds, status.AccepterCidrBlock, log)
+
+	log.Infof("Deleting vpc peering connection %s", connectionId)
+	if _, err := ec2Svc.DeleteVpcPeeringConnection(&ec2.DeleteVpcPeeringConnectionInput{
+		VpcPeeringConnectionId: aws.String(connectionId),
+	}); err != nil {
+		return errors.New("Unable to delete vpc peering connection: " + err.Error())
+	}
+
+	return nil
+}
+
+func describeVpcPeeringConnection(ec2Svc *ec2.EC2, connectionId string) (*ec2.VpcPeeringConnection, error) {
+	output, err := ec2Svc.DescribeVpcPeeringConnections(&ec2.DescribeVpcPeeringConnectionsInput{
+		VpcPeeringConnectionIds: []*string{aws.String(connectionId)},
+	})
+	if err != nil {
+		return nil, errors.New("Unable to describe vpc peering connection: " + err.Error())
+	}
+
+	if len(output.VpcPeeringConnections) == 0 {
+		return nil, errors.New("Unable to find vpc peering connection " + connectionId)
+	}
+
+	return output.VpcPeeringConnections[0], nil
+}
+
+func updateVpcPeeringStatus(status *apis.VPCPeeringStatus, connection *ec2.VpcPeeringConnection) {
+	if connection.Status != nil {
+		status.Status = aws.StringValue(connection.Status.Code)
+		status.Message = aws.StringValue(connection.Status.Message)
+	}
+
+	if connection.RequesterVpcInfo != nil {
+		status.RequesterCidrBlock = aws.StringValue(connection.RequesterVpcInfo.CidrBlock)
+	}
+
+	if connection.AccepterVpcInfo != nil {
+		status.AccepterCidrBlock = aws.StringValue(connection.AccepterVpcInfo.CidrBlock)
+	}
+}
+
+// waitUntilVpcPeeringConnectionActive waits until the accepted connection is provisioned,
+// which is when the cidr blocks of both VPCs are known
+func waitUntilVpcPeeringConnectionActive(ec2Svc *ec2.EC2, status *apis.VPCPeeringStatus) error {
+	return funcs.LoopUntil(time.Minute*5, time.Second*5, func() (bool, error) {
+		connection, err := describeVpcPeeringConnection(ec2Svc, status.ConnectionId)
+		if err != nil {
+			return false, err
+		}
+
+		updateVpcPeeringStatus(status, connection)
+		switch status.Status {
+		case ec2.VpcPeeringConnectionStateReasonCodeActive:
+			return true, nil
+		case ec2.VpcPeeringConnectionStateReasonCodeFailed,
+			ec2.VpcPeeringConnectionStateReasonCodeRejected,
+			ec2.VpcPeeringConnectionStateReasonCodeExpired:
+			return false, fmt.Errorf("Vpc peering connection %s is %s: %s",
+				status.ConnectionId, status.Status, status.Message)
+		}
+
+		return false, nil
+	})
+}
+
+func listRouteTableIds(ec2Svc *ec2.EC2, vpcId string) ([]string, error) {
+	output, err := ec2Svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(vpcId)},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to describe route tables of %s: %s", vpcId, err.Error())
+	}
+
+	routeTableIds := []string{}
+	for _, routeTable := range output.RouteTables {
+		routeTableIds = append(routeTableIds, aws.StringValue(routeTable.RouteTableId))
+	}
+
+	return routeTableIds, nil
+}
+
+// createPeeringRoutes routes the cidr block through the peering connection, and return the
+// route tables updated. Route tables already routing the cidr block are left unchanged.
+func createPeeringRoutes(
+	ec2Svc *ec2.EC2,
+	routeTableIds []string,
+	cidrBlock string,
+	connectionId *string) ([]string, error) {
+	createdIds := []string{}
+	for _, routeTableId := range routeTableIds {
+		_, err := ec2Svc.CreateRoute(&ec2.CreateRouteInput{
+			RouteTableId:           aws.String(routeTableId),
+			DestinationCidrBlock:   aws.String(cidrBlock),
+			VpcPeeringConnectionId: connectionId,
+		})
+		if isErrorCode(err, "RouteAlreadyExists") {
+			continue
+		} else if err != nil {
+			return createdIds, fmt.Errorf("Unable to create route in %s: %s", routeTableId, err.Error())
+		}
+		createdIds = append(createdIds, routeTableId)
+	}
+
+	return createdIds, nil
+}
+
+func deletePeeringRoutes(ec2Svc *ec2.EC2, routeTableIds []string, cidrBlock string, log *logging.Logger) {
+	for _, routeTableId := range routeTableIds {
+		if _, err := ec2Svc.DeleteRoute(&ec2.DeleteRouteInput{
+			RouteTableId:         aws.String(routeTableId),
+			DestinationCidrBlock: aws.String(cidrBlock),
+		}); err != nil {
+			log.Warningf("Unable to delete route to %s in %s: %s", cidrBlock, routeTableId, err.Error())
+		}
+	}
+}
+
+// peeringIpPermissions return the permissions opening the ports to the cidr block
+func peeringIpPermissions(ports []apis.PeeringPort, cidrBlock string) []*ec2.IpPermission {
+	permissions := []*ec2.IpPermission{}
+	for _, port := range ports {
+		permission := &ec2.IpPermission{
+			IpProtocol: aws.String(port.Protocol),
+			IpRanges: []*ec2.IpRange{
+				{
+					CidrIp: aws.String(cidrBlock),
+				},
+			},
+		}
+
+		// Every protocol has no port range
+		if port.Protocol != "-1" {
+			fromPort, toPort := port.PortRange()
+			permission.FromPort = aws.Int64(int64(fromPort))
+			permission.ToPort = aws.Int64(int64(toPort))
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return permissions
+}
+
+// authorizePeeringIngress adds the permissions to the security groups, and return the security
+// groups updated. Security groups already having them are left unchanged.
+func authorizePeeringIngress(ec2Svc *ec2.EC2, groupIds []string, permissions []*ec2.IpPermission) ([]string, error) {
+	authorizedIds := []string{}
+	for _, groupId := range groupIds {
+		_, err := ec2Svc.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       aws.String(groupId),
+			IpPermissions: permissions,
+		})
+		if isErrorCode(err, "InvalidPermission.Duplicate") {
+			continue
+		} else if err != nil {
+			return authorizedIds, fmt.Errorf("Unable to authorize ingress of %s: %s", groupId, err.Error())
+		}
+		authorizedIds = append(authorizedIds, groupId)
+	}
+
+	return authorizedIds, nil
+}
+
+func revokePeeringIngress(ec2Svc *ec2.EC2, groupIds []string, permissions []*ec2.IpPermission, log *logging.Logger) {
+	for _, groupId := range groupIds {
+		if _, err := ec2Svc.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       aws.String(groupId),
+			IpPermissions: permissions,
+		}); err != nil {
+			log.Warningf("Unable to revoke ingress of %s: %s", groupId, err.Error())
+		}
+	}
+}
+
+// enablePeeringDnsResolution resolves the private host names of each VPC from the other one,
+// both sides of the connection have to be modified by their own account
+func enablePeeringDnsResolution(ec2Svc *ec2.EC2, targetEc2Svc *ec2.EC2, connectionId *string) error {
+	if _, err := ec2Svc.ModifyVpcPeeringConnectionOptions(&ec2.ModifyVpcPeeringConnectionOptionsInput{
+		VpcPeeringConnectionId: connectionId,
+		RequesterPeeringConnectionOptions: &ec2.PeeringConnectionOptionsRequest{
+			AllowDnsResolutionFromRemoteVpc: aws.Bool(true),
+		},
+	}); err != nil {
+		return errors.New("Unable to modify requester options: " + err.Error())
+	}
+
+	if _, err := targetEc2Svc.ModifyVpcPeeringConnectionOptions(&ec2.ModifyVpcPeeringConnectionOptionsInput{
+		VpcPeeringConnectionId: connectionId,
+		AccepterPeeringConnectionOptions: &ec2.PeeringConnectionOptionsRequest{
+			AllowDnsResolutionFromRemoteVpc: aws.Bool(true),
+		},
+	}); err != nil {
+		return errors.New("Unable to modify accepter options: " + err.Error())
+	}
+
+	return nil
+}
+
+func isErrorCode(err error, code string) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == code
+	}
+
+	return false
+}
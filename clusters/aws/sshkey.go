@@ -0,0 +1,90 @@
+package aws
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/hyperpilotio/deployer/common"
+	logging "github.com/op/go-logging"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"golang.org/x/crypto/ssh"
+)
+
+// privateKeyAuthorizedKey return the public key of the private key in authorized keys format
+func privateKeyAuthorizedKey(keyMaterial string) (string, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(strings.Replace(keyMaterial, "\\n", "\n", -1)))
+	if err != nil {
+		return "", errors.New("Unable to parse private key: " + err.Error())
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
+
+// RotateKeyPair replaces the key pair of the cluster. The new public key is authorized on the
+// hosts with the old key pair first, so the old key pair keeps working when that fails. The
+// new key pair is then stored with storeKey, before the key pair is replaced in EC2 for nodes
+// launched later and the old public key is removed from the hosts with the new key pair.
+// Targets are asked for again after the swap, as their ssh clients are bound to the key pair
+// of the cluster.
+func RotateKeyPair(
+	awsCluster *AWSCluster,
+	targets func() ([]common.NodeTarget, error),
+	concurrency int,
+	storeKey func() error,
+	log *logging.Logger) error {
+	oldPublicKey, err := privateKeyAuthorizedKey(awsCluster.GetKeyMaterial())
+	if err != nil {
+		return errors.New("Unable to get current public key: " + err.Error())
+	}
+
+	keyPair, publicKey, err := GenerateKeypair(awsCluster.KeyName())
+	if err != nil {
+		return errors.New("Unable to generate key pair: " + err.Error())
+	}
+
+	oldTargets, err := targets()
+	if err != nil {
+		return errors.New("Unable to get ssh clients: " + err.Error())
+	}
+
+	log.Infof("Authorizing new ssh key on %d hosts", len(oldTargets))
+	if err := common.AddAuthorizedKey(oldTargets, concurrency, publicKey).Err(); err != nil {
+		if err := common.RemoveAuthorizedKey(oldTargets, concurrency, publicKey).Err(); err != nil {
+			log.Warningf("Unable to remove new ssh key after failed rotation: %s", err.Error())
+		}
+		return errors.New("Unable to authorize new ssh key: " + err.Error())
+	}
+
+	// The old key pair is only dropped once the new one can't be lost
+	oldKeyPair := awsCluster.KeyPair
+	awsCluster.KeyPair = keyPair
+	if err := storeKey(); err != nil {
+		awsCluster.KeyPair = oldKeyPair
+		if err := common.RemoveAuthorizedKey(oldTargets, concurrency, publicKey).Err(); err != nil {
+			log.Warningf("Unable to remove new ssh key after failed rotation: %s", err.Error())
+		}
+		return errors.New("Unable to store new key pair: " + err.Error())
+	}
+
+	sess, err := CreateSession(awsCluster.AWSProfile, awsCluster.Region)
+	if err != nil {
+		return errors.New("Unable to create session: " + err.Error())
+	}
+
+	if err := ReplaceKeypair(ec2.New(sess), keyPair, publicKey); err != nil {
+		return errors.New("Unable to replace key pair: " + err.Error())
+	}
+
+	newTargets, err := targets()
+	if err != nil {
+		return errors.New("Unable to get ssh clients with new key pair: " + err.Error())
+	}
+
+	log.Infof("Removing old ssh key from %d hosts", len(newTargets))
+	if err := common.RemoveAuthorizedKey(newTargets, concurrency, oldPublicKey).Err(); err != nil {
+		return errors.New("Key pair is rotated, but unable to remove old ssh key: " + err.Error())
+	}
+
+	return nil
+}
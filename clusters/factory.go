@@ -6,6 +6,7 @@ import (
 	"github.com/hyperpilotio/deployer/apis"
 	hpaws "github.com/hyperpilotio/deployer/clusters/aws"
 	hpgcp "github.com/hyperpilotio/deployer/clusters/gcp"
+	"github.com/hyperpilotio/deployer/clusters/hostkeys"
 	"github.com/spf13/viper"
 )
 
@@ -13,6 +14,7 @@ type Cluster interface {
 	GetClusterType() string
 	GetKeyMaterial() string
 	ReloadKeyPair(keyMaterial string) error
+	GetHostKeys() *hostkeys.HostKeys
 }
 
 type UserProfile interface {
@@ -26,7 +28,7 @@ func NewCluster(
 	userProfile UserProfile,
 	deployment *apis.Deployment) Cluster {
 	switch deployType {
-	case "ECS", "K8S":
+	case "ECS", "K8S", "EKS":
 		awsCluster := hpaws.NewAWSCluster(deployment.Name, deployment.Region)
 		awsCluster.AWSProfile = userProfile.GetAWSProfile()
 		return awsCluster
@@ -10,7 +10,9 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/golang/glog"
 	"github.com/hyperpilotio/deployer/apis"
+	"github.com/hyperpilotio/deployer/clusters/hostkeys"
 	"github.com/spf13/viper"
 
 	"golang.org/x/crypto/ssh"
@@ -70,6 +72,9 @@ type GCPCluster struct {
 	KeyPair        *GCPKeyPairOutput
 	NodeInfos      map[int]*NodeInfo
 	NodePoolIds    []string
+
+	// HostKeys are the ssh host keys pinned for the nodes of the cluster
+	HostKeys *hostkeys.HostKeys `json:"-"`
 }
 
 func NewGCPCluster(
@@ -88,6 +93,7 @@ func NewGCPCluster(
 		},
 		NodeInfos:   make(map[int]*NodeInfo),
 		NodePoolIds: make([]string, 0),
+		HostKeys:    hostkeys.New(),
 	}
 
 	if deployment.KubernetesDeployment.GCPDefinition != nil {
@@ -141,6 +147,7 @@ func (gcpCluster *GCPCluster) SshConfig(user string) (*ssh.ClientConfig, error)
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(signer),
 		},
+		HostKeyCallback: gcpCluster.HostKeys.Callback(),
 	}
 
 	return clientConfig, nil
@@ -167,6 +174,63 @@ func (gcpCluster *GCPCluster) GetKeyMaterial() string {
 	return keyMaterial
 }
 
+func (gcpCluster *GCPCluster) GetHostKeys() *hostkeys.HostKeys {
+	return gcpCluster.HostKeys
+}
+
+// PinSerialHostKeys forgets the host keys pinned for the addresses of new nodes, which can be
+// reused from removed instances, and pins the keys the nodes print to their serial port.
+// Nodes without keys in their serial port output yet are trusted on first connection.
+func (gcpCluster *GCPCluster) PinSerialHostKeys(nodeInfos map[int]*NodeInfo) {
+	for _, nodeInfo := range nodeInfos {
+		gcpCluster.HostKeys.Forget(nodeInfo.PrivateIp, nodeInfo.PublicIp)
+	}
+
+	client, err := CreateClient(gcpCluster.GCPProfile)
+	if err != nil {
+		glog.Warningf("Unable to create client to get serial port output: %s", err.Error())
+		return
+	}
+
+	computeSvc, err := compute.New(client)
+	if err != nil {
+		glog.Warningf("Unable to create compute service: %s", err.Error())
+		return
+	}
+
+	projectId, err := gcpCluster.GCPProfile.GetProjectId()
+	if err != nil {
+		glog.Warningf("Unable to get project id to get serial port output: %s", err.Error())
+		return
+	}
+
+	for nodeId, nodeInfo := range nodeInfos {
+		if nodeInfo.Instance == nil {
+			continue
+		}
+
+		output, err := computeSvc.Instances.GetSerialPortOutput(projectId, gcpCluster.Zone, nodeInfo.Instance.Name).Do()
+		if err != nil {
+			glog.Warningf("Unable to get serial port output of node %d: %s", nodeId, err.Error())
+			continue
+		}
+
+		keys := hostkeys.ParseConsoleHostKeys(output.Contents)
+		if len(keys) == 0 {
+			continue
+		}
+
+		for _, host := range []string{nodeInfo.PrivateIp, nodeInfo.PublicIp} {
+			if host == "" {
+				continue
+			}
+			if err := gcpCluster.HostKeys.Pin(host, keys); err != nil {
+				glog.Warningf("Unable to pin host keys of node %d: %s", nodeId, err.Error())
+			}
+		}
+	}
+}
+
 func (gcpCluster *GCPCluster) KeyName() string {
 	return gcpCluster.Name + "-key"
 }
@@ -0,0 +1,174 @@
+package hostkeys
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	consoleKeysBegin = "-----BEGIN SSH HOST KEY KEYS-----"
+	consoleKeysEnd   = "-----END SSH HOST KEY KEYS-----"
+)
+
+// HostKeys are the ssh host keys pinned for the hosts of a cluster, keyed by host without
+// port. Hosts without pinned keys are trusted on first use, later connections have to
+// present one of the keys pinned for the host.
+type HostKeys struct {
+	mutex   sync.Mutex
+	keys    map[string][]string
+	changed bool
+}
+
+func New() *HostKeys {
+	return &HostKeys{
+		keys: make(map[string][]string),
+	}
+}
+
+// marshalKey return the key in authorized keys format, without comment
+func marshalKey(key ssh.PublicKey) string {
+	return key.Type() + " " + base64.StdEncoding.EncodeToString(key.Marshal())
+}
+
+func hostName(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+
+	return address
+}
+
+// Keys return a copy of the pinned keys, to be stored with the deployment
+func (hostKeys *HostKeys) Keys() map[string][]string {
+	hostKeys.mutex.Lock()
+	defer hostKeys.mutex.Unlock()
+
+	keys := make(map[string][]string)
+	for host, hostKeyList := range hostKeys.keys {
+		keys[host] = append([]string{}, hostKeyList...)
+	}
+
+	return keys
+}
+
+// Load replaces the pinned keys with the stored ones
+func (hostKeys *HostKeys) Load(keys map[string][]string) {
+	hostKeys.mutex.Lock()
+	defer hostKeys.mutex.Unlock()
+
+	hostKeys.keys = make(map[string][]string)
+	for host, hostKeyList := range keys {
+		hostKeys.keys[host] = append([]string{}, hostKeyList...)
+	}
+	hostKeys.changed = false
+}
+
+// Pin replaces the keys of the host with the keys in authorized keys format
+func (hostKeys *HostKeys) Pin(host string, keys []string) error {
+	normalizedKeys := []string{}
+	for _, key := range keys {
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			return fmt.Errorf("Unable to parse host key of %s: %s", host, err.Error())
+		}
+		normalizedKeys = append(normalizedKeys, marshalKey(publicKey))
+	}
+
+	if len(normalizedKeys) == 0 {
+		return errors.New("No host keys to pin for " + host)
+	}
+
+	hostKeys.mutex.Lock()
+	defer hostKeys.mutex.Unlock()
+
+	hostKeys.keys[host] = normalizedKeys
+	hostKeys.changed = true
+
+	return nil
+}
+
+// Forget removes the keys of the hosts, e.g. once their addresses can be reused by new nodes
+func (hostKeys *HostKeys) Forget(hosts ...string) {
+	hostKeys.mutex.Lock()
+	defer hostKeys.mutex.Unlock()
+
+	for _, host := range hosts {
+		if _, ok := hostKeys.keys[host]; ok {
+			delete(hostKeys.keys, host)
+			hostKeys.changed = true
+		}
+	}
+}
+
+// TakeChanged return if keys were pinned or forgotten since the last call, for the caller
+// to store them
+func (hostKeys *HostKeys) TakeChanged() bool {
+	hostKeys.mutex.Lock()
+	defer hostKeys.mutex.Unlock()
+
+	changed := hostKeys.changed
+	hostKeys.changed = false
+	return changed
+}
+
+// Callback verifies the host keys presented on connections, pinning the key of hosts seen
+// for the first time
+func (hostKeys *HostKeys) Callback() ssh.HostKeyCallback {
+	return func(address string, remote net.Addr, key ssh.PublicKey) error {
+		host := hostName(address)
+		presentedKey := marshalKey(key)
+
+		hostKeys.mutex.Lock()
+		defer hostKeys.mutex.Unlock()
+
+		pinnedKeys, ok := hostKeys.keys[host]
+		if !ok {
+			hostKeys.keys[host] = []string{presentedKey}
+			hostKeys.changed = true
+			return nil
+		}
+
+		for _, pinnedKey := range pinnedKeys {
+			if pinnedKey == presentedKey {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("Host key %s of %s doesn't match its pinned keys",
+			ssh.FingerprintSHA256(key), host)
+	}
+}
+
+// ParseConsoleHostKeys return the host keys cloud-init prints to the console of instances
+func ParseConsoleHostKeys(output string) []string {
+	begin := strings.LastIndex(output, consoleKeysBegin)
+	if begin == -1 {
+		return nil
+	}
+
+	block := output[begin+len(consoleKeysBegin):]
+	end := strings.Index(block, consoleKeysEnd)
+	if end == -1 {
+		return nil
+	}
+
+	keys := []string{}
+	for _, line := range strings.Split(block[:end], "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line)); err == nil {
+			keys = append(keys, line)
+		}
+	}
+
+	return keys
+}
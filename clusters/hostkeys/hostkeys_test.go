@@ -0,0 +1,195 @@
+package hostkeys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newPublicKey(t *testing.T) ssh.PublicKey {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err.Error())
+	}
+
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Unable to create public key: %s", err.Error())
+	}
+
+	return publicKey
+}
+
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func TestCallbackPinsFirstKey(t *testing.T) {
+	hostKeys := New()
+	callback := hostKeys.Callback()
+	key := newPublicKey(t)
+
+	if err := callback("10.0.0.1:22", nil, key); err != nil {
+		t.Fatalf("Expected first key to be trusted: %s", err.Error())
+	}
+
+	if !hostKeys.TakeChanged() {
+		t.Error("Expected pinning the first key to change the keys")
+	}
+	if hostKeys.TakeChanged() {
+		t.Error("Expected changes to be taken once")
+	}
+
+	expected := map[string][]string{"10.0.0.1": {marshalKey(key)}}
+	if keys := hostKeys.Keys(); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys %v, got %v", expected, keys)
+	}
+}
+
+func TestCallbackVerifiesPinnedKeys(t *testing.T) {
+	key := newPublicKey(t)
+	otherKey := newPublicKey(t)
+
+	hostKeys := New()
+	if err := hostKeys.Pin("10.0.0.1", []string{authorizedKey(key)}); err != nil {
+		t.Fatalf("Unable to pin key: %s", err.Error())
+	}
+	hostKeys.TakeChanged()
+	callback := hostKeys.Callback()
+
+	tests := []struct {
+		name    string
+		address string
+		key     ssh.PublicKey
+		valid   bool
+	}{
+		{"pinned key with port", "10.0.0.1:22", key, true},
+		{"pinned key with other port", "10.0.0.1:2222", key, true},
+		{"pinned key without port", "10.0.0.1", key, true},
+		{"mismatched key", "10.0.0.1:22", otherKey, false},
+	}
+
+	for _, test := range tests {
+		if err := callback(test.address, nil, test.key); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got error %v", test.name, test.valid, err)
+		}
+	}
+
+	if hostKeys.TakeChanged() {
+		t.Error("Expected verifying keys to leave the keys unchanged")
+	}
+}
+
+func TestPin(t *testing.T) {
+	key := newPublicKey(t)
+
+	tests := []struct {
+		name  string
+		keys  []string
+		valid bool
+	}{
+		{"key", []string{authorizedKey(key)}, true},
+		{"key with comment", []string{authorizedKey(key) + " root@host"}, true},
+		{"no keys", []string{}, false},
+		{"garbage", []string{"not a key"}, false},
+	}
+
+	for _, test := range tests {
+		hostKeys := New()
+		err := hostKeys.Pin("10.0.0.1", test.keys)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got error %v", test.name, test.valid, err)
+			continue
+		}
+
+		if test.valid {
+			expected := map[string][]string{"10.0.0.1": {marshalKey(key)}}
+			if keys := hostKeys.Keys(); !reflect.DeepEqual(keys, expected) {
+				t.Errorf("%s: expected keys %v, got %v", test.name, expected, keys)
+			}
+		}
+	}
+}
+
+func TestForgetAllowsPinningAgain(t *testing.T) {
+	key := newPublicKey(t)
+	newKey := newPublicKey(t)
+
+	hostKeys := New()
+	callback := hostKeys.Callback()
+	if err := callback("10.0.0.1:22", nil, key); err != nil {
+		t.Fatalf("Expected first key to be trusted: %s", err.Error())
+	}
+	hostKeys.TakeChanged()
+
+	hostKeys.Forget("10.0.0.2")
+	if hostKeys.TakeChanged() {
+		t.Error("Expected forgetting unknown hosts to leave the keys unchanged")
+	}
+
+	hostKeys.Forget("10.0.0.1")
+	if !hostKeys.TakeChanged() {
+		t.Error("Expected forgetting the host to change the keys")
+	}
+
+	if err := callback("10.0.0.1:22", nil, newKey); err != nil {
+		t.Errorf("Expected the key of the reused address to be trusted: %s", err.Error())
+	}
+	if err := callback("10.0.0.1:22", nil, key); err == nil {
+		t.Error("Expected the forgotten key to be rejected")
+	}
+}
+
+func TestLoadResetsChanged(t *testing.T) {
+	key := newPublicKey(t)
+
+	hostKeys := New()
+	if err := hostKeys.Pin("10.0.0.2", []string{authorizedKey(key)}); err != nil {
+		t.Fatalf("Unable to pin key: %s", err.Error())
+	}
+
+	stored := map[string][]string{"10.0.0.1": {marshalKey(key)}}
+	hostKeys.Load(stored)
+	if hostKeys.TakeChanged() {
+		t.Error("Expected loaded keys to be unchanged")
+	}
+
+	// Loaded keys replace the pinned ones and are copied
+	stored["10.0.0.1"][0] = "changed"
+	expected := map[string][]string{"10.0.0.1": {marshalKey(key)}}
+	if keys := hostKeys.Keys(); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys %v, got %v", expected, keys)
+	}
+}
+
+func TestParseConsoleHostKeys(t *testing.T) {
+	key := authorizedKey(newPublicKey(t))
+	otherKey := authorizedKey(newPublicKey(t))
+	block := func(keys ...string) string {
+		return consoleKeysBegin + "\n" + strings.Join(keys, "\n") + "\n" + consoleKeysEnd + "\n"
+	}
+
+	tests := []struct {
+		name     string
+		output   string
+		expected []string
+	}{
+		{"block", "boot\n" + block(key) + "login:", []string{key}},
+		{"garbage lines", block("", "ci-info: garbage", key+" root@host", "  "), []string{key + " root@host"}},
+		{"last block wins", block(otherKey) + "reboot\n" + block(key), []string{key}},
+		{"no block", "boot\nlogin:", nil},
+		{"unterminated block", consoleKeysBegin + "\n" + key + "\n", nil},
+		{"empty block", block(), []string{}},
+	}
+
+	for _, test := range tests {
+		if keys := ParseConsoleHostKeys(test.output); !reflect.DeepEqual(keys, test.expected) {
+			t.Errorf("%s: expected keys %v, got %v", test.name, test.expected, keys)
+		}
+	}
+}
@@ -1,59 +1,270 @@
 package common
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/spf13/viper"
 
 	"github.com/hyperpilotio/deployer/apis"
+	hpgcp "github.com/hyperpilotio/deployer/clusters/gcp"
 	logging "github.com/op/go-logging"
 )
 
+// FileVariables are the values template files are rendered with for a node, e.g.
+// {{.PrivateIp}} or {{(index .Services "redis").NodeName}}
+type FileVariables struct {
+	DeploymentName string
+	Region         string
+	NodeId         int
+	PrivateIp      string
+	PublicIp       string
+	// Service mappings of the deployment, keyed by service name
+	Services map[string]interface{}
+}
+
+// UploadedFileKey return the key of an uploaded file of a user. User ids can't contain the
+// separator, so keys of different users never collide.
+func UploadedFileKey(userId string, fileId string) string {
+	return userId + "_" + fileId
+}
+
+// HasTemplateFiles return if any file of the deployment has to be rendered per node
+func HasTemplateFiles(deployment *apis.Deployment) bool {
+	for _, deployFile := range deployment.Files {
+		if deployFile.Template {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SplitTemplateFiles return copies of the deployment with its plain files, which are uploaded
+// before the services are deployed, and with its template files, which are uploaded once the
+// services are deployed so they are rendered with the service mappings
+func SplitTemplateFiles(deployment *apis.Deployment) (*apis.Deployment, *apis.Deployment) {
+	plainFiles := *deployment
+	plainFiles.Files = []apis.DeploymentFile{}
+	templateFiles := *deployment
+	templateFiles.Files = []apis.DeploymentFile{}
+	for _, file := range deployment.Files {
+		if file.Template {
+			templateFiles.Files = append(templateFiles.Files, file)
+		} else {
+			plainFiles.Files = append(plainFiles.Files, file)
+		}
+	}
+
+	return &plainFiles, &templateFiles
+}
+
+// renderTemplateFile renders the template file to a temp file, which the caller removes
+func renderTemplateFile(location string, variables *FileVariables) (string, error) {
+	content, err := ioutil.ReadFile(location)
+	if err != nil {
+		return "", errors.New("Unable to read template file: " + err.Error())
+	}
+
+	fileTemplate, err := template.New(path.Base(location)).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return "", errors.New("Unable to parse template file: " + err.Error())
+	}
+
+	tmpFile, err := ioutil.TempFile("", "rendered-")
+	if err != nil {
+		return "", errors.New("Unable to create temp file: " + err.Error())
+	}
+	defer tmpFile.Close()
+
+	if err := fileTemplate.Execute(tmpFile, variables); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", errors.New("Unable to render template file: " + err.Error())
+	}
+
+	return tmpFile.Name(), nil
+}
+
+// shellQuote quotes the value as a single shell word
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// extractCommand return the command extracting the archive by its format
+func extractCommand(location string, archivePath string, destination string) (string, error) {
+	file, err := os.Open(location)
+	if err != nil {
+		return "", errors.New("Unable to open archive: " + err.Error())
+	}
+	defer file.Close()
+
+	header := make([]byte, 262)
+	n, _ := io.ReadFull(file, header)
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return fmt.Sprintf("tar -xzf %s -C %s", shellQuote(archivePath), shellQuote(destination)), nil
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+		return fmt.Sprintf("unzip -o -q %s -d %s", shellQuote(archivePath), shellQuote(destination)), nil
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return fmt.Sprintf("tar -xf %s -C %s", shellQuote(archivePath), shellQuote(destination)), nil
+	}
+
+	return "", errors.New("Unsupported archive format, only tar, tar.gz and zip can be extracted")
+}
+
+// uploadFile copies the local file to the node, extracting it and setting its mode and owner
+// as the deployment file asks
+func uploadFile(conn *SshConnection, location string, deployFile *apis.DeploymentFile) error {
+	remotePath := deployFile.Path
+	commands := []string{}
+	if deployFile.Extract {
+		remotePath = fmt.Sprintf("/tmp/%s-%d.archive", path.Base(deployFile.Path), time.Now().UnixNano())
+		extract, err := extractCommand(location, remotePath, deployFile.Path)
+		if err != nil {
+			return err
+		}
+		commands = append(commands,
+			"mkdir -p "+shellQuote(deployFile.Path),
+			extract+"; status=$?; rm -f "+shellQuote(remotePath)+"; [ $status -eq 0 ]")
+	}
+
+	if err := conn.CopyLocalFileToRemote(location, remotePath); err != nil {
+		return err
+	}
+
+	mode, err := deployFile.FileMode()
+	if err != nil {
+		return err
+	}
+
+	// The mode is set first, as the ssh user may not own the file anymore afterwards
+	if mode != 0 {
+		commands = append(commands, fmt.Sprintf("chmod %04o %s", mode, shellQuote(deployFile.Path)))
+	}
+
+	if deployFile.Owner != "" {
+		recursive := ""
+		if deployFile.Extract {
+			recursive = "-R "
+		}
+		commands = append(commands,
+			fmt.Sprintf("sudo chown %s%s %s", recursive, shellQuote(deployFile.Owner), shellQuote(deployFile.Path)))
+	}
+
+	if len(commands) == 0 {
+		return nil
+	}
+
+	if err := conn.RunCommand(strings.Join(commands, " && "), false); err != nil {
+		return errors.New("Unable to set up file: " + err.Error())
+	}
+
+	return nil
+}
+
+// NodeUpload is a node UploadFilesToNodes copies files to
+type NodeUpload struct {
+	Client    SshClient
+	Variables *FileVariables
+}
+
+// UploadFilesToNodes uploads the files of the deployment to the nodes in parallel, and
+// return an error listing the nodes uploads failed on
+func UploadFilesToNodes(
+	config *viper.Viper,
+	nodes []NodeUpload,
+	deployment *apis.Deployment,
+	uploadedFiles map[string]string,
+	gcpProfile *hpgcp.GCPProfile,
+	log *logging.Logger) error {
+	targets := []NodeTarget{}
+	variables := map[int]*FileVariables{}
+	for _, node := range nodes {
+		targets = append(targets, NodeTarget{
+			NodeId: node.Variables.NodeId,
+			Client: node.Client,
+		})
+		variables[node.Variables.NodeId] = node.Variables
+	}
+
+	results := RunOnNodes(targets, SshConcurrency(config), func(nodeId int, conn *SshConnection) error {
+		return UploadFiles(config, conn, deployment, uploadedFiles, gcpProfile, variables[nodeId], log)
+	})
+	for _, result := range results {
+		if result.Error != "" {
+			log.Warningf("Unable to upload files to node %d (%s): %s", result.NodeId, result.Host, result.Error)
+		}
+	}
+
+	return results.Err()
+}
+
+// UploadFiles copies the files of the deployment targeting the node of the variables to it.
+// Template files are rendered with the variables of the node first.
 func UploadFiles(
 	config *viper.Viper,
-	sshClient SshClient,
+	conn *SshConnection,
 	deployment *apis.Deployment,
 	uploadedFiles map[string]string,
+	gcpProfile *hpgcp.GCPProfile,
+	variables *FileVariables,
 	log *logging.Logger) error {
-	if len(deployment.Files) == 0 {
+	nodeFiles := deployment.NodeFiles(variables.NodeId)
+	if len(nodeFiles) == 0 {
 		return nil
 	}
 
-	var s3Downloader Downloader
-	for _, deployFile := range deployment.Files {
+	options := &DownloadOptions{
+		Config:     config,
+		GCPProfile: gcpProfile,
+	}
+	for i := range nodeFiles {
+		deployFile := &nodeFiles[i]
+		uploadFilePath := ""
 		if deployFile.FileUrl != "" {
-			downloader, err := NewS3Downloader(config)
+			downloader, err := NewDownloader(deployFile.FileUrl, options)
 			if err != nil {
-				return errors.New("Unable to init S3Downloader: " + err.Error())
+				return errors.New("Unable to init downloader: " + err.Error())
 			}
-			s3Downloader = downloader
-			break
-		}
-	}
 
-	for _, deployFile := range deployment.Files {
-		uploadFilePath := ""
-		if deployFile.FileUrl != "" {
-			location, err := s3Downloader.Download(deployFile.FileUrl)
+			location, err := downloader.Download(deployFile.FileUrl)
 			if err != nil {
 				return errors.New("Unable to download file: " + err.Error())
 			}
 			uploadFilePath = location
 		} else {
-			location, ok := uploadedFiles[deployment.UserId+"_"+deployFile.FileId]
+			location, ok := uploadedFiles[UploadedFileKey(deployment.UserId, deployFile.FileId)]
 			if !ok {
 				return errors.New("Unable to find uploaded file " + deployFile.FileId)
 			}
 			uploadFilePath = location
 		}
 
-		if err := sshClient.CopyLocalFileToRemote(uploadFilePath, deployFile.Path); err != nil {
+		if deployFile.Template {
+			renderedPath, err := renderTemplateFile(uploadFilePath, variables)
+			if err != nil {
+				return fmt.Errorf("Unable to render file %s: %s", deployFile.Path, err.Error())
+			}
+			defer os.Remove(renderedPath)
+			uploadFilePath = renderedPath
+		}
+
+		if err := uploadFile(conn, uploadFilePath, deployFile); err != nil {
 			return fmt.Errorf("Unable to upload file %s to server %s:%s: %s",
-				deployFile.FileId, sshClient.Host, deployFile.Path, err.Error())
+				deployFile.FileId, conn.Host, deployFile.Path, err.Error())
 		}
 	}
 
-	log.Info("Uploaded files to " + sshClient.Host)
+	log.Info("Uploaded files to " + conn.Host)
 	return nil
 }
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hyperpilotio/deployer/apis"
+)
+
+// testServiceMapping has the fields templates use of the service mappings of the deployers
+type testServiceMapping struct {
+	NodeId   int
+	NodeName string
+}
+
+func TestRenderTemplateFile(t *testing.T) {
+	templateFile, err := ioutil.TempFile("", "template-")
+	if err != nil {
+		t.Fatalf("Unable to create template file: %s", err.Error())
+	}
+	defer os.Remove(templateFile.Name())
+
+	templateFile.WriteString(`{{.DeploymentName}} {{.NodeId}} {{.PrivateIp}} {{(index .Services "redis").NodeName}}`)
+	templateFile.Close()
+
+	variables := &FileVariables{
+		DeploymentName: "tech-demo",
+		NodeId:         2,
+		PrivateIp:      "10.0.0.2",
+		Services: map[string]interface{}{
+			"redis": testServiceMapping{NodeId: 1, NodeName: "ip-10-0-0-1"},
+		},
+	}
+
+	renderedPath, err := renderTemplateFile(templateFile.Name(), variables)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.Remove(renderedPath)
+
+	rendered, err := ioutil.ReadFile(renderedPath)
+	if err != nil {
+		t.Fatalf("Unable to read rendered file: %s", err.Error())
+	}
+
+	expected := "tech-demo 2 10.0.0.2 ip-10-0-0-1"
+	if string(rendered) != expected {
+		t.Errorf("Rendered %q, expected %q", string(rendered), expected)
+	}
+
+	// Templates can't be rendered before the services they use are deployed
+	variables.Services = map[string]interface{}{}
+	if renderedPath, err := renderTemplateFile(templateFile.Name(), variables); err == nil {
+		os.Remove(renderedPath)
+		t.Error("Expected rendering without the service mapping to fail")
+	}
+}
+
+func TestSplitTemplateFiles(t *testing.T) {
+	deployment := &apis.Deployment{
+		Name: "tech-demo",
+		Files: []apis.DeploymentFile{
+			{FileId: "config", Template: true},
+			{FileId: "binary"},
+			{FileId: "hosts", Template: true},
+		},
+	}
+
+	plainFiles, templateFiles := SplitTemplateFiles(deployment)
+	if plainFiles.Name != deployment.Name || templateFiles.Name != deployment.Name {
+		t.Error("Expected the copies to keep the deployment")
+	}
+
+	if len(plainFiles.Files) != 1 || plainFiles.Files[0].FileId != "binary" {
+		t.Errorf("Unexpected plain files %+v", plainFiles.Files)
+	}
+
+	if len(templateFiles.Files) != 2 || templateFiles.Files[0].FileId != "config" ||
+		templateFiles.Files[1].FileId != "hosts" {
+		t.Errorf("Unexpected template files %+v", templateFiles.Files)
+	}
+
+	if len(deployment.Files) != 3 {
+		t.Error("Expected the deployment files to be left unchanged")
+	}
+}
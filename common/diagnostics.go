@@ -0,0 +1,140 @@
+package common
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const diagnosticsCommandTimeout = 2 * time.Minute
+
+// KubernetesNodeLogCommands collect the logs of kubernetes nodes, keyed by file name
+var KubernetesNodeLogCommands = map[string]string{
+	"kubelet.log":    "sudo journalctl -u kubelet --no-pager -n 10000",
+	"docker.log":     "sudo journalctl -u docker --no-pager -n 10000",
+	"containers.txt": "sudo docker ps -a",
+}
+
+// ECSNodeLogCommands collect the logs of ECS container instances, keyed by file name
+var ECSNodeLogCommands = map[string]string{
+	"ecs-agent.log":  "sudo tail -n 10000 /var/log/ecs/ecs-agent.log",
+	"docker.log":     "sudo journalctl -u docker --no-pager -n 10000 2>/dev/null || sudo tail -n 10000 /var/log/docker",
+	"containers.txt": "sudo docker ps -a",
+}
+
+// Diagnostics writes the files of a diagnostics bundle to a gzipped tarball. Files can be
+// added concurrently, and what couldn't be collected is listed in errors.txt on close.
+type Diagnostics struct {
+	mutex      sync.Mutex
+	prefix     string
+	gzipWriter *gzip.Writer
+	tarWriter  *tar.Writer
+	errors     []string
+}
+
+// NewDiagnostics return a bundle writing to the writer, with the files under the prefix directory
+func NewDiagnostics(writer io.Writer, prefix string) *Diagnostics {
+	gzipWriter := gzip.NewWriter(writer)
+	return &Diagnostics{
+		prefix:     prefix,
+		gzipWriter: gzipWriter,
+		tarWriter:  tar.NewWriter(gzipWriter),
+	}
+}
+
+func (diagnostics *Diagnostics) AddFile(name string, content []byte) error {
+	diagnostics.mutex.Lock()
+	defer diagnostics.mutex.Unlock()
+
+	return diagnostics.addFile(name, content)
+}
+
+func (diagnostics *Diagnostics) addFile(name string, content []byte) error {
+	if err := diagnostics.tarWriter.WriteHeader(&tar.Header{
+		Name:    diagnostics.prefix + "/" + name,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return errors.New("Unable to write tar header: " + err.Error())
+	}
+
+	if _, err := diagnostics.tarWriter.Write(content); err != nil {
+		return errors.New("Unable to write tar file: " + err.Error())
+	}
+
+	return nil
+}
+
+// AddError records that the named diagnostics couldn't be collected
+func (diagnostics *Diagnostics) AddError(name string, err error) {
+	diagnostics.mutex.Lock()
+	defer diagnostics.mutex.Unlock()
+
+	diagnostics.errors = append(diagnostics.errors, name+": "+err.Error())
+}
+
+func (diagnostics *Diagnostics) Close() error {
+	diagnostics.mutex.Lock()
+	defer diagnostics.mutex.Unlock()
+
+	if len(diagnostics.errors) > 0 {
+		sort.Strings(diagnostics.errors)
+		content := strings.Join(diagnostics.errors, "\n") + "\n"
+		if err := diagnostics.addFile("errors.txt", []byte(content)); err != nil {
+			return err
+		}
+	}
+
+	if err := diagnostics.tarWriter.Close(); err != nil {
+		return errors.New("Unable to close tar writer: " + err.Error())
+	}
+
+	return diagnostics.gzipWriter.Close()
+}
+
+// CollectNodeLogs runs the log commands on the nodes, adding their output as
+// nodes/<node id>/<file name>
+func CollectNodeLogs(
+	diagnostics *Diagnostics,
+	sshClients map[int]SshClient,
+	commands map[string]string,
+	concurrency int) {
+	results := RunOnNodes(SshTargets(sshClients), concurrency, func(nodeId int, conn *SshConnection) error {
+		for fileName, command := range commands {
+			name := fmt.Sprintf("nodes/%d/%s", nodeId, fileName)
+			result, err := conn.ExecCommand(command, diagnosticsCommandTimeout)
+			if err != nil {
+				diagnostics.AddError(name, err)
+				continue
+			}
+
+			if result.ExitCode != 0 {
+				diagnostics.AddError(name, fmt.Errorf("Exited with %d: %s", result.ExitCode, result.Stderr))
+			}
+
+			content := result.Stdout
+			if result.Truncated {
+				content += fmt.Sprintf("\n... output truncated at %dMB\n", maxCommandOutput/(1024*1024))
+			}
+
+			if err := diagnostics.AddFile(name, []byte(content)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	for _, result := range results {
+		if result.Error != "" {
+			diagnostics.AddError(fmt.Sprintf("nodes/%d", result.NodeId), errors.New(result.Error))
+		}
+	}
+}
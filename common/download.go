@@ -1,89 +1,155 @@
 package common
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
-	"strings"
+	"sync"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	hpgcp "github.com/hyperpilotio/deployer/clusters/gcp"
 	"github.com/spf13/viper"
 )
 
+// Downloader fetches the deployment files of an url scheme to a local path
 type Downloader interface {
 	Download(fileUrl string) (string, error)
 }
 
-type S3Downloader struct {
-	config    *viper.Viper
-	region    string
-	awsId     string
-	awsSecret string
+// DownloadOptions holds what downloaders of a deployment can use to access the file urls
+type DownloadOptions struct {
+	Config *viper.Viper
+	// Profile of the deployment user, used for gs:// urls
+	GCPProfile *hpgcp.GCPProfile
 }
 
-func NewS3Downloader(config *viper.Viper) (Downloader, error) {
-	return &S3Downloader{
-		config:    config,
-		region:    config.GetString("s3.region"),
-		awsId:     config.GetString("awsId"),
-		awsSecret: config.GetString("awsSecret"),
-	}, nil
+// NewDownloaderFunc creates the downloader of an url scheme
+type NewDownloaderFunc func(options *DownloadOptions) (Downloader, error)
+
+var (
+	downloadersMutex sync.Mutex
+	downloaders      = map[string]NewDownloaderFunc{
+		"s3": func(options *DownloadOptions) (Downloader, error) {
+			return NewS3Downloader(options.Config)
+		},
+		"http":  NewHTTPDownloader,
+		"https": NewHTTPDownloader,
+		"gs":    NewGCSDownloader,
+		"file":  NewLocalDownloader,
+	}
+)
+
+// RegisterDownloader registers the downloader of file urls with the scheme, replacing the
+// downloader registered for it before
+func RegisterDownloader(scheme string, newDownloader NewDownloaderFunc) {
+	downloadersMutex.Lock()
+	defer downloadersMutex.Unlock()
+
+	downloaders[scheme] = newDownloader
 }
 
-func (files *S3Downloader) Download(s3FileUrl string) (string, error) {
-	url, err := url.Parse(s3FileUrl)
+// NewDownloader return the downloader registered for the scheme of the file url
+func NewDownloader(fileUrl string, options *DownloadOptions) (Downloader, error) {
+	parsedUrl, err := url.Parse(fileUrl)
 	if err != nil {
-		return "", errors.New("Unable to parse file url: " + err.Error())
+		return nil, errors.New("Unable to parse file url: " + err.Error())
 	}
 
-	// TODO need to supported https://s3.amazonaws.com or
-	// http://${bucketName}.s3.amazonaws.com
-	bucketName := ""
-	fileKey := ""
-	switch url.Scheme {
-	case "s3":
-		urls := strings.Split(strings.Replace(s3FileUrl, "s3://", "", 1), "/")
-		bucketName = urls[0]
-		fileKey = urls[1]
-	default:
-		return "", errors.New("Unsupported file url: " + err.Error())
+	downloadersMutex.Lock()
+	newDownloader, ok := downloaders[parsedUrl.Scheme]
+	downloadersMutex.Unlock()
+	if !ok {
+		return nil, errors.New("Unsupported file url scheme: " + parsedUrl.Scheme)
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(files.region),
-		Credentials: credentials.NewStaticCredentials(files.awsId, files.awsSecret, ""),
-	})
+	return newDownloader(options)
+}
+
+// downloadSource is implemented by downloaders whose files are cached by downloadCached
+type downloadSource interface {
+	// etag return the version of the file, or an empty string when it can't be known
+	etag(fileUrl string) (string, error)
+	// fetch writes the file to the destination
+	fetch(fileUrl string, destination string) error
+}
+
+// downloadLock serializes the downloads of a cached file, counting the downloads using it
+type downloadLock struct {
+	sync.Mutex
+	users int
+}
+
+var (
+	downloadLocksMutex sync.Mutex
+	downloadLocks      = map[string]*downloadLock{}
+)
+
+// lockDownload locks the downloads of the cached file, so nodes of a deployment fetching the
+// same file wait for the first download instead of fetching it again, while other files are
+// downloaded in parallel. It return the function unlocking it.
+func lockDownload(destination string) func() {
+	downloadLocksMutex.Lock()
+	lock, ok := downloadLocks[destination]
+	if !ok {
+		lock = &downloadLock{}
+		downloadLocks[destination] = lock
+	}
+	lock.users++
+	downloadLocksMutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		downloadLocksMutex.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(downloadLocks, destination)
+		}
+		downloadLocksMutex.Unlock()
+	}
+}
+
+// downloadCached return the local copy of the file url, which is only fetched again when its
+// etag changed. Files without an etag are fetched every time.
+func downloadCached(config *viper.Viper, fileUrl string, source downloadSource) (string, error) {
+	etag, err := source.etag(fileUrl)
 	if err != nil {
-		return "", errors.New("Unable to create aws session: " + err.Error())
+		return "", err
+	}
+
+	downloadsPath := path.Join(config.GetString("filesPath"), "downloads")
+	if err := os.MkdirAll(downloadsPath, 0755); err != nil {
+		return "", errors.New("Unable to create downloads directory: " + err.Error())
 	}
 
-	downloader := s3manager.NewDownloader(sess)
-	tmpFile, err := ioutil.TempFile("", fileKey+".tmp")
+	hash := sha256.Sum256([]byte(fileUrl + "\n" + etag))
+	destination := path.Join(downloadsPath, hex.EncodeToString(hash[:16]))
+
+	unlock := lockDownload(destination)
+	defer unlock()
+
+	if etag != "" {
+		if _, err := os.Stat(destination); err == nil {
+			return destination, nil
+		}
+	}
+
+	tmpFile, err := ioutil.TempFile(downloadsPath, "download-")
 	if err != nil {
 		return "", errors.New("Unable to create temp file: " + err.Error())
 	}
-	defer func() {
-		tmpFile.Close()
-		os.Remove(tmpFile.Name())
-	}()
-
-	_, err = downloader.Download(tmpFile,
-		&s3.GetObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(fileKey),
-		})
-	if err != nil {
-		return "", fmt.Errorf("Unable to download %s from bucket %s: %v", fileKey, bucketName, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	if err := source.fetch(fileUrl, tmpFile.Name()); err != nil {
+		return "", fmt.Errorf("Unable to download %s: %s", fileUrl, err.Error())
 	}
 
-	destination := path.Join(files.config.GetString("filesPath"), fileKey)
 	if err := os.Rename(tmpFile.Name(), destination); err != nil {
 		return "", errors.New("Unable to rename file: " + err.Error())
 	}
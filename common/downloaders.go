@@ -0,0 +1,346 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	hpgcp "github.com/hyperpilotio/deployer/clusters/gcp"
+	"github.com/spf13/viper"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// bucketObject splits urls like s3://<bucket>/<key> into the bucket and the object key
+func bucketObject(fileUrl string) (string, string, error) {
+	parsedUrl, err := url.Parse(fileUrl)
+	if err != nil {
+		return "", "", errors.New("Unable to parse file url: " + err.Error())
+	}
+
+	key := strings.TrimPrefix(parsedUrl.Path, "/")
+	if parsedUrl.Host == "" || key == "" {
+		return "", "", errors.New("Unable to find bucket and object of file url " + fileUrl)
+	}
+
+	return parsedUrl.Host, key, nil
+}
+
+type S3Downloader struct {
+	config    *viper.Viper
+	region    string
+	awsId     string
+	awsSecret string
+}
+
+func NewS3Downloader(config *viper.Viper) (Downloader, error) {
+	return &S3Downloader{
+		config:    config,
+		region:    config.GetString("s3.region"),
+		awsId:     config.GetString("awsId"),
+		awsSecret: config.GetString("awsSecret"),
+	}, nil
+}
+
+func (files *S3Downloader) session() (*session.Session, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String(files.region),
+		Credentials: credentials.NewStaticCredentials(files.awsId, files.awsSecret, ""),
+	})
+	if err != nil {
+		return nil, errors.New("Unable to create aws session: " + err.Error())
+	}
+
+	return sess, nil
+}
+
+func (files *S3Downloader) Download(s3FileUrl string) (string, error) {
+	return downloadCached(files.config, s3FileUrl, files)
+}
+
+func (files *S3Downloader) etag(s3FileUrl string) (string, error) {
+	bucketName, fileKey, err := bucketObject(s3FileUrl)
+	if err != nil {
+		return "", err
+	}
+
+	sess, err := files.session()
+	if err != nil {
+		return "", err
+	}
+
+	output, err := s3.New(sess).HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(fileKey),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Unable to find %s in bucket %s: %v", fileKey, bucketName, err)
+	}
+
+	return aws.StringValue(output.ETag), nil
+}
+
+func (files *S3Downloader) fetch(s3FileUrl string, destination string) error {
+	bucketName, fileKey, err := bucketObject(s3FileUrl)
+	if err != nil {
+		return err
+	}
+
+	sess, err := files.session()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(destination)
+	if err != nil {
+		return errors.New("Unable to create file: " + err.Error())
+	}
+	defer file.Close()
+
+	_, err = s3manager.NewDownloader(sess).Download(file,
+		&s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(fileKey),
+		})
+	if err != nil {
+		return fmt.Errorf("Unable to download %s from bucket %s: %v", fileKey, bucketName, err)
+	}
+
+	return nil
+}
+
+// HTTPDownloader downloads http(s) urls. Urls can pin the content with a sha256=<hex>
+// fragment, which is verified after downloading.
+type HTTPDownloader struct {
+	config *viper.Viper
+	client *http.Client
+}
+
+func NewHTTPDownloader(options *DownloadOptions) (Downloader, error) {
+	return &HTTPDownloader{
+		config: options.Config,
+		client: &http.Client{
+			Timeout: 10 * time.Minute,
+		},
+	}, nil
+}
+
+// httpChecksum splits the sha256 fragment from the url
+func httpChecksum(fileUrl string) (string, string, error) {
+	parsedUrl, err := url.Parse(fileUrl)
+	if err != nil {
+		return "", "", errors.New("Unable to parse file url: " + err.Error())
+	}
+
+	checksum := ""
+	if parsedUrl.Fragment != "" {
+		if !strings.HasPrefix(parsedUrl.Fragment, "sha256=") {
+			return "", "", errors.New("Unsupported checksum in file url: " + parsedUrl.Fragment)
+		}
+		checksum = strings.ToLower(strings.TrimPrefix(parsedUrl.Fragment, "sha256="))
+		parsedUrl.Fragment = ""
+	}
+
+	return parsedUrl.String(), checksum, nil
+}
+
+func (files *HTTPDownloader) Download(fileUrl string) (string, error) {
+	return downloadCached(files.config, fileUrl, files)
+}
+
+func (files *HTTPDownloader) etag(fileUrl string) (string, error) {
+	requestUrl, checksum, err := httpChecksum(fileUrl)
+	if err != nil {
+		return "", err
+	}
+
+	if checksum != "" {
+		return checksum, nil
+	}
+
+	response, err := files.client.Head(requestUrl)
+	if err != nil {
+		return "", errors.New("Unable to request file headers: " + err.Error())
+	}
+	response.Body.Close()
+
+	// Servers not supporting HEAD requests leave the file uncached
+	if response.StatusCode != http.StatusOK {
+		return "", nil
+	}
+
+	if etag := response.Header.Get("ETag"); etag != "" {
+		return etag, nil
+	}
+
+	return response.Header.Get("Last-Modified"), nil
+}
+
+func (files *HTTPDownloader) fetch(fileUrl string, destination string) error {
+	requestUrl, checksum, err := httpChecksum(fileUrl)
+	if err != nil {
+		return err
+	}
+
+	response, err := files.client.Get(requestUrl)
+	if err != nil {
+		return errors.New("Unable to request file: " + err.Error())
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected response status %s", response.Status)
+	}
+
+	file, err := os.Create(destination)
+	if err != nil {
+		return errors.New("Unable to create file: " + err.Error())
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), response.Body); err != nil {
+		return errors.New("Unable to write file: " + err.Error())
+	}
+
+	if checksum != "" && hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return errors.New("Checksum of downloaded file doesn't match " + checksum)
+	}
+
+	return nil
+}
+
+// GCSDownloader downloads gs:// urls with the google cloud platform profile of the user
+type GCSDownloader struct {
+	config     *viper.Viper
+	gcpProfile *hpgcp.GCPProfile
+}
+
+func NewGCSDownloader(options *DownloadOptions) (Downloader, error) {
+	if options.GCPProfile == nil {
+		return nil, errors.New("Unable to download gs:// files without a google cloud platform profile")
+	}
+
+	return &GCSDownloader{
+		config:     options.Config,
+		gcpProfile: options.GCPProfile,
+	}, nil
+}
+
+func (files *GCSDownloader) service() (*storage.Service, error) {
+	client, err := hpgcp.CreateClient(files.gcpProfile)
+	if err != nil {
+		return nil, errors.New("Unable to create google cloud platform client: " + err.Error())
+	}
+
+	storageSvc, err := storage.New(client)
+	if err != nil {
+		return nil, errors.New("Unable to create google cloud platform storage service: " + err.Error())
+	}
+
+	return storageSvc, nil
+}
+
+func (files *GCSDownloader) Download(fileUrl string) (string, error) {
+	return downloadCached(files.config, fileUrl, files)
+}
+
+func (files *GCSDownloader) etag(fileUrl string) (string, error) {
+	bucketName, objectName, err := bucketObject(fileUrl)
+	if err != nil {
+		return "", err
+	}
+
+	storageSvc, err := files.service()
+	if err != nil {
+		return "", err
+	}
+
+	object, err := storageSvc.Objects.Get(bucketName, objectName).Do()
+	if err != nil {
+		return "", fmt.Errorf("Unable to find %s in bucket %s: %v", objectName, bucketName, err)
+	}
+
+	return object.Etag, nil
+}
+
+func (files *GCSDownloader) fetch(fileUrl string, destination string) error {
+	bucketName, objectName, err := bucketObject(fileUrl)
+	if err != nil {
+		return err
+	}
+
+	storageSvc, err := files.service()
+	if err != nil {
+		return err
+	}
+
+	response, err := storageSvc.Objects.Get(bucketName, objectName).Download()
+	if err != nil {
+		return fmt.Errorf("Unable to download %s from bucket %s: %v", objectName, bucketName, err)
+	}
+	defer response.Body.Close()
+
+	file, err := os.Create(destination)
+	if err != nil {
+		return errors.New("Unable to create file: " + err.Error())
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, response.Body); err != nil {
+		return errors.New("Unable to write file: " + err.Error())
+	}
+
+	return nil
+}
+
+// LocalDownloader serves file:// urls from the directories listed in the
+// downloads.fileAllowList config, without copying them
+type LocalDownloader struct {
+	allowedDirs []string
+}
+
+func NewLocalDownloader(options *DownloadOptions) (Downloader, error) {
+	return &LocalDownloader{
+		allowedDirs: options.Config.GetStringSlice("downloads.fileAllowList"),
+	}, nil
+}
+
+func (files *LocalDownloader) Download(fileUrl string) (string, error) {
+	parsedUrl, err := url.Parse(fileUrl)
+	if err != nil {
+		return "", errors.New("Unable to parse file url: " + err.Error())
+	}
+
+	// Symlinks are resolved so they can't point out of the allowed directories
+	location, err := filepath.EvalSymlinks(filepath.Clean(parsedUrl.Path))
+	if err != nil {
+		return "", errors.New("Unable to find local file: " + err.Error())
+	}
+
+	for _, dir := range files.allowedDirs {
+		allowedDir, err := filepath.EvalSymlinks(filepath.Clean(dir))
+		if err != nil {
+			continue
+		}
+
+		if strings.HasPrefix(location, allowedDir+string(filepath.Separator)) {
+			return location, nil
+		}
+	}
+
+	return "", errors.New("Local file is not in an allowed directory: " + parsedUrl.Path)
+}
@@ -0,0 +1,236 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestBucketObject(t *testing.T) {
+	tests := []struct {
+		fileUrl string
+		bucket  string
+		key     string
+		valid   bool
+	}{
+		{"s3://bucket/file.py", "bucket", "file.py", true},
+		{"gs://bucket/dir/file.tar.gz", "bucket", "dir/file.tar.gz", true},
+		{"s3://bucket/", "", "", false},
+		{"s3:///file.py", "", "", false},
+		{"s3://bucket", "", "", false},
+	}
+
+	for _, test := range tests {
+		bucket, key, err := bucketObject(test.fileUrl)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.fileUrl)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.fileUrl, err.Error())
+		} else if bucket != test.bucket || key != test.key {
+			t.Errorf("%s: got bucket %s and key %s", test.fileUrl, bucket, key)
+		}
+	}
+}
+
+func TestHttpChecksum(t *testing.T) {
+	tests := []struct {
+		fileUrl    string
+		requestUrl string
+		checksum   string
+		valid      bool
+	}{
+		{"https://host/file", "https://host/file", "", true},
+		{"https://host/file#sha256=ABCD", "https://host/file", "abcd", true},
+		{"https://host/file?a=b#sha256=abcd", "https://host/file?a=b", "abcd", true},
+		{"https://host/file#md5=abcd", "", "", false},
+	}
+
+	for _, test := range tests {
+		requestUrl, checksum, err := httpChecksum(test.fileUrl)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.fileUrl)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.fileUrl, err.Error())
+		} else if requestUrl != test.requestUrl || checksum != test.checksum {
+			t.Errorf("%s: got url %s and checksum %s", test.fileUrl, requestUrl, checksum)
+		}
+	}
+}
+
+func testDownloadConfig(t *testing.T) *viper.Viper {
+	filesPath, err := ioutil.TempDir("", "downloads")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err.Error())
+	}
+
+	downloadConfig := viper.New()
+	downloadConfig.Set("filesPath", filesPath)
+	return downloadConfig
+}
+
+func TestNewDownloader(t *testing.T) {
+	downloadConfig := testDownloadConfig(t)
+	defer os.RemoveAll(downloadConfig.GetString("filesPath"))
+	options := &DownloadOptions{Config: downloadConfig}
+
+	tests := []struct {
+		fileUrl string
+		valid   bool
+	}{
+		{"s3://bucket/file", true},
+		{"http://host/file", true},
+		{"https://host/file", true},
+		{"file:///tmp/file", true},
+		// gs:// urls need the google cloud platform profile of the user
+		{"gs://bucket/file", false},
+		{"ftp://host/file", false},
+	}
+
+	for _, test := range tests {
+		_, err := NewDownloader(test.fileUrl, options)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.fileUrl, err.Error())
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.fileUrl)
+		}
+	}
+}
+
+func TestHTTPDownloaderChecksum(t *testing.T) {
+	content := "deployment file"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, content)
+	}))
+	defer server.Close()
+
+	downloadConfig := testDownloadConfig(t)
+	defer os.RemoveAll(downloadConfig.GetString("filesPath"))
+	downloader, _ := NewHTTPDownloader(&DownloadOptions{Config: downloadConfig})
+
+	hash := sha256.Sum256([]byte(content))
+	location, err := downloader.Download(server.URL + "/file#sha256=" + hex.EncodeToString(hash[:]))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	downloaded, err := ioutil.ReadFile(location)
+	if err != nil {
+		t.Fatalf("Unable to read downloaded file: %s", err.Error())
+	}
+	if string(downloaded) != content {
+		t.Errorf("Downloaded %q, expected %q", string(downloaded), content)
+	}
+
+	otherHash := sha256.Sum256([]byte("other file"))
+	if _, err := downloader.Download(server.URL + "/file#sha256=" + hex.EncodeToString(otherHash[:])); err == nil {
+		t.Error("Expected the checksum mismatch to fail the download")
+	}
+}
+
+func TestLocalDownloaderAllowList(t *testing.T) {
+	root, err := ioutil.TempDir("", "local-downloads")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(root)
+
+	allowedDir := filepath.Join(root, "allowed")
+	outsideDir := filepath.Join(root, "outside")
+	os.Mkdir(allowedDir, 0755)
+	os.Mkdir(outsideDir, 0755)
+	ioutil.WriteFile(filepath.Join(allowedDir, "file"), []byte("allowed"), 0644)
+	ioutil.WriteFile(filepath.Join(outsideDir, "secret"), []byte("secret"), 0644)
+	if err := os.Symlink(filepath.Join(outsideDir, "secret"), filepath.Join(allowedDir, "link")); err != nil {
+		t.Fatalf("Unable to create symlink: %s", err.Error())
+	}
+
+	downloadConfig := viper.New()
+	downloadConfig.Set("downloads.fileAllowList", []string{allowedDir})
+	downloader, _ := NewLocalDownloader(&DownloadOptions{Config: downloadConfig})
+
+	tests := []struct {
+		name  string
+		path  string
+		valid bool
+	}{
+		{"allowed file", filepath.Join(allowedDir, "file"), true},
+		{"file outside", filepath.Join(outsideDir, "secret"), false},
+		{"symlink escaping", filepath.Join(allowedDir, "link"), false},
+		{"parent directory", allowedDir + "/../outside/secret", false},
+		{"allowed directory itself", allowedDir, false},
+		{"missing file", filepath.Join(allowedDir, "missing"), false},
+	}
+
+	for _, test := range tests {
+		_, err := downloader.Download("file://" + test.path)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+// countingSource counts the fetches of its files
+type countingSource struct {
+	mutex   sync.Mutex
+	fetches int
+}
+
+func (source *countingSource) etag(fileUrl string) (string, error) {
+	return "etag", nil
+}
+
+func (source *countingSource) fetch(fileUrl string, destination string) error {
+	source.mutex.Lock()
+	source.fetches++
+	source.mutex.Unlock()
+
+	return ioutil.WriteFile(destination, []byte(fileUrl), 0644)
+}
+
+func TestDownloadCachedFetchesOnce(t *testing.T) {
+	downloadConfig := testDownloadConfig(t)
+	defer os.RemoveAll(downloadConfig.GetString("filesPath"))
+
+	source := &countingSource{}
+	var group sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		group.Add(1)
+		go func(i int) {
+			defer group.Done()
+			// Two files, each downloaded by four nodes
+			if _, err := downloadCached(downloadConfig, fmt.Sprintf("s3://bucket/file-%d", i%2), source); err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+			}
+		}(i)
+	}
+	group.Wait()
+
+	if source.fetches != 2 {
+		t.Errorf("Fetched %d times, expected each file to be fetched once", source.fetches)
+	}
+
+	if len(downloadLocks) != 0 {
+		t.Errorf("%d download locks left", len(downloadLocks))
+	}
+}
@@ -0,0 +1,117 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+const defaultSshConcurrency = 10
+
+// NodeTarget is a node reached by RunOnNodes through its ssh client
+type NodeTarget struct {
+	NodeId int
+	Client SshClient
+}
+
+// SshTargets return the targets of the ssh clients, sorted by node id
+func SshTargets(sshClients map[int]SshClient) []NodeTarget {
+	targets := []NodeTarget{}
+	for nodeId, sshClient := range sshClients {
+		targets = append(targets, NodeTarget{
+			NodeId: nodeId,
+			Client: sshClient,
+		})
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].NodeId < targets[j].NodeId
+	})
+
+	return targets
+}
+
+// NodeTask is run on a node with the connection opened to it
+type NodeTask func(nodeId int, conn *SshConnection) error
+
+// NodeResult is the outcome of a task on a node
+type NodeResult struct {
+	NodeId int    `json:"nodeId"`
+	Host   string `json:"host"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NodeResults are sorted by node id
+type NodeResults []NodeResult
+
+// Err return an error listing the nodes the task failed on, or nil if it succeeded on all of them
+func (results NodeResults) Err() error {
+	failures := []string{}
+	for _, result := range results {
+		if result.Error != "" {
+			failures = append(failures, fmt.Sprintf("node %d (%s): %s", result.NodeId, result.Host, result.Error))
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("Failed on %d of %d nodes: %s", len(failures), len(results), strings.Join(failures, "; "))
+}
+
+// SshConcurrency return the number of nodes ssh tasks run on at the same time, set by the
+// ssh.concurrency config
+func SshConcurrency(config *viper.Viper) int {
+	if concurrency := config.GetInt("ssh.concurrency"); concurrency > 0 {
+		return concurrency
+	}
+
+	return defaultSshConcurrency
+}
+
+// RunOnNodes runs the task on the nodes, at most concurrency of them at a time. Each node gets
+// a single connection, reused by everything the task does on it.
+func RunOnNodes(targets []NodeTarget, concurrency int, task NodeTask) NodeResults {
+	if concurrency <= 0 {
+		concurrency = defaultSshConcurrency
+	}
+
+	results := make(NodeResults, len(targets))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target NodeTarget) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			results[i] = NodeResult{
+				NodeId: target.NodeId,
+				Host:   target.Client.Host,
+			}
+
+			conn, err := target.Client.Connect()
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			defer conn.Close()
+
+			if err := task(target.NodeId, conn); err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, target)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].NodeId < results[j].NodeId
+	})
+
+	return results
+}
@@ -0,0 +1,151 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startSshServer starts an ssh server accepting any client, which only opens connections,
+// and return its address
+func startSshServer(t *testing.T) (string, func()) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate host key: %s", err.Error())
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("Unable to create host key signer: %s", err.Error())
+	}
+
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err.Error())
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					newChannel.Reject(ssh.Prohibited, "no channels")
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+func TestRunOnNodes(t *testing.T) {
+	address, stop := startSshServer(t)
+	defer stop()
+
+	sshClients := map[int]SshClient{}
+	for nodeId := 1; nodeId <= 6; nodeId++ {
+		sshClients[nodeId] = SshClient{
+			Host: address,
+			ClientConfig: &ssh.ClientConfig{
+				User:            "test",
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+		}
+	}
+
+	var mutex sync.Mutex
+	running := 0
+	maxRunning := 0
+	results := RunOnNodes(SshTargets(sshClients), 2, func(nodeId int, conn *SshConnection) error {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+
+		if nodeId%3 == 0 {
+			return errors.New("task failed")
+		}
+		return nil
+	})
+
+	if maxRunning > 2 {
+		t.Errorf("Expected at most 2 nodes at a time, got %d", maxRunning)
+	}
+
+	if len(results) != 6 {
+		t.Fatalf("Expected 6 results, got %d", len(results))
+	}
+	for i, result := range results {
+		if result.NodeId != i+1 || result.Host != address {
+			t.Errorf("Unexpected result %d: %+v", i, result)
+		}
+
+		failed := result.NodeId%3 == 0
+		if (result.Error != "") != failed {
+			t.Errorf("Node %d: expected failure %v, got error %q", result.NodeId, failed, result.Error)
+		}
+	}
+
+	err := results.Err()
+	if err == nil {
+		t.Fatal("Expected failed nodes to be reported")
+	}
+	if !strings.Contains(err.Error(), "2 of 6 nodes") ||
+		!strings.Contains(err.Error(), "node 3 ("+address+"): task failed") ||
+		!strings.Contains(err.Error(), "node 6 ("+address+"): task failed") {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+}
+
+func TestNodeResultsErr(t *testing.T) {
+	tests := []struct {
+		name     string
+		results  NodeResults
+		expected string
+	}{
+		{"no nodes", NodeResults{}, ""},
+		{"succeeded", NodeResults{{NodeId: 1, Host: "a"}, {NodeId: 2, Host: "b"}}, ""},
+		{
+			"failed nodes",
+			NodeResults{{NodeId: 1, Host: "a", Error: "timeout"}, {NodeId: 2, Host: "b"}, {NodeId: 3, Host: "c", Error: "refused"}},
+			"Failed on 2 of 3 nodes: node 1 (a): timeout; node 3 (c): refused",
+		},
+	}
+
+	for _, test := range tests {
+		err := test.results.Err()
+		if test.expected == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+		} else if test.expected != "" && (err == nil || err.Error() != test.expected) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.expected, err)
+		}
+	}
+}
@@ -3,7 +3,8 @@ package common
 import (
 	"bytes"
 	"errors"
-	"io/ioutil"
+	"fmt"
+	"io"
 	"os"
 	"path"
 	"time"
@@ -44,11 +45,38 @@ func (a *SshClient) connectViaBastion() (*ssh.Client, *ssh.Client, error) {
 	return client, bastion, nil
 }
 
-// Connects to the remote SSH server, returns error if it couldn't establish a session to the SSH server
+// sshConnectWindow is how long connecting to a server is retried for, as servers that were
+// just launched can take that long to accept ssh connections
+const sshConnectWindow = 50 * time.Second
+
+// sshRetryWaits return the waits between connection attempts, an exponential backoff from 2s
+// capped at 16s, with the last wait cut so they add up to the window
+func sshRetryWaits(window time.Duration) []time.Duration {
+	waits := []time.Duration{}
+	backoff := 2 * time.Second
+	for waited := time.Duration(0); waited < window; {
+		wait := backoff
+		if remaining := window - waited; wait > remaining {
+			wait = remaining
+		}
+		waits = append(waits, wait)
+		waited += wait
+
+		backoff *= 2
+		if backoff > 16*time.Second {
+			backoff = 16 * time.Second
+		}
+	}
+
+	return waits
+}
+
+// Connects to the remote SSH server, returns error if it couldn't establish a session to the SSH server.
+// Attempts are retried with exponential backoff until sshConnectWindow has been waited.
 func (a *SshClient) connect() (*ssh.Client, *ssh.Client, error) {
-	maxRetries := 5
+	waits := sshRetryWaits(sshConnectWindow)
 	var sshError error
-	for i := 1; i <= maxRetries; i++ {
+	for i := 0; ; i++ {
 		if a.BastionHost != "" {
 			client, bastion, err := a.connectViaBastion()
 			if err != nil {
@@ -60,30 +88,69 @@ func (a *SshClient) connect() (*ssh.Client, *ssh.Client, error) {
 			client, err := ssh.Dial("tcp", a.Host, a.ClientConfig)
 			if err != nil {
 				sshError = errors.New("Unable to connect to server: " + err.Error())
-				glog.Infof("Unable to ssh to %s, retrying %d time", a.Host, i)
 			} else {
 				return client, nil, nil
 			}
 		}
 
-		time.Sleep(time.Duration(10) * time.Second)
+		if i == len(waits) {
+			break
+		}
+
+		glog.Infof("Unable to ssh to %s, retrying %d time in %s", a.Host, i+1, waits[i])
+		time.Sleep(waits[i])
 	}
 
 	return nil, nil, sshError
 }
 
-func (a *SshClient) RunCommand(command string, verbose bool) error {
-	client, bastion, connectErr := a.connect()
-	if connectErr != nil {
-		return connectErr
+// SshConnection is an open connection to a server, reused by its commands and file
+// copies until closed
+type SshConnection struct {
+	Host       string
+	client     *ssh.Client
+	bastion    *ssh.Client
+	sftpClient *sftp.Client
+}
+
+// Connect opens a connection to the server, which the caller has to close
+func (a *SshClient) Connect() (*SshConnection, error) {
+	client, bastion, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SshConnection{
+		Host:    a.Host,
+		client:  client,
+		bastion: bastion,
+	}, nil
+}
+
+func (conn *SshConnection) Close() {
+	if conn.sftpClient != nil {
+		conn.sftpClient.Close()
+	}
+	conn.client.Close()
+	if conn.bastion != nil {
+		conn.bastion.Close()
 	}
+}
 
-	if bastion != nil {
-		defer bastion.Close()
+func (conn *SshConnection) sftp() (*sftp.Client, error) {
+	if conn.sftpClient == nil {
+		sftpClient, err := sftp.NewClient(conn.client)
+		if err != nil {
+			return nil, errors.New("Unable to create sftp client: " + err.Error())
+		}
+		conn.sftpClient = sftpClient
 	}
-	defer client.Close()
 
-	session, err := client.NewSession()
+	return conn.sftpClient, nil
+}
+
+func (conn *SshConnection) RunCommand(command string, verbose bool) error {
+	session, err := conn.client.NewSession()
 	if err != nil {
 		return errors.New("Unable to create ssh session: " + err.Error())
 	}
@@ -109,21 +176,90 @@ func (a *SshClient) RunCommand(command string, verbose bool) error {
 	return nil
 }
 
-// Copies the contents of an local file to a remote location
-func (a *SshClient) CopyLocalFileToRemote(localPath string, remotePath string) error {
-	client, bastion, connectErr := a.connect()
-	if connectErr != nil {
-		return connectErr
+// maxCommandOutput bounds the stdout and stderr kept of an executed command
+const maxCommandOutput = 1024 * 1024
+
+// CommandResult is the output and exit code of an executed command
+type CommandResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+	// Output beyond maxCommandOutput was dropped
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// limitedBuffer keeps the first maxCommandOutput bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (buffer *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := maxCommandOutput - buffer.Len(); len(p) > remaining {
+		buffer.truncated = true
+		if remaining > 0 {
+			buffer.Buffer.Write(p[:remaining])
+		}
+		return len(p), nil
+	}
+
+	return buffer.Buffer.Write(p)
+}
+
+// ExecCommand runs the command and captures its output and exit code, killing it after the
+// timeout. A non zero exit code isn't an error.
+func (conn *SshConnection) ExecCommand(command string, timeout time.Duration) (*CommandResult, error) {
+	session, err := conn.client.NewSession()
+	if err != nil {
+		return nil, errors.New("Unable to create ssh session: " + err.Error())
+	}
+	defer session.Close()
+
+	var stdout, stderr limitedBuffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command)
+	}()
+
+	timedOut := false
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		timedOut = true
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		err = <-done
+	}
+
+	result := &CommandResult{
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+	}
+
+	if timedOut {
+		return result, fmt.Errorf("Command timed out after %s", timeout)
 	}
 
-	if bastion != nil {
-		defer bastion.Close()
+	if err != nil {
+		exitErr, ok := err.(*ssh.ExitError)
+		if !ok {
+			return result, errors.New("Unable to run command: " + err.Error())
+		}
+		result.ExitCode = exitErr.ExitStatus()
 	}
-	defer client.Close()
 
-	sftpClient, err := sftp.NewClient(client)
+	return result, nil
+}
+
+// Copies the contents of an local file to a remote location
+func (conn *SshConnection) CopyLocalFileToRemote(localPath string, remotePath string) error {
+	sftpClient, err := conn.sftp()
 	if err != nil {
-		return errors.New("Unable to create sftp client: " + err.Error())
+		return err
 	}
 
 	sftpClient.Mkdir(path.Dir(remotePath))
@@ -134,32 +270,50 @@ func (a *SshClient) CopyLocalFileToRemote(localPath string, remotePath string) e
 	}
 	defer dstFile.Close()
 
-	bytes, readError := ioutil.ReadFile(localPath)
-	if readError != nil {
-		return errors.New("Unable to read local file: " + readError.Error())
+	srcFile, err := os.Open(localPath)
+	if err != nil {
+		return errors.New("Unable to read local file: " + err.Error())
 	}
+	defer srcFile.Close()
 
-	if _, err := dstFile.Write(bytes); err != nil {
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
 		return errors.New("Unable to write to remote file: " + err.Error())
 	}
 
 	return nil
 }
 
-func (a *SshClient) CopyRemoteFileToLocal(remotePath string, localPath string) error {
-	client, bastion, connectErr := a.connect()
-	if connectErr != nil {
-		return connectErr
+// OpenRemoteFile opens the regular file at the remote path for reading, the caller has to
+// close it
+func (conn *SshConnection) OpenRemoteFile(remotePath string) (io.ReadCloser, os.FileInfo, error) {
+	sftpClient, err := conn.sftp()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := sftpClient.Open(remotePath)
+	if err != nil {
+		return nil, nil, errors.New("Unable to open remote path: " + err.Error())
 	}
 
-	if bastion != nil {
-		defer bastion.Close()
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, errors.New("Unable to stat remote path: " + err.Error())
+	}
+
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, nil, errors.New("Remote path is not a regular file")
 	}
-	defer client.Close()
 
-	sftpClient, err := sftp.NewClient(client)
+	return file, info, nil
+}
+
+func (conn *SshConnection) CopyRemoteFileToLocal(remotePath string, localPath string) error {
+	sftpClient, err := conn.sftp()
 	if err != nil {
-		return errors.New("Unable to create sftp client: " + err.Error())
+		return err
 	}
 
 	srcFile, err := sftpClient.Open(remotePath)
@@ -179,6 +333,37 @@ func (a *SshClient) CopyRemoteFileToLocal(remotePath string, localPath string) e
 	return nil
 }
 
+func (a *SshClient) RunCommand(command string, verbose bool) error {
+	conn, err := a.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.RunCommand(command, verbose)
+}
+
+// Copies the contents of an local file to a remote location
+func (a *SshClient) CopyLocalFileToRemote(localPath string, remotePath string) error {
+	conn, err := a.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.CopyLocalFileToRemote(localPath, remotePath)
+}
+
+func (a *SshClient) CopyRemoteFileToLocal(remotePath string, localPath string) error {
+	conn, err := a.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.CopyRemoteFileToLocal(remotePath, localPath)
+}
+
 func NewSshClient(host string, config *ssh.ClientConfig, bastionHost string) SshClient {
 	return SshClient{
 		Host:         host,
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSshRetryWaits(t *testing.T) {
+	s := time.Second
+	tests := []struct {
+		window   time.Duration
+		expected []time.Duration
+	}{
+		{sshConnectWindow, []time.Duration{2 * s, 4 * s, 8 * s, 16 * s, 16 * s, 4 * s}},
+		{62 * s, []time.Duration{2 * s, 4 * s, 8 * s, 16 * s, 16 * s, 16 * s}},
+		{3 * s, []time.Duration{2 * s, 1 * s}},
+		{2 * s, []time.Duration{2 * s}},
+		{0, []time.Duration{}},
+	}
+
+	for _, test := range tests {
+		waits := sshRetryWaits(test.window)
+		if !reflect.DeepEqual(waits, test.expected) {
+			t.Errorf("%s window: expected waits %v, got %v", test.window, test.expected, waits)
+		}
+
+		waited := time.Duration(0)
+		for _, wait := range waits {
+			waited += wait
+		}
+		if waited != test.window {
+			t.Errorf("%s window: expected waits to add up to the window, got %s", test.window, waited)
+		}
+	}
+}
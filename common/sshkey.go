@@ -0,0 +1,79 @@
+package common
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const authorizedKeysCommandTimeout = time.Minute
+
+// authorizedKeyBlob return the base64 blob of the public key, which identifies it in
+// authorized_keys regardless of its options and comment
+func authorizedKeyBlob(publicKey string) (string, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return "", errors.New("Unable to parse public key: " + err.Error())
+	}
+
+	return base64.StdEncoding.EncodeToString(key.Marshal()), nil
+}
+
+func runAuthorizedKeysCommand(targets []NodeTarget, concurrency int, command string) NodeResults {
+	return RunOnNodes(targets, concurrency, func(nodeId int, conn *SshConnection) error {
+		result, err := conn.ExecCommand(command, authorizedKeysCommandTimeout)
+		if err != nil {
+			return err
+		}
+
+		if result.ExitCode != 0 {
+			return fmt.Errorf("Exited with %d: %s", result.ExitCode, result.Stderr)
+		}
+
+		return nil
+	})
+}
+
+// AddAuthorizedKey adds the public key to the authorized keys of the ssh user on the nodes,
+// unless it's already there
+func AddAuthorizedKey(targets []NodeTarget, concurrency int, publicKey string) NodeResults {
+	blob, err := authorizedKeyBlob(publicKey)
+	if err != nil {
+		return failedResults(targets, err)
+	}
+
+	command := fmt.Sprintf("mkdir -p ~/.ssh && chmod 700 ~/.ssh && "+
+		"(grep -q -F %s ~/.ssh/authorized_keys 2>/dev/null || echo %s >> ~/.ssh/authorized_keys) && "+
+		"chmod 600 ~/.ssh/authorized_keys", shellQuote(blob), shellQuote(publicKey))
+	return runAuthorizedKeysCommand(targets, concurrency, command)
+}
+
+// RemoveAuthorizedKey removes the public key from the authorized keys of the ssh user on the
+// nodes. The file is rewritten in place to keep its owner and mode.
+func RemoveAuthorizedKey(targets []NodeTarget, concurrency int, publicKey string) NodeResults {
+	blob, err := authorizedKeyBlob(publicKey)
+	if err != nil {
+		return failedResults(targets, err)
+	}
+
+	command := fmt.Sprintf("{ grep -v -F %s ~/.ssh/authorized_keys || true; } > ~/.ssh/authorized_keys.rotate && "+
+		"cat ~/.ssh/authorized_keys.rotate > ~/.ssh/authorized_keys && rm -f ~/.ssh/authorized_keys.rotate",
+		shellQuote(blob))
+	return runAuthorizedKeysCommand(targets, concurrency, command)
+}
+
+func failedResults(targets []NodeTarget, err error) NodeResults {
+	results := NodeResults{}
+	for _, target := range targets {
+		results = append(results, NodeResult{
+			NodeId: target.NodeId,
+			Host:   target.Client.Host,
+			Error:  err.Error(),
+		})
+	}
+
+	return results
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperpilotio/deployer/common"
+)
+
+func (server *Server) getNodeFile(c *gin.Context) {
+	deploymentName := c.Param("deployment")
+	remotePath := c.Query("path")
+	if remotePath == "" || !path.IsAbs(remotePath) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "An absolute path of the file is required",
+		})
+		return
+	}
+
+	nodeId, err := strconv.Atoi(c.Param("nodeId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Invalid node id " + c.Param("nodeId"),
+		})
+		return
+	}
+
+	server.mutex.Lock()
+	deploymentInfo, ok := server.DeployedClusters[deploymentName]
+	available := ok && deploymentInfo.State == AVAILABLE
+	server.mutex.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  "Unable to find deployment",
+		})
+		return
+	}
+
+	if !available {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Deployment is not available",
+		})
+		return
+	}
+
+	sshClients, err := deploymentInfo.Deployer.GetNodeSshClients()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Unable to get ssh clients of nodes: " + err.Error(),
+		})
+		return
+	}
+
+	sshClient, ok := sshClients[nodeId]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  fmt.Sprintf("Unable to find reachable node %d", nodeId),
+		})
+		return
+	}
+
+	conn, err := sshClient.Connect()
+	server.storePinnedHostKeys(deploymentInfo)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": true,
+			"data":  "Unable to connect to node: " + err.Error(),
+		})
+		return
+	}
+	defer conn.Close()
+
+	file, info, err := conn.OpenRemoteFile(remotePath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	log := deploymentInfo.Deployer.GetLog().Logger
+	log.Infof("Downloading %s from node %d", remotePath, nodeId)
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(remotePath)))
+	c.Header("Content-Length", strconv.FormatInt(info.Size(), 10))
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, file); err != nil {
+		log.Warningf("Unable to send %s of node %d: %s", remotePath, nodeId, err.Error())
+	}
+}
+
+// collectDiagnostics streams a tarball of the deployment, its log and what its deployer
+// collects from the cluster, for post-mortems of failed deployments too
+func (server *Server) collectDiagnostics(c *gin.Context) {
+	deploymentName := c.Param("deployment")
+
+	server.mutex.Lock()
+	deploymentInfo, ok := server.DeployedClusters[deploymentName]
+	server.mutex.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  "Unable to find deployment",
+		})
+		return
+	}
+
+	log := deploymentInfo.Deployer.GetLog().Logger
+	log.Infof("Collecting diagnostics of deployment %s", deploymentName)
+
+	prefix := fmt.Sprintf("%s-diagnostics-%s", deploymentName, time.Now().UTC().Format("20060102150405"))
+	c.Header("Content-Type", "application/gzip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", prefix+".tar.gz"))
+	c.Status(http.StatusOK)
+
+	diagnostics := common.NewDiagnostics(c.Writer, prefix)
+	defer func() {
+		if err := diagnostics.Close(); err != nil {
+			log.Warningf("Unable to finish diagnostics: %s", err.Error())
+		}
+	}()
+
+	if content, err := json.MarshalIndent(deploymentInfo.Deployment, "", "  "); err != nil {
+		diagnostics.AddError("deployment.json", err)
+	} else if err := diagnostics.AddFile("deployment.json", content); err != nil {
+		log.Warningf("Unable to write diagnostics: %s", err.Error())
+		return
+	}
+
+	logPath := path.Join(server.Config.GetString("filesPath"), "log", deploymentName+".log")
+	if content, err := ioutil.ReadFile(logPath); err != nil {
+		diagnostics.AddError("deployer.log", err)
+	} else if err := diagnostics.AddFile("deployer.log", content); err != nil {
+		log.Warningf("Unable to write diagnostics: %s", err.Error())
+		return
+	}
+
+	if err := deploymentInfo.Deployer.CollectDiagnostics(diagnostics); err != nil {
+		log.Warningf("Unable to write diagnostics: %s", err.Error())
+	}
+	server.storePinnedHostKeys(deploymentInfo)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperpilotio/deployer/common"
+)
+
+const (
+	defaultExecTimeout = 60 * time.Second
+	maxExecTimeout     = 10 * time.Minute
+
+	// allNodesId is the node id of requests fanning out to every node of the deployment
+	allNodesId = "all"
+)
+
+type ExecRequest struct {
+	Command string `form:"command" json:"command" binding:"required"`
+	// Seconds the command can run before it's killed, defaults to 60
+	TimeoutSeconds int `form:"timeoutSeconds" json:"timeoutSeconds"`
+}
+
+// NodeExecResult is the outcome of a command on a node. The command result is missing when
+// the node couldn't be reached.
+type NodeExecResult struct {
+	NodeId int    `json:"nodeId"`
+	Host   string `json:"host"`
+	*common.CommandResult
+	Error string `json:"error,omitempty"`
+}
+
+// nodeTargets return the ssh targets of the node id param, which is either a node id or all
+func nodeTargets(sshClients map[int]common.SshClient, nodeIdParam string) ([]common.NodeTarget, error) {
+	if nodeIdParam == allNodesId {
+		return common.SshTargets(sshClients), nil
+	}
+
+	nodeId, err := strconv.Atoi(nodeIdParam)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid node id %s", nodeIdParam)
+	}
+
+	sshClient, ok := sshClients[nodeId]
+	if !ok {
+		return nil, fmt.Errorf("Unable to find reachable node %d", nodeId)
+	}
+
+	return []common.NodeTarget{{
+		NodeId: nodeId,
+		Client: sshClient,
+	}}, nil
+}
+
+func (server *Server) execNodeCommand(c *gin.Context) {
+	deploymentName := c.Param("deployment")
+
+	var request ExecRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Error deserializing exec request: " + err.Error(),
+		})
+		return
+	}
+
+	timeout := time.Duration(request.TimeoutSeconds) * time.Second
+	if request.TimeoutSeconds == 0 {
+		timeout = defaultExecTimeout
+	}
+	if timeout <= 0 || timeout > maxExecTimeout {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  fmt.Sprintf("Timeout has to be between 1 and %d seconds", int(maxExecTimeout.Seconds())),
+		})
+		return
+	}
+
+	server.mutex.Lock()
+	deploymentInfo, ok := server.DeployedClusters[deploymentName]
+	available := ok && deploymentInfo.State == AVAILABLE
+	server.mutex.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  "Unable to find deployment",
+		})
+		return
+	}
+
+	if !available {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Deployment is not available",
+		})
+		return
+	}
+
+	sshClients, err := deploymentInfo.Deployer.GetNodeSshClients()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Unable to get ssh clients of nodes: " + err.Error(),
+		})
+		return
+	}
+
+	targets, err := nodeTargets(sshClients, c.Param("nodeId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  err.Error(),
+		})
+		return
+	}
+
+	log := deploymentInfo.Deployer.GetLog().Logger
+	log.Infof("Executing command on %d nodes: %s", len(targets), request.Command)
+
+	var resultsMutex sync.Mutex
+	commandResults := map[int]*common.CommandResult{}
+	nodeResults := common.RunOnNodes(targets, common.SshConcurrency(server.Config),
+		func(nodeId int, conn *common.SshConnection) error {
+			result, err := conn.ExecCommand(request.Command, timeout)
+			resultsMutex.Lock()
+			commandResults[nodeId] = result
+			resultsMutex.Unlock()
+			return err
+		})
+
+	server.storePinnedHostKeys(deploymentInfo)
+
+	results := []NodeExecResult{}
+	for _, nodeResult := range nodeResults {
+		if nodeResult.Error != "" {
+			log.Warningf("Unable to execute command on node %d: %s", nodeResult.NodeId, nodeResult.Error)
+		}
+
+		results = append(results, NodeExecResult{
+			NodeId:        nodeResult.NodeId,
+			Host:          nodeResult.Host,
+			CommandResult: commandResults[nodeResult.NodeId],
+			Error:         nodeResult.Error,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"error": nodeResults.Err() != nil,
+		"data":  results,
+	})
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/hyperpilotio/deployer/common"
+)
+
+// StoreFile is the catalog entry of an uploaded file, persisted in the files store.
+// Contents are stored once per checksum, so identical uploads share the same file on disk.
+type StoreFile struct {
+	UserId   string `json:"userId"`
+	FileId   string `json:"fileId"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+	Uploaded string `json:"uploaded"`
+}
+
+// validFileUserId checks the user id can't make the key of its files collide with the files
+// of another user
+func validFileUserId(userId string) error {
+	if userId == "" {
+		return errors.New("Unable to find userId")
+	}
+
+	if strings.Contains(userId, "_") {
+		return errors.New("User id " + userId + " can't contain '_'")
+	}
+
+	return nil
+}
+
+func (server *Server) blobsPath() string {
+	return path.Join(server.Config.GetString("filesPath"), "blobs")
+}
+
+func (server *Server) blobPath(checksum string) string {
+	return path.Join(server.blobsPath(), checksum)
+}
+
+// uploadedFiles return a copy of the locations of the uploaded files, for deployers to read
+// while files are uploaded and deleted
+func (server *Server) uploadedFiles() map[string]string {
+	server.filesMutex.RLock()
+	defer server.filesMutex.RUnlock()
+
+	uploadedFiles := make(map[string]string, len(server.UploadedFiles))
+	for key, location := range server.UploadedFiles {
+		uploadedFiles[key] = location
+	}
+
+	return uploadedFiles
+}
+
+// reloadFiles loads the file catalog from the store, skipping files missing on disk
+func (server *Server) reloadFiles() error {
+	files, err := server.FileStore.LoadAll(func() interface{} {
+		return &StoreFile{}
+	})
+	if err != nil {
+		return errors.New("Unable to load files: " + err.Error())
+	}
+
+	server.filesMutex.Lock()
+	defer server.filesMutex.Unlock()
+
+	for _, file := range files.([]interface{}) {
+		storeFile := file.(*StoreFile)
+		location := server.blobPath(storeFile.Checksum)
+		if _, err := os.Stat(location); err != nil {
+			glog.Warningf("Skip loading file %s of user %s: %s", storeFile.FileId, storeFile.UserId, err.Error())
+			continue
+		}
+
+		key := common.UploadedFileKey(storeFile.UserId, storeFile.FileId)
+		server.Files[key] = storeFile
+		server.UploadedFiles[key] = location
+	}
+
+	return nil
+}
+
+// stageBlob writes the content to a temporary file in the blobs directory, and return its
+// path along with the sha256 checksum and size of the content. The caller removes the file,
+// unless it was moved into place by commitBlob.
+func (server *Server) stageBlob(reader io.Reader) (string, string, int64, error) {
+	if err := os.MkdirAll(server.blobsPath(), 0755); err != nil {
+		return "", "", 0, errors.New("Unable to create blobs directory: " + err.Error())
+	}
+
+	// The temporary file is in the blobs directory so it can be renamed into place
+	tempFile, err := ioutil.TempFile(server.blobsPath(), "upload-")
+	if err != nil {
+		return "", "", 0, errors.New("Unable to create temporary file: " + err.Error())
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hash), reader)
+	tempFile.Close()
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", "", 0, errors.New("Unable to write to temporary file: " + err.Error())
+	}
+
+	return tempFile.Name(), hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// commitBlob moves the staged content in place under its checksum, unless the same content is
+// stored already. Callers must hold the files mutex until the blob is referenced, so
+// removeUnusedBlob can't delete it in between.
+func (server *Server) commitBlob(stagedPath string, checksum string) error {
+	destination := server.blobPath(checksum)
+	if _, err := os.Stat(destination); err == nil {
+		return nil
+	}
+
+	if err := os.Rename(stagedPath, destination); err != nil {
+		return errors.New("Unable to rename file: " + err.Error())
+	}
+
+	return nil
+}
+
+// removeUnusedBlob deletes the content of the checksum once no catalog entry refers to it.
+// Callers must hold the files mutex.
+func (server *Server) removeUnusedBlob(checksum string) {
+	for _, file := range server.Files {
+		if file.Checksum == checksum {
+			return
+		}
+	}
+
+	if err := os.Remove(server.blobPath(checksum)); err != nil && !os.IsNotExist(err) {
+		glog.Warningf("Unable to remove blob %s: %s", checksum, err.Error())
+	}
+}
+
+// fileDeployments return the names of the live deployments using the file of a user.
+// Callers must hold the server mutex.
+func (server *Server) fileDeployments(userId string, fileId string) []string {
+	names := []string{}
+	for name, deploymentInfo := range server.DeployedClusters {
+		if deploymentInfo.State == DELETED || deploymentInfo.State == FAILED {
+			continue
+		}
+
+		deployment := deploymentInfo.Deployment
+		if deployment.UserId != userId {
+			continue
+		}
+
+		for _, file := range deployment.Files {
+			if file.FileId == fileId {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// fileUserId return the user of the file request, which is either part of the path or
+// passed as the userId query parameter
+func fileUserId(c *gin.Context) string {
+	if userId := c.Param("userId"); userId != "" {
+		return userId
+	}
+
+	return c.Query("userId")
+}
+
+func (server *Server) getFiles(c *gin.Context) {
+	userId := fileUserId(c)
+	if userId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Unable to find userId",
+		})
+		return
+	}
+
+	server.filesMutex.RLock()
+	defer server.filesMutex.RUnlock()
+
+	files := []*StoreFile{}
+	for _, file := range server.Files {
+		if file.UserId == userId {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].FileId < files[j].FileId
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"error": false,
+		"data":  files,
+	})
+}
+
+func (server *Server) uploadFile(c *gin.Context) {
+	userId := fileUserId(c)
+	if err := validFileUserId(userId); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  err.Error(),
+		})
+		return
+	}
+
+	upload, _, err := c.Request.FormFile("upload")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Unable to read uploaded file: " + err.Error(),
+		})
+		return
+	}
+	defer upload.Close()
+
+	stagedPath, checksum, size, err := server.stageBlob(upload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": true,
+			"data":  err.Error(),
+		})
+		return
+	}
+	defer os.Remove(stagedPath)
+
+	fileId := c.Param("fileId")
+	key := common.UploadedFileKey(userId, fileId)
+	storeFile := &StoreFile{
+		UserId:   userId,
+		FileId:   fileId,
+		Size:     size,
+		Checksum: checksum,
+		Uploaded: time.Now().Format(time.RFC3339),
+	}
+
+	server.filesMutex.Lock()
+	defer server.filesMutex.Unlock()
+
+	if err := server.commitBlob(stagedPath, checksum); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": true,
+			"data":  err.Error(),
+		})
+		return
+	}
+
+	if err := server.FileStore.Store(key, storeFile); err != nil {
+		server.removeUnusedBlob(checksum)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": true,
+			"data":  "Unable to store file: " + err.Error(),
+		})
+		return
+	}
+
+	previousFile, replaced := server.Files[key]
+	server.Files[key] = storeFile
+	server.UploadedFiles[key] = server.blobPath(checksum)
+	if replaced && previousFile.Checksum != checksum {
+		server.removeUnusedBlob(previousFile.Checksum)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"error": false,
+		"data":  storeFile,
+	})
+}
+
+func (server *Server) deleteFile(c *gin.Context) {
+	userId := fileUserId(c)
+	fileId := c.Param("fileId")
+	key := common.UploadedFileKey(userId, fileId)
+
+	server.filesMutex.Lock()
+	defer server.filesMutex.Unlock()
+
+	storeFile, ok := server.Files[key]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  fmt.Sprintf("File %s of user %s not found", fileId, userId),
+		})
+		return
+	}
+
+	server.mutex.Lock()
+	deployments := server.fileDeployments(userId, fileId)
+	server.mutex.Unlock()
+
+	if len(deployments) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":       true,
+			"data":        fmt.Sprintf("File %s is used by %d deployments", fileId, len(deployments)),
+			"deployments": deployments,
+		})
+		return
+	}
+
+	if err := server.FileStore.Delete(key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": true,
+			"data":  "Unable to delete file from store: " + err.Error(),
+		})
+		return
+	}
+
+	delete(server.Files, key)
+	delete(server.UploadedFiles, key)
+	server.removeUnusedBlob(storeFile.Checksum)
+
+	c.JSON(http.StatusOK, gin.H{
+		"error": false,
+		"data":  "",
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hyperpilotio/deployer/apis"
+	"github.com/spf13/viper"
+)
+
+func newFilesTestServer(t *testing.T) (*Server, func()) {
+	filesPath, err := ioutil.TempDir("", "deployer-files")
+	if err != nil {
+		t.Fatalf("Unable to create files directory: %s", err.Error())
+	}
+
+	config := viper.New()
+	config.Set("filesPath", filesPath)
+	server := NewServer(config)
+
+	return server, func() { os.RemoveAll(filesPath) }
+}
+
+func TestValidFileUserId(t *testing.T) {
+	tests := []struct {
+		userId string
+		valid  bool
+	}{
+		{"alan", true},
+		{"alan-1.dev", true},
+		{"", false},
+		{"alan_dev", false},
+		{"_", false},
+	}
+
+	for _, test := range tests {
+		if err := validFileUserId(test.userId); (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %v, got error %v", test.userId, test.valid, err)
+		}
+	}
+}
+
+func TestFileDeployments(t *testing.T) {
+	server := NewServer(viper.New())
+	deployment := func(userId string, fileIds ...string) *apis.Deployment {
+		files := []apis.DeploymentFile{}
+		for _, fileId := range fileIds {
+			files = append(files, apis.DeploymentFile{FileId: fileId, Path: "/tmp/" + fileId})
+		}
+		return &apis.Deployment{UserId: userId, Files: files}
+	}
+
+	server.DeployedClusters = map[string]*DeploymentInfo{
+		"b-demo":      {Deployment: deployment("alan", "config", "data"), State: AVAILABLE},
+		"a-demo":      {Deployment: deployment("alan", "config"), State: CREATING},
+		"other-file":  {Deployment: deployment("alan", "data"), State: AVAILABLE},
+		"other-user":  {Deployment: deployment("bob", "config"), State: AVAILABLE},
+		"deleted":     {Deployment: deployment("alan", "config"), State: DELETED},
+		"failed":      {Deployment: deployment("alan", "config"), State: FAILED},
+		"without-any": {Deployment: deployment("alan"), State: AVAILABLE},
+	}
+
+	tests := []struct {
+		userId   string
+		fileId   string
+		expected []string
+	}{
+		{"alan", "config", []string{"a-demo", "b-demo"}},
+		{"alan", "data", []string{"b-demo", "other-file"}},
+		{"bob", "config", []string{"other-user"}},
+		{"bob", "data", []string{}},
+		{"carol", "config", []string{}},
+	}
+
+	for _, test := range tests {
+		names := server.fileDeployments(test.userId, test.fileId)
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%s of %s: expected deployments %v, got %v", test.fileId, test.userId, test.expected, names)
+		}
+	}
+}
+
+func TestCommitBlobStoresContentOnce(t *testing.T) {
+	server, cleanup := newFilesTestServer(t)
+	defer cleanup()
+
+	checksums := []string{}
+	for i := 0; i < 2; i++ {
+		stagedPath, checksum, size, err := server.stageBlob(strings.NewReader("content"))
+		if err != nil {
+			t.Fatalf("Unable to stage blob: %s", err.Error())
+		}
+		defer os.Remove(stagedPath)
+
+		if size != int64(len("content")) {
+			t.Errorf("Unexpected size %d", size)
+		}
+		if err := server.commitBlob(stagedPath, checksum); err != nil {
+			t.Fatalf("Unable to commit blob: %s", err.Error())
+		}
+		checksums = append(checksums, checksum)
+	}
+
+	if checksums[0] != checksums[1] {
+		t.Fatalf("Expected identical content to have one checksum, got %v", checksums)
+	}
+
+	files, err := ioutil.ReadDir(server.blobsPath())
+	if err != nil {
+		t.Fatalf("Unable to list blobs: %s", err.Error())
+	}
+	// The second staged file is left for its caller to remove
+	blobs := 0
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "upload-") {
+			blobs++
+		}
+	}
+	if blobs != 1 {
+		t.Errorf("Expected one blob, got %d", blobs)
+	}
+}
+
+func TestRemoveUnusedBlob(t *testing.T) {
+	server, cleanup := newFilesTestServer(t)
+	defer cleanup()
+
+	if err := os.MkdirAll(server.blobsPath(), 0755); err != nil {
+		t.Fatalf("Unable to create blobs directory: %s", err.Error())
+	}
+	for _, checksum := range []string{"shared", "single"} {
+		if err := ioutil.WriteFile(server.blobPath(checksum), []byte(checksum), 0644); err != nil {
+			t.Fatalf("Unable to write blob: %s", err.Error())
+		}
+	}
+
+	server.Files = map[string]*StoreFile{
+		"alan_config": {UserId: "alan", FileId: "config", Checksum: "shared"},
+		"bob_config":  {UserId: "bob", FileId: "config", Checksum: "shared"},
+		"alan_data":   {UserId: "alan", FileId: "data", Checksum: "single"},
+	}
+
+	exists := func(checksum string) bool {
+		_, err := os.Stat(server.blobPath(checksum))
+		return err == nil
+	}
+
+	// Blobs are kept while any file refers to them
+	delete(server.Files, "alan_config")
+	server.removeUnusedBlob("shared")
+	if !exists("shared") {
+		t.Error("Expected blob of bob's file to be kept")
+	}
+
+	delete(server.Files, "bob_config")
+	server.removeUnusedBlob("shared")
+	if exists("shared") {
+		t.Error("Expected unused blob to be removed")
+	}
+
+	if !exists("single") {
+		t.Error("Expected blob of other files to be kept")
+	}
+
+	// Removing a missing blob is a no-op
+	server.removeUnusedBlob("shared")
+}
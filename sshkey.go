@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rotateSshKey replaces the ssh key pair of the deployment on its nodes, the old key pair
+// can't be used to reach them anymore once it succeeds
+func (server *Server) rotateSshKey(c *gin.Context) {
+	deploymentName := c.Param("deployment")
+
+	server.mutex.Lock()
+	deploymentInfo, ok := server.DeployedClusters[deploymentName]
+	if !ok {
+		server.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  "Deployment not found",
+		})
+		return
+	}
+
+	if deploymentInfo.State != AVAILABLE {
+		server.mutex.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Deployment is not available",
+		})
+		return
+	}
+
+	deploymentInfo.SetState(UPDATING)
+	server.mutex.Unlock()
+
+	log := deploymentInfo.Deployer.GetLog().Logger
+	log.Infof("Rotating ssh key of deployment %s", deploymentName)
+	// The new key is stored before the old key pair is dropped, so it can't be lost
+	err := deploymentInfo.Deployer.RotateSshKey(func() error {
+		return server.storeDeployment(deploymentInfo)
+	})
+
+	// A failed rotation leaves the nodes reachable with either key pair, so the deployment
+	// stays available, and the stored key material follows whichever one the cluster uses
+	deploymentInfo.SetState(AVAILABLE)
+	if storeErr := server.storeDeployment(deploymentInfo); storeErr != nil && err == nil {
+		err = storeErr
+	}
+
+	if err != nil {
+		log.Warningf("Unable to rotate ssh key: %s", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": true,
+			"data":  "Unable to rotate ssh key: " + err.Error(),
+		})
+		return
+	}
+
+	log.Infof("Rotated ssh key of deployment %s", deploymentName)
+	c.JSON(http.StatusOK, gin.H{
+		"error": false,
+		"data":  "",
+	})
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/hyperpilotio/deployer/apis"
+)
+
+// templateVersionSeparator separates the template id and version in template references
+const templateVersionSeparator = "@"
+
+// TemplateVersion is an immutable version of a template. Storing a template again adds a
+// new version, so deployments created from a version can always be reset to it.
+type TemplateVersion struct {
+	TemplateId string                   `json:"templateId"`
+	Version    int                      `json:"version"`
+	Created    string                   `json:"created"`
+	Deployment *apis.DeploymentTemplate `json:"deployment,omitempty"`
+
+	// Key of the version in the templates store
+	storeKey string
+}
+
+// Ref return the templateId@version reference of the version
+func (version *TemplateVersion) Ref() string {
+	return templateRef(version.TemplateId, version.Version)
+}
+
+// Template is a deployment template with its versions, sorted by version
+type Template struct {
+	TemplateId string             `json:"templateId"`
+	Versions   []*TemplateVersion `json:"versions"`
+}
+
+func (template *Template) Latest() *TemplateVersion {
+	return template.Versions[len(template.Versions)-1]
+}
+
+// Version return the version of the template, or the latest one for version 0
+func (template *Template) Version(version int) (*TemplateVersion, bool) {
+	if version == 0 {
+		return template.Latest(), true
+	}
+
+	for _, templateVersion := range template.Versions {
+		if templateVersion.Version == version {
+			return templateVersion, true
+		}
+	}
+
+	return nil, false
+}
+
+func templateRef(templateId string, version int) string {
+	return templateId + templateVersionSeparator + strconv.Itoa(version)
+}
+
+// parseTemplateRef splits a templateId or templateId@version reference, version is 0 when
+// the reference is to the latest version
+func parseTemplateRef(ref string) (string, int, error) {
+	parts := strings.SplitN(ref, templateVersionSeparator, 2)
+	if parts[0] == "" {
+		return "", 0, errors.New("Empty template id in " + ref)
+	}
+
+	if len(parts) == 1 {
+		return parts[0], 0, nil
+	}
+
+	version, err := strconv.Atoi(parts[1])
+	if err != nil || version <= 0 {
+		return "", 0, fmt.Errorf("Invalid version of template %s: %s", parts[0], parts[1])
+	}
+
+	return parts[0], version, nil
+}
+
+// deploymentTemplateValues return the values to render the template of the reference with for
+// the deployment: the values of the request when it has any, otherwise the values the deployment
+// was rendered with, only when it was created from the same template
+func deploymentTemplateValues(
+	deploymentInfo *DeploymentInfo,
+	ref string,
+	values map[string]interface{}) map[string]interface{} {
+	if values != nil {
+		return values
+	}
+
+	if deploymentInfo == nil || deploymentInfo.TemplateId == "" {
+		return nil
+	}
+
+	templateId, _, err := parseTemplateRef(ref)
+	if err != nil {
+		return nil
+	}
+
+	if deploymentTemplateId, _, err := parseTemplateRef(deploymentInfo.TemplateId); err != nil ||
+		deploymentTemplateId != templateId {
+		return nil
+	}
+
+	return deploymentInfo.TemplateValues
+}
+
+// findTemplateVersion return the template version of the reference.
+// Callers must hold the server mutex.
+func (server *Server) findTemplateVersion(ref string) (*TemplateVersion, error) {
+	templateId, version, err := parseTemplateRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	template, ok := server.Templates[templateId]
+	if !ok {
+		return nil, fmt.Errorf("Unable to find %s deployment templates", templateId)
+	}
+
+	templateVersion, ok := template.Version(version)
+	if !ok {
+		return nil, fmt.Errorf("Unable to find version %d of %s deployment templates", version, templateId)
+	}
+
+	return templateVersion, nil
+}
+
+// templateDeployments return the names of the live deployments created from any version of
+// the template. Callers must hold the server mutex.
+func (server *Server) templateDeployments(templateId string) []string {
+	names := []string{}
+	for name, deploymentInfo := range server.DeployedClusters {
+		if deploymentInfo.State == DELETED || deploymentInfo.TemplateId == "" {
+			continue
+		}
+
+		if id, _, err := parseTemplateRef(deploymentInfo.TemplateId); err == nil && id == templateId {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// templateVersionOf return the template version of a stored template, templates stored before
+// versioning are their first version and keep their template id as key
+func templateVersionOf(templateDeployment *StoreTemplateDeployment) *TemplateVersion {
+	if templateDeployment.Version == 0 {
+		return &TemplateVersion{
+			TemplateId: templateDeployment.TemplateId,
+			Version:    1,
+			Created:    templateDeployment.Created,
+			storeKey:   templateDeployment.TemplateId,
+		}
+	}
+
+	return &TemplateVersion{
+		TemplateId: templateDeployment.TemplateId,
+		Version:    templateDeployment.Version,
+		Created:    templateDeployment.Created,
+		storeKey:   templateRef(templateDeployment.TemplateId, templateDeployment.Version),
+	}
+}
+
+// addTemplateVersion adds the version to its template, keeping versions sorted.
+// Callers must hold the server mutex.
+func (server *Server) addTemplateVersion(templateVersion *TemplateVersion) {
+	template, ok := server.Templates[templateVersion.TemplateId]
+	if !ok {
+		template = &Template{
+			TemplateId: templateVersion.TemplateId,
+		}
+		server.Templates[templateVersion.TemplateId] = template
+	}
+
+	versions := append(template.Versions, templateVersion)
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	template.Versions = versions
+
+	if server.templateVersions[templateVersion.TemplateId] < templateVersion.Version {
+		server.templateVersions[templateVersion.TemplateId] = templateVersion.Version
+	}
+}
+
+// reloadTemplates loads the template versions from the store. Templates stored before
+// versioning are loaded as their first version, and versions up to the deleted mark of a
+// template are dropped.
+func (server *Server) reloadTemplates() error {
+	templates, err := server.TemplateStore.LoadAll(func() interface{} {
+		return &StoreTemplateDeployment{}
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to load deployment templates: %s", err.Error())
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	glog.V(1).Infof("Loading %d template deployment from store", len(templates.([]interface{})))
+	deleted := map[string]int{}
+	templateVersions := []*TemplateVersion{}
+	for _, template := range templates.([]interface{}) {
+		templateDeployment := template.(*StoreTemplateDeployment)
+		templateVersion := templateVersionOf(templateDeployment)
+		if server.templateVersions[templateVersion.TemplateId] < templateVersion.Version {
+			server.templateVersions[templateVersion.TemplateId] = templateVersion.Version
+		}
+
+		if templateDeployment.Deleted {
+			if deleted[templateVersion.TemplateId] < templateVersion.Version {
+				deleted[templateVersion.TemplateId] = templateVersion.Version
+			}
+			continue
+		}
+
+		deployment, err := apis.ParseDeploymentTemplate([]byte(templateDeployment.Deployment))
+		if err != nil {
+			glog.Warningf("Skip loading template deployment %s: %s", templateVersion.Ref(), err.Error())
+			continue
+		}
+		templateVersion.Deployment = deployment
+		templateVersions = append(templateVersions, templateVersion)
+	}
+
+	for _, templateVersion := range templateVersions {
+		if templateVersion.Version <= deleted[templateVersion.TemplateId] {
+			glog.Warningf("Skip loading template %s of a deleted template", templateVersion.Ref())
+			continue
+		}
+
+		glog.V(1).Infof("Recovered template %s deployment from store", templateVersion.Ref())
+		server.addTemplateVersion(templateVersion)
+	}
+
+	return nil
+}
+
+// versionsWithoutDeployments return the versions of the template without their deployments,
+// to list them
+func versionsWithoutDeployments(template *Template) []*TemplateVersion {
+	versions := []*TemplateVersion{}
+	for _, version := range template.Versions {
+		versions = append(versions, &TemplateVersion{
+			TemplateId: version.TemplateId,
+			Version:    version.Version,
+			Created:    version.Created,
+		})
+	}
+
+	return versions
+}
+
+func (server *Server) getTemplates(c *gin.Context) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	templates := []*Template{}
+	for templateId, template := range server.Templates {
+		templates = append(templates, &Template{
+			TemplateId: templateId,
+			Versions:   versionsWithoutDeployments(template),
+		})
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].TemplateId < templates[j].TemplateId
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"error": false,
+		"data":  templates,
+	})
+}
+
+// getTemplate return the template version of the templateId or templateId@version param
+func (server *Server) getTemplate(c *gin.Context) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	templateVersion, err := server.findTemplateVersion(c.Param("templateId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"error": false,
+		"data":  templateVersion,
+	})
+}
+
+func (server *Server) getTemplateVersions(c *gin.Context) {
+	templateId := c.Param("templateId")
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	template, ok := server.Templates[templateId]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  "Template not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"error": false,
+		"data":  versionsWithoutDeployments(template),
+	})
+}
+
+// deleteTemplate deletes all versions of a template, unless deployments were created from it.
+// The latest version is replaced by a deleted mark, so versions of the template aren't reused
+// after restarts.
+func (server *Server) deleteTemplate(c *gin.Context) {
+	templateId := c.Param("templateId")
+	if strings.Contains(templateId, templateVersionSeparator) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": true,
+			"data":  "Template versions are immutable, only whole templates can be deleted",
+		})
+		return
+	}
+
+	// The template is dropped under the lock, so no deployment is created from it while it's
+	// deleted from the store, and put back when the deleted mark can't be stored
+	server.mutex.Lock()
+	template, ok := server.Templates[templateId]
+	if !ok {
+		server.mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": true,
+			"data":  "Template not found",
+		})
+		return
+	}
+
+	if deployments := server.templateDeployments(templateId); len(deployments) > 0 {
+		server.mutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{
+			"error":       true,
+			"data":        fmt.Sprintf("Template %s is used by %d deployments", templateId, len(deployments)),
+			"deployments": deployments,
+		})
+		return
+	}
+	delete(server.Templates, templateId)
+	server.mutex.Unlock()
+
+	latest := template.Latest()
+	deletedMark := &StoreTemplateDeployment{
+		TemplateId: templateId,
+		Version:    latest.Version,
+		Created:    latest.Created,
+		Deleted:    true,
+	}
+	if err := server.TemplateStore.Store(latest.storeKey, deletedMark); err != nil {
+		server.mutex.Lock()
+		for _, version := range template.Versions {
+			server.addTemplateVersion(version)
+		}
+		server.mutex.Unlock()
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": true,
+			"data":  fmt.Sprintf("Unable to mark %s template deleted in store: %s", templateId, err.Error()),
+		})
+		return
+	}
+
+	// Older versions are dropped on reload after the deleted mark, so failing to delete
+	// them only leaves them in the store
+	for _, version := range template.Versions[:len(template.Versions)-1] {
+		if err := server.TemplateStore.Delete(version.storeKey); err != nil {
+			glog.Warningf("Unable to delete %s template from store: %s", version.Ref(), err.Error())
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"error": false,
+		"data":  "",
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseTemplateRef(t *testing.T) {
+	tests := []struct {
+		ref        string
+		templateId string
+		version    int
+		valid      bool
+	}{
+		{"tech-demo", "tech-demo", 0, true},
+		{"tech-demo@3", "tech-demo", 3, true},
+		{"tech-demo@0", "", 0, false},
+		{"tech-demo@-1", "", 0, false},
+		{"tech-demo@latest", "", 0, false},
+		{"tech-demo@", "", 0, false},
+		{"@1", "", 0, false},
+		{"", "", 0, false},
+	}
+
+	for _, test := range tests {
+		templateId, version, err := parseTemplateRef(test.ref)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %v, got error %v", test.ref, test.valid, err)
+			continue
+		}
+		if templateId != test.templateId || version != test.version {
+			t.Errorf("%q: expected %s version %d, got %s version %d",
+				test.ref, test.templateId, test.version, templateId, version)
+		}
+	}
+}
+
+func TestTemplateVersion(t *testing.T) {
+	template := &Template{
+		TemplateId: "tech-demo",
+		Versions: []*TemplateVersion{
+			{TemplateId: "tech-demo", Version: 1},
+			{TemplateId: "tech-demo", Version: 2},
+			{TemplateId: "tech-demo", Version: 4},
+		},
+	}
+
+	tests := []struct {
+		version  int
+		expected int
+		found    bool
+	}{
+		{0, 4, true},
+		{1, 1, true},
+		{4, 4, true},
+		{3, 0, false},
+		{5, 0, false},
+	}
+
+	for _, test := range tests {
+		templateVersion, ok := template.Version(test.version)
+		if ok != test.found {
+			t.Errorf("version %d: expected found %v, got %v", test.version, test.found, ok)
+			continue
+		}
+		if ok && templateVersion.Version != test.expected {
+			t.Errorf("version %d: expected version %d, got %d", test.version, test.expected, templateVersion.Version)
+		}
+	}
+}
+
+func TestTemplateVersionOf(t *testing.T) {
+	tests := []struct {
+		name       string
+		stored     *StoreTemplateDeployment
+		version    int
+		storeKey   string
+		templateId string
+	}{
+		{"legacy template", &StoreTemplateDeployment{TemplateId: "tech-demo"}, 1, "tech-demo", "tech-demo"},
+		{"first version", &StoreTemplateDeployment{TemplateId: "tech-demo", Version: 1}, 1, "tech-demo@1", "tech-demo"},
+		{"later version", &StoreTemplateDeployment{TemplateId: "tech-demo", Version: 7}, 7, "tech-demo@7", "tech-demo"},
+		{"deleted mark", &StoreTemplateDeployment{TemplateId: "tech-demo", Version: 3, Deleted: true}, 3,
+			"tech-demo@3", "tech-demo"},
+	}
+
+	for _, test := range tests {
+		templateVersion := templateVersionOf(test.stored)
+		if templateVersion.TemplateId != test.templateId {
+			t.Errorf("%s: expected template id %s, got %s", test.name, test.templateId, templateVersion.TemplateId)
+		}
+		if templateVersion.Version != test.version {
+			t.Errorf("%s: expected version %d, got %d", test.name, test.version, templateVersion.Version)
+		}
+		if templateVersion.storeKey != test.storeKey {
+			t.Errorf("%s: expected store key %s, got %s", test.name, test.storeKey, templateVersion.storeKey)
+		}
+	}
+}
+
+func TestAddTemplateVersion(t *testing.T) {
+	server := &Server{
+		Templates:        make(map[string]*Template),
+		templateVersions: map[string]int{"tech-demo": 5},
+	}
+
+	for _, version := range []int{7, 6, 8} {
+		server.addTemplateVersion(&TemplateVersion{TemplateId: "tech-demo", Version: version})
+	}
+
+	versions := server.Templates["tech-demo"].Versions
+	for i, expected := range []int{6, 7, 8} {
+		if versions[i].Version != expected {
+			t.Errorf("expected version %d at %d, got %d", expected, i, versions[i].Version)
+		}
+	}
+	if server.templateVersions["tech-demo"] != 8 {
+		t.Errorf("expected highest version 8, got %d", server.templateVersions["tech-demo"])
+	}
+
+	// Versions added after a delete don't lower the highest version reserved
+	server.templateVersions["other"] = 4
+	server.addTemplateVersion(&TemplateVersion{TemplateId: "other", Version: 2})
+	if server.templateVersions["other"] != 4 {
+		t.Errorf("expected highest version 4, got %d", server.templateVersions["other"])
+	}
+}
+
+func TestDeploymentTemplateValues(t *testing.T) {
+	storedValues := map[string]interface{}{"replicas": float64(2)}
+	requestValues := map[string]interface{}{"replicas": float64(3)}
+	deploymentInfo := &DeploymentInfo{
+		TemplateId:     "tech-demo@2",
+		TemplateValues: storedValues,
+	}
+
+	tests := []struct {
+		name           string
+		deploymentInfo *DeploymentInfo
+		ref            string
+		values         map[string]interface{}
+		expected       map[string]interface{}
+	}{
+		{"same template", deploymentInfo, "tech-demo", nil, storedValues},
+		{"other version of the template", deploymentInfo, "tech-demo@3", nil, storedValues},
+		{"other template", deploymentInfo, "other-demo", nil, nil},
+		{"request values", deploymentInfo, "other-demo", requestValues, requestValues},
+		{"request values of the same template", deploymentInfo, "tech-demo", requestValues, requestValues},
+		{"deployment without template", &DeploymentInfo{}, "tech-demo", nil, nil},
+		{"unknown deployment", nil, "tech-demo", nil, nil},
+	}
+
+	for _, test := range tests {
+		values := deploymentTemplateValues(test.deploymentInfo, test.ref, test.values)
+		if fmt.Sprint(values) != fmt.Sprint(test.expected) {
+			t.Errorf("%s: expected values %v, got %v", test.name, test.expected, values)
+		}
+	}
+}